@@ -0,0 +1,184 @@
+//**************************************************************
+//
+// N4L.go
+//
+// The N4L notes language, read into a document of lines and the
+// statements on them, with their positions, so the compilers and
+// other tools share one parser and need no database
+//
+//**************************************************************
+
+package N4L
+
+import (
+	"fmt"
+)
+
+//**************************************************************
+// Roles of statements on a line
+//**************************************************************
+
+const (
+	ROLE_EVENT = 1
+	ROLE_RELATION = 2
+	ROLE_SECTION = 3
+	ROLE_CONTEXT = 4
+	ROLE_CONTEXT_ADD = 5
+	ROLE_CONTEXT_SUBTRACT = 6
+	ROLE_BLANK_LINE = 7
+	ROLE_LINE_ALIAS = 8
+	ROLE_LOOKUP = 9
+	ROLE_DITTO = 10
+	ROLE_INCLUDE = 12
+
+	HAVE_PLUS = 11
+	HAVE_MINUS = 22
+
+	ERROR = 1     // the line can't be compiled
+	WARNING = 2   // it can, but probably not as meant
+
+	ALPHATEXT = 'x'
+	NON_ASCII_LQUOTE = '“'
+	NON_ASCII_RQUOTE = '”'
+
+	SEQUENCE_RELN = "then"
+	INCLUDE_DIRECTIVE = "#include"
+	WORD_MISTAKE_LEN = 3 // a string shorter than this is probably a mistake
+)
+
+//**************************************************************
+// Messages
+//**************************************************************
+
+const (
+	WARN_NOTE_TO_SELF = "WARNING: Found a note to self in the text"
+	WARN_INADVISABLE_CONTEXT_EXPRESSION = "WARNING: Inadvisably complex/parenthetic context expression - simplify?"
+	WARN_CHAPTER_CLASS_MIXUP="WARNING: possible space between class cancellation -:: <class> :: ambiguous chapter name, in: "
+	WARN_DIFFERENT_CAPITALS = "WARNING: Another capitalization exists"
+
+	ERR_NO_SUCH_FILE_FOUND = "No file found in the name "
+	ERR_MISSING_EVENT = "Missing item? Dangling section, relation, or context"
+	ERR_MISSING_SECTION = "Declarations outside a section or chapter"
+	ERR_NO_SUCH_ALIAS = "No such alias or \" reference exists to fill in - aborting"
+	ERR_NO_SUCH_ARROW = "No such arrow has been declared in the configuration: "
+	ERR_MISSING_ITEM_SOMEWHERE = "Missing item somewhere"
+	ERR_MISSING_ITEM_RELN = "Missing item or double relation"
+	ERR_MISMATCH_QUOTE = "Apparent missing or mismatch in ', \" or ( )"
+	ERR_ILLEGAL_CONFIGURATION = "Error in configuration, no such section"
+	ERR_BAD_LABEL_OR_REF = "Badly formed label or reference (@label becomes $label.n) in "
+	ERR_ILLEGAL_QUOTED_STRING_OR_REF = "WARNING: Something wrong, bad quoted string or mistaken back reference. Close any space after a quote..."
+	ERR_ANNOTATION_BAD = "Annotation marker should be short mark of non-space, non-alphanumeric character "
+	ERR_BAD_ABBRV = "abbreviation out of place"
+	ERR_BAD_ALIAS_REFERENCE = "Alias references start from $name.1"
+	ERR_ANNOTATION_MISSING = "Missing non-alphnumeric annotation marker or stray relation"
+	ERR_ANNOTATION_REDEFINE = "Redefinition of annotation character"
+	ERR_SIMILAR_NO_SIGN = "Arrows for similarity do not have signs, they are directionless"
+	ERR_ARROW_SELFLOOP = "Arrow's origin points to itself"
+	ERR_ARR_REDEFINITION="Redefinition of arrow "
+	ERR_NEGATIVE_WEIGHT = "Arrow relation has a negative weight, which is disallowed. Use a NOT relation if you want to signify inhibition: "
+	ERR_TOO_MANY_WEIGHTS = "More than one weight value in the arrow relation "
+	ERR_STRAY_PAREN="Stray ) in an event/item - illegal character"
+	ERR_MISSING_LINE_LABEL_IN_REFERENCE="Missing a line label in reference, should be in the form $label.n"
+	ERR_NON_WORD_WHITE="Non word (whitespace) character after an annotation: "
+	ERR_SHORT_WORD="Short word, probably a mistake: "
+	ERR_ILLEGAL_ANNOT_CHAR="Cannot use +/- reserved tokens for annotation"
+	ERR_BAD_INCLUDE = "Expected a file name in quotes, and nothing else, after #include"
+	ERR_INCLUDE_CYCLE = "Files include each other in a loop: "
+	ERR_NO_SUCH_FILE_ALIAS = "No file of this name has been read before, for $file:label.n: "
+	ERR_AMBIGUOUS_FILE_ALIAS = "More than one file of this name has been read, so $file:label.n could mean any of: "
+)
+
+//**************************************************************
+// The document
+//**************************************************************
+
+type Position struct {
+
+	File   string
+	Line   int    // from 1
+	Column int    // in characters, from 1
+}
+
+//**************************************************************
+
+type Diagnostic struct {
+
+	Pos      Position
+	Severity int         // ERROR or WARNING
+	Message  string
+	Source   string      // the line it is about, for an excerpt
+}
+
+//**************************************************************
+
+type Relation struct {
+
+	Name    string       // the short or long name of the arrow
+	Weight  float64      // 1 unless given
+	Context []string     // added to this link only
+}
+
+//**************************************************************
+
+type Annotation struct {
+
+	Pos    Position
+	Offset int           // where the mark is in the item's text, in characters
+	Mark   string
+	Arrow  string        // from the annotations of the configuration
+	Word   string        // the item it links to
+}
+
+//**************************************************************
+
+type Statement struct {
+
+	Role        int      // ROLE_EVENT, ROLE_RELATION, ...
+	Pos         Position
+	Text        string   // the token as written, without any quotes
+	Quoted      bool
+
+	Value       string   // the item, chapter, alias label, context expression or included file
+	Context     []string // the OR-parts of a context expression
+	Relation    Relation // for ROLE_RELATION
+	Alias       string   // for ROLE_LOOKUP, $Alias.Index or $AliasFile:Alias.Index
+	AliasFile   string
+	Index       int
+	Annotations []Annotation
+}
+
+//**************************************************************
+
+type Line struct {
+
+	Pos        Position  // of the first statement
+	End        Position  // of the newline, or the end of the file
+	Statements []Statement
+}
+
+//**************************************************************
+
+type Document struct {
+
+	File   string
+	Lines  []Line        // only those with statements
+	Source []string      // the text, line by line
+}
+
+//**************************************************************
+
+func (p Position) String() string {
+
+	return fmt.Sprintf("%s:%d:%d",p.File,p.Line,p.Column)
+}
+
+//**************************************************************
+
+func (p Position) Before(q Position) bool {
+
+	if p.Line != q.Line {
+		return p.Line < q.Line
+	}
+
+	return p.Column < q.Column
+}
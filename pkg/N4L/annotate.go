@@ -0,0 +1,176 @@
+//**************************************************************
+//
+// annotate.go
+//
+// Annotations, like %word, mark a word in an item's text that
+// the item should also be linked to, by the arrow the mark
+// stands for in the configuration
+//
+//**************************************************************
+
+package N4L
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+//**************************************************************
+
+func StripAnnotations(text string,marks map[string]string,report func(string)) string {
+
+	// The text without the marks. Problems are reported, if report
+	// isn't nil
+
+	clean,_ := scanAnnotations(text,marks,report)
+	return clean
+}
+
+//**************************************************************
+
+func FindAnnotations(text string,marks map[string]string,report func(string)) []Annotation {
+
+	_,found := scanAnnotations(text,marks,report)
+	return found
+}
+
+//**************************************************************
+
+func scanAnnotations(text string,marks map[string]string,report func(string)) (string,[]Annotation) {
+
+	// Text in double quotes is protected from marks
+
+	var protected bool = false
+	var deloused []rune
+	var found []Annotation
+	var preserve_unicode = []rune(text)
+
+	if report == nil {
+		report = func(string) {}
+	}
+
+	order := markOrder(marks)
+
+	for r := 0; r < len(preserve_unicode); r++ {
+
+		if preserve_unicode[r] == '"' {
+			protected = !protected
+		}
+
+		if !protected {
+
+			skip,symb := EmbeddedSymbol(preserve_unicode,r,order)
+
+			if skip > 0 {
+
+				var a Annotation
+
+				a.Offset = r
+				a.Mark = symb
+				a.Arrow = marks[symb]
+				a.Word = ExtractWord(preserve_unicode,r+skip-1,report)
+				found = append(found,a)
+
+				r += skip-1
+
+				if unicode.IsSpace(preserve_unicode[r]) {
+					report(ERR_NON_WORD_WHITE+symb)
+				}
+				continue
+			}
+		}
+
+		deloused = append(deloused,preserve_unicode[r])
+	}
+
+	return string(deloused),found
+}
+
+//**************************************************************
+
+func markOrder(marks map[string]string) []string {
+
+	// Longest first, so that a mark is never mistaken for a shorter
+	// one it starts with
+
+	var order []string
+
+	for m := range marks {
+		order = append(order,m)
+	}
+
+	sort.Slice(order, func(i,j int) bool {
+		if len(order[i]) != len(order[j]) {
+			return len(order[i]) > len(order[j])
+		}
+		return order[i] < order[j]
+	})
+
+	return order
+}
+
+//**************************************************************
+
+func EmbeddedSymbol(fulltext []rune,offset int,marks []string) (int,string) {
+
+	// The length of the mark at offset, if there is one, with no
+	// space between the mark and the text
+
+	for _,an := range marks {
+
+		uni := []rune(an)
+
+		if len(uni) == 0 || offset+len(uni) >= len(fulltext) {
+			continue
+		}
+
+		match := true
+
+		for r := 0; r < len(uni); r++ {
+
+			if uni[r] != fulltext[offset+r] || unicode.IsSpace(fulltext[offset+r+1]) {
+				match = false
+				break
+			}
+		}
+
+		if match {
+			return len(uni),an
+		}
+	}
+
+	return 0,"UNKNOWN SYMBOL"
+}
+
+//**************************************************************
+
+func ExtractWord(fulltext []rune,offset int,report func(string)) string {
+
+	// The word after the mark ending at offset, or the quoted phrase
+
+	var protected bool = false
+	var word string
+
+	for r := offset+1; r < len(fulltext); r++ {
+
+		if fulltext[r] == '"' {
+			protected = !protected
+		}
+
+		if !protected && !unicode.IsLetter(fulltext[r]) {
+			word = strings.Trim(strings.TrimSpace(word),"\" ")
+			return word
+		}
+
+		word += string(fulltext[r])
+	}
+
+	word = strings.Trim(strings.TrimSpace(word),"\" ")
+
+	if len(word) <= WORD_MISTAKE_LEN && report != nil {
+		report(ERR_SHORT_WORD+word)
+	}
+
+	return word
+}
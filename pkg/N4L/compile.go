@@ -0,0 +1,861 @@
+//**************************************************************
+//
+// compile.go
+//
+// The meaning of the notes, line by line: items become nodes,
+// relations become links, in the chapter and context in force.
+// The program decides what to make of them, through callbacks
+//
+//**************************************************************
+
+package N4L
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+//**************************************************************
+
+type NodeRef interface{} // whatever the program made of a node, see AddNode
+
+//**************************************************************
+
+type Link struct {
+
+	Arrow   int      // index in Config.Arrows
+	Weight  float64
+	Context []string
+	Dst     NodeRef  // only in the Path of a PageLine
+}
+
+//**************************************************************
+
+type PageLine struct {
+
+	// A line of notes as written, for showing the notes again
+
+	File    string
+	Chapter string
+	Alias   string
+	Context []string
+	Line    int
+	Path    []Link   // the first node, with no arrow, then each link made
+}
+
+//**************************************************************
+
+type Compiler struct {
+
+	Config      *Config
+	Reporter    *Reporter
+	Files       *FileSet
+
+	Verbose     bool
+	Out         io.Writer // for the verbose account
+	LineNum     int       // the line being compiled
+
+	AddNode     func(text,annotated,chapter string) NodeRef // the same node for the same text
+	AddLink     func(from NodeRef,link Link,to NodeRef)
+	AddPageLine func(page PageLine)                         // optional
+	EnterFile   func(filename string)                       // optional, on starting or resuming a file
+
+	file        string
+	source      *Document
+	here        Position
+	pending     []Diagnostic // from the parser, reported as the lines are compiled
+
+	items       map[string][]string // current, previous and labelled line items
+	refs        []NodeRef           // the nodes of the current line
+	relns       map[string][]Link
+	state       int
+	alias       string
+	itemCounter int
+	relnCounter int
+	path        []Link
+
+	context     map[string]bool
+	section     string
+
+	sequenceMode   bool
+	lastInSequence string
+
+	texts       map[string]bool // every node text, and in lower case, to
+	lower       map[string]bool // warn of the same with different capitals
+}
+
+//**************************************************************
+
+var END_OF_FILE = Position{Line: math.MaxInt32}
+
+//**************************************************************
+
+func NewCompiler(reporter *Reporter) *Compiler {
+
+	var c Compiler
+
+	c.Config = NewConfig()
+	c.Reporter = reporter
+	c.Files = NewFileSet()
+	c.Out = os.Stdout
+	c.source = &Document{}
+	c.texts = make(map[string]bool)
+	c.lower = make(map[string]bool)
+	c.items = make(map[string][]string)
+	c.relns = make(map[string][]Link)
+	c.context = make(map[string]bool)
+
+	return &c
+}
+
+//**************************************************************
+
+func (c *Compiler) File() string {
+
+	return c.file
+}
+
+//**************************************************************
+
+func (c *Compiler) Configure(filename string) *Config {
+
+	// Read the arrows and annotation marks, reporting any errors,
+	// without which the notes can't be understood
+
+	c.newFile(filename)
+
+	cfg,diags := ParseConfigFile(filename)
+
+	c.pending = diags
+	c.reportUpto(END_OF_FILE)
+
+	c.Config = cfg
+
+	return cfg
+}
+
+//**************************************************************
+
+func (c *Compiler) Compile(filename string) {
+
+	// The parser checks the grammar, here we give it meaning
+
+	c.newFile(filename)
+	c.enter()
+
+	doc,diags := ParseFile(filename,c.Config)
+
+	c.source = doc
+	c.pending = diags
+
+	c.Files.Enter(filename)
+
+	for _,line := range doc.Lines {
+
+		if c.Reporter.Stopped() {
+			break
+		}
+
+		// After an error, give up on the rest of the line
+
+		for _,s := range line.Statements {
+			c.reportUpto(s.Pos)
+			c.LineNum = s.Pos.Line
+			c.here = s.Pos
+
+			if !c.statement(s) || c.Reporter.Stopped() {
+				break
+			}
+		}
+
+		c.reportUpto(line.End)
+		c.LineNum = line.Pos.Line
+		c.endLine()
+	}
+
+	c.reportUpto(END_OF_FILE)
+
+	c.Files.Leave(filename,c.items)
+}
+
+//**************************************************************
+
+func (c *Compiler) newFile(filename string) {
+
+	c.file = filename
+
+	c.Box("Parsing new file",filename)
+
+	c.state = ROLE_BLANK_LINE
+	c.LineNum = 1
+	c.items = make(map[string][]string)
+	c.relns = make(map[string][]Link)
+	c.refs = nil
+	c.itemCounter = 1
+	c.relnCounter = 0
+	c.alias = ""
+	c.path = nil
+	c.lastInSequence = ""
+	c.pending = nil
+	c.section = ""
+	c.context = make(map[string]bool)
+}
+
+//**************************************************************
+
+func (c *Compiler) enter() {
+
+	if c.EnterFile != nil {
+		c.EnterFile(c.file)
+	}
+}
+
+//**************************************************************
+
+type fileState struct {
+
+	// What newFile() starts again, kept while an #include is read
+
+	file           string
+	lineNum        int
+	items          map[string][]string
+	refs           []NodeRef
+	relns          map[string][]Link
+	state          int
+	alias          string
+	itemCounter    int
+	relnCounter    int
+	path           []Link
+	lastInSequence string
+	sequenceMode   bool
+	pending        []Diagnostic
+	section        string
+	context        map[string]bool
+	source         *Document
+	here           Position
+}
+
+//**************************************************************
+
+func (c *Compiler) saveFileState() fileState {
+
+	var f fileState
+
+	f.file = c.file
+	f.lineNum = c.LineNum
+	f.items = c.items
+	f.refs = c.refs
+	f.relns = c.relns
+	f.state = c.state
+	f.alias = c.alias
+	f.itemCounter = c.itemCounter
+	f.relnCounter = c.relnCounter
+	f.path = c.path
+	f.lastInSequence = c.lastInSequence
+	f.sequenceMode = c.sequenceMode
+	f.pending = c.pending
+	f.section = c.section
+	f.context = c.context
+	f.source = c.source
+	f.here = c.here
+
+	return f
+}
+
+//**************************************************************
+
+func (c *Compiler) restoreFileState(f fileState) {
+
+	c.file = f.file
+	c.LineNum = f.lineNum
+	c.items = f.items
+	c.refs = f.refs
+	c.relns = f.relns
+	c.state = f.state
+	c.alias = f.alias
+	c.itemCounter = f.itemCounter
+	c.relnCounter = f.relnCounter
+	c.path = f.path
+	c.lastInSequence = f.lastInSequence
+	c.sequenceMode = f.sequenceMode
+	c.pending = f.pending
+	c.section = f.section
+	c.context = f.context
+	c.source = f.source
+	c.here = f.here
+}
+
+//**************************************************************
+
+func (c *Compiler) statement(s Statement) bool {
+
+	switch s.Role {
+
+	case ROLE_CONTEXT,ROLE_CONTEXT_ADD:
+		c.checkSequenceMode(s.Value,'+')
+		c.state = s.Role
+		c.assessGrammarCompletions(s.Value,c.state)
+
+	case ROLE_CONTEXT_SUBTRACT:
+		c.checkSequenceMode(s.Value,'-')
+		c.state = s.Role
+		c.assessGrammarCompletions(s.Value,c.state)
+
+	case ROLE_SECTION:
+		c.state = ROLE_SECTION
+		c.assessGrammarCompletions(s.Value,c.state)
+
+	case ROLE_RELATION:
+		link,ok := c.relationLink(s.Relation)
+
+		if !ok {
+			return false
+		}
+
+		c.state = ROLE_RELATION
+		c.relns["THIS"] = append(c.relns["THIS"],link)
+		c.relnCounter++
+
+	case ROLE_DITTO: // prior reference
+		result,ok := c.lookupAlias("","PREV",c.itemCounter)
+
+		if !ok {
+			return false
+		}
+
+		c.items["THIS"] = append(c.items["THIS"],result)
+		c.storeAlias(result)
+		c.assessGrammarCompletions(result,c.state)
+		c.state = ROLE_EVENT
+		c.itemCounter++
+
+	case ROLE_LINE_ALIAS:
+		c.state = ROLE_LINE_ALIAS
+		c.alias = s.Value
+
+	case ROLE_LOOKUP:
+		actual,ok := c.lookupAlias(s.AliasFile,s.Alias,s.Index)
+
+		if !ok {
+			return false
+		}
+
+		c.items["THIS"] = append(c.items["THIS"],actual)
+		c.PVerbose("fyi, line reference",s.Text,"resolved to",actual)
+		c.assessGrammarCompletions(actual,c.state)
+		c.state = ROLE_LOOKUP
+		c.itemCounter++
+
+	case ROLE_INCLUDE:
+		return c.includeFile(s.Value)
+
+	default:
+		c.items["THIS"] = append(c.items["THIS"],s.Text)
+		c.storeAlias(s.Text)
+		c.assessGrammarCompletions(s.Text,c.state)
+
+		c.state = ROLE_EVENT
+		c.itemCounter++
+	}
+
+	return true
+}
+
+//**************************************************************
+
+func (c *Compiler) assessGrammarCompletions(token string,prior_state int) {
+
+	if len(token) == 0 {
+		return
+	}
+
+	this_item := token
+
+	switch prior_state {
+
+	case ROLE_RELATION:
+
+		last_item := c.items["THIS"][c.itemCounter-2]
+		last_reln := c.relns["THIS"][c.relnCounter-1]
+		last_iptr := c.refs[c.itemCounter-2]
+		this_iptr := c.handleNode(this_item)
+		c.idempAddLink(last_item,last_iptr,last_reln,this_item,this_iptr)
+
+	case ROLE_CONTEXT:
+		c.Box("Reset context: ->",this_item)
+		c.contextEval(this_item,"=")
+
+	case ROLE_CONTEXT_ADD:
+		c.PVerbose("Add to context:",this_item)
+		c.contextEval(this_item,"+")
+
+	case ROLE_CONTEXT_SUBTRACT:
+		c.PVerbose("Remove from context:",this_item)
+		c.contextEval(this_item,"-")
+
+	case ROLE_SECTION:
+		c.Box("Set chapter/section: ->",this_item)
+		c.sequenceMode = false
+		c.section = this_item
+
+	default:
+		if AllCaps(token) {
+			c.Warning(WARN_NOTE_TO_SELF+" ("+token+")")
+		}
+
+		c.handleNode(this_item)
+		c.linkUpStorySequence(this_item)
+	}
+}
+
+//**************************************************************
+
+func (c *Compiler) storeAlias(name string) {
+
+	if c.alias != "" {
+		c.PVerbose("-- Storing alias",c.items[c.alias],name,"as",c.alias)
+		c.items[c.alias] = append(c.items[c.alias],name)
+	}
+}
+
+//**************************************************************
+
+func (c *Compiler) idempAddLink(from string,frptr NodeRef,link Link,to string,toptr NodeRef) {
+
+	if from == to {
+		c.Error(ERR_ARROW_SELFLOOP)
+		return
+	}
+
+	if link.Weight != 1 {
+		c.PVerbose("... Relation:",from,"--(",c.Config.Arrows[link.Arrow].Long,",",link.Weight,")->",to,link.Context)
+	} else {
+		c.PVerbose("... Relation:",from,"--",c.Config.Arrows[link.Arrow].Long,"->",to,link.Context)
+	}
+
+	// For the page map
+
+	link.Dst = toptr
+	c.path = append(c.path,link)
+	link.Dst = nil
+
+	c.AddLink(frptr,link,toptr)
+
+	// Double up the reverse definition for easy indexing of both in/out arrows
+	// But be careful not the make the graph undirected by mistake
+
+	c.addInverseLink(toptr,link,frptr)
+}
+
+//**************************************************************
+
+func (c *Compiler) addInverseLink(toptr NodeRef,link Link,frptr NodeRef) {
+
+	// The link read backwards, if its arrow has an inverse
+
+	inverse,ok := c.Config.Inverse(c.Config.Arrows[link.Arrow].Short)
+
+	if !ok {
+		return
+	}
+
+	invlink,ok := c.linkArrowByName(inverse.Short)
+
+	if ok {
+		c.AddLink(toptr,invlink,frptr)
+	}
+}
+
+//**************************************************************
+
+func (c *Compiler) handleNode(annotated string) NodeRef {
+
+	clean_ptr,clean_version := c.idempAddNode(annotated)
+
+	c.PVerbose("Event/item/node:",clean_version,"in chapter",c.section)
+
+	c.refs = append(c.refs,clean_ptr)
+
+	if len(clean_version) != len(annotated) {
+		c.addBackAnnotations(clean_version,clean_ptr,annotated)
+	}
+
+	return clean_ptr
+}
+
+//**************************************************************
+
+func (c *Compiler) idempAddNode(s string) (NodeRef,string) {
+
+	clean_version := StripAnnotations(s,c.Config.Annotations,nil)
+
+	c.checkAltCaps(clean_version)
+
+	iptr := c.AddNode(clean_version,s,c.section)
+
+	// For the page map
+
+	if c.path == nil {
+		c.path = append(c.path,Link{Dst: iptr})
+	}
+
+	return iptr,clean_version
+}
+
+//**************************************************************
+
+func (c *Compiler) checkAltCaps(text string) {
+
+	// The same node spelled with different capitals is probably a
+	// mistake, but is kept as a node of its own
+
+	lower := strings.ToLower(text)
+
+	if !c.texts[text] && c.lower[lower] {
+		c.Warning(WARN_DIFFERENT_CAPITALS+" ("+text+")")
+	}
+
+	c.texts[text] = true
+	c.lower[lower] = true
+}
+
+//**************************************************************
+
+func (c *Compiler) endLine() {
+
+	if c.AddPageLine != nil && len(c.path) > 0 {
+
+		var page PageLine
+
+		page.File = c.file
+		page.Chapter = c.section
+		page.Alias = c.alias
+		page.Context = c.getContext(nil)
+		page.Line = c.LineNum
+		page.Path = c.path
+
+		c.AddPageLine(page)
+	}
+
+	c.LineNum++
+
+	// If this line was not blank, overwrite previous settings and reset
+
+	if c.state != ROLE_BLANK_LINE {
+
+		if c.items["THIS"] != nil {
+			c.items["PREV"] = c.items["THIS"]
+		}
+		if c.relns["THIS"] != nil {
+			c.relns["PREV"] = c.relns["THIS"]
+		}
+	}
+
+	c.items["THIS"] = nil
+	c.relns["THIS"] = nil
+	c.refs = nil
+	c.itemCounter = 1
+	c.relnCounter = 0
+	c.alias = ""
+	c.path = nil
+
+	c.state = ROLE_BLANK_LINE
+}
+
+//**************************************************************
+
+func (c *Compiler) checkSequenceMode(context string,mode rune) {
+
+	if strings.Contains(context,"_sequence_") {
+
+		switch mode {
+		case '+':
+			c.PVerbose("\nStart sequence mode for items")
+			c.sequenceMode = true
+			c.lastInSequence = ""
+
+		case '-':
+			c.PVerbose("End sequence mode for items\n")
+			c.sequenceMode = false
+		}
+	}
+}
+
+//**************************************************************
+
+func (c *Compiler) linkUpStorySequence(this string) {
+
+	// Join together a sequence of nodes using default "(then)"
+
+	if c.sequenceMode && this != c.lastInSequence {
+
+		if c.itemCounter == 1 && c.lastInSequence != "" {
+
+			c.PVerbose("* ... Sequence addition: ",c.lastInSequence,"-(",SEQUENCE_RELN,")->",this,"\n")
+
+			last_iptr,_ := c.idempAddNode(c.lastInSequence)
+			this_iptr,_ := c.idempAddNode(this)
+			link,ok := c.linkArrowByName("("+SEQUENCE_RELN+")")
+
+			if ok {
+				c.AddLink(last_iptr,link,this_iptr)
+				c.addInverseLink(this_iptr,link,last_iptr)
+			}
+		}
+
+		c.lastInSequence = this
+	}
+}
+
+//**************************************************************
+
+func (c *Compiler) linkArrowByName(token string) (Link,bool) {
+
+	// A link by the name of its arrow, with the context in force
+
+	rel,err := ParseRelation(token)
+
+	if err != nil {
+		c.Error(err.Error())
+		return Link{},false
+	}
+
+	return c.relationLink(rel)
+}
+
+//**************************************************************
+
+func (c *Compiler) relationLink(rel Relation) (Link,bool) {
+
+	// By short name first, then long
+
+	ptr,ok := c.Config.short[rel.Name]
+
+	if !ok {
+		ptr,ok = c.Config.long[rel.Name]
+
+		if !ok {
+			c.Error(ERR_NO_SUCH_ARROW+"("+rel.Name+")")
+			return Link{},false
+		}
+	}
+
+	var link Link
+
+	link.Arrow = ptr
+	link.Weight = rel.Weight
+	link.Context = c.getContext(rel.Context)
+
+	return link,true
+}
+
+//**************************************************************
+
+func (c *Compiler) lookupAlias(file,alias string,counter int) (string,bool) {
+
+	// $alias.n in this file, or $file:alias.n in one read before
+
+	value,err := c.Files.LookupAlias(c.file,c.items,file,alias,counter)
+
+	if err != nil {
+		c.Error(err.Error())
+		return "",false
+	}
+
+	return value,true
+}
+
+//**************************************************************
+
+func (c *Compiler) includeFile(name string) bool {
+
+	// Read another file where the #include stands, as if it were
+	// given on the command line, then carry on with this one
+
+	path,unread,err := c.Files.IncludeFile(c.file,name)
+
+	if err != nil {
+		c.Error(err.Error())
+		return false
+	}
+
+	if !unread {
+		c.PVerbose("Already read",path)
+		return true
+	}
+
+	// What this file has labelled so far can be used there too
+
+	c.Files.ExportAliases(c.file,c.items)
+	saved := c.saveFileState()
+
+	c.Compile(path)
+
+	c.restoreFileState(saved)
+	c.enter()
+
+	return true
+}
+
+//**************************************************************
+
+func (c *Compiler) addBackAnnotations(cleantext string,cleanptr NodeRef,annotated string) {
+
+	reminder := fmt.Sprintf("%.30s...",cleantext)
+	c.PVerbose("\n        Checking annotations from \""+reminder+"\"")
+
+	for _,a := range FindAnnotations(annotated,c.Config.Annotations,nil) {
+		link,ok := c.linkArrowByName(a.Arrow)
+
+		if !ok {
+			continue
+		}
+
+		this_iptr,_ := c.idempAddNode(a.Word)
+		c.idempAddLink(reminder,cleanptr,link,a.Word,this_iptr)
+	}
+}
+
+//**************************************************************
+// Context logic
+//**************************************************************
+
+func (c *Compiler) contextEval(s,op string) {
+
+	or_parts := ContextParts(s)
+
+	// +,-,= on the context in force
+
+	switch op {
+
+	case "=":
+		c.context = make(map[string]bool)
+		c.modContext(or_parts,"+")
+	default:
+		c.modContext(or_parts,op)
+	}
+}
+
+//**************************************************************
+
+func (c *Compiler) getContext(ctx []string) []string {
+
+	var merge = make(map[string]bool)
+	var clist []string
+
+	for cn := range c.context {
+		merge[cn] = true
+	}
+
+	for _,cn := range ctx {
+		merge[cn] = true
+	}
+
+	for cn := range merge {
+		clist = append(clist,cn)
+	}
+
+	sort.Strings(clist)
+
+	return clist
+}
+
+//**************************************************************
+
+func (c *Compiler) modContext(list []string,op string) {
+
+	for or_frag := range list {
+
+		frag := strings.TrimSpace(list[or_frag])
+
+		if len(frag) == 0 {
+			continue
+		}
+
+		switch op {
+		case "+":
+			c.context[frag] = true
+
+		case "-": // to remove, we also need to look at children
+			for cand := range c.context {
+				and_parts := SplitWithParensIntact(cand,'.')
+
+				for part := range and_parts {
+
+					if strings.Contains(and_parts[part],frag) {
+						delete(c.context,cand)
+					}
+				}
+			}
+		}
+	}
+}
+
+//**************************************************************
+// Diagnostics and the verbose account
+//**************************************************************
+
+func (c *Compiler) reportUpto(upto Position) {
+
+	// Report what the parser found, in order, as the compilation
+	// reaches it
+
+	for len(c.pending) > 0 && !upto.Before(c.pending[0].Pos) {
+
+		d := c.pending[0]
+		c.pending = c.pending[1:]
+		c.report(d)
+	}
+}
+
+//**************************************************************
+
+func (c *Compiler) report(d Diagnostic) {
+
+	c.LineNum = d.Pos.Line
+	c.Reporter.Report(d)
+}
+
+//**************************************************************
+
+func (c *Compiler) Error(message string) {
+
+	c.report(c.source.Diagnose(c.here,ERROR,message))
+}
+
+//**************************************************************
+
+func (c *Compiler) Warning(message string) {
+
+	c.report(c.source.Diagnose(c.here,WARNING,message))
+}
+
+//**************************************************************
+
+func (c *Compiler) PVerbose(a ...interface{}) {
+
+	const green = "\x1b[36m"
+	const endgreen = "\x1b[0m"
+
+	if c.Verbose {
+		fmt.Fprint(c.Out,c.LineNum,":\t",green)
+		fmt.Fprintln(c.Out,a...)
+		fmt.Fprint(c.Out,endgreen)
+	}
+}
+
+//**************************************************************
+
+func (c *Compiler) Box(a ...interface{}) {
+
+	if c.Verbose {
+
+		fmt.Fprint(c.Out,"\n------------------------------------\n")
+		fmt.Fprintln(c.Out,a...)
+		fmt.Fprint(c.Out,"------------------------------------\n\n")
+	}
+}
@@ -0,0 +1,417 @@
+//**************************************************************
+//
+// config.go
+//
+// The configuration (N4Lconfig.in) declares the arrows, by
+// section, and the annotation marks that notes may use
+//
+//**************************************************************
+
+package N4L
+
+import (
+	"io"
+	"strings"
+	"unicode"
+)
+
+//**************************************************************
+
+type ArrowDef struct {
+
+	Pos     Position
+	Section string   // leadsto, contains, properties or similarity
+	Sign    string   // "+", "-" or "both"
+	Long    string
+	Short   string
+}
+
+//**************************************************************
+
+type MarkDef struct {
+
+	Pos     Position
+	Mark    string
+	Arrow   string
+}
+
+//**************************************************************
+
+type Config struct {
+
+	Arrows      []ArrowDef         // in the order declared, mandatory ones first
+	Marks       []MarkDef          // annotation marks, in the order declared
+	Annotations map[string]string  // mark -> arrow name
+
+	short       map[string]int
+	long        map[string]int
+	inverse     map[int]int
+	fwd         int                // the last + arrow, which the next - one reverses
+}
+
+//**************************************************************
+
+func MandatoryArrows() []ArrowDef {
+
+	// Arrows every graph has, whatever the configuration says
+
+	return []ArrowDef{
+		{Section: "leadsto",Sign: "+",Short: "empty",Long: "debug"},
+		{Section: "leadsto",Sign: "-",Short: "void",Long: "unbug"},
+		{Section: "leadsto",Sign: "+",Short: SEQUENCE_RELN,Long: SEQUENCE_RELN},
+		{Section: "leadsto",Sign: "-",Short: "prev",Long: "follows on from"},
+		{Section: "properties",Sign: "+",Short: "url",Long: "has URL"},
+		{Section: "properties",Sign: "-",Short: "isurl",Long: "is a URL for"},
+		{Section: "properties",Sign: "+",Short: "img",Long: "has image"},
+		{Section: "properties",Sign: "-",Short: "isimg",Long: "is an image for"},
+	}
+}
+
+//**************************************************************
+
+func NewConfig() *Config {
+
+	var cfg Config
+
+	cfg.Annotations = make(map[string]string)
+	cfg.short = make(map[string]int)
+	cfg.long = make(map[string]int)
+	cfg.inverse = make(map[int]int)
+
+	for _,def := range MandatoryArrows() {
+		cfg.add(def)
+	}
+
+	return &cfg
+}
+
+//**************************************************************
+
+func (cfg *Config) Arrow(name string) (ArrowDef,bool) {
+
+	// By short name first, then long
+
+	if i,ok := cfg.short[name]; ok {
+		return cfg.Arrows[i],true
+	}
+
+	if i,ok := cfg.long[name]; ok {
+		return cfg.Arrows[i],true
+	}
+
+	return ArrowDef{},false
+}
+
+//**************************************************************
+
+func (cfg *Config) Inverse(name string) (ArrowDef,bool) {
+
+	// The arrow that reads a link the other way, which for
+	// similarity is the arrow itself
+
+	i,ok := cfg.short[name]
+
+	if !ok {
+		i,ok = cfg.long[name]
+	}
+
+	if !ok {
+		return ArrowDef{},false
+	}
+
+	j,ok := cfg.inverse[i]
+
+	if !ok {
+		return ArrowDef{},false
+	}
+
+	return cfg.Arrows[j],true
+}
+
+//**************************************************************
+
+func (cfg *Config) add(def ArrowDef) {
+
+	cfg.Arrows = append(cfg.Arrows,def)
+	index := len(cfg.Arrows)-1
+
+	switch def.Sign {
+	case "+":
+		cfg.fwd = index
+	case "-":
+		cfg.inverse[cfg.fwd] = index
+		cfg.inverse[index] = cfg.fwd
+	default:
+		cfg.inverse[index] = index
+	}
+
+	if _,ok := cfg.short[def.Short]; !ok {
+		cfg.short[def.Short] = len(cfg.Arrows)-1
+	}
+
+	if _,ok := cfg.long[def.Long]; !ok {
+		cfg.long[def.Long] = len(cfg.Arrows)-1
+	}
+}
+
+//**************************************************************
+
+type configParser struct {
+
+	*lexer
+	cfg     *Config
+	state   int
+	section string
+	fwd     string
+	bwd     string
+	mark    string
+}
+
+//**************************************************************
+
+func ParseConfigFile(filename string) (*Config,[]Diagnostic) {
+
+	f,diags := openSource(filename)
+
+	if f == nil {
+		return NewConfig(),diags
+	}
+
+	defer f.Close()
+
+	return ParseConfig(f)
+}
+
+//**************************************************************
+
+func ParseConfig(r io.Reader) (*Config,[]Diagnostic) {
+
+	name,text,err := readSource(r)
+
+	if err != nil {
+		d := Diagnostic{Pos: Position{File: name,Line: 1,Column: 1},Severity: ERROR,Message: err.Error()}
+		return NewConfig(),[]Diagnostic{d}
+	}
+
+	return ParseConfigString(name,text)
+}
+
+//**************************************************************
+
+func ParseConfigString(file,text string) (*Config,[]Diagnostic) {
+
+	var p configParser
+
+	p.lexer = newLexer(file,text)
+	p.cfg = NewConfig()
+	p.state = ROLE_BLANK_LINE
+	p.lexer.endLine = p.endLine
+
+	for pos := 0; pos < len(p.src); {
+
+		pos = p.skipWhiteSpace(pos)
+
+		if pos >= len(p.src) {
+			break
+		}
+
+		start := pos
+		stop := rune(ALPHATEXT)
+
+		if p.src[pos] == '(' {
+			stop = ')'
+		}
+
+		token,next,ok := p.readToLast(pos,stop)
+
+		if ok {
+			ok = p.classify(token,start)
+		}
+
+		if !ok {
+			p.state = ROLE_BLANK_LINE
+			next = p.endOfLine(start)
+		}
+
+		if next <= start {
+			next = start+1
+		}
+
+		pos = next
+	}
+
+	p.endLine(len(p.src))
+
+	return p.cfg,p.diags
+}
+
+//**************************************************************
+
+func (p *configParser) endLine(pos int) {
+
+	if p.state == HAVE_PLUS {
+		p.report(pos,WARNING,ERR_MISSING_EVENT)
+	}
+
+	p.state = ROLE_BLANK_LINE
+}
+
+//**************************************************************
+
+func (p *configParser) classify(token string,start int) bool {
+
+	if len(token) == 0 {
+		return true
+	}
+
+	if token[0] == '-' && p.state == ROLE_BLANK_LINE {
+		p.section = strings.TrimSpace(token[1:])
+		p.state = ROLE_SECTION
+		return true
+	}
+
+	switch p.section {
+
+	case "leadsto","contains","properties":
+
+		switch token[0] {
+
+		case '+':
+			p.fwd = strings.TrimSpace(token[1:])
+			p.state = HAVE_PLUS
+
+		case '-':
+			p.bwd = strings.TrimSpace(token[1:])
+			p.state = HAVE_MINUS
+
+		case '(':
+			def := ArrowDef{Pos: p.position(start),Section: p.section}
+			def.Short = strings.TrimSpace(token[1:len(token)-1])
+
+			switch p.state {
+
+			case HAVE_PLUS:
+				def.Sign = "+"
+				def.Long = p.fwd
+
+			case HAVE_MINUS:
+				def.Sign = "-"
+				def.Long = p.bwd
+
+			default:
+				p.report(start,ERROR,ERR_BAD_ABBRV)
+				return false
+			}
+
+			if !p.checkArrow(def,start) {
+				return false
+			}
+
+			p.cfg.add(def)
+		}
+
+	case "similarity":
+
+		switch token[0] {
+
+		case '(':
+			if p.state != HAVE_MINUS {
+				p.report(start,WARNING,ERR_BAD_ABBRV)
+				return true
+			}
+
+			def := ArrowDef{Pos: p.position(start),Section: p.section,Sign: "both",Long: p.bwd}
+			def.Short = strings.TrimSpace(token[1:len(token)-1])
+			p.cfg.add(def)
+
+		case '+','-':
+			p.report(start,ERROR,ERR_SIMILAR_NO_SIGN)
+			return false
+
+		default:
+			p.fwd = strings.TrimSpace(token)
+			p.bwd = p.fwd
+			p.state = HAVE_MINUS
+		}
+
+	case "annotations":
+
+		switch token[0] {
+
+		case '(':
+			if p.state != HAVE_PLUS {
+				p.report(start,WARNING,ERR_ANNOTATION_MISSING)
+			}
+
+			arrow := StripParen(token)
+			value,defined := p.cfg.Annotations[p.mark]
+
+			if defined && value != arrow {
+				p.report(start,ERROR,ERR_ANNOTATION_REDEFINE)
+				return false
+			}
+
+			p.cfg.Annotations[p.mark] = arrow
+			p.cfg.Marks = append(p.cfg.Marks,MarkDef{Pos: p.position(start),Mark: p.mark,Arrow: arrow})
+			p.state = ROLE_BLANK_LINE
+
+		default:
+			for _,r := range token {
+				if unicode.IsLetter(r) {
+					p.report(start,WARNING,ERR_ANNOTATION_BAD)
+					break
+				}
+			}
+
+			if token[0] == '+' || token[0] == '-' {
+				p.report(start,ERROR,ERR_ILLEGAL_ANNOT_CHAR)
+				return false
+			}
+
+			p.state = HAVE_PLUS
+			p.mark = token
+		}
+
+	default:
+		p.report(start,ERROR,ERR_ILLEGAL_CONFIGURATION+" "+p.section)
+		return false
+	}
+
+	return true
+}
+
+//**************************************************************
+
+func (p *configParser) checkArrow(def ArrowDef,start int) bool {
+
+	// Each name can only mean one arrow
+
+	if prev,ok := p.cfg.short[def.Short]; ok {
+		p.report(start,ERROR,ERR_ARR_REDEFINITION+"\""+def.Short+"\" previous short name: "+p.cfg.Arrows[prev].Short)
+		return false
+	}
+
+	if prev,ok := p.cfg.long[def.Long]; ok {
+		p.report(start,ERROR,ERR_ARR_REDEFINITION+"\""+def.Long+"\" previous long name: "+p.cfg.Arrows[prev].Long)
+		return false
+	}
+
+	return true
+}
+
+//**************************************************************
+
+func StripParen(token string) string {
+
+	token = strings.TrimSpace(token[1:])
+
+	if len(token) > 0 && token[0] == '(' {
+		token = strings.TrimSpace(token[1:])
+	}
+
+	if len(token) > 0 && token[len(token)-1] == ')' {
+		token = token[:len(token)-1]
+	}
+
+	return token
+}
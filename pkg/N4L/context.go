@@ -0,0 +1,187 @@
+//**************************************************************
+//
+// context.go
+//
+// Context expressions, as in :: a, b.c, (d|e) ::, where commas
+// and bars are OR and dots or ampersands are AND
+//
+//**************************************************************
+
+package N4L
+
+import (
+	"regexp"
+	"strings"
+)
+
+//**************************************************************
+
+var (
+	or_marks = regexp.MustCompile("[|,]+")
+	and_marks = regexp.MustCompile("[&]+")
+	dots = regexp.MustCompile("[.]+")
+)
+
+//**************************************************************
+
+func ExtractContextExpression(token string) string {
+
+	// The expression between the colons of :: ... ::
+
+	var expression string
+
+	s := strings.Split(token, ":")
+
+	for i := 1; i < len(s); i++ {
+		if len(s[i]) > 1 {
+			expression = strings.TrimSpace(s[i])
+			break
+		}
+	}
+
+	return expression
+}
+
+//**************************************************************
+
+func ContextParts(expr string) []string {
+
+	// The OR-parts of an expression, each a context of its own
+
+	var parts []string
+
+	for _,frag := range SplitWithParensIntact(CleanExpression(expr),'|') {
+
+		frag = strings.TrimSpace(frag)
+
+		if len(frag) > 0 {
+			parts = append(parts,frag)
+		}
+	}
+
+	return parts
+}
+
+//**************************************************************
+
+func CleanExpression(s string) string {
+
+	s = TrimParen(s)
+	s = or_marks.ReplaceAllString(s,"|")
+	s = and_marks.ReplaceAllString(s,".")
+	s = dots.ReplaceAllString(s,".")
+
+	return s
+}
+
+// ***********************************************************************
+
+func SplitWithParensIntact(expr string,split_ch byte) []string {
+
+	var token string = ""
+	var set []string
+
+	for c := 0; c < len(expr); c++ {
+
+		switch expr[c] {
+
+		case split_ch:
+			set = append(set,token)
+			token = ""
+
+		case '(':
+			subtoken,offset := Paren(expr,c)
+
+			if offset < 0 {
+				token += expr[c:]
+				c = len(expr)
+				break
+			}
+
+			token += subtoken
+			c = offset-1
+
+		default:
+			token += expr[c:c+1]  // a byte at a time, keeping UTF-8 intact
+		}
+	}
+
+	if len(token) > 0 {
+		set = append(set,token)
+	}
+
+	return set
+}
+
+// ***********************************************************************
+
+func Paren(s string, offset int) (string,int) {
+
+	var level int = 0
+
+	for c := offset; c < len(s); c++ {
+
+		if s[c] == '(' {
+			level++
+			continue
+		}
+
+		if s[c] == ')' {
+			level--
+			if level == 0 {
+				token := s[offset:c+1]
+				return token, c+1
+			}
+		}
+	}
+
+	return "bad expression", -1
+}
+
+// ***********************************************************************
+
+func TrimParen(s string) string {
+
+	var level int = 0
+	var trim = true
+
+	if len(s) == 0 {
+		return s
+	}
+
+	s = strings.TrimSpace(s)
+
+	if s[0] != '(' {
+		return s
+	}
+
+	for c := 0; c < len(s); c++ {
+
+		if s[c] == '(' {
+			level++
+			continue
+		}
+
+		if level == 0 && c < len(s)-1 {
+			trim = false
+		}
+
+		if s[c] == ')' {
+			level--
+
+			if level == 0 && c == len(s)-1 {
+
+				var token string
+
+				if trim {
+					token = s[1:len(s)-1]
+				} else {
+					token = s
+				}
+				return token
+			}
+		}
+	}
+
+	return s
+}
@@ -0,0 +1,281 @@
+//**************************************************************
+//
+// diagnostic.go
+//
+// Errors and warnings, written for people (with an excerpt of
+// the source and a caret under the place) or for editors (JSON)
+//
+//**************************************************************
+
+package N4L
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+//**************************************************************
+
+type jsonDiagnostic struct {
+
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+	Source   string `json:"source,omitempty"`
+}
+
+//**************************************************************
+
+type Reporter struct {
+
+	// Collects the errors and warnings of a compilation, printing
+	// each as it is found, or keeping them all for one JSON array
+
+	Out       io.Writer
+	JSON      bool
+	MaxErrors int              // stop after this many errors, 0 for no limit
+	LogFile   func() string    // if set, where to append each one too, e.g. a test log
+
+	Errors    int
+	Warnings  int
+
+	reported  []Diagnostic
+}
+
+//**************************************************************
+
+func NewReporter(out io.Writer) *Reporter {
+
+	var r Reporter
+
+	r.Out = out
+
+	return &r
+}
+
+//**************************************************************
+
+func (r *Reporter) Report(d Diagnostic) {
+
+	const red = "\033[31;1;1m"
+	const endred = "\033[0m"
+
+	if r.Stopped() {
+		return
+	}
+
+	if d.Severity == ERROR {
+		r.Errors++
+	} else {
+		r.Warnings++
+	}
+
+	if r.LogFile != nil {
+		r.log(d)
+	}
+
+	if r.JSON {
+		r.reported = append(r.reported,d)
+	} else {
+		fmt.Fprintln(r.Out,"\n"+red+d.String()+endred)
+		fmt.Fprint(r.Out,d.Excerpt())
+	}
+}
+
+//**************************************************************
+
+func (r *Reporter) Stopped() bool {
+
+	// Whether there have been as many errors as we were asked to take
+
+	return r.MaxErrors > 0 && r.Errors >= r.MaxErrors
+}
+
+//**************************************************************
+
+func (r *Reporter) End() bool {
+
+	// Say what was found, and whether any of it was an error, which
+	// should stop the program
+
+	if r.JSON {
+		fmt.Fprintln(r.Out,JSONDiagnostics(r.reported))
+	} else if r.Errors+r.Warnings > 0 {
+		if r.Stopped() {
+			fmt.Fprintln(r.Out,"\nN4L: too many errors, stopping")
+		}
+		fmt.Fprintf(r.Out,"\nN4L: %d error(s), %d warning(s)\n",r.Errors,r.Warnings)
+	}
+
+	r.reported = nil
+
+	return r.Errors > 0
+}
+
+//**************************************************************
+
+func (r *Reporter) Finish() {
+
+	// Say what was found, and stop the program if any of it was an
+	// error
+
+	if r.End() {
+		os.Exit(-1)
+	}
+}
+
+//**************************************************************
+
+func (r *Reporter) log(d Diagnostic) {
+
+	name := r.LogFile()
+	f,err := os.OpenFile(name,os.O_APPEND|os.O_CREATE|os.O_WRONLY,0644)
+
+	if err != nil {
+		fmt.Fprintln(r.Out,"Couldn't open for write/append to",name,err)
+		return
+	}
+
+	fmt.Fprintf(f,"%d:%s\n",d.Pos.Line,d.String())
+	f.Close()
+}
+
+//**************************************************************
+
+func (d Diagnostic) String() string {
+
+	return fmt.Sprintf("%s: %s: %s",d.Pos,SeverityName(d.Severity),plainMessage(d.Message))
+}
+
+//**************************************************************
+
+func (d Diagnostic) Excerpt() string {
+
+	// The source line, and a caret under the column, e.g.
+	//
+	//    12 | A (then) B
+	//       |   ^
+
+	if d.Source == "" {
+		return ""
+	}
+
+	gutter := fmt.Sprintf("%5d | ",d.Pos.Line)
+	margin := strings.Repeat(" ",len(gutter)-2)+"| "
+
+	// Keep any tabs, so the caret lines up however they are shown
+
+	var pad []rune
+
+	for i,r := range []rune(d.Source) {
+
+		if i >= d.Pos.Column-1 {
+			break
+		}
+
+		if r == '\t' {
+			pad = append(pad,'\t')
+		} else {
+			pad = append(pad,' ')
+		}
+	}
+
+	return gutter+d.Source+"\n"+margin+string(pad)+"^\n"
+}
+
+//**************************************************************
+
+func SeverityName(severity int) string {
+
+	if severity == ERROR {
+		return "error"
+	}
+
+	return "warning"
+}
+
+//**************************************************************
+
+func HasErrors(diags []Diagnostic) bool {
+
+	for _,d := range diags {
+		if d.Severity == ERROR {
+			return true
+		}
+	}
+
+	return false
+}
+
+//**************************************************************
+
+func JSONDiagnostics(diags []Diagnostic) string {
+
+	// An array, one object per diagnostic, for editors and scripts
+
+	var list = []jsonDiagnostic{}
+
+	for _,d := range diags {
+
+		var j jsonDiagnostic
+
+		j.File = d.Pos.File
+		j.Line = d.Pos.Line
+		j.Column = d.Pos.Column
+		j.Severity = SeverityName(d.Severity)
+		j.Message = plainMessage(d.Message)
+		j.Source = d.Source
+		list = append(list,j)
+	}
+
+	data,_ := json.MarshalIndent(list,""," ")
+
+	return string(data)
+}
+
+//**************************************************************
+
+func (doc *Document) Diagnose(pos Position,severity int,message string) Diagnostic {
+
+	// A diagnostic about the document found by the program that
+	// reads it, rather than the parser
+
+	var d Diagnostic
+
+	d.Pos = pos
+	d.Severity = severity
+	d.Message = message
+
+	if pos.Line > 0 && pos.Line <= len(doc.Source) {
+		d.Source = doc.Source[pos.Line-1]
+	}
+
+	return d
+}
+
+//**************************************************************
+
+func plainMessage(message string) string {
+
+	// Some messages say what they are, which the severity now does
+
+	return strings.TrimPrefix(message,"WARNING: ")
+}
+
+//**************************************************************
+
+func sourceLines(text string) []string {
+
+	lines := strings.Split(text,"\n")
+
+	for i := range lines {
+		lines[i] = strings.TrimRight(lines[i],"\r")
+	}
+
+	return lines
+}
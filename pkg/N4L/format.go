@@ -0,0 +1,502 @@
+//**************************************************************
+//
+// format.go
+//
+// The canonical layout of N4L notes, as written by n4lfmt: the
+// relations of neighbouring lines in columns, :: context :: lines,
+// "" for quotes and " for ditto, and the comments kept
+//
+//**************************************************************
+
+package N4L
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/width"
+)
+
+//**************************************************************
+
+const (
+	ERR_FORMAT_CHANGES_MEANING = "Formatting would change the meaning of this line, so the file is left alone"
+)
+
+//**************************************************************
+
+type formatLine struct {
+
+	fields  []string  // label and item, then relation and item in turn
+	text    string    // or the whole line, if it can't go in columns
+	comment string
+	aligned bool
+}
+
+//**************************************************************
+
+func Format(file,text string) (string,[]Diagnostic) {
+
+	// The notes in canonical form, unless they can't be parsed. The
+	// arrows don't matter here, so there's no configuration
+
+	doc,diags := ParseString(file,text,nil)
+
+	if HasErrors(diags) {
+		return "",diags
+	}
+
+	formatted := formatDocument(doc)
+
+	// Never change what the notes say
+
+	again,_ := ParseString(file,formatted,nil)
+
+	if pos,same := sameMeaning(doc,again); !same {
+		return "",append(diags,doc.Diagnose(pos,ERROR,ERR_FORMAT_CHANGES_MEANING))
+	}
+
+	return formatted,diags
+}
+
+//**************************************************************
+
+func formatDocument(doc *Document) string {
+
+	var out []string
+	var block []formatLine
+
+	flush := func() {
+		out = append(out,alignBlock(block)...)
+		block = nil
+	}
+
+	starts := make(map[int]Line)
+
+	for _,line := range doc.Lines {
+		starts[line.Pos.Line] = line
+	}
+
+	for n := 1; n <= len(doc.Source); n++ {
+
+		source := strings.TrimRightFunc(doc.Source[n-1],unicode.IsSpace)
+		line,ok := starts[n]
+
+		if !ok {
+			trimmed := strings.TrimSpace(source)
+
+			switch {
+
+			case trimmed == "":
+				flush()
+				out = append(out,"")
+
+			case source == trimmed:
+				block = append(block,formatLine{text: source})
+
+			default:
+				block = append(block,formatLine{text: " "+trimmed})
+			}
+			continue
+		}
+
+		f,last := formatStatements(doc,line)
+
+		if f.aligned {
+			block = append(block,f)
+		} else {
+			flush()
+			out = append(out,f.text)
+		}
+
+		n = last
+	}
+
+	flush()
+
+	return joinLines(out)
+}
+
+//**************************************************************
+
+func formatStatements(doc *Document,line Line) (formatLine,int) {
+
+	// One line of statements, and the last line of the source it
+	// took up, which is further on after a quote over several lines
+
+	var f formatLine
+	var words []string
+	var label string
+
+	f.aligned = true
+	last := line.Statements[len(line.Statements)-1]
+	end := last.End()
+
+	// If the parser passed over anything, like the rest of a
+	// quote that ended too soon, leave the line as it was
+
+	if !wellSpaced(doc,line) {
+		var lines []string
+
+		for n := line.Pos.Line; n <= end.Line && n <= len(doc.Source); n++ {
+			lines = append(lines,strings.TrimRightFunc(doc.Source[n-1],unicode.IsSpace))
+		}
+
+		f.aligned = false
+		f.text = strings.Join(lines,"\n")
+		return f,end.Line
+	}
+
+	for i,s := range line.Statements {
+
+		var word string
+
+		switch s.Role {
+
+		case ROLE_SECTION:
+			word = "-"
+			if s.Value != "" {
+				word = "- "+s.Value
+			}
+
+		case ROLE_CONTEXT,ROLE_CONTEXT_ADD,ROLE_CONTEXT_SUBTRACT:
+			word,f.comment = formatContext(s)
+
+		case ROLE_RELATION:
+			word = "("+strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(s.Text,"("),")"))+")"
+
+		case ROLE_DITTO:
+			word = "\""
+
+		case ROLE_INCLUDE:
+			word = INCLUDE_DIRECTIVE+" \""+s.Value+"\""
+
+			if strings.Contains(s.Value,"\"") {
+				word = INCLUDE_DIRECTIVE+" '"+s.Value+"'"
+			}
+
+		case ROLE_LINE_ALIAS:
+			if i == 0 {
+				label = s.Text
+				continue
+			}
+			word = s.Text
+
+		default:
+			word = s.Text
+
+			if s.Quoted {
+				word = quoteItem(doc,s)
+			}
+		}
+
+		words = append(words,word)
+	}
+
+	// Items and relations in turn go in columns, anything else
+	// as it comes
+
+	for i,s := range line.Statements {
+
+		if label != "" && i == 0 {
+			continue
+		}
+
+		k := len(f.fields)
+
+		relation := s.Role == ROLE_RELATION
+		item := s.Role == ROLE_EVENT || s.Role == ROLE_DITTO || s.Role == ROLE_LOOKUP
+
+		if (k % 2 == 0 && !item) || (k % 2 == 1 && !relation) || strings.Contains(s.Text,"\n") {
+			f.aligned = false
+		}
+
+		f.fields = append(f.fields,words[len(f.fields)])
+	}
+
+	if len(f.fields) == 0 {
+		f.aligned = false
+	}
+
+	if f.aligned {
+
+		if label == "" {
+			f.fields[0] = " "+f.fields[0]
+		} else {
+			f.fields[0] = label+" "+f.fields[0]
+		}
+
+	} else {
+		f.text = strings.Join(words," ")
+
+		if label != "" {
+			f.text = label+" "+f.text
+		}
+
+		if line.Statements[0].Role == ROLE_EVENT || line.Statements[0].Role == ROLE_DITTO || line.Statements[0].Role == ROLE_LOOKUP {
+			f.text = " "+f.text
+		}
+	}
+
+	// Whatever follows the last statement, most likely a comment
+
+	if end.Line <= len(doc.Source) {
+
+		rest := []rune(doc.Source[end.Line-1])
+
+		if end.Column-1 < len(rest) {
+			if after := strings.TrimSpace(string(rest[end.Column-1:])); after != "" {
+				f.comment = after
+			}
+		}
+	}
+
+	if !f.aligned && f.comment != "" {
+		f.text += "  "+f.comment
+	}
+
+	return f,end.Line
+}
+
+//**************************************************************
+
+func wellSpaced(doc *Document,line Line) bool {
+
+	// Nothing but space between the statements of a line, and all
+	// of each quote in its text
+
+	for i,s := range line.Statements {
+
+		end := s.End()
+
+		if end.Line > len(doc.Source) {
+			continue
+		}
+
+		src := []rune(doc.Source[end.Line-1])
+
+		if end.Column-1 > len(src) {
+			return false
+		}
+
+		if s.Quoted && end.Line == s.Pos.Line && string(src[s.Pos.Column:end.Column-2]) != s.Text {
+			return false
+		}
+
+		// Up to the next statement, or a comment
+
+		var gap string
+
+		if i+1 < len(line.Statements) {
+
+			next := line.Statements[i+1].Pos
+
+			if next.Line != end.Line {
+				continue
+			}
+
+			gap = string(src[end.Column-1:next.Column-1])
+
+		} else {
+			gap = strings.TrimSpace(string(src[end.Column-1:]))
+
+			if strings.HasPrefix(gap,"#") || strings.HasPrefix(gap,"//") {
+				gap = ""
+			}
+		}
+
+		if strings.TrimSpace(gap) != "" {
+			return false
+		}
+	}
+
+	return true
+}
+
+//**************************************************************
+
+func formatContext(s Statement) (string,string) {
+
+	// :: expression ::, and anything after the last colon, which
+	// the token runs on to
+
+	var sign string
+
+	switch s.Role {
+	case ROLE_CONTEXT_ADD:
+		sign = "+"
+	case ROLE_CONTEXT_SUBTRACT:
+		sign = "-"
+	}
+
+	var rest string
+
+	if i := strings.LastIndex(s.Text,":"); i >= 0 {
+		rest = strings.TrimSpace(s.Text[i+1:])
+	}
+
+	if s.Value == "" {
+		return sign+":: ::",rest
+	}
+
+	return sign+":: "+s.Value+" ::",rest
+}
+
+//**************************************************************
+
+func quoteItem(doc *Document,s Statement) string {
+
+	// Double quotes, unless the text has them in it
+
+	quote := "\""
+
+	if strings.Contains(s.Text,"\"") {
+
+		quote = "'"
+
+		if strings.Contains(s.Text,"'") {
+
+			// Both, so only the original will do
+
+			src := []rune(doc.Source[s.Pos.Line-1])
+			quote = string(src[s.Pos.Column-1])
+		}
+	}
+
+	return quote+s.Text+quote
+}
+
+//**************************************************************
+
+func alignBlock(block []formatLine) []string {
+
+	// Pad each column to the widest of its neighbours, counting
+	// wide characters (like Chinese) twice, as they are shown
+
+	var widths []int
+	var out []string
+
+	for _,f := range block {
+		for k := 0; k < len(f.fields)-1; k++ {
+
+			if k >= len(widths) {
+				widths = append(widths,0)
+			}
+
+			widths[k] = max(widths[k],DisplayWidth(f.fields[k]))
+		}
+	}
+
+	for _,f := range block {
+
+		if f.fields == nil {
+			out = append(out,f.text)
+			continue
+		}
+
+		var line string
+
+		for k,field := range f.fields {
+
+			line += field
+
+			if k < len(f.fields)-1 {
+				line += strings.Repeat(" ",widths[k]-DisplayWidth(field)+1)
+			}
+		}
+
+		if f.comment != "" {
+			line += "  "+f.comment
+		}
+
+		out = append(out,line)
+	}
+
+	return out
+}
+
+//**************************************************************
+
+func DisplayWidth(s string) int {
+
+	var w int
+
+	for _,r := range s {
+
+		switch {
+
+		case unicode.Is(unicode.Mn,r):
+			// combining accents take no room
+
+		case width.LookupRune(r).Kind() == width.EastAsianWide,width.LookupRune(r).Kind() == width.EastAsianFullwidth:
+			w += 2
+
+		default:
+			w++
+		}
+	}
+
+	return w
+}
+
+//**************************************************************
+
+func joinLines(lines []string) string {
+
+	// No more than one blank line at a time, and none at the ends
+
+	var out []string
+
+	for _,l := range lines {
+
+		if l == "" && (len(out) == 0 || out[len(out)-1] == "") {
+			continue
+		}
+
+		out = append(out,l)
+	}
+
+	for len(out) > 0 && out[len(out)-1] == "" {
+		out = out[:len(out)-1]
+	}
+
+	if len(out) == 0 {
+		return ""
+	}
+
+	return strings.Join(out,"\n")+"\n"
+}
+
+//**************************************************************
+
+func sameMeaning(a,b *Document) (Position,bool) {
+
+	// The same statements, on the same lines, saying the same things
+
+	for i,line := range a.Lines {
+
+		if i >= len(b.Lines) || len(line.Statements) != len(b.Lines[i].Statements) {
+			return line.Pos,false
+		}
+
+		for j,s := range line.Statements {
+
+			t := b.Lines[i].Statements[j]
+
+			if s.Role != t.Role || s.Value != t.Value || s.Alias != t.Alias || s.Index != t.Index {
+				return s.Pos,false
+			}
+
+			if fmt.Sprint(s.Relation) != fmt.Sprint(t.Relation) || fmt.Sprint(s.Context) != fmt.Sprint(t.Context) {
+				return s.Pos,false
+			}
+		}
+	}
+
+	if len(b.Lines) > len(a.Lines) {
+		return Position{File: a.File,Line: len(a.Source),Column: 1},false
+	}
+
+	return Position{},true
+}
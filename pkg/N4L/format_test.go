@@ -0,0 +1,102 @@
+//**************************************************************
+//
+// format_test.go
+//
+// n4lfmt on the examples: formatting is stable, and the notes
+// mean the same after it
+//
+//**************************************************************
+
+package N4L
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+//**************************************************************
+
+func withoutPositions(doc *Document) []Line {
+
+	// What the lines say, wherever they are on the page. The text
+	// as written of a section or context is laid out anew
+
+	var lines []Line
+
+	for _,line := range doc.Lines {
+
+		var l Line
+
+		for _,s := range line.Statements {
+
+			s.Pos = Position{}
+			s.Text = ""
+
+			var marks []Annotation
+
+			for _,a := range s.Annotations {
+				a.Pos = Position{}
+				marks = append(marks,a)
+			}
+
+			s.Annotations = marks
+			l.Statements = append(l.Statements,s)
+		}
+
+		lines = append(lines,l)
+	}
+
+	return lines
+}
+
+//**************************************************************
+
+func TestFormatExamples(t *testing.T) {
+
+	files,_ := filepath.Glob("../../examples/*.n4l")
+
+	if len(files) == 0 {
+		t.Skip("no examples")
+	}
+
+	cfg,diags := ParseConfigFile("../../examples/N4Lconfig.in")
+
+	if HasErrors(diags) {
+		t.Fatalf("examples configuration: %v",diags)
+	}
+
+	for _,file := range files {
+
+		text,err := os.ReadFile(file)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Some examples have mistakes, and are left alone
+
+		once,diags := Format(file,string(text))
+
+		if HasErrors(diags) {
+			if once != "" {
+				t.Errorf("%s: formatted in spite of %v",file,diags[0])
+			}
+			continue
+		}
+
+		twice,_ := Format(file,once)
+
+		if twice != once {
+			t.Errorf("%s: formatting again changes it",file)
+		}
+
+		before,_ := ParseString(file,string(text),cfg)
+		after,_ := ParseString(file,once,cfg)
+
+		if !reflect.DeepEqual(withoutPositions(before),withoutPositions(after)) {
+			t.Errorf("%s: the formatted notes parse differently",file)
+		}
+	}
+}
@@ -0,0 +1,5 @@
+module N4L
+
+go 1.24.2
+
+require golang.org/x/text v0.24.0
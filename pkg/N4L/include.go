@@ -0,0 +1,245 @@
+//**************************************************************
+//
+// include.go
+//
+// Notes split over several files: #include "other.n4l" reads
+// another file where it stands, and $other:label.n refers to a
+// label of a file that has been read before
+//
+//**************************************************************
+
+package N4L
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+//**************************************************************
+
+func IncludePath(from,name string) string {
+
+	// An included file is found relative to the file including it
+
+	if filepath.IsAbs(name) {
+		return filepath.Clean(name)
+	}
+
+	return filepath.Join(filepath.Dir(from),name)
+}
+
+//**************************************************************
+
+func FileAlias(filename string) string {
+
+	// The name other files use for this one, in $name:label.n,
+	// which is the file name without its directory or extension.
+	// Two files of the same name in different directories share it,
+	// and a reference to it is then ambiguous
+
+	base := filepath.Base(filename)
+
+	return strings.TrimSuffix(base,filepath.Ext(base))
+}
+
+//**************************************************************
+
+func (doc *Document) Includes() []Statement {
+
+	var list []Statement
+
+	for _,line := range doc.Lines {
+		for _,s := range line.Statements {
+			if s.Role == ROLE_INCLUDE {
+				list = append(list,s)
+			}
+		}
+	}
+
+	return list
+}
+
+//**************************************************************
+
+func (doc *Document) AliasFilePath(name string) (string,bool) {
+
+	// Where to look for the labels of $name:label.n, without
+	// compiling: a file this one includes, or name.n4l beside it.
+	// The compilers refuse a name shared by two of the files read
+
+	for _,s := range doc.Includes() {
+
+		path := IncludePath(doc.File,s.Value)
+
+		if FileAlias(path) == name {
+			return path,true
+		}
+	}
+
+	path := IncludePath(doc.File,name+".n4l")
+
+	if _,err := os.Stat(path); err == nil {
+		return path,true
+	}
+
+	return "",false
+}
+
+//**************************************************************
+
+func AbsPath(filename string) string {
+
+	abs,err := filepath.Abs(filename)
+
+	if err != nil {
+		return filename
+	}
+
+	return abs
+}
+
+//**************************************************************
+// The files of one compilation
+//**************************************************************
+
+type FileSet struct {
+
+	// Which files have been read, which are being read, each
+	// included by the one before, and the labels each left behind
+
+	including []string
+	read      map[string]bool                           // by absolute path
+	labels    map[string]map[string]map[string][]string // FileAlias -> absolute path -> label -> items
+	names     map[string]string                         // absolute path -> as given
+}
+
+//**************************************************************
+
+func NewFileSet() *FileSet {
+
+	var fs FileSet
+
+	fs.read = make(map[string]bool)
+	fs.labels = make(map[string]map[string]map[string][]string)
+	fs.names = make(map[string]string)
+
+	return &fs
+}
+
+//**************************************************************
+
+func (fs *FileSet) AlreadyRead(filename string) bool {
+
+	return fs.read[AbsPath(filename)]
+}
+
+//**************************************************************
+
+func (fs *FileSet) Enter(filename string) {
+
+	fs.including = append(fs.including,filename)
+	fs.read[AbsPath(filename)] = true
+}
+
+//**************************************************************
+
+func (fs *FileSet) Leave(filename string,labels map[string][]string) {
+
+	fs.ExportAliases(filename,labels)
+	fs.including = fs.including[:len(fs.including)-1]
+}
+
+//**************************************************************
+
+func (fs *FileSet) ExportAliases(filename string,labels map[string][]string) {
+
+	// Keep the labels of a file, for $file:label.n in the files
+	// read after it, or included by it from here on
+
+	kept := make(map[string][]string)
+
+	for name,items := range labels {
+		if name != "THIS" && name != "PREV" {
+			kept[name] = items
+		}
+	}
+
+	alias := FileAlias(filename)
+	abs := AbsPath(filename)
+
+	if fs.labels[alias] == nil {
+		fs.labels[alias] = make(map[string]map[string][]string)
+	}
+
+	fs.labels[alias][abs] = kept
+	fs.names[abs] = filename
+}
+
+//**************************************************************
+
+func (fs *FileSet) IncludeFile(from,name string) (string,bool,error) {
+
+	// The file an #include in from refers to, and whether to read
+	// it now: not if it has been read already
+
+	path := IncludePath(from,name)
+
+	for i,f := range fs.including {
+		if AbsPath(f) == AbsPath(path) {
+			return path,false,errors.New(ERR_INCLUDE_CYCLE+strings.Join(append(fs.including[i:],path)," -> "))
+		}
+	}
+
+	if _,err := os.Stat(path); err != nil {
+		return path,false,errors.New(ERR_NO_SUCH_FILE_FOUND+path)
+	}
+
+	return path,!fs.AlreadyRead(path),nil
+}
+
+//**************************************************************
+
+func (fs *FileSet) LookupAlias(current string,cache map[string][]string,file,alias string,counter int) (string,error) {
+
+	// $alias.n in the current file, whose labels so far are in the
+	// cache, or $file:alias.n in one read before. The current file
+	// goes by its own name, whatever else shares it
+
+	if file != "" && file != FileAlias(current) {
+
+		files := fs.labels[file]
+
+		switch len(files) {
+
+		case 0:
+			return "",errors.New(ERR_NO_SUCH_FILE_ALIAS+file)
+
+		case 1:
+			for _,labels := range files {
+				cache = labels
+			}
+
+		default:
+			var paths []string
+
+			for abs := range files {
+				paths = append(paths,fs.names[abs])
+			}
+
+			sort.Strings(paths)
+
+			return "",errors.New(ERR_AMBIGUOUS_FILE_ALIAS+strings.Join(paths,", "))
+		}
+	}
+
+	value,ok := cache[alias]
+
+	if !ok || counter > len(value) {
+		return "",errors.New(ERR_NO_SUCH_ALIAS)
+	}
+
+	return value[counter-1],nil
+}
@@ -0,0 +1,239 @@
+//**************************************************************
+//
+// include_test.go
+//
+// Notes over several files: finding included files, loops of
+// includes, and $file:label.n across them
+//
+//**************************************************************
+
+package N4L
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//**************************************************************
+
+func writeFiles(t *testing.T,files map[string]string) string {
+
+	// Make the files in a new directory, and return it
+
+	t.Helper()
+
+	dir := t.TempDir()
+
+	for name,text := range files {
+
+		path := filepath.Join(dir,name)
+
+		if err := os.MkdirAll(filepath.Dir(path),0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path,[]byte(text),0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+//**************************************************************
+
+type testLink struct {
+	From,Arrow,To string
+}
+
+//**************************************************************
+
+func compileFiles(t *testing.T,dir string,files ...string) (*Reporter,[]testLink,string) {
+
+	// Compile with the mandatory arrows only, keeping the links
+	// made, by text, and what was reported
+
+	t.Helper()
+
+	var out bytes.Buffer
+	var links []testLink
+
+	c := NewCompiler(NewReporter(&out))
+
+	c.AddNode = func(text,annotated,chapter string) NodeRef {
+		return text
+	}
+
+	c.AddLink = func(from NodeRef,link Link,to NodeRef) {
+		links = append(links,testLink{from.(string),c.Config.Arrows[link.Arrow].Short,to.(string)})
+	}
+
+	for _,f := range files {
+
+		f = filepath.Join(dir,f)
+
+		if !c.Files.AlreadyRead(f) {
+			c.Compile(f)
+		}
+	}
+
+	return c.Reporter,links,out.String()
+}
+
+//**************************************************************
+
+func hasLink(links []testLink,from,arrow,to string) bool {
+
+	for _,l := range links {
+		if l == (testLink{from,arrow,to}) {
+			return true
+		}
+	}
+
+	return false
+}
+
+//**************************************************************
+
+func TestIncludeAndFileAlias(t *testing.T) {
+
+	dir := writeFiles(t,map[string]string{
+		"main.n4l": "-main\n\n#include \"sub/fruit.n4l\"\n\n $fruit:basket.2 (then) banana\n",
+		"sub/fruit.n4l": "-fruit\n\n @basket apple (then) pear\n",
+		"later.n4l": "-later\n\n $fruit:basket.1 (then) cherry\n",
+	})
+
+	r,links,out := compileFiles(t,dir,"main.n4l","sub/fruit.n4l","later.n4l")
+
+	if r.Errors+r.Warnings > 0 {
+		t.Fatalf("unexpected diagnostics:\n%s",out)
+	}
+
+	for _,want := range []testLink{{"apple","then","pear"},{"pear","then","banana"},{"apple","then","cherry"}} {
+		if !hasLink(links,want.From,want.Arrow,want.To) {
+			t.Errorf("no link %v in %v",want,links)
+		}
+	}
+
+	// The included file is read once, though given again
+
+	var n int
+
+	for _,l := range links {
+		if l == (testLink{"apple","then","pear"}) {
+			n++
+		}
+	}
+
+	if n != 1 {
+		t.Errorf("apple (then) pear made %d times",n)
+	}
+}
+
+//**************************************************************
+
+func TestIncludeErrors(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		files   map[string]string
+		compile []string
+		message string
+	}{
+		{"loop",
+			map[string]string{
+				"a.n4l": "-a\n\n#include \"b/b.n4l\"\n",
+				"b/b.n4l": "-b\n\n#include \"../a.n4l\"\n",
+			},
+			[]string{"a.n4l"},
+			ERR_INCLUDE_CYCLE},
+		{"missing file",
+			map[string]string{"a.n4l": "-a\n\n#include \"nosuch.n4l\"\n"},
+			[]string{"a.n4l"},
+			ERR_NO_SUCH_FILE_FOUND},
+		{"no quotes",
+			map[string]string{"a.n4l": "-a\n\n#include b.n4l\n"},
+			[]string{"a.n4l"},
+			ERR_BAD_INCLUDE},
+		{"no file of the name",
+			map[string]string{"a.n4l": "-a\n\n $nosuch:label.1\n"},
+			[]string{"a.n4l"},
+			ERR_NO_SUCH_FILE_ALIAS},
+		{"two files of the name",
+			map[string]string{
+				"x/fruit.n4l": "-x\n\n @basket apple\n",
+				"y/fruit.n4l": "-y\n\n @basket pear\n",
+				"a.n4l": "-a\n\n $fruit:basket.1\n",
+			},
+			[]string{"x/fruit.n4l","y/fruit.n4l","a.n4l"},
+			ERR_AMBIGUOUS_FILE_ALIAS},
+	}
+
+	for _,test := range tests {
+
+		r,_,out := compileFiles(t,writeFiles(t,test.files),test.compile...)
+
+		if r.Errors != 1 || !strings.Contains(out,test.message) {
+			t.Errorf("%s: expected one error %q, got %d:\n%s",test.name,test.message,r.Errors,out)
+		}
+	}
+}
+
+//**************************************************************
+
+func TestIncludeOrComment(t *testing.T) {
+
+	// Only a quoted name, or a bare name.n4l, is an include, and
+	// the latter an error. Anything else is a comment
+
+	tests := []struct {
+		line    string
+		include bool
+	}{
+		{"#include \"a.n4l\"",true},
+		{"  #include   'a.n4l'  # a comment",true},
+		{"#include a.n4l",true},
+		{"#include these notes one day",false},
+		{"#included",false},
+		{"# include \"a.n4l\"",false},
+	}
+
+	for _,test := range tests {
+
+		doc,diags := ParseString("t.n4l",test.line+"\n",nil)
+
+		if got := len(doc.Includes())+len(diags) > 0; got != test.include {
+			t.Errorf("%q: include %v, expected %v",test.line,got,test.include)
+		}
+	}
+}
+
+//**************************************************************
+
+func TestLookupAliasOwnName(t *testing.T) {
+
+	// The current file goes by its own name, even when another file
+	// of the same name has been read
+
+	fs := NewFileSet()
+
+	fs.ExportAliases("x/fruit.n4l",map[string][]string{"basket": {"apple"}})
+	fs.ExportAliases("y/fruit.n4l",map[string][]string{"basket": {"pear"}})
+
+	own := map[string][]string{"basket": {"cherry"}}
+
+	if v,err := fs.LookupAlias("z/fruit.n4l",own,"fruit","basket",1); err != nil || v != "cherry" {
+		t.Errorf("own file gave %q, %v",v,err)
+	}
+
+	if _,err := fs.LookupAlias("a.n4l",nil,"fruit","basket",1); err == nil || !strings.Contains(err.Error(),"x/fruit.n4l, y/fruit.n4l") {
+		t.Errorf("expected the two files of the name, got %v",err)
+	}
+
+	if _,err := fs.LookupAlias("z/fruit.n4l",own,"","basket",2); err == nil {
+		t.Errorf("$basket.2 of one item found")
+	}
+}
@@ -0,0 +1,393 @@
+//**************************************************************
+//
+// lexer.go
+//
+// Splitting N4L text into tokens, shared by notes and the
+// configuration. Positions are kept so that every complaint
+// can point at the place it is about
+//
+//**************************************************************
+
+package N4L
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+//**************************************************************
+
+type lexer struct {
+
+	file    string
+	src     []rune
+	starts  []int        // where each line starts in src
+	lines   []string     // the text as written, for excerpts
+	diags   []Diagnostic
+	endLine func(pos int)
+
+	directives bool     // #include is read, not skipped as a comment
+}
+
+//**************************************************************
+
+func newLexer(file string,text string) *lexer {
+
+	var l lexer
+
+	l.file = file
+	l.src = []rune(text)
+	l.lines = sourceLines(text)
+	l.starts = []int{0}
+
+	// clean unicode nonsense
+
+	for r := range l.src {
+
+		switch l.src[r] {
+		case NON_ASCII_LQUOTE,NON_ASCII_RQUOTE:
+			l.src[r] = '"'
+		case '\n':
+			l.starts = append(l.starts,r+1)
+		}
+	}
+
+	l.endLine = func(int) {}
+
+	return &l
+}
+
+//**************************************************************
+
+func readSource(r io.Reader) (string,string,error) {
+
+	// The text, and the name of the file if it has one
+
+	var name string
+
+	if f,ok := r.(interface{ Name() string }); ok {
+		name = f.Name()
+	}
+
+	content,err := io.ReadAll(r)
+
+	return name,string(content),err
+}
+
+//**************************************************************
+
+func openSource(filename string) (*os.File,[]Diagnostic) {
+
+	f,err := os.Open(filename)
+
+	if err != nil {
+		var d Diagnostic
+		d.Pos = Position{File: filename,Line: 1,Column: 1}
+		d.Severity = ERROR
+		d.Message = ERR_NO_SUCH_FILE_FOUND+filename
+		return nil,[]Diagnostic{d}
+	}
+
+	return f,nil
+}
+
+//**************************************************************
+
+func (l *lexer) position(pos int) Position {
+
+	line := sort.Search(len(l.starts), func(i int) bool { return l.starts[i] > pos })
+
+	return Position{File: l.file,Line: line,Column: pos-l.starts[line-1]+1}
+}
+
+//**************************************************************
+
+func (l *lexer) report(pos int,severity int,message string) {
+
+	var d Diagnostic
+
+	d.Pos = l.position(pos)
+	d.Severity = severity
+	d.Message = message
+
+	if d.Pos.Line <= len(l.lines) {
+		d.Source = l.lines[d.Pos.Line-1]
+	}
+
+	l.diags = append(l.diags,d)
+}
+
+//**************************************************************
+
+func (l *lexer) at(pos int) rune {
+
+	if pos < 0 || pos >= len(l.src) {
+		return 0
+	}
+
+	return l.src[pos]
+}
+
+//**************************************************************
+
+func (l *lexer) endOfLine(pos int) int {
+
+	// Where to carry on after something that can't be read
+
+	for ; pos < len(l.src) && l.src[pos] != '\n'; pos++ {
+	}
+
+	return pos
+}
+
+//**************************************************************
+
+func (l *lexer) skipWhiteSpace(pos int) int {
+
+	for ; pos < len(l.src) && IsWhiteSpace(l.src[pos],l.src[pos]); pos++ {
+
+		if l.src[pos] == '\n' {
+			l.endLine(pos)
+		} else {
+
+			if l.src[pos] == '#' && l.directives && l.isDirective(pos) {
+				break
+			}
+
+			if l.src[pos] == '#' || (l.src[pos] == '/' && l.at(pos+1) == '/') {
+
+				for ; pos < len(l.src) && l.src[pos] != '\n'; pos++ {
+				}
+
+				l.endLine(pos)
+			}
+		}
+	}
+
+	return pos
+}
+
+//**************************************************************
+
+func (l *lexer) isDirective(pos int) bool {
+
+	// #include "file" at the start of a line, rather than a comment.
+	// Without the quoted name, it is an old comment that happens to
+	// start with the word, unless all that follows is a name.n4l,
+	// which is an include missing its quotes
+
+	for i := pos-1; i >= 0 && l.src[i] != '\n'; i-- {
+		if !unicode.IsSpace(l.src[i]) {
+			return false
+		}
+	}
+
+	word := []rune(INCLUDE_DIRECTIVE)
+
+	for i,r := range word {
+		if l.at(pos+i) != r {
+			return false
+		}
+	}
+
+	pos += len(word)
+
+	for ; pos < len(l.src) && l.src[pos] != '\n' && unicode.IsSpace(l.src[pos]); pos++ {
+	}
+
+	if IsQuote(l.at(pos)) {
+		return true
+	}
+
+	start := pos
+
+	for ; pos < len(l.src) && l.src[pos] != '\n' && !unicode.IsSpace(l.src[pos]); pos++ {
+	}
+
+	name := string(l.src[start:pos])
+
+	for ; pos < len(l.src) && l.src[pos] != '\n' && unicode.IsSpace(l.src[pos]); pos++ {
+	}
+
+	return strings.HasSuffix(name,".n4l") && (pos == len(l.src) || l.src[pos] == '\n')
+}
+
+//**************************************************************
+
+func (l *lexer) readToLast(pos int,stop rune) (string,int,bool) {
+
+	// Read a token up to the stop character, or the end of a text
+	// item if ALPHATEXT. False if the token is malformed
+
+	var cpy []rune
+	var start = pos
+
+	for ; pos < len(l.src); pos++ {
+
+		collect,stray := l.collect(pos,stop,cpy)
+
+		if stray {
+			l.report(pos,ERROR,ERR_STRAY_PAREN)
+			return "",pos,false
+		}
+
+		if !collect {
+			break
+		}
+
+		cpy = append(cpy,l.src[pos])
+	}
+
+	if IsQuote(stop) && l.at(pos-1) != stop || IsQuote(stop) && pos-1 == start {
+		e := fmt.Sprintf("%s starting at line %d (found token %s)",ERR_MISMATCH_QUOTE,l.position(start).Line,string(cpy))
+		l.report(start,ERROR,e)
+		return "",pos,false
+	}
+
+	return strings.TrimSpace(string(cpy)),pos,true
+}
+
+//**************************************************************
+
+func (l *lexer) collect(pos int,stop rune,cpy []rune) (bool,bool) {
+
+	// Whether to take the character at pos, and if it is a stray )
+
+	// Quoted strings are tricky
+
+	if IsQuote(stop) {
+
+		var is_end bool
+
+		if pos+1 >= len(l.src) {
+			is_end = true
+		} else {
+			is_end = IsWhiteSpace(l.src[pos],l.src[pos+1])
+		}
+
+		return !(l.at(pos-1) == stop && is_end && len(cpy) > 1),false
+	}
+
+	if pos >= len(l.src) || l.src[pos] == '\n' {
+		return false,false
+	}
+
+	if stop == ALPHATEXT {
+		return l.isGeneralString(pos)
+	}
+
+	// a ::: cluster is special, we don't care how many
+
+	if stop != ':' {
+		return !l.lastSpecialChar(pos,stop),false
+	}
+
+	var groups int = 0
+
+	for r := 1; r < len(cpy)-1; r++ {
+
+		if cpy[r] != ':' && cpy[r-1] == ':' {
+			groups++
+		}
+
+		if cpy[r] != '"' && cpy[r-1] == '"' {
+			groups++
+		}
+	}
+
+	if groups > 1 {
+		return !l.lastSpecialChar(pos,stop),false
+	}
+
+	return true,false
+}
+
+//**************************************************************
+
+func (l *lexer) isGeneralString(pos int) (bool,bool) {
+
+	switch l.src[pos] {
+
+	case ')':
+		return false,true
+	case '(':
+		return false,false
+	case '#':
+		return false,false
+	case '\n':
+		return false,false
+
+	case '/':
+		if l.at(pos+1) == '/' {
+			return false,false
+		}
+	}
+
+	return true,false
+}
+
+//**************************************************************
+
+func (l *lexer) lastSpecialChar(pos int,stop rune) bool {
+
+	if l.src[pos] == '\n' {
+		if stop != '"' {
+			return true
+		}
+	}
+
+	// Special case, but still don't understand why?!
+
+	if l.src[pos] == '@' {
+		return false
+	}
+
+	if pos > 0 && l.src[pos-1] == stop && l.src[pos] != stop {
+		return true
+	}
+
+	return false
+}
+
+//**************************************************************
+
+func (l *lexer) isBackReference(pos int) bool {
+
+	// Any non-whitespace before \n or ( means it's not a back reference
+
+	for pos++; pos < len(l.src); pos++ {
+
+		if l.src[pos] == '(' || l.src[pos] == '\n' || l.src[pos] == '#' {
+			return true
+		} else {
+			if !unicode.IsSpace(l.src[pos]) {
+				return false
+			}
+		}
+	}
+
+	return false
+}
+
+//**************************************************************
+
+func IsWhiteSpace(r,rn rune) bool {
+
+	return (unicode.IsSpace(r) || r == '#' || r == '/' && rn == '/')
+}
+
+//**************************************************************
+
+func IsQuote(r rune) bool {
+
+	switch r {
+	case '"','\'',NON_ASCII_LQUOTE,NON_ASCII_RQUOTE:
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,584 @@
+//**************************************************************
+//
+// parse.go
+//
+// Reading notes into a Document. Each token on a line becomes a
+// statement with a role, and the grammar of a line (an item, then
+// relation and item in turn) is checked here, so the compilers
+// only have to give the statements their meaning
+//
+//**************************************************************
+
+package N4L
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+//**************************************************************
+
+type parser struct {
+
+	*lexer
+	cfg     *Config
+	doc     Document
+	line    Line
+	state   int      // the role of the last statement on the line
+	items   int      // on this line
+	section string
+}
+
+//**************************************************************
+
+func Parse(r io.Reader,cfg *Config) (*Document,[]Diagnostic) {
+
+	// Read N4L notes. Without a configuration, arrow names can't be
+	// checked and items have no annotations
+
+	name,text,err := readSource(r)
+
+	if err != nil {
+		d := Diagnostic{Pos: Position{File: name,Line: 1,Column: 1},Severity: ERROR,Message: err.Error()}
+		return &Document{File: name},[]Diagnostic{d}
+	}
+
+	return ParseString(name,text,cfg)
+}
+
+//**************************************************************
+
+func ParseFile(filename string,cfg *Config) (*Document,[]Diagnostic) {
+
+	f,diags := openSource(filename)
+
+	if f == nil {
+		return &Document{File: filename},diags
+	}
+
+	defer f.Close()
+
+	return Parse(f,cfg)
+}
+
+//**************************************************************
+
+func ParseString(file,text string,cfg *Config) (*Document,[]Diagnostic) {
+
+	var p parser
+
+	p.lexer = newLexer(file,text)
+	p.cfg = cfg
+	p.doc.File = file
+	p.doc.Source = p.lines
+	p.state = ROLE_BLANK_LINE
+	p.lexer.endLine = p.endLine
+	p.lexer.directives = true
+
+	for pos := 0; pos < len(p.src); {
+
+		pos = p.skipWhiteSpace(pos)
+
+		if pos >= len(p.src) {
+			break
+		}
+
+		start := pos
+		token,quoted,next,ok := p.getToken(pos)
+
+		if ok {
+			ok = p.classify(token,quoted,start)
+		}
+
+		if !ok {
+			next = p.skipLine(start)
+		}
+
+		if next <= start {
+			next = start+1   // never stuck
+		}
+
+		pos = next
+	}
+
+	p.endLine(len(p.src))
+
+	return &p.doc,p.diags
+}
+
+//**************************************************************
+
+func (p *parser) skipLine(start int) int {
+
+	// Give up on the line where something went wrong, but not on
+	// the rest of the file
+
+	p.line = Line{}
+	p.state = ROLE_BLANK_LINE
+	p.items = 0
+
+	return p.endOfLine(start)
+}
+
+//**************************************************************
+
+func (p *parser) endLine(pos int) {
+
+	switch p.state {
+
+	case ROLE_RELATION,ROLE_LINE_ALIAS:
+		p.report(pos,WARNING,ERR_MISSING_EVENT)
+	}
+
+	if len(p.line.Statements) > 0 {
+		p.line.End = p.position(pos)
+		p.doc.Lines = append(p.doc.Lines,p.line)
+	}
+
+	p.line = Line{}
+	p.state = ROLE_BLANK_LINE
+	p.items = 0
+}
+
+//**************************************************************
+
+func (p *parser) getToken(pos int) (string,bool,int,bool) {
+
+	// The next token, whether it was quoted, where to carry on,
+	// and false if it can't be read
+
+	var token string
+	var ok = true
+
+	switch p.src[pos] {
+
+	case '+','-':  // could be +:: or -:: or -section
+
+		if p.at(pos+1) == ':' {
+			token,pos,ok = p.readToLast(pos,':')
+		} else {
+			token,pos,ok = p.readToLast(pos,ALPHATEXT)
+		}
+
+	case ':':
+		token,pos,ok = p.readToLast(pos,':')
+
+	case '(':
+		token,pos,ok = p.readToLast(pos,')')
+
+	case '"','\'':
+		quote := p.src[pos]
+
+		if p.isBackReference(pos) {
+			return "\"",false,pos+1,true
+		}
+
+		if pos+2 < len(p.src) && IsWhiteSpace(p.src[pos+1],p.src[pos+2]) {
+			p.report(pos,ERROR,ERR_ILLEGAL_QUOTED_STRING_OR_REF)
+			return "",false,pos,false
+		}
+
+		token,pos,ok = p.readToLast(pos,quote)
+
+		if ok {
+			token = strings.Split(token,string(quote))[1]
+		}
+
+		return token,true,pos,ok
+
+	case '@':
+		token,pos,ok = p.readToLast(pos,' ')
+
+	case '#': // only #include gets this far
+		token,pos,ok = p.readInclude(pos)
+
+	default: // a text item that could end with any of the above
+		token,pos,ok = p.readToLast(pos,ALPHATEXT)
+	}
+
+	return token,false,pos,ok
+}
+
+//**************************************************************
+
+func (p *parser) readInclude(pos int) (string,int,bool) {
+
+	// #include "file", alone on its line but for a comment. The
+	// lexer only lets it through with the quote, or a bare name.n4l
+
+	start := pos
+	pos += len([]rune(INCLUDE_DIRECTIVE))
+
+	for ; pos < len(p.src) && p.src[pos] != '\n' && unicode.IsSpace(p.src[pos]); pos++ {
+	}
+
+	quote := p.at(pos)
+
+	if !IsQuote(quote) {
+		p.report(pos,ERROR,ERR_BAD_INCLUDE)
+		return "",pos,false
+	}
+
+	for pos++; pos < len(p.src) && p.src[pos] != quote && p.src[pos] != '\n'; pos++ {
+	}
+
+	if p.at(pos) != quote {
+		e := fmt.Sprintf("%s starting at line %d (found token %s)",ERR_MISMATCH_QUOTE,p.position(start).Line,string(p.src[start:pos]))
+		p.report(start,ERROR,e)
+		return "",pos,false
+	}
+
+	pos++
+
+	end := pos
+
+	for ; pos < len(p.src) && p.src[pos] != '\n' && unicode.IsSpace(p.src[pos]); pos++ {
+	}
+
+	if pos < len(p.src) && p.src[pos] != '\n' && !IsWhiteSpace(p.src[pos],p.at(pos+1)) {
+		p.report(pos,ERROR,ERR_BAD_INCLUDE)
+		return "",pos,false
+	}
+
+	return string(p.src[start:end]),end,true
+}
+
+//**************************************************************
+
+func (p *parser) classify(token string,quoted bool,start int) bool {
+
+	// Make a statement of the token, checking it fits on the line
+
+	if len(token) == 0 {
+		return true
+	}
+
+	var s Statement
+
+	s.Pos = p.position(start)
+	s.Text = token
+	s.Quoted = quoted
+
+	if quoted {
+		return p.item(s)
+	}
+
+	if token == "\"" {
+		s.Role = ROLE_DITTO
+		return p.item(s)
+	}
+
+	switch token[0] {
+
+	case ':':
+		s.Role = ROLE_CONTEXT
+		return p.context(s)
+
+	case '+':
+		s.Role = ROLE_CONTEXT_ADD
+		return p.context(s)
+
+	case '-':
+		if token[1:2] == string(':') {
+			s.Role = ROLE_CONTEXT_SUBTRACT
+			return p.context(s)
+		}
+
+		s.Role = ROLE_SECTION
+		s.Value = strings.TrimSpace(token[1:])
+
+		if len(s.Value) > 0 && s.Value[0] == ':' {
+			p.report(start,ERROR,WARN_CHAPTER_CLASS_MIXUP+s.Value)
+			return false
+		}
+
+		if s.Value != "" {
+			p.section = s.Value
+		}
+
+		p.state = ROLE_SECTION
+		p.add(s)
+
+	case '(':
+		if p.state == ROLE_RELATION {
+			p.report(start,ERROR,ERR_MISSING_ITEM_RELN)
+			return false
+		}
+
+		rel,err := ParseRelation(token)
+
+		if err != nil {
+			p.report(start,ERROR,err.Error())
+			return false
+		}
+
+		if p.cfg != nil {
+			if _,defined := p.cfg.Arrow(rel.Name); !defined {
+				p.report(start,ERROR,ERR_NO_SUCH_ARROW+"("+rel.Name+")")
+				return false
+			}
+		}
+
+		s.Role = ROLE_RELATION
+		s.Relation = rel
+		p.state = ROLE_RELATION
+		p.add(s)
+
+	case '@':
+		var contig string
+		fmt.Sscanf(token,"%s",&contig)
+
+		if len(contig) == 1 {
+			p.report(start,ERROR,ERR_BAD_LABEL_OR_REF+token)
+			return false
+		}
+
+		s.Role = ROLE_LINE_ALIAS
+		s.Value = token[1:]
+		p.state = ROLE_LINE_ALIAS
+		p.add(s)
+
+	case '$':
+		return p.lookup(s)
+
+	case '#':
+		return p.include(s)
+
+	default:
+		return p.item(s)
+	}
+
+	return true
+}
+
+//**************************************************************
+
+func (p *parser) add(s Statement) {
+
+	if len(p.line.Statements) == 0 {
+		p.line.Pos = s.Pos
+	}
+
+	p.line.Statements = append(p.line.Statements,s)
+}
+
+//**************************************************************
+
+func (p *parser) context(s Statement) bool {
+
+	s.Value = ExtractContextExpression(s.Text)
+
+	if s.Value != "" {
+
+		if p.section == "" {
+			p.report(p.offset(s.Pos),ERROR,ERR_MISSING_SECTION)
+			return false
+		}
+
+		if strings.Contains(s.Value,"(") {
+			p.report(p.offset(s.Pos),WARNING,WARN_INADVISABLE_CONTEXT_EXPRESSION)
+		}
+
+		s.Context = ContextParts(s.Value)
+	}
+
+	p.state = s.Role
+	p.add(s)
+
+	return true
+}
+
+//**************************************************************
+
+func (p *parser) lookup(s Statement) bool {
+
+	// $label.n is the n-th item on the line labelled @label, but a
+	// lone $ or $$ is just text
+
+	var contig string
+	fmt.Sscanf(s.Text,"%s",&contig)
+
+	if len(contig) == 1 || contig == "$$" {
+		return p.item(s)
+	}
+
+	// $file:label.n is a label of another file
+
+	ref := s.Text[1:]
+
+	if i := strings.Index(ref,":"); i >= 0 {
+
+		s.AliasFile = strings.TrimSpace(ref[:i])
+		ref = ref[i+1:]
+
+		if s.AliasFile == "" {
+			p.report(p.offset(s.Pos),ERROR,ERR_MISSING_LINE_LABEL_IN_REFERENCE)
+			return false
+		}
+	}
+
+	split := strings.Split(ref,".")
+
+	if len(split) < 2 {
+		p.report(p.offset(s.Pos),ERROR,ERR_MISSING_LINE_LABEL_IN_REFERENCE)
+		return false
+	}
+
+	s.Alias = strings.TrimSpace(split[0])
+	fmt.Sscanf(split[1],"%d",&s.Index)
+
+	if s.Index < 1 {
+		p.report(p.offset(s.Pos),ERROR,ERR_BAD_ALIAS_REFERENCE)
+		return false
+	}
+
+	s.Role = ROLE_LOOKUP
+
+	return p.item(s)
+}
+
+//**************************************************************
+
+func (p *parser) include(s Statement) bool {
+
+	// The file is read by the compiler, if and when it gets here
+
+	s.Role = ROLE_INCLUDE
+	s.Value = strings.TrimSpace(strings.TrimPrefix(s.Text,INCLUDE_DIRECTIVE))
+
+	if len(s.Value) > 1 && IsQuote([]rune(s.Value)[0]) {
+		s.Value = strings.TrimSpace(string([]rune(s.Value)[1:len([]rune(s.Value))-1]))
+	}
+
+	if s.Value == "" {
+		p.report(p.offset(s.Pos),ERROR,ERR_BAD_INCLUDE)
+		return false
+	}
+
+	p.state = ROLE_INCLUDE
+	p.add(s)
+
+	return true
+}
+
+//**************************************************************
+
+func (p *parser) item(s Statement) bool {
+
+	// Items, and what stands for them, all complete a relation
+
+	if p.section == "" {
+		p.report(p.offset(s.Pos),ERROR,ERR_MISSING_SECTION)
+		return false
+	}
+
+	if p.state == ROLE_RELATION && p.items == 0 {
+		p.report(p.offset(s.Pos),ERROR,ERR_MISSING_ITEM_SOMEWHERE)
+		return false
+	}
+
+	if s.Role == 0 {
+
+		s.Role = ROLE_EVENT
+		s.Value = s.Text
+
+		if p.cfg != nil && len(p.cfg.Annotations) > 0 {
+
+			var quote int
+
+			if s.Quoted {
+				quote = 1
+			}
+
+			_,s.Annotations = scanAnnotations(s.Text,p.cfg.Annotations, func(message string) {
+				p.report(p.offset(s.Pos)+quote,WARNING,message)
+			})
+
+			for a := range s.Annotations {
+				s.Annotations[a].Pos = s.Pos
+				s.Annotations[a].Pos.Column += s.Annotations[a].Offset+quote
+			}
+		}
+	}
+
+	p.state = ROLE_EVENT
+	p.items++
+	p.add(s)
+
+	return true
+}
+
+//**************************************************************
+
+func (p *parser) offset(pos Position) int {
+
+	return p.starts[pos.Line-1]+pos.Column-1
+}
+
+//**************************************************************
+
+func ParseRelation(token string) (Relation,error) {
+
+	// (name,weight,context...) or just a name. Anything after the name
+	// that isn't a number is context for this link
+
+	var rel Relation
+	var weightcount int
+
+	rel.Weight = 1
+	rel.Name = token
+
+	if len(token) > 1 && token[0] == '(' {
+		rel.Name = token[1:len(token)-1]
+	}
+
+	rel.Name = strings.TrimSpace(rel.Name)
+
+	if strings.Contains(rel.Name,",") {
+
+		reln := strings.Split(rel.Name,",")
+		rel.Name = reln[0]
+
+		for i := 1; i < len(reln); i++ {
+
+			v, err := strconv.ParseFloat(reln[i], 64)
+
+			if err == nil {
+				if v < 0 {
+					return rel,fmt.Errorf("%s%s",ERR_NEGATIVE_WEIGHT,token)
+				}
+				if weightcount > 1 {
+					return rel,fmt.Errorf("%s%s",ERR_TOO_MANY_WEIGHTS,token)
+				}
+				rel.Weight = v
+				weightcount++
+			} else {
+				rel.Context = append(rel.Context,reln[i])
+			}
+		}
+	}
+
+	return rel,nil
+}
+
+//**************************************************************
+
+func AllCaps(s string) bool {
+
+	// A note to self, like TODO
+
+	if len(s) <= WORD_MISTAKE_LEN {
+		return false
+	}
+
+	for _, r := range s {
+		if !unicode.IsUpper(r) && unicode.IsLetter(r) || unicode.IsNumber(r) {
+			return false
+		}
+	}
+
+	return true
+}
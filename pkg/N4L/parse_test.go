@@ -0,0 +1,236 @@
+//**************************************************************
+//
+// parse_test.go
+//
+// The statements, and where they are, that the parser makes of
+// each kind of thing written on a line
+//
+//**************************************************************
+
+package N4L
+
+import (
+	"reflect"
+	"testing"
+)
+
+//**************************************************************
+
+const TEST_CONFIG = `
+- leadsto
+ + leads to (lt) - arriving from (af)
+
+- annotations
+ % (discusses)
+`
+
+//**************************************************************
+
+type wantStatement struct {
+	Role   int
+	Line   int
+	Column int
+	Value  string
+}
+
+//**************************************************************
+
+func testConfig(t *testing.T) *Config {
+
+	t.Helper()
+
+	cfg,diags := ParseConfigString("N4Lconfig.in",TEST_CONFIG)
+
+	if len(diags) > 0 {
+		t.Fatalf("bad test configuration: %v",diags)
+	}
+
+	return cfg
+}
+
+//**************************************************************
+
+func statements(doc *Document) []Statement {
+
+	var all []Statement
+
+	for _,line := range doc.Lines {
+		all = append(all,line.Statements...)
+	}
+
+	return all
+}
+
+//**************************************************************
+
+func TestParseStatements(t *testing.T) {
+
+	// The value of a relation, lookup or ditto is not in Value,
+	// see TestParseDetails
+
+	tests := []struct {
+		name  string
+		notes string
+		want  []wantStatement
+	}{
+		{"section, context and items",
+			"-chapter one\n\n :: a, b ::\n\n apple (lt) pear\n",
+			[]wantStatement{
+				{ROLE_SECTION,1,1,"chapter one"},
+				{ROLE_CONTEXT,3,2,"a, b"},
+				{ROLE_EVENT,5,2,"apple"},
+				{ROLE_RELATION,5,8,""},
+				{ROLE_EVENT,5,13,"pear"},
+			}},
+		{"context changes",
+			"- ch\n +:: x ::\n -:: y ::\n a\n",
+			[]wantStatement{
+				{ROLE_SECTION,1,1,"ch"},
+				{ROLE_CONTEXT_ADD,2,2,"x"},
+				{ROLE_CONTEXT_SUBTRACT,3,2,"y"},
+				{ROLE_EVENT,4,2,"a"},
+			}},
+		{"aliases",
+			"-ch\n @fruit apple pear\n $fruit.2 (lt) banana\n $other:fruit.1\n",
+			[]wantStatement{
+				{ROLE_SECTION,1,1,"ch"},
+				{ROLE_LINE_ALIAS,2,2,"fruit"},
+				{ROLE_EVENT,2,9,"apple pear"},
+				{ROLE_LOOKUP,3,2,""},
+				{ROLE_RELATION,3,11,""},
+				{ROLE_EVENT,3,16,"banana"},
+				{ROLE_LOOKUP,4,2,""},
+			}},
+		{"ditto and quotes",
+			"-ch\n a (lt) b\n \" (lt) \"c (d)\"\n",
+			[]wantStatement{
+				{ROLE_SECTION,1,1,"ch"},
+				{ROLE_EVENT,2,2,"a"},
+				{ROLE_RELATION,2,4,""},
+				{ROLE_EVENT,2,9,"b"},
+				{ROLE_DITTO,3,2,""},
+				{ROLE_RELATION,3,4,""},
+				{ROLE_EVENT,3,9,"c (d)"},
+			}},
+		{"columns count characters",
+			"-ch\n 中文 (lt) b\n",
+			[]wantStatement{
+				{ROLE_SECTION,1,1,"ch"},
+				{ROLE_EVENT,2,2,"中文"},
+				{ROLE_RELATION,2,5,""},
+				{ROLE_EVENT,2,10,"b"},
+			}},
+		{"include",
+			"#include \"x.n4l\"\n-ch\n a\n",
+			[]wantStatement{
+				{ROLE_INCLUDE,1,1,"x.n4l"},
+				{ROLE_SECTION,2,1,"ch"},
+				{ROLE_EVENT,3,2,"a"},
+			}},
+	}
+
+	cfg := testConfig(t)
+
+	for _,test := range tests {
+
+		doc,diags := ParseString("t.n4l",test.notes,cfg)
+
+		if len(diags) > 0 {
+			t.Errorf("%s: unexpected diagnostics %v",test.name,diags)
+			continue
+		}
+
+		var got []wantStatement
+
+		for _,s := range statements(doc) {
+			got = append(got,wantStatement{s.Role,s.Pos.Line,s.Pos.Column,s.Value})
+		}
+
+		if !reflect.DeepEqual(got,test.want) {
+			t.Errorf("%s:\n got %v\nwant %v",test.name,got,test.want)
+		}
+	}
+}
+
+//**************************************************************
+
+func TestParseDetails(t *testing.T) {
+
+	notes := "-ch\n @fruit a %word here\n $other:fruit.1 (lt,0.5,near) \"b\"\n"
+
+	doc,diags := ParseString("t.n4l",notes,testConfig(t))
+
+	if len(diags) > 0 {
+		t.Fatalf("unexpected diagnostics %v",diags)
+	}
+
+	s := statements(doc)
+
+	if len(s) != 6 {
+		t.Fatalf("expected 6 statements, got %d",len(s))
+	}
+
+	// Annotations are placed on the mark within the item
+
+	a := s[2].Annotations
+
+	if len(a) != 1 || a[0].Mark != "%" || a[0].Arrow != "discusses" || a[0].Word != "word" {
+		t.Errorf("annotation %+v",a)
+	} else if a[0].Pos.Line != 2 || a[0].Pos.Column != 11 || a[0].Offset != 2 {
+		t.Errorf("annotation at %v, offset %d, expected t.n4l:2:11, offset 2",a[0].Pos,a[0].Offset)
+	}
+
+	if s[3].AliasFile != "other" || s[3].Alias != "fruit" || s[3].Index != 1 {
+		t.Errorf("lookup %q:%q.%d",s[3].AliasFile,s[3].Alias,s[3].Index)
+	}
+
+	rel := s[4].Relation
+
+	if rel.Name != "lt" || rel.Weight != 0.5 || !reflect.DeepEqual(rel.Context,[]string{"near"}) {
+		t.Errorf("relation %+v",rel)
+	}
+
+	if !s[5].Quoted || s[5].Text != "b" {
+		t.Errorf("quoted item %q, quoted %v",s[5].Text,s[5].Quoted)
+	}
+
+	if doc.Lines[1].Pos != s[1].Pos {
+		t.Errorf("line at %v, its first statement at %v",doc.Lines[1].Pos,s[1].Pos)
+	}
+}
+
+//**************************************************************
+
+func TestParseErrorPositions(t *testing.T) {
+
+	tests := []struct {
+		notes   string
+		line    int
+		column  int
+		message string
+	}{
+		{" a\n",1,2,ERR_MISSING_SECTION},
+		{"-ch\n a (lt) (lt) b\n",2,9,ERR_MISSING_ITEM_RELN},
+		{"-ch\n a (nosuch) b\n",2,4,ERR_NO_SUCH_ARROW+"(nosuch)"},
+		{"-ch\n $fruit.0\n",2,2,ERR_BAD_ALIAS_REFERENCE},
+		{"-ch\n 中文 (lt) (lt)\n",2,10,ERR_MISSING_ITEM_RELN},
+	}
+
+	cfg := testConfig(t)
+
+	for _,test := range tests {
+
+		_,diags := ParseString("t.n4l",test.notes,cfg)
+
+		if len(diags) == 0 {
+			t.Errorf("%q: no error",test.notes)
+			continue
+		}
+
+		d := diags[0]
+
+		if d.Severity != ERROR || d.Message != test.message || d.Pos.Line != test.line || d.Pos.Column != test.column {
+			t.Errorf("%q: got %v, expected t.n4l:%d:%d: error: %s",test.notes,d,test.line,test.column,test.message)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+//**************************************************************
+//
+// query.go
+//
+// Finding things in a parsed document, for tools that work
+// on the notes as they are written, like an editor
+//
+//**************************************************************
+
+package N4L
+
+import (
+	"strings"
+)
+
+//**************************************************************
+
+type Label struct {
+
+	Name  string
+	Pos   Position     // of the @label
+	Items []Statement  // $Name.1, $Name.2, ...
+}
+
+//**************************************************************
+
+func (s Statement) End() Position {
+
+	// Just after the token as it was written, quotes and all
+
+	end := s.Pos
+	text := []rune(s.Text)
+
+	if s.Quoted {
+		end.Column++
+	}
+
+	if n := strings.LastIndex(s.Text,"\n"); n >= 0 {
+		end.Line += strings.Count(s.Text,"\n")
+		end.Column = 1
+		text = []rune(s.Text[n+1:])
+	}
+
+	end.Column += len(text)
+
+	if s.Quoted {
+		end.Column++
+	}
+
+	return end
+}
+
+//**************************************************************
+
+func (doc *Document) StatementAt(pos Position) (Statement,bool) {
+
+	// The statement written over pos, if any
+
+	for _,line := range doc.Lines {
+
+		if line.Pos.Line > pos.Line {
+			break
+		}
+
+		for _,s := range line.Statements {
+
+			if !pos.Before(s.Pos) && pos.Before(s.End()) {
+				return s,true
+			}
+		}
+	}
+
+	return Statement{},false
+}
+
+//**************************************************************
+
+func (doc *Document) Labels() []Label {
+
+	// Every @label, in order, with the items on its line. A label
+	// used again collects more items, as the compiler does
+
+	var labels []Label
+	var index = make(map[string]int)
+
+	for _,line := range doc.Lines {
+
+		var name string
+
+		for _,s := range line.Statements {
+
+			if s.Role == ROLE_LINE_ALIAS {
+
+				name = s.Value
+
+				if _,ok := index[name]; !ok {
+					index[name] = len(labels)
+					labels = append(labels,Label{Name: name,Pos: s.Pos})
+				}
+				continue
+			}
+
+			// Items from $references aren't labelled again
+
+			if name != "" && (s.Role == ROLE_EVENT || s.Role == ROLE_DITTO) {
+				i := index[name]
+				labels[i].Items = append(labels[i].Items,s)
+			}
+		}
+	}
+
+	return labels
+}
+
+//**************************************************************
+
+func (doc *Document) Label(name string) (Label,bool) {
+
+	for _,l := range doc.Labels() {
+		if l.Name == name {
+			return l,true
+		}
+	}
+
+	return Label{},false
+}
@@ -9,14 +9,19 @@ package SSTorytime
 
 import (
 	"database/sql"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"unicode"
 	"sort"
+	"sync"
+	"time"
 	"encoding/json"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 
 )
 
@@ -29,6 +34,7 @@ const (
 	ERR_ILLEGAL_LINK_CLASS="ILLEGAL LINK CLASS"
 	ERR_NO_SUCH_ARROW = "No such arrow has been declared in the configuration: "
 	ERR_MEMORY_DB_ARROW_MISMATCH = "Arrows in database are not in synch (shouldn't happen)"
+	ERR_ARROW_CONFLICT = "Arrow definitions conflict with those already in the database"
 	WARN_DIFFERENT_CAPITALS = "WARNING: Another capitalization exists"
 
 	SCREENWIDTH = 100
@@ -65,6 +71,29 @@ const (
 	GT1024 = 6
 )
 
+//**************************************************************
+// Sentinel errors for the error-returning (Try...) API, so that
+// callers can test with errors.Is(err,SST.ErrNoSuchArrow) etc
+//**************************************************************
+
+var (
+	ErrSTOutOfBounds = errors.New(ERR_ST_OUT_OF_BOUNDS)
+	ErrIllegalLinkClass = errors.New(ERR_ILLEGAL_LINK_CLASS)
+	ErrNoSuchArrow = errors.New(strings.TrimSuffix(ERR_NO_SUCH_ARROW,": "))
+	ErrArrowMismatch = errors.New(ERR_MEMORY_DB_ARROW_MISMATCH)
+	ErrArrowConflict = errors.New(ERR_ARROW_CONFLICT)
+	ErrNoArrowsDefined = errors.New("No arrows have yet been defined, so you can't rely on the arrow names")
+	ErrSelfLoop = errors.New("Self-loops are not allowed")
+	ErrNoSuchNode = errors.New("No such node")
+	ErrTooManyMatches = errors.New("Query returned too many matches (multi-model conflict?)")
+	ErrNegativeWeight = errors.New("A link with a negative weight can't be part of a weighted path")
+	ErrBadDiracNotation = errors.New("Bad Dirac notation, should be <a|b> or <a|context|b>")
+	ErrNoSuchFormat = errors.New("No such export format")
+	ErrDBConnection = errors.New("Unable to connect to the database")
+	ErrDBSchema = errors.New("Unable to create database schema")
+	ErrDBQuery = errors.New("Database query failed")
+)
+
 //**************************************************************
 
 type Node struct {
@@ -111,6 +140,24 @@ type PageMap struct {  // Thereis additional intent in the layout
 	Context []string
 	Line    int
 	Path    []Link
+	File    string  // source file, for provenance
+}
+
+//**************************************************************
+
+type LinkKey struct {  // a link independent of its weight and context
+
+	NFrom NodePtr
+	Arr   ArrowPtr
+	NTo   NodePtr
+}
+
+//**************************************************************
+
+type Provenance struct {  // what one source file contributed to the graph
+
+	Nodes map[NodePtr]bool
+	Links map[LinkKey]bool
 }
 
 type PageView struct {
@@ -154,52 +201,20 @@ type Link struct {  // A link is a type of arrow, with context
 	Dst NodePtr          // adjacent event/item/node
 }
 
-const NODEPTR_TYPE = "CREATE TYPE NodePtr AS  " +
-	"(                    " +
-	"Chan     int,        " +
-	"CPtr     int         " +
-	")"
-
-const LINK_TYPE = "CREATE TYPE Link AS  " +
-	"(                    " +
-	"Arr      int,        " +
-	"Wgt      real,       " +
-	"Ctx      text,       " +
-	"Dst      NodePtr     " +
-	")"
-
-const NODE_TABLE = "CREATE TABLE IF NOT EXISTS Node " +
+const SOURCE_NODE_TABLE = "CREATE TABLE IF NOT EXISTS SourceNode " +
 	"( " +
-	"NPtr      NodePtr,        " +
-	"L         int,            " +
-	"S         text,           " +
-	"Chap      text,           " +
-	I_MEXPR+"  Link[],         " + // Im3
-	I_MCONT+"  Link[],         " + // Im2
-	I_MLEAD+"  Link[],         " + // Im1
-	I_NEAR +"  Link[],         " + // In0
-	I_PLEAD+"  Link[],         " + // Il1
-	I_PCONT+"  Link[],         " + // Ic2
-	I_PEXPR+"  Link[]          " + // Ie3
+	"File     text,    " +
+	"NPtr     NodePtr, " +
+	"Primary Key(File,NPtr)" +
 	")"
 
-const LINK_TABLE = "CREATE TABLE IF NOT EXISTS NodeArrowNode " +
+const SOURCE_LINK_TABLE = "CREATE TABLE IF NOT EXISTS SourceLink " +
 	"( " +
+	"File     text,    " +
 	"NFrom    NodePtr, " +
-	"STtype   int,     " +
 	"Arr      int,     " +
-	"Wgt      int,     " +
-	"Ctx      text[],  " +
-	"NTo      NodePtr  " +
-	")"
-
-const PAGEMAP_TABLE = "CREATE TABLE IF NOT EXISTS PageMap " +
-	"( " +
-	"Chap     Text,  " +
-	"Alias    Text,  " +
-	"Ctx      Text[]," +
-	"Line     Int,   " +
-	"Path     Link[] " +
+	"NTo      NodePtr, " +
+	"Primary Key(File,NFrom,Arr,NTo)" +
 	")"
 
 //**************************************************************
@@ -232,10 +247,6 @@ type NodeDirectory struct {
 
 //**************************************************************
 
-var NODE_CACHE = make(map[NodePtr]NodePtr)
-
-//**************************************************************
-
 type NodePtr struct {
 
 	Class int            // Text size-class
@@ -260,23 +271,6 @@ type ArrowDirectory struct {
 
 type ArrowPtr int // ArrowDirectory index
 
-//**************************************************************
-
-const ARROW_DIRECTORY_TABLE = "CREATE TABLE IF NOT EXISTS ArrowDirectory " +
-	"(    " +
-	"STAindex int,             " +
-	"Long text,              " +
-	"Short text,             " +
-	"ArrPtr int primary key  " +
-	")"
-
-const ARROW_INVERSES_TABLE = "CREATE TABLE IF NOT EXISTS ArrowInverses " +
-	"(    " +
-	"Plus int,  " +
-	"Minus int,  " +
-	"Primary Key(Plus,Minus)" +
-	")"
-
 //**************************************************************
 // Lookup tables
 //**************************************************************
@@ -295,6 +289,13 @@ var (
 	NO_NODE_PTR NodePtr // see Init()
 
 	WIPE_DB bool = false
+	SYNC_SOURCES bool = false     // replace, rather than add to, what each file uploaded before
+
+	SOURCE_FILE string            // the file being parsed, see SetSourceFile()
+	PROVENANCE = make(map[string]*Provenance)
+
+	HIGHLIGHT_ON string = "["     // marks search matches, see HighlightText()
+	HIGHLIGHT_OFF string = "]"
         SILLINESS_COUNTER int
         SILLINESS_POS int
 	SILLINESS bool
@@ -307,6 +308,39 @@ var (
 type PoSST struct {
 
    DB *sql.DB
+   Store GraphStore  // nil means use DB directly, else e.g. a MemStore
+   Tx *sql.Tx        // non-nil inside a transaction, see TryBeginTransaction()
+   Cache *NodeCache  // nodes already fetched from DB, see GetDBNodesByNodePtrs()
+}
+
+//******************************************************************
+
+type SQLHandle interface {  // the parts of *sql.DB and *sql.Tx we use
+
+	Query(query string,args ...interface{}) (*sql.Rows,error)
+	Exec(query string,args ...interface{}) (sql.Result,error)
+}
+
+//******************************************************************
+
+type NodeMatch struct {  // a ranked result of SearchDBNodeText()
+
+	NPtr       NodePtr
+	S          string
+	Chap       string
+	Rank       float64
+	Importance float64 // the saved whole graph PageRank, breaks ties in Rank
+	Highlight  string  // S with the matching words marked
+}
+
+//******************************************************************
+
+type GraphUpload struct {  // a whole graph, as it will be stored
+
+	Nodes    []Node
+	Arrows   []ArrowDirectory
+	Inverses map[ArrowPtr]ArrowPtr
+	PageMap  []PageMap
 }
 
 //******************************************************************
@@ -344,44 +378,301 @@ type Orbit struct {  // union, JSON transformer
 
 //******************************************************************
 
-func Open(load_arrows bool) PoSST {
+type DBConfig struct {
+
+	// Connection settings for the postgres store, see GetDBConfig()
+
+	Host     string
+	Port     int
+	User     string
+	Password string
+	DBName   string
+	SSLMode  string
+	URL      string  // a full postgres:// DSN overrides the fields above
+}
+
+//******************************************************************
+
+const (
+	DB_URL_ENV = "SST_DB_URL"        // full DSN, e.g. postgres://user:pw@host/db
+	DB_CONFIG_ENV = "SST_DB_CONFIG"  // path to a private key = value file
+	DB_CONFIG_FILE = ".SSTorytime"   // default private file in $HOME
+	MEM_STORE_ENV = "SST_MEM_STORE"  // use an in-memory graph snapshot instead of postgres
+)
+
+var (
+	DB_URL_FLAG string     // set by DBFlags(), -db
+	DB_CONFIG_FLAG string  // set by DBFlags(), -db-config
+	MEM_STORE_FLAG string  // set by DBFlags(), -mem
+)
+
+//******************************************************************
+
+func DefaultDBConfig() DBConfig {
+
+	// The historical defaults for a local test database
+
+	var cfg DBConfig
+
+	cfg.Host = "localhost"
+	cfg.Port = 5432
+	cfg.User = "sstoryline"
+	cfg.Password = "sst_1234"
+	cfg.DBName = "sstoryline"
+	cfg.SSLMode = "disable"
+
+	return cfg
+}
+
+//******************************************************************
+
+func DBFlags() {
+
+	// Register the common connection options on the command line, so that
+	// all tools accept the same switches. Call before flag.Parse()
+
+	flag.StringVar(&DB_URL_FLAG,"db","","database connection URL/DSN (overrides $"+DB_URL_ENV+")")
+	flag.StringVar(&DB_CONFIG_FLAG,"db-config","","private file of database settings (default $"+DB_CONFIG_ENV+" or ~/"+DB_CONFIG_FILE+")")
+	flag.StringVar(&MEM_STORE_FLAG,"mem","","use an in-memory graph saved in this file instead of the database (or $"+MEM_STORE_ENV+")")
+}
+
+//******************************************************************
+
+func GetDBConfig() DBConfig {
+
+	cfg,err := TryGetDBConfig()
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	return cfg
+}
+
+//******************************************************************
+
+func TryGetDBConfig() (DBConfig,error) {
+
+	// Precedence: -db flag, $SST_DB_URL, config file, built-in defaults
 
-	var ctx PoSST
 	var err error
 
-	// Replace this with a private file
+	cfg := DefaultDBConfig()
+
+	filename := DB_CONFIG_FLAG
+
+	if filename == "" {
+		filename = os.Getenv(DB_CONFIG_ENV)
+	}
+
+	if filename == "" {
+		home,err := os.UserHomeDir()
+		if err == nil {
+			filename = filepath.Join(home,DB_CONFIG_FILE)
+		}
+	}
+
+	if filename != "" {
+		cfg,err = ReadDBConfigFile(filename,cfg,DB_CONFIG_FLAG != "")
+
+		if err != nil {
+			return cfg,err
+		}
+	}
+
+	if url := os.Getenv(DB_URL_ENV); url != "" {
+		cfg.URL = url
+	}
+
+	if DB_URL_FLAG != "" {
+		cfg.URL = DB_URL_FLAG
+	}
+
+	return cfg,nil
+}
+
+//******************************************************************
+
+func ReadDBConfigFile(filename string,cfg DBConfig,must_exist bool) (DBConfig,error) {
+
+	// Simple key = value lines, with # comments, e.g.
+	//   host = db.example.com
+	//   dbname = sstoryline
+
+	content,err := os.ReadFile(filename)
+
+	if err != nil {
+		if must_exist {
+			return cfg,fmt.Errorf("%w: reading database configuration file %s: %v",ErrDBConnection,filename,err)
+		}
+		return cfg,nil
+	}
+
+	lines := strings.Split(string(content),"\n")
+
+	for l := range lines {
+
+		line := strings.TrimSpace(lines[l])
+
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		kv := strings.SplitN(line,"=",2)
+
+		if len(kv) != 2 {
+			fmt.Println("Ignoring bad line in",filename,":",line)
+			continue
+		}
+
+		key := strings.ToLower(strings.TrimSpace(kv[0]))
+		value := strings.Trim(strings.TrimSpace(kv[1]),"\"'")
+
+		switch key {
+		case "host":
+			cfg.Host = value
+		case "port":
+			fmt.Sscanf(value,"%d",&cfg.Port)
+		case "user":
+			cfg.User = value
+		case "password":
+			cfg.Password = value
+		case "dbname":
+			cfg.DBName = value
+		case "sslmode":
+			cfg.SSLMode = value
+		case "url":
+			cfg.URL = value
+		default:
+			fmt.Println("Unknown key in",filename,":",key)
+		}
+	}
+
+	return cfg,nil
+}
+
+//******************************************************************
+
+func (cfg DBConfig) ConnString() string {
+
+	if cfg.URL != "" {
+		return cfg.URL
+	}
+
+	var params []string
+
+	add := func(key,value string) {
+		if value != "" {
+			value = strings.Replace(value,`\`,`\\`,-1)
+			value = strings.Replace(value,`'`,`\'`,-1)
+			params = append(params,key+"='"+value+"'")
+		}
+	}
+
+	add("host",cfg.Host)
+
+	if cfg.Port > 0 {
+		add("port",fmt.Sprint(cfg.Port))
+	}
+
+	add("user",cfg.User)
+	add("password",cfg.Password)
+	add("dbname",cfg.DBName)
+	add("sslmode",cfg.SSLMode)
 
-	const (
-		host     = "localhost"
-		port     = 5432
-		user     = "sstoryline"
-		password = "sst_1234"
-		dbname   = "sstoryline"
-	)
+	return strings.Join(params," ")
+}
+
+//******************************************************************
+
+func Open(load_arrows bool) PoSST {
+
+	ctx,err := TryOpen(load_arrows)
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	return ctx
+}
+
+//******************************************************************
 
-        connStr := "user="+user+" dbname="+dbname+" password="+password+" sslmode=disable"
+func OpenWithConfig(cfg DBConfig,load_arrows bool) PoSST {
 
-        ctx.DB, err = sql.Open("postgres", connStr)
+	ctx,err := TryOpenWithConfig(cfg,load_arrows)
 
 	if err != nil {
-	   	fmt.Println("Error connecting to the database: ", err)
+		fmt.Println(err)
 		os.Exit(-1)
 	}
+
+	return ctx
+}
+
+//******************************************************************
+
+func TryOpen(load_arrows bool) (PoSST,error) {
+
+	// The -mem option or $SST_MEM_STORE selects the in-memory backend
+
+	filename := MEM_STORE_FLAG
+
+	if filename == "" {
+		filename = os.Getenv(MEM_STORE_ENV)
+	}
+
+	if filename != "" {
+		return TryOpenMemStore(filename,load_arrows)
+	}
+
+	cfg,err := TryGetDBConfig()
+
+	if err != nil {
+		var ctx PoSST
+		return ctx,err
+	}
+
+	return TryOpenWithConfig(cfg,load_arrows)
+}
+
+//******************************************************************
+
+func TryOpenWithConfig(cfg DBConfig,load_arrows bool) (PoSST,error) {
+
+	var ctx PoSST
+	var err error
+
+        ctx.DB, err = sql.Open("postgres", cfg.ConnString())
+
+	if err != nil {
+		return ctx,fmt.Errorf("%w: connecting: %v",ErrDBConnection,err)
+	}
 	
 	err = ctx.DB.Ping()
 	
 	if err != nil {
-		fmt.Println("Error pinging the database: ", err)
-		os.Exit(-1)
+		ctx.DB.Close()
+		return ctx,fmt.Errorf("%w: pinging: %v",ErrDBConnection,err)
 	}
 
 	MemoryInit()
-	Configure(ctx,load_arrows)
+
+	ctx.Cache = NewNodeCache()
+
+	err = TryConfigure(ctx,load_arrows)
+
+	if err != nil {
+		ctx.DB.Close()
+		return ctx,err
+	}
 
 	NO_NODE_PTR.Class = 0
 	NO_NODE_PTR.CPtr =  -1
 
-	return ctx
+	return ctx,nil
 }
 
 // **************************************************************************
@@ -405,6 +696,23 @@ func MemoryInit() {
 
 func Configure(ctx PoSST,load_arrows bool) {
 
+	err := TryConfigure(ctx,load_arrows)
+
+	if err != nil {
+		fmt.Println(err)
+
+		// Stored function failures were never fatal
+
+		if errors.Is(err,ErrDBSchema) || errors.Is(err,ErrArrowMismatch) {
+			os.Exit(-1)
+		}
+	}
+}
+
+// **************************************************************************
+
+func TryConfigure(ctx PoSST,load_arrows bool) error {
+
 	// Tmp reset
 
 	if WIPE_DB {
@@ -444,56 +752,56 @@ func Configure(ctx PoSST,load_arrows bool) {
 
 		ctx.DB.QueryRow("drop table ArrowDirectory")
 		ctx.DB.QueryRow("drop table ArrowInverses")
+		ctx.DB.QueryRow("drop table SourceNode")
+		ctx.DB.QueryRow("drop table SourceLink")
+		ctx.DB.QueryRow("drop table schema_version")
+		ctx.DB.QueryRow("drop table function_version")
+		ctx.DB.QueryRow("drop table search_config")
+		ctx.DB.QueryRow("drop table Centrality")
+		ctx.DB.QueryRow("drop function sst_search_language")
+		ctx.DB.QueryRow("drop function node_search_vector")
 	}
 
 	// Ignore error
 	ctx.DB.QueryRow("CREATE EXTENSION unaccent")
 
-	if !CreateType(ctx,NODEPTR_TYPE) {
-		fmt.Println("Unable to create type as, ",NODEPTR_TYPE)
-		os.Exit(-1)
-	}
-
-	if !CreateType(ctx,LINK_TYPE) {
-		fmt.Println("Unable to create type as, ",LINK_TYPE)
-		os.Exit(-1)
-	}
+	var fn_err error
 
-	if !CreateTable(ctx,PAGEMAP_TABLE) {
-		fmt.Println("Unable to create table as, ",PAGEMAP_TABLE)
-		os.Exit(-1)
-	}
+	if SCHEMA_AUTO_UPGRADE {
 
-	if !CreateTable(ctx,NODE_TABLE) {
-		fmt.Println("Unable to create table as, ",NODE_TABLE)
-		os.Exit(-1)
-	}
+		_,err := TryMigrateDB(ctx)
 
-	if !CreateTable(ctx,LINK_TABLE) {
-		fmt.Println("Unable to create table as, ",LINK_TABLE)
-		os.Exit(-1)
-	}
+		if err != nil {
+			return err
+		}
 
-	if !CreateTable(ctx,ARROW_INVERSES_TABLE) {
-		fmt.Println("Unable to create table as, ",ARROW_INVERSES_TABLE)
-		os.Exit(-1)
-	}
-	if !CreateTable(ctx,ARROW_DIRECTORY_TABLE) {
-		fmt.Println("Unable to create table as, ",ARROW_DIRECTORY_TABLE)
-		os.Exit(-1)
+		_,fn_err = TryUpdateStoredFunctions(ctx)
 	}
 
-	DefineStoredFunctions(ctx)
-
 	if load_arrows {
-		DownloadArrowsFromDB(ctx)
+		err := TryDownloadArrowsFromDB(ctx)
+		if err != nil {
+			return errors.Join(fn_err,err)
+		}
 	}
+
+	return fn_err
 }
 
 // **************************************************************************
 
 func Close(ctx PoSST) {
-	ctx.DB.Close()
+
+	if ctx.Store != nil {
+		err := ctx.Store.Close()
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+
+	if ctx.DB != nil {
+		ctx.DB.Close()
+	}
 }
 
 // **************************************************************************
@@ -552,6 +860,28 @@ func GetNodeFromPtr(frptr NodePtr) Node {
 	return node
 }
 
+// **************************************************************************
+
+func GetMemoryNodesByClass(class int) []Node {
+
+	switch class {
+	case N1GRAM:
+		return NODE_DIRECTORY.N1directory
+	case N2GRAM:
+		return NODE_DIRECTORY.N2directory
+	case N3GRAM:
+		return NODE_DIRECTORY.N3directory
+	case LT128:
+		return NODE_DIRECTORY.LT128
+	case LT1024:
+		return NODE_DIRECTORY.LT1024
+	case GT1024:
+		return NODE_DIRECTORY.GT1024
+	}
+
+	return nil
+}
+
 //**************************************************************
 
 func AppendTextToDirectory(event Node,ErrFunc func(string)) NodePtr {
@@ -567,6 +897,7 @@ func AppendTextToDirectory(event Node,ErrFunc func(string)) NodePtr {
 	if ok {
 		node_alloc_ptr.CPtr = cnode_slot
 		IdempAddChapterToNode(node_alloc_ptr.Class,node_alloc_ptr.CPtr,event.Chap)
+		NoteProvenanceNode(node_alloc_ptr)
 		return node_alloc_ptr
 	}
 
@@ -578,6 +909,7 @@ func AppendTextToDirectory(event Node,ErrFunc func(string)) NodePtr {
 		NODE_DIRECTORY.N1directory = append(NODE_DIRECTORY.N1directory,event)
 		NODE_DIRECTORY.N1grams[event.S] = cnode_slot
 		NODE_DIRECTORY.N1_top++ 
+		NoteProvenanceNode(node_alloc_ptr)
 		return node_alloc_ptr
 	case N2GRAM:
 		cnode_slot = NODE_DIRECTORY.N2_top
@@ -586,6 +918,7 @@ func AppendTextToDirectory(event Node,ErrFunc func(string)) NodePtr {
 		NODE_DIRECTORY.N2directory = append(NODE_DIRECTORY.N2directory,event)
 		NODE_DIRECTORY.N2grams[event.S] = cnode_slot
 		NODE_DIRECTORY.N2_top++
+		NoteProvenanceNode(node_alloc_ptr)
 		return node_alloc_ptr
 	case N3GRAM:
 		cnode_slot = NODE_DIRECTORY.N3_top
@@ -594,6 +927,7 @@ func AppendTextToDirectory(event Node,ErrFunc func(string)) NodePtr {
 		NODE_DIRECTORY.N3directory = append(NODE_DIRECTORY.N3directory,event)
 		NODE_DIRECTORY.N3grams[event.S] = cnode_slot
 		NODE_DIRECTORY.N3_top++
+		NoteProvenanceNode(node_alloc_ptr)
 		return node_alloc_ptr
 	case LT128:
 		cnode_slot = NODE_DIRECTORY.LT128_top
@@ -601,6 +935,7 @@ func AppendTextToDirectory(event Node,ErrFunc func(string)) NodePtr {
 		event.NPtr = node_alloc_ptr
 		NODE_DIRECTORY.LT128 = append(NODE_DIRECTORY.LT128,event)
 		NODE_DIRECTORY.LT128_top++
+		NoteProvenanceNode(node_alloc_ptr)
 		return node_alloc_ptr
 	case LT1024:
 		cnode_slot = NODE_DIRECTORY.LT1024_top
@@ -608,6 +943,7 @@ func AppendTextToDirectory(event Node,ErrFunc func(string)) NodePtr {
 		event.NPtr = node_alloc_ptr
 		NODE_DIRECTORY.LT1024 = append(NODE_DIRECTORY.LT1024,event)
 		NODE_DIRECTORY.LT1024_top++
+		NoteProvenanceNode(node_alloc_ptr)
 		return node_alloc_ptr
 	case GT1024:
 		cnode_slot = NODE_DIRECTORY.GT1024_top
@@ -615,6 +951,7 @@ func AppendTextToDirectory(event Node,ErrFunc func(string)) NodePtr {
 		event.NPtr = node_alloc_ptr
 		NODE_DIRECTORY.GT1024 = append(NODE_DIRECTORY.GT1024,event)
 		NODE_DIRECTORY.GT1024_top++
+		NoteProvenanceNode(node_alloc_ptr)
 		return node_alloc_ptr
 	}
 
@@ -692,32 +1029,40 @@ func CheckExistingOrAltCaps(event Node,ErrFunc func(string)) (ClassedNodePtr,boo
 
 func IdempDBAddNode(ctx PoSST,n Node) Node {
 
-	// alternative for np = SST.CreateDBNode(ctx, np)
-	// without assuming management/control of the Nptr increments
+	n,err := TryIdempDBAddNode(ctx,n)
 
-	var qstr string
+	if err != nil && !strings.Contains(err.Error(),"duplicate key") {
+		fmt.Println(err)
+	}
 
-	// No need to trust the values, ignore/overwrite CPtr
+	return n
+}
 
-        n.L,n.NPtr.Class = StorageClass(n.S)
+//**************************************************************
 
-	es := SQLEscape(n.S)
-	ec := SQLEscape(n.Chap)
+func TryIdempDBAddNode(ctx PoSST,n Node) (Node,error) {
+
+	if ctx.Store != nil {
+		return ctx.Store.IdempAddNode(n)
+	}
+
+	// alternative for np = SST.CreateDBNode(ctx, np)
+	// without assuming management/control of the Nptr increments
+
+	var qstr string
+
+	// No need to trust the values, ignore/overwrite CPtr
+
+        n.L,n.NPtr.Class = StorageClass(n.S)
 
 	// Wrap BEGIN/END a single transaction
 
-	qstr = fmt.Sprintf("SELECT IdempAppendNode(%d,%d,'%s','%s')",n.L,n.NPtr.Class,es,ec)
+	qstr = "SELECT IdempAppendNode($1,$2,$3,$4)"
 
-	row,err := ctx.DB.Query(qstr)
+	row,err := SQLConn(ctx).Query(qstr,n.L,n.NPtr.Class,n.S,n.Chap)
 	
 	if err != nil {
-		s := fmt.Sprint("Failed to add node",err)
-		
-		if strings.Contains(s,"duplicate key") {
-		} else {
-			fmt.Println(s,"FAILED \n",qstr,err)
-		}
-		return n
+		return n,fmt.Errorf("%w: failed to add node: %v\n%s",ErrDBQuery,err,qstr)
 	}
 
 	var whole string
@@ -733,8 +1078,7 @@ func IdempDBAddNode(ctx PoSST,n Node) Node {
 
 	row.Close()
 
-	return n
-
+	return n,err
 }
 
 //**************************************************************
@@ -795,6 +1139,8 @@ func AppendLinkToNode(frptr NodePtr,link Link,toptr NodePtr) {
 
 	link.Dst = toptr // fill in the last part of the reference
 
+	NoteProvenanceLink(frptr,link.Arr,toptr)
+
 	// Add idempotently ...
 
 	switch frclass {
@@ -841,6 +1187,40 @@ func MergeLinks(list []Link,lnk Link) []Link {
 
 //**************************************************************
 
+func SetSourceFile(filename string) {
+
+	// Attribute the nodes and links that follow to this file
+
+	SOURCE_FILE = filepath.Clean(filename)
+
+	if PROVENANCE[SOURCE_FILE] == nil {
+		var prov Provenance
+		prov.Nodes = make(map[NodePtr]bool)
+		prov.Links = make(map[LinkKey]bool)
+		PROVENANCE[SOURCE_FILE] = &prov
+	}
+}
+
+//**************************************************************
+
+func NoteProvenanceNode(nptr NodePtr) {
+
+	if prov := PROVENANCE[SOURCE_FILE]; prov != nil {
+		prov.Nodes[nptr] = true
+	}
+}
+
+//**************************************************************
+
+func NoteProvenanceLink(frptr NodePtr,arr ArrowPtr,toptr NodePtr) {
+
+	if prov := PROVENANCE[SOURCE_FILE]; prov != nil {
+		prov.Links[LinkKey{NFrom: frptr, Arr: arr, NTo: toptr}] = true
+	}
+}
+
+//**************************************************************
+
 func MergeContexts(one,two []string) []string {
 
 	var merging = make(map[string]bool)
@@ -986,935 +1366,2411 @@ func InsertInverseArrowDirectory(fwd,bwd ArrowPtr) {
 
 func GraphToDB(ctx PoSST) {
 
-	fmt.Println("Storing nodes...")
+	err := TryGraphToDB(ctx)
 
-	for class := N1GRAM; class <= GT1024; class++ {
-		switch class {
-		case N1GRAM:
-			for n := range NODE_DIRECTORY.N1directory {
-				org := NODE_DIRECTORY.N1directory[n]
-				UploadNodeToDB(ctx,org)
-			}
-		case N2GRAM:
-			for n := range NODE_DIRECTORY.N2directory {
-				org := NODE_DIRECTORY.N2directory[n]
-				UploadNodeToDB(ctx,org)
-			}
-		case N3GRAM:
-			for n := range NODE_DIRECTORY.N3directory {
-				org := NODE_DIRECTORY.N3directory[n]
-				UploadNodeToDB(ctx,org)
-			}
-		case LT128:
-			for n := range NODE_DIRECTORY.LT128 {
-				org := NODE_DIRECTORY.LT128[n]
-				UploadNodeToDB(ctx,org)
-			}
-		case LT1024:
-			for n := range NODE_DIRECTORY.LT1024 {
-				org := NODE_DIRECTORY.LT1024[n]
-				UploadNodeToDB(ctx,org)
-			}
+	if err != nil {
+		fmt.Println("Upload aborted, nothing was changed:",err)
+	}
+}
 
-		case GT1024:
-			for n := range NODE_DIRECTORY.GT1024 {
-				org := NODE_DIRECTORY.GT1024[n]
-				UploadNodeToDB(ctx,org)
-			}
-		}
+// **************************************************************************
+
+func TryGraphToDB(ctx PoSST) (err error) {
+
+	// Upload the in-memory graph in a single transaction, so that an
+	// error or a crash leaves the database as it was
+
+	ctx,owned,err := TryBeginTransaction(ctx)
+
+	if err != nil {
+		return err
 	}
 
+	defer func() {
+		err = TryEndTransaction(ctx,owned,err)
+	}()
 
-	fmt.Println("\nStoring Arrows...")
+	if ctx.Tx != nil {
 
-	for arrow := range ARROW_DIRECTORY {
+		// Two uploads at once would allocate the same pointers
+
+		_,err = ctx.Tx.Exec("LOCK TABLE Node IN SHARE ROW EXCLUSIVE MODE")
 
-		UploadArrowToDB(ctx,ArrowPtr(arrow))
+		if err != nil {
+			return fmt.Errorf("%w: Failed to lock for upload: %v",ErrDBQuery,err)
+		}
 	}
 
-	fmt.Println("Storing inverse Arrows...")
+	// The in-memory NodePtrs and ArrowPtrs are only unique to this run,
+	// so first find where each text and arrow name already lives in the
+	// database, or allocate a new slot after those, and write everything
+	// through that mapping
 
-	for arrow := range INVERSE_ARROWS {
+	fmt.Println("Allocating node pointers...")
 
-		UploadInverseArrowToDB(ctx,ArrowPtr(arrow))
+	arrows,err := TryAllocateArrowPtrs(ctx)
+
+	if err != nil {
+		return err
 	}
 
-	fmt.Println("Storing page map...")
+	remap,err := TryAllocateNodePtrs(ctx)
 
-	for line := 0; line < len(PAGE_MAP); line ++ {
-		UploadPageMapEvent(ctx,PAGE_MAP[line])
+	if err != nil {
+		return err
 	}
 
-	// CREATE INDICES
+	var sources []string
 
-	fmt.Println("Indexing ....")
+	for file := range PROVENANCE {
+		sources = append(sources,file)
+	}
 
-	ctx.DB.QueryRow("CREATE INDEX on NodeArrowNode (Arr,STType)")
-	ctx.DB.QueryRow("CREATE INDEX on Node (((NPtr).Chan),L,S)")
-}
+	sort.Strings(sources)
 
-// **************************************************************************
-// Postgres
-// **************************************************************************
+	if SYNC_SOURCES {
 
-func CreateType(ctx PoSST, defn string) bool {
+		fmt.Println("Removing what has changed since the last upload...")
 
-	row,err := ctx.DB.Query(defn)
+		for _,file := range sources {
 
-	if err != nil {
-		s := fmt.Sprintln("Failed to create datatype PGLink ",err)
-		
-		if strings.Contains(s,"already exists") {
-			return true
-		} else {
-			return false
+			err = TrySyncSourceFile(ctx,file,remap,arrows)
+
+			if err != nil {
+				return err
+			}
 		}
 	}
 
-	row.Close();
-	return true
-}
+	upload,links := MemoryGraphUpload(remap,arrows)
 
-// **************************************************************************
+	fmt.Printf("Storing %d nodes, %d links and %d page map lines...\n",len(upload.Nodes),links,len(upload.PageMap))
 
-func CreateTable(ctx PoSST,defn string) bool {
+	err = TryUploadGraph(ctx,upload)
 
-	row,err := ctx.DB.Query(defn)
-	
 	if err != nil {
-		s := fmt.Sprintln("Failed to create a table %.10 ...",defn,err)
-		
-		if strings.Contains(s,"already exists") {
-			return true
-		} else {
-			return false
+		return err
+	}
+
+	fmt.Println("Storing provenance...")
+
+	for _,file := range sources {
+
+		nodes,links := RemapProvenance(PROVENANCE[file],remap,arrows)
+
+		err = TryUploadProvenance(ctx,file,nodes,links)
+
+		if err != nil {
+			return err
 		}
 	}
 
-	row.Close()
-	return true
+	return nil
 }
 
-// **************************************************************************
-// Store
 // **************************************************************************
 
-func CreateDBNode(ctx PoSST, n Node) Node {
+func MemoryGraphUpload(remap map[NodePtr]NodePtr,arrows map[ArrowPtr]ArrowPtr) (GraphUpload,int) {
 
-	var qstr string
+	// The graph compiled in memory, with its pointers remapped to where
+	// they will be stored (unchanged if the maps are nil), and the
+	// number of links
 
-	// No need to trust the values
+	var upload GraphUpload
+	var links int
 
-        n.L,n.NPtr.Class = StorageClass(n.S)
-	
-	cptr := n.NPtr.CPtr
-	es := SQLEscape(n.S)
-	ec := SQLEscape(n.Chap)
+	for class := N1GRAM; class <= GT1024; class++ {
 
-	qstr = fmt.Sprintf("SELECT IdempInsertNode(%d,%d,%d,'%s','%s')",n.L,n.NPtr.Class,cptr,es,ec)
+		nodes := GetMemoryNodesByClass(class)
 
-	row,err := ctx.DB.Query(qstr)
-	
-	if err != nil {
-		s := fmt.Sprint("Failed to insert",err)
-		
-		if strings.Contains(s,"duplicate key") {
-		} else {
-			fmt.Println(s,"FAILED \n",qstr,err)
+		for n := range nodes {
+			upload.Nodes = append(upload.Nodes,RemapNode(nodes[n],remap,arrows))
+
+			for stindex := range nodes[n].I {
+				links += len(nodes[n].I[stindex])
+			}
 		}
-		return n
 	}
 
-	var whole string
-	var cl,ch int
+	for arrow := range ARROW_DIRECTORY {
 
-	for row.Next() {		
-		err = row.Scan(&whole)
-		fmt.Sscanf(whole,"(%d,%d)",&cl,&ch)
+		ad := ARROW_DIRECTORY[arrow]
+		ad.Ptr = RemapArrowPtr(ad.Ptr,arrows)
+		upload.Arrows = append(upload.Arrows,ad)
 	}
 
-	n.NPtr.Class = cl
-	n.NPtr.CPtr = ClassedNodePtr(ch)
+	upload.Inverses = make(map[ArrowPtr]ArrowPtr)
 
-	row.Close()
+	for plus,minus := range INVERSE_ARROWS {
+		upload.Inverses[RemapArrowPtr(plus,arrows)] = RemapArrowPtr(minus,arrows)
+	}
 
-	return n
+	for line := 0; line < len(PAGE_MAP); line ++ {
+		upload.PageMap = append(upload.PageMap,RemapPageMap(PAGE_MAP[line],remap,arrows))
+	}
+
+	return upload,links
 }
 
 // **************************************************************************
 
-func UploadNodeToDB(ctx PoSST, org Node) {
+func TryUploadGraph(ctx PoSST,upload GraphUpload) (err error) {
 
-	CreateDBNode(ctx, org)
+	// Bulk version of UploadNodeToDB() etc. The rows are copied into
+	// temporary tables with the COPY protocol, and merged into the
+	// graph with a handful of statements, idempotently as before
 
-	const nolink = 999
-	var empty Link
+	if ctx.Store != nil {
+		return ctx.Store.UploadGraph(upload)
+	}
 
-	for stindex := range org.I {
+	ctx.Cache.Forget()
 
-		for lnk := range org.I[stindex] {
+	ctx,owned,err := TryBeginTransaction(ctx)
 
-			dstlnk := org.I[stindex][lnk]
-			sttype := STIndexToSTType(stindex)
+	if err != nil {
+		return err
+	}
 
-			AppendDBLinkToNode(ctx,org.NPtr,dstlnk,sttype)
-			CreateDBNodeArrowNode(ctx,org.NPtr,dstlnk,sttype)
-			Waiting()
-		}
+	defer func() {
+		err = TryEndTransaction(ctx,owned,err)
+	}()
 
-		CreateDBNodeArrowNode(ctx,org.NPtr,empty,nolink)
-		Waiting()
-	}
-}
+	for _,qstr := range []string{
+		"CREATE TEMP TABLE IF NOT EXISTS UploadNode (Seq int,Chan int,CPtr int,L int,S text,Chap text) ON COMMIT DROP",
+		"CREATE TEMP TABLE IF NOT EXISTS UploadLink (Seq int,FChan int,FCPtr int,STType int,Arr int,Wgt real,Ctx text,TChan int,TCPtr int) ON COMMIT DROP",
+		"CREATE TEMP TABLE IF NOT EXISTS UploadPage (Id int,Chap text,Alias text,Ctx text[],Line int,File text) ON COMMIT DROP",
+		"CREATE TEMP TABLE IF NOT EXISTS UploadPath (Id int,Seq int,Arr int,Wgt real,Ctx text,TChan int,TCPtr int) ON COMMIT DROP",
+		"TRUNCATE UploadNode,UploadLink,UploadPage,UploadPath",
+	} {
+		_,err = ctx.Tx.Exec(qstr)
 
-// **************************************************************************
+		if err != nil {
+			return fmt.Errorf("%w: Failed to prepare upload: %v\n%s",ErrDBQuery,err,qstr)
+		}
+	}
 
-func UploadArrowToDB(ctx PoSST,arrow ArrowPtr) {
+	var noderows,linkrows,pagerows,pathrows [][]interface{}
 
-	staidx := ARROW_DIRECTORY[arrow].STAindex
-	long := SQLEscape(ARROW_DIRECTORY[arrow].Long)
-	short := SQLEscape(ARROW_DIRECTORY[arrow].Short)
+	for n := range upload.Nodes {
 
-	qstr := fmt.Sprintf("INSERT INTO ArrowDirectory (STAindex,Long,Short,ArrPtr) VALUES (%d,'%s','%s',%d)",staidx,long,short,arrow)
+		node := upload.Nodes[n]
+		noderows = append(noderows,[]interface{}{n,node.NPtr.Class,node.NPtr.CPtr,node.L,node.S,node.Chap})
 
-	row,err := ctx.DB.Query(qstr)
-	
-	if err != nil {
-		s := fmt.Sprint("Failed to insert",err)
-		
-		if strings.Contains(s,"duplicate key") {
-		} else {
-			fmt.Println(s,"FAILED \n",qstr,err)
+		for stindex := range node.I {
+			for _,lnk := range node.I[stindex] {
+				linkrows = append(linkrows,[]interface{}{len(linkrows),node.NPtr.Class,node.NPtr.CPtr,STIndexToSTType(stindex),
+					lnk.Arr,lnk.Wgt,SQLLinkContext(lnk.Ctx),lnk.Dst.Class,lnk.Dst.CPtr})
+			}
 		}
-		return
 	}
 
-	row.Close()
-}
+	for p := range upload.PageMap {
 
-// **************************************************************************
+		line := upload.PageMap[p]
+		pagerows = append(pagerows,[]interface{}{p,line.Chapter,line.Alias,SQLStringArray(line.Context),line.Line,line.File})
 
-func UploadInverseArrowToDB(ctx PoSST,arrow ArrowPtr) {
+		for seq,lnk := range line.Path {
+			pathrows = append(pathrows,[]interface{}{p,seq,lnk.Arr,lnk.Wgt,SQLLinkContext(lnk.Ctx),lnk.Dst.Class,lnk.Dst.CPtr})
+		}
+	}
 
-	plus := arrow
-	minus := INVERSE_ARROWS[arrow]
+	err = TryCopyRows(ctx,"uploadnode",[]string{"seq","chan","cptr","l","s","chap"},noderows)
 
-	qstr := fmt.Sprintf("INSERT INTO ArrowInverses (Plus,Minus) VALUES (%d,%d)",plus,minus)
+	if err == nil {
+		err = TryCopyRows(ctx,"uploadlink",[]string{"seq","fchan","fcptr","sttype","arr","wgt","ctx","tchan","tcptr"},linkrows)
+	}
+
+	if err == nil {
+		err = TryCopyRows(ctx,"uploadpage",[]string{"id","chap","alias","ctx","line","file"},pagerows)
+	}
+
+	if err == nil {
+		err = TryCopyRows(ctx,"uploadpath",[]string{"id","seq","arr","wgt","ctx","tchan","tcptr"},pathrows)
+	}
 
-	row,err := ctx.DB.Query(qstr)
-	
 	if err != nil {
-		s := fmt.Sprint("Failed to insert",err)
-		
-		if strings.Contains(s,"duplicate key") {
-		} else {
-			fmt.Println(s,"FAILED \n",qstr,err)
-		}
-		return
+		return err
 	}
 
-	row.Close()
-}
+	fmt.Println("\nMerging into the graph...")
 
-//**************************************************************
+	// New nodes, unless the text is already there in any capitalization, as IdempInsertNode()
 
-func UploadPageMapEvent(ctx PoSST, line PageMap) {
+	qnodes := fmt.Sprintf("INSERT INTO Node (NPtr,L,S,Chap,%s,%s,%s,%s,%s,%s,%s) " +
+		"SELECT DISTINCT ON (lower(u.S)) (u.Chan,u.CPtr)::NodePtr,u.L,u.S,u.Chap,'{}','{}','{}','{}','{}','{}','{}' FROM UploadNode u " +
+		"WHERE NOT EXISTS (SELECT 1 FROM Node n WHERE lower(n.S)=lower(u.S)) ORDER BY lower(u.S),u.Seq",
+		I_MEXPR,I_MCONT,I_MLEAD,I_NEAR,I_PLEAD,I_PCONT,I_PEXPR)
 
-	qstr := fmt.Sprintf("INSERT INTO PageMap (Chap,Alias,Ctx,Line) VALUES ('%s','%s',%s,%d)",line.Chapter,line.Alias,FormatSQLStringArray(line.Context),line.Line)
+	// Append the links each node doesn't already have, as AppendDBLinkToNode()
 
-	row,err := ctx.DB.Query(qstr)
-	
-	if err != nil {
-		s := fmt.Sprint("Failed to insert pagemap event",err)
-		
-		if strings.Contains(s,"duplicate key") {
-		} else {
-			fmt.Println(s,"FAILED \n",qstr,err)
-		}
-		row.Close()
-		return
+	const newlink = "ROW(l.Arr,l.Wgt,l.Ctx,ROW(l.TChan,l.TCPtr)::NodePtr)::Link"
+
+	var appends []string
+
+	for sttype := -EXPRESS; sttype <= EXPRESS; sttype++ {
+
+		col,_ := TrySTTypeDBChannel(sttype)
+
+		appends = append(appends,fmt.Sprintf("%s=COALESCE(n.%s,'{}') || ARRAY(SELECT %s FROM UploadLink l " +
+			"WHERE l.FChan=(n.NPtr).Chan AND l.FCPtr=(n.NPtr).CPtr AND l.STType=%d AND NOT (l.FChan=l.TChan AND l.FCPtr=l.TCPtr) " +
+			"AND (n.%s IS NULL OR NOT %s = ANY(n.%s)) ORDER BY l.Seq)",col,col,newlink,sttype,col,newlink,col))
 	}
 
-	row.Close()
+	qlinks := "UPDATE Node n SET " + strings.Join(appends,",") +
+		" WHERE n.NPtr IN (SELECT DISTINCT (FChan,FCPtr)::NodePtr FROM UploadLink)"
 
-	for lnk := 0; lnk < len(line.Path); lnk++ {
+	// And their NodeArrowNode index, plus the empty "nolink" row for each node, as IdempInsertNodeArrowNode()
 
-		linkval := fmt.Sprintf("(%d, %f, %s, (%d,%d)::NodePtr)",line.Path[lnk].Arr,line.Path[lnk].Wgt,FormatSQLStringArray(line.Path[lnk].Ctx),line.Path[lnk].Dst.Class,line.Path[lnk].Dst.CPtr)
+	qnan := "INSERT INTO NodeArrowNode (NFrom,STType,Arr,Wgt,Ctx,NTo) " +
+		"SELECT DISTINCT ON (l.FChan,l.FCPtr,l.Arr,l.TChan,l.TCPtr) (l.FChan,l.FCPtr)::NodePtr,l.STType,l.Arr,l.Wgt,l.Ctx::text[],(l.TChan,l.TCPtr)::NodePtr " +
+		"FROM UploadLink l WHERE NOT EXISTS (SELECT 1 FROM NodeArrowNode x " +
+		"WHERE x.NFrom=(l.FChan,l.FCPtr)::NodePtr AND x.Arr=l.Arr AND x.NTo=(l.TChan,l.TCPtr)::NodePtr) " +
+		"ORDER BY l.FChan,l.FCPtr,l.Arr,l.TChan,l.TCPtr,l.Seq"
+
+	qnolink := "INSERT INTO NodeArrowNode (NFrom,STType,Arr,Wgt,Ctx,NTo) " +
+		"SELECT DISTINCT (u.Chan,u.CPtr)::NodePtr,999,0,0,'{}'::text[],(0,0)::NodePtr FROM UploadNode u " +
+		"WHERE NOT EXISTS (SELECT 1 FROM NodeArrowNode x WHERE x.NFrom=(u.Chan,u.CPtr)::NodePtr AND x.Arr=0 AND x.NTo=(0,0)::NodePtr)"
+
+	// Page map lines replace any earlier upload of the same file and line, as in the MemStore
+
+	qoldpages := "DELETE FROM PageMap x USING UploadPage p WHERE x.Chap=p.Chap AND x.Line=p.Line AND x.File IS NOT DISTINCT FROM p.File"
+
+	qpages := "INSERT INTO PageMap (Chap,Alias,Ctx,Line,Path,File) " +
+		"SELECT p.Chap,p.Alias,p.Ctx,p.Line,ARRAY(SELECT " + strings.Replace(newlink,"l.","q.",-1) +
+		" FROM UploadPath q WHERE q.Id=p.Id ORDER BY q.Seq),p.File FROM UploadPage p ORDER BY p.Id"
+
+	for _,qstr := range []string{qnodes,qlinks,qnan,qnolink,qoldpages,qpages} {
+
+		_,err = ctx.Tx.Exec(qstr)
 
-		literal := fmt.Sprintf("%s::Link",linkval)
-		
-		qstr := fmt.Sprintf("UPDATE PageMap SET Path=array_append(Path,%s) WHERE Chap = '%s' AND Line = '%d'",literal,line.Chapter,line.Line)
-		
-		row,err := ctx.DB.Query(qstr)
-		
 		if err != nil {
-			fmt.Println("Failed to append",err,qstr)
+			return fmt.Errorf("%w: Failed to merge upload: %v\n%s",ErrDBQuery,err,qstr)
 		}
-		
-		row.Close()
 	}
-}
 
-//**************************************************************
+	fmt.Println("Storing Arrows...")
 
-func IdempDBAddLink(ctx PoSST,from Node,link Link,to Node) {
+	var staidx,ptrs []int64
+	var longs,shorts []string
 
-	frptr := from.NPtr
-	toptr := to.NPtr
+	for _,ad := range upload.Arrows {
+		staidx = append(staidx,int64(ad.STAindex))
+		longs = append(longs,ad.Long)
+		shorts = append(shorts,ad.Short)
+		ptrs = append(ptrs,int64(ad.Ptr))
+	}
 
-	link.Dst = toptr // it might have changed, so override
+	qstr := "INSERT INTO ArrowDirectory (STAindex,Long,Short,ArrPtr) " +
+		"SELECT * FROM unnest($1::int[],$2::text[],$3::text[],$4::int[]) ON CONFLICT DO NOTHING"
 
-	if frptr == toptr {
-		fmt.Println("Self-loops are not allowed",from.S)
-		os.Exit(-1)
+	// Bind as pq.Array, as SQLIntArray() would sort the columns out of step
+
+	_,err = ctx.Tx.Exec(qstr,pq.Array(staidx),pq.Array(longs),pq.Array(shorts),pq.Array(ptrs))
+
+	if err != nil {
+		return fmt.Errorf("%w: Failed to insert arrows: %v\n%s",ErrDBQuery,err,qstr)
 	}
 
-	if link.Arr < 0 {
-		fmt.Println("No arrows have yet been defined, so you can't rely on the arrow names")
-		os.Exit(-1)
+	var plus,minus []int64
+
+	for p,m := range upload.Inverses {
+		plus = append(plus,int64(p))
+		minus = append(minus,int64(m))
 	}
 
-	sttype := STIndexToSTType(ARROW_DIRECTORY[link.Arr].STAindex)
+	qstr = "INSERT INTO ArrowInverses (Plus,Minus) SELECT * FROM unnest($1::int[],$2::int[]) ON CONFLICT DO NOTHING"
 
-	AppendDBLinkToNode(ctx,frptr,link,sttype)
+	_,err = ctx.Tx.Exec(qstr,pq.Array(plus),pq.Array(minus))
 
-	// Double up the reverse definition for easy indexing of both in/out arrows
-	// But be careful not the make the graph undirected by mistake
+	if err != nil {
+		return fmt.Errorf("%w: Failed to insert inverse arrows: %v\n%s",ErrDBQuery,err,qstr)
+	}
 
-	var invlink Link
-	invlink.Arr = INVERSE_ARROWS[link.Arr]
-	invlink.Wgt = link.Wgt
-	invlink.Dst = frptr
-	AppendDBLinkToNode(ctx,toptr,invlink,-sttype)
+	return nil
 }
 
 // **************************************************************************
 
-func AppendDBLinkToNode(ctx PoSST, n1ptr NodePtr, lnk Link, sttype int) bool {
+func TryCopyRows(ctx PoSST,table string,columns []string,rows [][]interface{}) error {
 
-	// Want to make this idempotent, because SQL is not (and not clause)
+	// Stream rows into a table with the COPY protocol, inside ctx.Tx
 
-	if sttype < -EXPRESS || sttype > EXPRESS {
-		fmt.Println(ERR_ST_OUT_OF_BOUNDS,sttype)
-		os.Exit(-1)
-	}
+	stmt,err := ctx.Tx.Prepare(pq.CopyIn(table,columns...))
 
-	if n1ptr == lnk.Dst {
-		return false
+	if err != nil {
+		return fmt.Errorf("%w: Failed to start copy into %s: %v",ErrDBQuery,table,err)
 	}
 
-	//                       Arr,Wgt,Ctx,  Dst
-	linkval := fmt.Sprintf("(%d, %f, %s, (%d,%d)::NodePtr)",lnk.Arr,lnk.Wgt,FormatSQLStringArray(lnk.Ctx),lnk.Dst.Class,lnk.Dst.CPtr)
+	const progress_interval = 1000
 
-	literal := fmt.Sprintf("%s::Link",linkval)
+	for r := range rows {
 
-	link_table := STTypeDBChannel(sttype)
+		_,err = stmt.Exec(rows[r]...)
 
-	qstr := fmt.Sprintf("UPDATE NODE set %s=array_append(%s,%s) where (NPtr).CPtr = '%d' and (NPtr).Chan = '%d' and (%s is null or not %s = ANY(%s))",
-		link_table,
-		link_table,
-		literal,
-		n1ptr.CPtr,
-		n1ptr.Class,
-		link_table,
-		literal,
-		link_table)
+		if err != nil {
+			stmt.Close()
+			return fmt.Errorf("%w: Failed to copy into %s: %v",ErrDBQuery,table,err)
+		}
+
+		if r % progress_interval == 0 {
+			Waiting()
+		}
+	}
 
-	row,err := ctx.DB.Query(qstr)
+	_,err = stmt.Exec()
 
 	if err != nil {
-		fmt.Println("Failed to append",err,qstr)
-	       return false
+		stmt.Close()
+		return fmt.Errorf("%w: Failed to copy into %s: %v",ErrDBQuery,table,err)
 	}
 
-	row.Close()
-	return true
+	return stmt.Close()
 }
 
 // **************************************************************************
 
-func CreateDBNodeArrowNode(ctx PoSST, org NodePtr, dst Link, sttype int) bool {
-
-	qstr := fmt.Sprintf("SELECT IdempInsertNodeArrowNode(" +
-		"%d," + //infromptr
-		"%d," + //infromchan
-		"%d," + //isttype
-		"%d," + //iarr
-		"%.2f," + //iwgt
-		"%s," + //ictx
-		"%d," + //intoptr
-		"%d " + //intochan,
-		")",
-		org.CPtr,
-		org.Class,
-		sttype,
-		dst.Arr,
-		dst.Wgt,
-		FormatSQLStringArray(dst.Ctx),
-		dst.Dst.CPtr,
-		dst.Dst.Class)
+func SQLConn(ctx PoSST) SQLHandle {
 
-	row,err := ctx.DB.Query(qstr)
+	// The current transaction, if any, else the database
 
-	if err != nil {
-		fmt.Println("Failed to make node-arrow-node",err,qstr)
-	       return false
+	if ctx.Tx != nil {
+		return ctx.Tx
 	}
 
-	row.Close()
-	return true
+	return ctx.DB
 }
 
 // **************************************************************************
 
-func DefineStoredFunctions(ctx PoSST) {
+func TryBeginTransaction(ctx PoSST) (PoSST,bool,error) {
 
-	// NB! these functions are in "plpgsql" language, NOT SQL. They look similar but they are DIFFERENT!
-	
-	// Insert a node structure, also an anchor for and containing link arrays
-	
-	cols := I_MEXPR+","+I_MCONT+","+I_MLEAD+","+I_NEAR +","+I_PLEAD+","+I_PCONT+","+I_PEXPR
+	// Returns a context inside a transaction, and whether we started it
+	// (and so must end it) or joined one that was already open. The
+	// in-memory store has nothing to roll back, so doesn't need one
 
-	qstr := fmt.Sprintf("CREATE OR REPLACE FUNCTION IdempInsertNode(iLi INT, iszchani INT, icptri INT, iSi TEXT, ichapi TEXT)\n" +
-		"RETURNS TABLE (    \n" +
-		"    ret_cptr INTEGER," +
-		"    ret_channel INTEGER" +
-		") AS $fn$ " +
-		"DECLARE \n" +
-		"BEGIN\n" +
-		"  IF NOT EXISTS (SELECT (NPtr).Chan,(NPtr).CPtr FROM Node WHERE lower(s) = lower(iSi)) THEN\n" +
-		"     INSERT INTO Node (Nptr.Chan,Nptr.Cptr,L,S,chap,%s) VALUES (iszchani,icptri,iLi,iSi,ichapi,'{}','{}','{}','{}','{}','{}','{}');" +
-		"  END IF;\n" +
-		"  RETURN QUERY SELECT (NPtr).Chan,(NPtr).CPtr FROM Node WHERE s = iSi;\n" +
-		"END ;\n" +
-		"$fn$ LANGUAGE plpgsql;",cols);
+	if ctx.Store != nil || ctx.Tx != nil {
+		return ctx,false,nil
+	}
+
+	tx,err := ctx.DB.Begin()
 
-	row,err := ctx.DB.Query(qstr)
-	
 	if err != nil {
-		fmt.Println("Error defining postgres function:",qstr,err)
+		return ctx,false,fmt.Errorf("%w: Failed to begin transaction: %v",ErrDBQuery,err)
 	}
 
-	row.Close()
+	ctx.Tx = tx
+	return ctx,true,nil
+}
 
-	qstr = "CREATE OR REPLACE FUNCTION IdempAppendNode(iLi INT, iszchani INT, iSi TEXT, ichapi TEXT)\n" +
-		"RETURNS TABLE (    \n" +
-		"    ret_cptr INTEGER," +
-		"    ret_channel INTEGER" +
-		") AS $fn$ " +
-		"DECLARE \n" +
-		"    icptri INT = 0;" +
-		"BEGIN\n" +
-		"  IF NOT EXISTS (SELECT (NPtr).Chan,(NPtr).CPtr FROM Node WHERE s = iSi) THEN\n" +
-		"     SELECT max((Nptr).CPtr) INTO icptri FROM Node WHERE (Nptr).Chan=iszchani;\n"+
-		"     INSERT INTO Node (Nptr.Chan,Nptr.Cptr,L,S,chap) VALUES (iszchani,icptri+1,iLi,iSi,ichapi);" +
-		"  END IF;\n" +
-		"  RETURN QUERY SELECT (NPtr).Chan,(NPtr).CPtr FROM Node WHERE s = iSi;\n" +
-		"END ;\n" +
-		"$fn$ LANGUAGE plpgsql;";
+// **************************************************************************
+
+func TryEndTransaction(ctx PoSST,owned bool,err error) error {
+
+	// Commit what we started if all went well, else roll it back
+
+	if !owned {
+		return err
+	}
 
-	row,err = ctx.DB.Query(qstr)
-	
 	if err != nil {
-		fmt.Println("Error defining postgres function:",qstr,err)
+		ctx.Tx.Rollback()
+		return err
 	}
 
-	row.Close()
+	err = ctx.Tx.Commit()
 
-	// For lookup by arrow
+	if err != nil {
+		return fmt.Errorf("%w: Failed to commit transaction: %v",ErrDBQuery,err)
+	}
 
-	qstr = "CREATE OR REPLACE FUNCTION IdempInsertNodeArrowNode\n" +
-		"(\n" +
-		"infromptr  int,   \n" +
-		"infromchan int,   \n" +
-		"isttype    int,   \n" +
-		"iarr       int,   \n" +
-		"iwgt       real,  \n" +
-		"ictx       text[],\n" +
-		"intoptr    int,   \n" +
-		"intochan   int    \n" +
-		")\n" +
+	return nil
+}
 
-		"RETURNS real AS $fn$ " +
+// **************************************************************************
 
-		"DECLARE \n" +
-		"  ret_wgt real;\n" +
-		"BEGIN\n" +
+func TryAllocateArrowPtrs(ctx PoSST) (map[ArrowPtr]ArrowPtr,error) {
 
-		"  IF NOT EXISTS (SELECT Wgt FROM NodeArrowNode WHERE (NFrom).Cptr=infromptr AND Arr=iarr AND (NTo).Cptr=intoptr) THEN\n" +
+	// Arrows are identified by name in the database, so map each local
+	// ArrowPtr to the stored arrow with the same long and short names,
+	// or to a new slot after those. An arrow that matches only one of its
+	// names, or has changed its type or inverse, would silently change the
+	// meaning of stored links, so it stops the upload
 
-		"     INSERT INTO NodeArrowNode (nfrom.Cptr,nfrom.Chan,sttype,arr,wgt,ctx,nto.Cptr,nto.Chan) \n" +
-		"       VALUES (infromptr,infromchan,isttype,iarr,iwgt,ictx,intoptr,intochan);" +
+	var remap = make(map[ArrowPtr]ArrowPtr)
+	var conflicts []error
 
-		"  END IF;\n" +
-		"  SELECT Wgt into ret_wgt FROM NodeArrowNode WHERE (NFrom).Cptr=infromptr AND Arr=iarr AND (NTo).Cptr=intoptr;\n" +
-		"  RETURN ret_wgt;" +
-		"END ;\n" +
-		"$fn$ LANGUAGE plpgsql;";
+	stored,inverses,err := TryGetDBArrowDirectory(ctx)
 
-	row,err = ctx.DB.Query(qstr)
-	
 	if err != nil {
-		fmt.Println("Error defining postgres function:",qstr,err)
+		return nil,err
 	}
 
-	row.Close()
+	var bylong = make(map[string]ArrowDirectory)
+	var byshort = make(map[string]ArrowDirectory)
+	var byptr = make(map[ArrowPtr]ArrowDirectory)
+	var next ArrowPtr = 0
 
-	// Construct an empty link pointing nowhere as a starting node
+	for a := range stored {
 
-	qstr = "CREATE OR REPLACE FUNCTION GetSingletonAsLinkArray(start NodePtr)\n"+
-		"RETURNS Link[] AS $fn$\n"+
-		"DECLARE \n"+
-		"    level Link[] := Array[] :: Link[];\n"+
-		"    lnk Link := (0,1.0,Array[]::text[],(0,0));\n"+
-		"BEGIN\n"+
-		" lnk.Dst = start;\n"+
-		" level = array_append(level,lnk);\n"+
-		"RETURN level; \n"+
-		"END ;\n"+
-		"$fn$ LANGUAGE plpgsql;"
+		bylong[stored[a].Long] = stored[a]
+		byshort[stored[a].Short] = stored[a]
+		byptr[stored[a].Ptr] = stored[a]
 
-	row,err = ctx.DB.Query(qstr)
-	
-	if err != nil {
-		fmt.Println("Error defining postgres function:",qstr,err)
+		if stored[a].Ptr >= next {
+			next = stored[a].Ptr + 1
+		}
 	}
 
-	row.Close()
+	for a := range ARROW_DIRECTORY {
 
-	// Construct an empty link pointing nowhere as a starting node
+		local := ARROW_DIRECTORY[a]
+		long,haslong := bylong[local.Long]
+		short,hasshort := byshort[local.Short]
 
-	qstr = "CREATE OR REPLACE FUNCTION GetSingletonAsLink(start NodePtr)\n"+
-		"RETURNS Link AS $fn$\n"+
-		"DECLARE \n"+
-		"    lnk Link := (0,1.0,Array[]::text[],(0,0));\n"+
-		"BEGIN\n"+
-		" lnk.Dst = start;\n"+
-		"RETURN lnk; \n"+
-		"END ;\n"+
-		"$fn$ LANGUAGE plpgsql;"
+		switch {
 
-	row,err = ctx.DB.Query(qstr)
-	
-	if err != nil {
-		fmt.Println("Error defining postgres function:",qstr,err)
+		case !haslong && !hasshort:
+			remap[local.Ptr] = next
+			next++
+
+		case haslong && hasshort && long.Ptr == short.Ptr && long.STAindex == local.STAindex:
+			remap[local.Ptr] = long.Ptr
+
+		case haslong && hasshort && long.Ptr == short.Ptr:
+			conflicts = append(conflicts,fmt.Errorf("%w: arrow (%s) has changed type from %s to %s",ErrArrowConflict,local.Long,
+				STTypeName(STIndexToSTType(long.STAindex)),STTypeName(STIndexToSTType(local.STAindex))))
+
+		case haslong:
+			conflicts = append(conflicts,fmt.Errorf("%w: arrow (%s) was stored with short name (%s) not (%s)",ErrArrowConflict,local.Long,long.Short,local.Short))
+
+		default:
+			conflicts = append(conflicts,fmt.Errorf("%w: short name (%s) for (%s) is already used by (%s)",ErrArrowConflict,local.Short,local.Long,short.Long))
+		}
 	}
 
-	row.Close()
+	for plus,minus := range INVERSE_ARROWS {
 
-	// Construct search by sttype. since table names are static we need a case statement
+		rplus,okplus := remap[plus]
+		rminus,okminus := remap[minus]
 
-	qstr = "CREATE OR REPLACE FUNCTION GetNeighboursByType(start NodePtr, sttype int)\n"+
-		"RETURNS Link[] AS $fn$\n"+
-		"DECLARE \n"+
-		"    fwdlinks Link[] := Array[] :: Link[];\n"+
-		"    lnk Link := (0,1.0,Array[]::text[],(0,0));\n"+
-		"BEGIN\n"+
-		"   CASE sttype \n"
-	
-	for st := -EXPRESS; st <= EXPRESS; st++ {
-		qstr += fmt.Sprintf("WHEN %d THEN\n"+
-			"     SELECT %s INTO fwdlinks FROM Node WHERE Nptr=start;\n",st,STTypeDBChannel(st));
+		if !okplus || !okminus {
+			continue
+		}
+
+		if stored_minus,ok := inverses[rplus]; ok && stored_minus != rminus {
+			conflicts = append(conflicts,fmt.Errorf("%w: inverse of (%s) was stored as (%s) not (%s)",ErrArrowConflict,
+				ARROW_DIRECTORY[plus].Long,byptr[stored_minus].Long,ARROW_DIRECTORY[minus].Long))
+		}
 	}
-	qstr += "ELSE RAISE EXCEPTION 'No such sttype %', sttype;\n" +
-		"END CASE;\n" +
-		"    RETURN fwdlinks; \n" +
-		"END ;\n" +
-		"$fn$ LANGUAGE plpgsql;\n"
 
-	row,err = ctx.DB.Query(qstr)
-	
+	if conflicts != nil {
+		return nil,errors.Join(conflicts...)
+	}
+
+	return remap,nil
+}
+
+// **************************************************************************
+
+func TryAllocateNodePtrs(ctx PoSST) (map[NodePtr]NodePtr,error) {
+
+	// Map each in-memory NodePtr to the one it will have in the database.
+	// Texts already stored keep their pointer (IdempInsertNode merges
+	// texts that differ only by case, so we do the same), new texts are
+	// numbered after the highest pointer in their class
+
+	var remap = make(map[NodePtr]NodePtr)
+
+	top,err := TryGetDBTopNodePtrs(ctx)
+
 	if err != nil {
-		fmt.Println("Error defining postgres function:",qstr,err)
+		return nil,err
 	}
 
-	row.Close()
+	for class := N1GRAM; class <= GT1024; class++ {
 
-	// Get the nearest neighbours as NPtr, with respect to each of the four STtype
+		nodes := GetMemoryNodesByClass(class)
 
-	qstr = fmt.Sprintf("CREATE OR REPLACE FUNCTION GetFwdNodes(start NodePtr,exclude NodePtr[],sttype int)\n"+
-		"RETURNS NodePtr[] AS $fn$\n" +
-		"DECLARE \n" +
-		"    neighbours NodePtr[];\n" +
-		"    fwdlinks Link[];\n" +
-		"    lnk Link;\n" +
-		"BEGIN\n" +
+		if len(nodes) == 0 {
+			continue
+		}
 
-		"    fwdlinks =GetNeighboursByType(start,sttype);\n"+
+		var texts []string
 
-		"    IF fwdlinks IS NULL THEN\n" +
-		"        RETURN '{}';\n" +
-		"    END IF;\n" +
+		for n := range nodes {
+			texts = append(texts,nodes[n].S)
+		}
 
-		"    neighbours := ARRAY[]::NodePtr[];\n" +
+		existing,err := TryGetDBNodePtrsByText(ctx,texts)
 
-		"    FOREACH lnk IN ARRAY fwdlinks\n" +
-		"    LOOP\n"+
-		"      IF lnk.Arr = 0 THEN\n"+
-		"         CONTINUE;"+
-		"      END IF;\n"+
-		"      IF exclude is not NULL AND NOT lnk.dst=ANY(exclude) THEN\n" +
-		"         neighbours := array_append(neighbours, lnk.dst);\n" +
-		"      END IF; \n" +
-		"    END LOOP;\n" +
+		if err != nil {
+			return nil,err
+		}
 
-		"    RETURN neighbours; \n" +
-		"END ;\n" +
-		"$fn$ LANGUAGE plpgsql;\n")
+		// An exact match is taken first, below. Otherwise, of several
+		// stored texts that differ only by case, take the oldest, so
+		// that every upload chooses the same one
 
-	row,err = ctx.DB.Query(qstr)
-	
-	if err != nil {
-		fmt.Println("Error defining postgres function:",qstr,err)
-	}
+		var lower = make(map[string]NodePtr)
 
-	row.Close()
+		for text,nptr := range existing {
+			key := strings.ToLower(text)
+			if prev,dup := lower[key]; !dup || nptr.CPtr < prev.CPtr {
+				lower[key] = nptr
+			}
+		}
 
-	// Basic quick neighbour probe
+		next,used := top[class]
 
-	qstr = fmt.Sprintf("CREATE OR REPLACE FUNCTION GetFwdLinks(start NodePtr,exclude NodePtr[],sttype int)\n"+
-		"RETURNS Link[] AS $fn$\n" +
-		"DECLARE \n" +
-		"    neighbours Link[];\n" +
-		"    fwdlinks Link[];\n" +
-		"    lnk Link;\n" +
-		"BEGIN\n" +
+		if used {
+			next++
+		}
 
-		"    fwdlinks = GetNeighboursByType(start,sttype);\n"+
+		for n := range nodes {
 
-		"    IF fwdlinks IS NULL THEN\n" +
-		"        RETURN '{}';\n" +
-		"    END IF;\n" +
-		"    neighbours := ARRAY[]::Link[];\n" +
-		"    FOREACH lnk IN ARRAY fwdlinks\n" +
-		"    LOOP\n"+
-		"      IF lnk.Arr = 0 THEN\n"+
-		"         CONTINUE;"+
-		"      END IF;\n"+
-		"      IF exclude is not NULL AND NOT lnk.dst=ANY(exclude) THEN\n" +
-		"         neighbours := array_append(neighbours, lnk);\n" +
-		"      END IF; \n" + 
-		"    END LOOP;\n" +
-		"    RETURN neighbours; \n" +
-		"END ;\n" +
-		"$fn$ LANGUAGE plpgsql;\n")
-	
-	row,err = ctx.DB.Query(qstr)
-	
-	if err != nil {
-		fmt.Println("Error defining postgres function:",qstr,err)
+			if nptr,ok := existing[nodes[n].S]; ok {
+				remap[nodes[n].NPtr] = nptr
+				continue
+			}
+
+			if nptr,ok := lower[strings.ToLower(nodes[n].S)]; ok {
+				remap[nodes[n].NPtr] = nptr
+				continue
+			}
+
+			var nptr NodePtr
+			nptr.Class = class
+			nptr.CPtr = next
+			next++
+
+			remap[nodes[n].NPtr] = nptr
+			lower[strings.ToLower(nodes[n].S)] = nptr
+		}
 	}
 
-	row.Close()
-	
-	// Get the forward cone / half-ball as NPtr
+	return remap,nil
+}
 
-	qstr = "CREATE OR REPLACE FUNCTION FwdConeAsNodes(start NodePtr,sttype INT, maxdepth INT)\n"+
-		"RETURNS NodePtr[] AS $fn$\n" +
-		"DECLARE \n" +
-		"    nextlevel NodePtr[];\n" +
-		"    partlevel NodePtr[];\n" +
-		"    level NodePtr[] = ARRAY[start]::NodePtr[];\n" +
-		"    exclude NodePtr[] = ARRAY['(0,0)']::NodePtr[];\n" +
-		"    cone NodePtr[];\n" +
-		"    neigh NodePtr;\n" +
-		"    frn NodePtr;\n" +
-		"    counter int := 0;\n" +
+// **************************************************************************
 
-		"BEGIN\n" +
+func RemapNodePtr(nptr NodePtr,remap map[NodePtr]NodePtr) NodePtr {
 
-		"LOOP\n" +
-		"  EXIT WHEN counter = maxdepth+1;\n" +
+	if newptr,ok := remap[nptr]; ok {
+		return newptr
+	}
 
-		"  IF level IS NULL THEN\n" +
-		"     RETURN cone;\n" +
-		"  END IF;\n" +
+	return nptr
+}
 
-		"  nextlevel := ARRAY[]::NodePtr[];\n" +
+// **************************************************************************
 
-		"  FOREACH frn IN ARRAY level "+
-		"  LOOP \n"+
-		"     nextlevel = array_append(nextlevel,frn);\n" +
-		"  END LOOP;\n" +
+func RemapArrowPtr(arrow ArrowPtr,arrows map[ArrowPtr]ArrowPtr) ArrowPtr {
 
-		"  IF nextlevel IS NULL THEN\n" +
-		"     RETURN cone;\n" +
-		"  END IF;\n" +
+	if newptr,ok := arrows[arrow]; ok {
+		return newptr
+	}
 
-		"  FOREACH neigh IN ARRAY nextlevel LOOP \n"+
-		"    IF NOT neigh = ANY(exclude) THEN\n" +
-		"      cone = array_append(cone,neigh);\n" +
-		"      exclude := array_append(exclude,neigh);\n" +
-		"      partlevel := GetFwdNodes(neigh,exclude,sttype);\n" +
-		"    END IF;" +
-		"    IF partlevel IS NOT NULL THEN\n" +
-		"         level = array_cat(level,partlevel);\n"+
-		"    END IF;\n" +
-		"  END LOOP;\n" +
+	return arrow
+}
 
-		// Next, continue, foreach
-		"  counter = counter + 1;\n" +
-		"END LOOP;\n" +
-		
-		"RETURN cone; \n" +
-		"END ;\n" +
-		"$fn$ LANGUAGE plpgsql;\n"
-	
-	row,err = ctx.DB.Query(qstr)
-	
-	if err != nil {
-		fmt.Println("Error defining postgres function:",qstr,err)
-	}
-	
-	row.Close()
-	
-          /* e.g. select unnest(fwdconeaslinks) from FwdConeAsLinks('(4,1)',1,4);
-                           unnest                           
-             ------------------------------------------------------------
-              (0,0,{},"(4,1)")
-              (77,0.34,"{ ""fairy castles"", ""angel air"" }","(4,2)")
-              (77,0.34,"{ ""fairy castles"", ""angel air"" }","(4,3)")
-              (77,0.34,"{ ""steamy hot tubs"" }","(4,5)")
-              (77,0.34,"{ ""fairy castles"", ""angel air"" }","(4,4)")
-              (77,0.34,"{ ""steamy hot tubs"", ""lady gaga"" }","(4,6)")
-             (6 rows)
+// **************************************************************************
 
-          */
+func RemapNode(org Node,remap map[NodePtr]NodePtr,arrows map[ArrowPtr]ArrowPtr) Node {
 
-	qstr = "CREATE OR REPLACE FUNCTION FwdConeAsLinks(start NodePtr,sttype INT,maxdepth INT)\n"+
-		"RETURNS Link[] AS $fn$\n" +
-		"DECLARE \n" +
-		"    nextlevel Link[];\n" +
-		"    partlevel Link[];\n" +
-		"    level Link[] = ARRAY[]::Link[];\n" +
-		"    exclude NodePtr[] = ARRAY['(0,0)']::NodePtr[];\n" +
-		"    cone Link[];\n" +
-		"    neigh Link;\n" +
-		"    frn Link;\n" +
-		"    counter int := 0;\n" +
+	// Return a copy, leaving the in-memory directory addressable as before
 
-		"BEGIN\n" +
+	org.NPtr = RemapNodePtr(org.NPtr,remap)
 
-		"level := GetSingletonAsLinkArray(start);\n"+
+	for stindex := range org.I {
 
-		"LOOP\n" +
-		"  EXIT WHEN counter = maxdepth+1;\n" +
+		links := make([]Link,len(org.I[stindex]))
 
-		"  IF level IS NULL THEN\n" +
-		"     RETURN cone;\n" +
-		"  END IF;\n" +
+		for l := range org.I[stindex] {
+			links[l] = org.I[stindex][l]
+			links[l].Dst = RemapNodePtr(links[l].Dst,remap)
+			links[l].Arr = RemapArrowPtr(links[l].Arr,arrows)
+		}
 
-		"  nextlevel := ARRAY[]::Link[];\n" +
+		org.I[stindex] = links
+	}
 
-		"  FOREACH frn IN ARRAY level "+
-		"  LOOP \n"+
-		"     nextlevel = array_append(nextlevel,frn);\n" +
-		"  END LOOP;\n" +
+	return org
+}
 
-		"  IF nextlevel IS NULL THEN\n" +
-		"     RETURN cone;\n" +
-		"  END IF;\n" +
+// **************************************************************************
 
-		"  FOREACH neigh IN ARRAY nextlevel LOOP \n"+
-		"    IF NOT neigh.Dst = ANY(exclude) THEN\n" +
-		"      cone = array_append(cone,neigh);\n" +
-		"      exclude := array_append(exclude,neigh.Dst);\n" +
-		"      partlevel := GetFwdLinks(neigh.Dst,exclude,sttype);\n" +
-		"    END IF;" +
-		"    IF partlevel IS NOT NULL THEN\n" +
-		"         level = array_cat(level,partlevel);\n"+
-		"    END IF;\n" +
-		"  END LOOP;\n" +
+func RemapPageMap(line PageMap,remap map[NodePtr]NodePtr,arrows map[ArrowPtr]ArrowPtr) PageMap {
 
-		"  counter = counter + 1;\n" +
-		"END LOOP;\n" +
+	path := make([]Link,len(line.Path))
 
-		"RETURN cone; \n" +
-		"END ;\n" +
-		"$fn$ LANGUAGE plpgsql;\n"
+	for l := range line.Path {
+		path[l] = line.Path[l]
+		path[l].Dst = RemapNodePtr(path[l].Dst,remap)
+		path[l].Arr = RemapArrowPtr(path[l].Arr,arrows)
+	}
 
-	row,err = ctx.DB.Query(qstr)
-	
-	if err != nil {
-		fmt.Println("Error defining postgres function:",qstr,err)
+	line.Path = path
+	return line
+}
+
+// **************************************************************************
+
+func RemapProvenance(prov *Provenance,remap map[NodePtr]NodePtr,arrows map[ArrowPtr]ArrowPtr) ([]NodePtr,[]LinkKey) {
+
+	var nodes []NodePtr
+	var links []LinkKey
+
+	for nptr := range prov.Nodes {
+		nodes = append(nodes,RemapNodePtr(nptr,remap))
 	}
 
-	row.Close()
+	for key := range prov.Links {
+		links = append(links,RemapLinkKey(key,remap,arrows))
+	}
 
-	// Orthogonal (depth first) paths from origin spreading out
+	return nodes,links
+}
 
-	qstr = "CREATE OR REPLACE FUNCTION FwdPathsAsLinks(start NodePtr,sttype INT,maxdepth INT)\n"+
-		"RETURNS Text AS $fn$\n" +
-		"DECLARE\n" +
-		"   hop Text;\n" +
-		"   path Text;\n"+
-		"   summary_path Text[];\n"+
-		"   exclude NodePtr[] = ARRAY[start]::NodePtr[];\n" +
-		"   ret_paths Text;\n" +
-		"   startlnk Link;"+
+// **************************************************************************
 
-		"BEGIN\n" +
+func RemapLinkKey(key LinkKey,remap map[NodePtr]NodePtr,arrows map[ArrowPtr]ArrowPtr) LinkKey {
 
-		"startlnk := GetSingletonAsLink(start);\n"+
-		"path := Format('%s',startlnk::Text);\n"+
-		"ret_paths := SumFwdPaths(startlnk,path,sttype,1,maxdepth,exclude);" +
+	key.NFrom = RemapNodePtr(key.NFrom,remap)
+	key.Arr = RemapArrowPtr(key.Arr,arrows)
+	key.NTo = RemapNodePtr(key.NTo,remap)
+	return key
+}
 
-		"RETURN ret_paths; \n" +
-		"END ;\n" +
-		"$fn$ LANGUAGE plpgsql;\n"
+// **************************************************************************
 
-        // select FwdPathsAsLinks('(4,1)',1,3)
+func GetMemoryLink(key LinkKey) (Link,bool) {
+
+	// Find the in-memory link with this arrow and destination
+
+	node := GetNodeFromPtr(key.NFrom)
+
+	for stindex := range node.I {
+		for l := range node.I[stindex] {
+			if node.I[stindex][l].Arr == key.Arr && node.I[stindex][l].Dst == key.NTo {
+				return node.I[stindex][l],true
+			}
+		}
+	}
+
+	var none Link
+	return none,false
+}
+
+// **************************************************************************
+
+func TrySyncSourceFile(ctx PoSST,file string,remap map[NodePtr]NodePtr,arrows map[ArrowPtr]ArrowPtr) error {
+
+	// Compare what this file uploaded last time with what it says now,
+	// and remove the difference, so that the upload that follows leaves
+	// the database as if the file had always read this way. Links that
+	// only changed weight or context are removed too and then re-added
+
+	old_nodes,old_links,err := TryGetDBProvenance(ctx,file)
 
-	row,err = ctx.DB.Query(qstr)
-	
 	if err != nil {
-		fmt.Println("Error defining postgres function:",qstr,err)
+		return err
 	}
 
-	row.Close()
+	var current_nodes = make(map[NodePtr]bool)
+	var current_links = make(map[LinkKey]Link)
 
-	// Return end of path branches as aggregated text summaries
+	for nptr := range PROVENANCE[file].Nodes {
+		current_nodes[RemapNodePtr(nptr,remap)] = true
+	}
 
-	qstr = "CREATE OR REPLACE FUNCTION SumFwdPaths(start Link,path TEXT, sttype INT,depth int, maxdepth INT,exclude NodePtr[])\n"+
-		"RETURNS Text AS $fn$\n" +
-		"DECLARE \n" + 
-		"    fwdlinks Link[];\n" +
-		"    empty Link[] = ARRAY[]::Link[];\n" +
-		"    lnk Link;\n" +
-		"    fwd Link;\n" +
-		"    ret_paths Text;\n" +
-		"    appendix Text;\n" +
-		"    tot_path Text;\n"+
-		"BEGIN\n" +
+	for key := range PROVENANCE[file].Links {
 
-		"IF depth = maxdepth THEN\n"+
-		"  ret_paths := Format('%s\n%s',ret_paths,path);\n"+
-		"  RETURN ret_paths;\n"+
-		"END IF;\n"+
+		lnk,ok := GetMemoryLink(key)
 
-		"fwdlinks := GetFwdLinks(start.Dst,exclude,sttype);\n" +
+		if ok {
+			current_links[RemapLinkKey(key,remap,arrows)] = lnk
+		}
+	}
 
-		"FOREACH lnk IN ARRAY fwdlinks LOOP \n" +
-		"   IF NOT lnk.Dst = ANY(exclude) THEN\n"+
-		"      exclude = array_append(exclude,lnk.Dst);\n" +
-		"      IF lnk IS NULL THEN" +
-		          // set end of path as return val
-		"         ret_paths := Format('%s\n%s',ret_paths,path);\n"+
-		"         RETURN ret_paths;"+
-		"      ELSE\n"+
-		          // Add to the path and descend into new link
-		"         tot_path := Format('%s;%s',path,lnk::Text);\n"+
-		"         appendix := SumFwdPaths(lnk,tot_path,sttype,depth+1,maxdepth,exclude);\n" +
-		          // when we return, we reached the end of one path
-		"         IF appendix IS NOT NULL THEN\n"+
-	                     // append full path to list of all paths, separated by newlines
-		"            ret_paths := Format('%s\n%s',ret_paths,appendix);\n"+
-		"         ELSE"+
-		"            ret_paths := Format('%s\n%s',ret_paths,tot_path);"+
-		"         END IF;"+
-		"      END IF;"+
-		"   END IF;"+
-		"END LOOP;"+
+	var stale_nodes []NodePtr
+	var removed,changed []LinkKey
 
-		"RETURN ret_paths; \n" +
-		"END ;\n" +
-		"$fn$ LANGUAGE plpgsql;\n"
+	for _,nptr := range old_nodes {
+		if !current_nodes[nptr] {
+			stale_nodes = append(stale_nodes,nptr)
+		}
+	}
 
-	row,err = ctx.DB.Query(qstr)
-	
-	if err != nil {
-		fmt.Println("Error defining postgres function:",qstr,err)
+	for _,old := range old_links {
+
+		key := LinkKey{NFrom: old.NFrom, Arr: old.Arr, NTo: old.NTo}
+		lnk,still := current_links[key]
+
+		switch {
+		case !still:
+			removed = append(removed,key)
+		case float32(lnk.Wgt) != float32(old.Wgt) || CompareStringArrays(SortedContext(lnk.Ctx),SortedContext(old.Ctx)) != 0:
+			changed = append(changed,key)
+		}
 	}
 
-	row.Close()
+	if len(stale_nodes)+len(removed)+len(changed) > 0 {
+		fmt.Printf(" - %s: %d nodes, %d links removed and %d links changed\n",file,len(stale_nodes),len(removed),len(changed))
+	}
 
-	// Typeless cone searches
+	return TryRemoveDBSourceContribution(ctx,file,stale_nodes,removed,changed)
+}
 
-	qstr = "CREATE OR REPLACE FUNCTION AllPathsAsLinks(start NodePtr,orientation text,maxdepth INT)\n"+
-		"RETURNS Text AS $fn$\n" +
-		"DECLARE\n" +
-		"   hop Text;\n" +
-		"   path Text;\n"+
-		"   summary_path Text[];\n"+
-		"   exclude NodePtr[] = ARRAY[start]::NodePtr[];\n" +
-		"   ret_paths Text;\n" +
-		"   startlnk Link;"+
+// **************************************************************************
+// Store
+// **************************************************************************
 
-		"BEGIN\n" +
+func CreateDBNode(ctx PoSST, n Node) Node {
 
-		"startlnk := GetSingletonAsLink(start);\n"+
-		"path := Format('%s',startlnk::Text);\n"+
-		"ret_paths := SumAllPaths(startlnk,path,orientation,1,maxdepth,exclude);" +
-		
-		"RETURN ret_paths; \n" +
-		"END ;\n" +
-		"$fn$ LANGUAGE plpgsql;\n"
+	n,err := TryCreateDBNode(ctx,n)
+
+	if err != nil && !strings.Contains(err.Error(),"duplicate key") {
+		fmt.Println(err)
+	}
+
+	return n
+}
+
+// **************************************************************************
+
+func TryCreateDBNode(ctx PoSST, n Node) (Node,error) {
+
+	if ctx.Store != nil {
+		return ctx.Store.CreateNode(n)
+	}
+
+	ctx.Cache.Forget()
+
+	var qstr string
+
+	// No need to trust the values
+
+        n.L,n.NPtr.Class = StorageClass(n.S)
 	
-        // select AllPathsAsLinks('(4,1)',3)
+	cptr := n.NPtr.CPtr
 
-	row,err = ctx.DB.Query(qstr)
+	qstr = "SELECT IdempInsertNode($1,$2,$3,$4,$5)"
+
+	row,err := SQLConn(ctx).Query(qstr,n.L,n.NPtr.Class,cptr,n.S,n.Chap)
 	
 	if err != nil {
-		fmt.Println("Error defining postgres function:",qstr,err)
+		return n,fmt.Errorf("%w: failed to insert node: %v\n%s",ErrDBQuery,err,qstr)
+	}
+
+	var whole string
+	var cl,ch int
+
+	for row.Next() {		
+		err = row.Scan(&whole)
+		fmt.Sscanf(whole,"(%d,%d)",&cl,&ch)
 	}
 
+	n.NPtr.Class = cl
+	n.NPtr.CPtr = ClassedNodePtr(ch)
+
 	row.Close()
 
-	// SumAllPaths
+	return n,err
+}
 
-	qstr = "CREATE OR REPLACE FUNCTION SumAllPaths(start Link,path TEXT,orientation text,depth int, maxdepth INT,exclude NodePtr[])\n"+
-		"RETURNS Text AS $fn$\n" +
-		"DECLARE \n" + 
-		"    fwdlinks Link[];\n" +
-		"    stlinks  Link[];\n" +
-		"    empty Link[] = ARRAY[]::Link[];\n" +
-		"    lnk Link;\n" +
-		"    fwd Link;\n" +
-		"    ret_paths Text;\n" +
-		"    appendix Text;\n" +
-		"    tot_path Text;\n"+
-		"BEGIN\n" +
+// **************************************************************************
 
-		"IF depth = maxdepth THEN\n"+
-		"  ret_paths := Format('%s\n%s',ret_paths,path);\n"+
-		"  RETURN ret_paths;\n"+
-		"END IF;\n"+
+func UploadNodeToDB(ctx PoSST, org Node) {
 
-		// Get *All* in/out Links
-		"CASE \n" +
-		"   WHEN orientation = 'bwd' THEN\n" +
-		"     stlinks := GetFwdLinks(start.Dst,exclude,-3);\n" +
+	CreateDBNode(ctx, org)
+
+	const nolink = 999
+	var empty Link
+
+	for stindex := range org.I {
+
+		for lnk := range org.I[stindex] {
+
+			dstlnk := org.I[stindex][lnk]
+			sttype := STIndexToSTType(stindex)
+
+			AppendDBLinkToNode(ctx,org.NPtr,dstlnk,sttype)
+			CreateDBNodeArrowNode(ctx,org.NPtr,dstlnk,sttype)
+			Waiting()
+		}
+
+		CreateDBNodeArrowNode(ctx,org.NPtr,empty,nolink)
+		Waiting()
+	}
+}
+
+// **************************************************************************
+
+func UploadArrowToDB(ctx PoSST,arrow ArrowPtr) {
+
+	err := TryUploadArrowToDB(ctx,ARROW_DIRECTORY[arrow])
+
+	if err != nil && !strings.Contains(err.Error(),"duplicate key") {
+		fmt.Println(err)
+	}
+}
+
+// **************************************************************************
+
+func TryUploadArrowToDB(ctx PoSST,ad ArrowDirectory) error {
+
+	if ctx.Store != nil {
+		return ctx.Store.AddArrow(ad)
+	}
+
+	qstr := "INSERT INTO ArrowDirectory (STAindex,Long,Short,ArrPtr) VALUES ($1,$2,$3,$4)"
+
+	row,err := SQLConn(ctx).Query(qstr,ad.STAindex,ad.Long,ad.Short,ad.Ptr)
+	
+	if err != nil {
+		return fmt.Errorf("%w: Failed to insert arrow: %v\n%s",ErrDBQuery,err,qstr)
+	}
+
+	row.Close()
+	return nil
+}
+// **************************************************************************
+
+func UploadInverseArrowToDB(ctx PoSST,arrow ArrowPtr) {
+
+	err := TryUploadInverseArrowToDB(ctx,arrow,INVERSE_ARROWS[arrow])
+
+	if err != nil && !strings.Contains(err.Error(),"duplicate key") {
+		fmt.Println(err)
+	}
+}
+
+// **************************************************************************
+
+func TryUploadInverseArrowToDB(ctx PoSST,plus,minus ArrowPtr) error {
+
+	if ctx.Store != nil {
+		return ctx.Store.AddInverseArrow(plus,minus)
+	}
+
+	qstr := "INSERT INTO ArrowInverses (Plus,Minus) VALUES ($1,$2)"
+
+	row,err := SQLConn(ctx).Query(qstr,plus,minus)
+	
+	if err != nil {
+		return fmt.Errorf("%w: Failed to insert inverse arrow: %v\n%s",ErrDBQuery,err,qstr)
+	}
+
+	row.Close()
+	return nil
+}
+//**************************************************************
+
+func UploadPageMapEvent(ctx PoSST, line PageMap) {
+
+	err := TryUploadPageMapEvent(ctx,line)
+
+	if err != nil && !strings.Contains(err.Error(),"duplicate key") {
+		fmt.Println(err)
+	}
+}
+
+//**************************************************************
+
+func TryUploadPageMapEvent(ctx PoSST, line PageMap) error {
+
+	if ctx.Store != nil {
+		return ctx.Store.AddPageMapEvent(line)
+	}
+
+	qstr := "INSERT INTO PageMap (Chap,Alias,Ctx,Line,File) VALUES ($1,$2,$3::text[],$4,$5)"
+
+	row,err := SQLConn(ctx).Query(qstr,line.Chapter,line.Alias,SQLStringArray(line.Context),line.Line,line.File)
+	
+	if err != nil {
+		return fmt.Errorf("%w: Failed to insert pagemap event: %v\n%s",ErrDBQuery,err,qstr)
+	}
+
+	row.Close()
+
+	var errs []error
+
+	for lnk := 0; lnk < len(line.Path); lnk++ {
+
+		args := []interface{}{line.Chapter,line.Line,line.File}
+		literal := SQLLinkArg(&args,line.Path[lnk])
+		
+		qstr := fmt.Sprintf("UPDATE PageMap SET Path=array_append(Path,%s) WHERE Chap = $1 AND Line = $2 AND File IS NOT DISTINCT FROM $3",literal)
+		
+		row,err := SQLConn(ctx).Query(qstr,args...)
+		
+		if err != nil {
+			errs = append(errs,fmt.Errorf("%w: Failed to append: %v\n%s",ErrDBQuery,err,qstr))
+			continue
+		}
+		
+		row.Close()
+	}
+
+	return errors.Join(errs...)
+}
+//**************************************************************
+
+func IdempDBAddLink(ctx PoSST,from Node,link Link,to Node) {
+
+	err := TryIdempDBAddLink(ctx,from,link,to)
+
+	if err != nil {
+		fmt.Println(err)
+
+		if errors.Is(err,ErrSelfLoop) || errors.Is(err,ErrNoArrowsDefined) || errors.Is(err,ErrSTOutOfBounds) {
+			os.Exit(-1)
+		}
+	}
+}
+
+//**************************************************************
+
+func TryIdempDBAddLink(ctx PoSST,from Node,link Link,to Node) error {
+
+	frptr := from.NPtr
+	toptr := to.NPtr
+
+	link.Dst = toptr // it might have changed, so override
+
+	if frptr == toptr {
+		return fmt.Errorf("%w: %s",ErrSelfLoop,from.S)
+	}
+
+	if link.Arr < 0 || int(link.Arr) >= len(ARROW_DIRECTORY) {
+		return ErrNoArrowsDefined
+	}
+
+	sttype := STIndexToSTType(ARROW_DIRECTORY[link.Arr].STAindex)
+
+	_,err := TryAppendDBLinkToNode(ctx,frptr,link,sttype)
+
+	if err != nil {
+		return err
+	}
+
+	// Double up the reverse definition for easy indexing of both in/out arrows
+	// But be careful not the make the graph undirected by mistake
+
+	var invlink Link
+	invlink.Arr = INVERSE_ARROWS[link.Arr]
+	invlink.Wgt = link.Wgt
+	invlink.Dst = frptr
+	_,err = TryAppendDBLinkToNode(ctx,toptr,invlink,-sttype)
+
+	return err
+}
+
+//**************************************************************
+
+func DeleteDBLink(ctx PoSST,from Node,link Link,to Node) {
+
+	err := TryDeleteDBLink(ctx,from,link,to)
+
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+//**************************************************************
+
+func TryDeleteDBLink(ctx PoSST,from Node,link Link,to Node) (err error) {
+
+	// Undo IdempDBAddLink(), removing the link and its inverse from the
+	// Link[] columns of both nodes, NodeArrowNode and the provenance.
+	// The arrows are those stored, not those loaded in memory, which
+	// a program need not have done
+
+	ctx,owned,err := TryBeginTransaction(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		err = TryEndTransaction(ctx,owned,err)
+	}()
+
+	inverse,ok,err := TryGetDBArrowInverse(ctx,link.Arr)
+
+	if err != nil {
+		return err
+	}
+
+	err = TryRemoveDBLink(ctx,LinkKey{NFrom: from.NPtr, Arr: link.Arr, NTo: to.NPtr})
+
+	if err != nil || !ok {
+		return err
+	}
+
+	return TryRemoveDBLink(ctx,LinkKey{NFrom: to.NPtr, Arr: inverse, NTo: from.NPtr})
+}
+
+//**************************************************************
+
+func TryGetDBArrowInverse(ctx PoSST,arr ArrowPtr) (ArrowPtr,bool,error) {
+
+	// The stored inverse of an arrow, and whether it has one, or
+	// ErrNoSuchArrow if the arrow itself isn't stored
+
+	if ctx.Store != nil {
+
+		arrows,inverses,err := ctx.Store.GetArrowDirectory()
+
+		if err != nil {
+			return 0,false,err
+		}
+
+		for _,ad := range arrows {
+			if ad.Ptr == arr {
+				inverse,ok := inverses[arr]
+				return inverse,ok,nil
+			}
+		}
+
+		return 0,false,fmt.Errorf("%w: (%d)",ErrNoSuchArrow,arr)
+	}
+
+	qstr := "SELECT i.Minus FROM ArrowDirectory a LEFT JOIN ArrowInverses i ON i.Plus=a.ArrPtr WHERE a.ArrPtr=$1"
+
+	row,err := SQLConn(ctx).Query(qstr,arr)
+
+	if err != nil {
+		return 0,false,fmt.Errorf("%w: Failed to look up inverse arrow: %v\n%s",ErrDBQuery,err,qstr)
+	}
+
+	defer row.Close()
+
+	if !row.Next() {
+		return 0,false,fmt.Errorf("%w: (%d)",ErrNoSuchArrow,arr)
+	}
+
+	var minus sql.NullInt64
+
+	err = row.Scan(&minus)
+
+	if err != nil {
+		return 0,false,fmt.Errorf("%w: %v",ErrDBQuery,err)
+	}
+
+	return ArrowPtr(minus.Int64),minus.Valid,nil
+}
+
+//**************************************************************
+
+func TryRemoveDBLink(ctx PoSST,key LinkKey) (err error) {
+
+	// Remove one direction of a link, whichever channel it is in
+
+	if ctx.Store != nil {
+		return ctx.Store.RemoveLink(key)
+	}
+
+	ctx.Cache.Forget()
+
+	ctx,owned,err := TryBeginTransaction(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		err = TryEndTransaction(ctx,owned,err)
+	}()
+
+	var unlink []string
+
+	for _,col := range []string{I_MEXPR,I_MCONT,I_MLEAD,I_NEAR,I_PLEAD,I_PCONT,I_PEXPR} {
+		unlink = append(unlink,fmt.Sprintf("%s=ARRAY(SELECT lnk FROM unnest(%s) lnk WHERE NOT ((lnk).Arr=$3 AND (lnk).Dst=($4::int,$5::int)::NodePtr))",col,col))
+	}
+
+	const match = "NFrom=($1::int,$2::int)::NodePtr AND Arr=$3 AND NTo=($4::int,$5::int)::NodePtr"
+
+	for _,qstr := range []string{
+		"UPDATE Node SET "+strings.Join(unlink,",")+" WHERE NPtr=($1::int,$2::int)::NodePtr",
+		"DELETE FROM NodeArrowNode WHERE "+match,
+		"DELETE FROM SourceLink WHERE "+match,
+	} {
+		_,err = ctx.Tx.Exec(qstr,key.NFrom.Class,key.NFrom.CPtr,key.Arr,key.NTo.Class,key.NTo.CPtr)
+
+		if err != nil {
+			return fmt.Errorf("%w: Failed to delete link: %v\n%s",ErrDBQuery,err,qstr)
+		}
+	}
+
+	return nil
+}
+
+//**************************************************************
+
+func DeleteDBNode(ctx PoSST,nptr NodePtr) {
+
+	err := TryDeleteDBNode(ctx,nptr)
+
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+//**************************************************************
+
+func TryDeleteDBNode(ctx PoSST,nptr NodePtr) (err error) {
+
+	// Delete a node and every link to or from it, including the inverses
+	// held by its neighbours and any steps in the page map that lead to it
+
+	if ctx.Store != nil {
+		return ctx.Store.DeleteNode(nptr)
+	}
+
+	ctx.Cache.Forget()
+
+	ctx,owned,err := TryBeginTransaction(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		err = TryEndTransaction(ctx,owned,err)
+	}()
+
+	res,err := ctx.Tx.Exec("DELETE FROM Node WHERE NPtr=($1::int,$2::int)::NodePtr",nptr.Class,nptr.CPtr)
+
+	if err != nil {
+		return fmt.Errorf("%w: Failed to delete node: %v",ErrDBQuery,err)
+	}
+
+	if deleted,_ := res.RowsAffected(); deleted == 0 {
+		return fmt.Errorf("%w: %v",ErrNoSuchNode,nptr)
+	}
+
+	var unlink,all []string
+
+	for _,col := range []string{I_MEXPR,I_MCONT,I_MLEAD,I_NEAR,I_PLEAD,I_PCONT,I_PEXPR} {
+		unlink = append(unlink,fmt.Sprintf("%s=ARRAY(SELECT lnk FROM unnest(%s) lnk WHERE (lnk).Dst<>($1::int,$2::int)::NodePtr)",col,col))
+		all = append(all,col)
+	}
+
+	const links_here = "EXISTS (SELECT 1 FROM unnest(%s) lnk WHERE (lnk).Dst=($1::int,$2::int)::NodePtr)"
+
+	for _,qstr := range []string{
+		"UPDATE Node SET "+strings.Join(unlink,",")+" WHERE "+fmt.Sprintf(links_here,strings.Join(all,"||")),
+		"UPDATE PageMap SET Path=ARRAY(SELECT lnk FROM unnest(Path) lnk WHERE (lnk).Dst<>($1::int,$2::int)::NodePtr) WHERE "+fmt.Sprintf(links_here,"Path"),
+		"DELETE FROM NodeArrowNode WHERE NFrom=($1::int,$2::int)::NodePtr OR NTo=($1::int,$2::int)::NodePtr",
+		"DELETE FROM SourceNode WHERE NPtr=($1::int,$2::int)::NodePtr",
+		"DELETE FROM SourceLink WHERE NFrom=($1::int,$2::int)::NodePtr OR NTo=($1::int,$2::int)::NodePtr",
+	} {
+		_,err = ctx.Tx.Exec(qstr,nptr.Class,nptr.CPtr)
+
+		if err != nil {
+			return fmt.Errorf("%w: Failed to delete node links: %v\n%s",ErrDBQuery,err,qstr)
+		}
+	}
+
+	return nil
+}
+
+//**************************************************************
+
+func DeleteDBChapter(ctx PoSST,chap string) int {
+
+	deleted,err := TryDeleteDBChapter(ctx,chap)
+
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	return deleted
+}
+
+//**************************************************************
+
+func TryDeleteDBChapter(ctx PoSST,chap string) (deleted int,err error) {
+
+	// Delete the chapter's page map and the nodes that belong only to
+	// it (with their links). Nodes that other chapters also mention are
+	// kept, and just lose the chapter from their list, see IdempAddChapterToNode()
+
+	if ctx.Store != nil {
+		return ctx.Store.DeleteChapter(chap)
+	}
+
+	ctx.Cache.Forget()
+
+	ctx,owned,err := TryBeginTransaction(ctx)
+
+	if err != nil {
+		return 0,err
+	}
+
+	defer func() {
+		err = TryEndTransaction(ctx,owned,err)
+	}()
+
+	// Delete the nodes all at once, as TryDeleteDBNode() does one
+
+	qstr := "DELETE FROM Node WHERE Chap=$1 RETURNING NPtr"
+
+	row,err := ctx.Tx.Query(qstr,chap)
+
+	if err != nil {
+		return 0,fmt.Errorf("%w: Failed to delete chapter nodes: %v\n%s",ErrDBQuery,err,qstr)
+	}
+
+	var nodes []NodePtr
+
+	for row.Next() {
+
+		var whole string
+		var nptr NodePtr
+
+		err = row.Scan(&whole)
+
+		if err != nil {
+			row.Close()
+			return 0,fmt.Errorf("%w: %v",ErrDBQuery,err)
+		}
+
+		fmt.Sscanf(whole,"(%d,%d)",&nptr.Class,&nptr.CPtr)
+		nodes = append(nodes,nptr)
+	}
+
+	row.Close()
+
+	var unlink,all []string
+
+	for _,col := range []string{I_MEXPR,I_MCONT,I_MLEAD,I_NEAR,I_PLEAD,I_PCONT,I_PEXPR} {
+		unlink = append(unlink,fmt.Sprintf("%s=ARRAY(SELECT lnk FROM unnest(%s) lnk WHERE NOT (lnk).Dst = ANY($1::NodePtr[]))",col,col))
+		all = append(all,col)
+	}
+
+	const links_here = "EXISTS (SELECT 1 FROM unnest(%s) lnk WHERE (lnk).Dst = ANY($1::NodePtr[]))"
+
+	for _,qstr := range []string{
+		"UPDATE Node SET "+strings.Join(unlink,",")+" WHERE "+fmt.Sprintf(links_here,strings.Join(all,"||")),
+		"UPDATE PageMap SET Path=ARRAY(SELECT lnk FROM unnest(Path) lnk WHERE NOT (lnk).Dst = ANY($1::NodePtr[])) WHERE "+fmt.Sprintf(links_here,"Path"),
+		"DELETE FROM NodeArrowNode WHERE NFrom = ANY($1::NodePtr[]) OR NTo = ANY($1::NodePtr[])",
+		"DELETE FROM SourceNode WHERE NPtr = ANY($1::NodePtr[])",
+		"DELETE FROM SourceLink WHERE NFrom = ANY($1::NodePtr[]) OR NTo = ANY($1::NodePtr[])",
+	} {
+		_,err = ctx.Tx.Exec(qstr,SQLNodePtrArray(nodes))
+
+		if err != nil {
+			return 0,fmt.Errorf("%w: Failed to delete chapter node links: %v\n%s",ErrDBQuery,err,qstr)
+		}
+	}
+
+	for _,qstr := range []string{
+		"UPDATE Node SET Chap=array_to_string(array_remove(string_to_array(Chap,','),$1),',') WHERE $1=ANY(string_to_array(Chap,','))",
+		"DELETE FROM PageMap WHERE Chap=$1",
+	} {
+		_,err = ctx.Tx.Exec(qstr,chap)
+
+		if err != nil {
+			return 0,fmt.Errorf("%w: Failed to delete chapter: %v\n%s",ErrDBQuery,err,qstr)
+		}
+	}
+
+	return len(nodes),nil
+}
+
+// **************************************************************************
+
+func AppendDBLinkToNode(ctx PoSST, n1ptr NodePtr, lnk Link, sttype int) bool {
+
+	ok,err := TryAppendDBLinkToNode(ctx,n1ptr,lnk,sttype)
+
+	if err != nil {
+		fmt.Println(err)
+
+		if errors.Is(err,ErrSTOutOfBounds) {
+			os.Exit(-1)
+		}
+	}
+
+	return ok
+}
+
+// **************************************************************************
+
+func TryAppendDBLinkToNode(ctx PoSST, n1ptr NodePtr, lnk Link, sttype int) (bool,error) {
+
+	if ctx.Store != nil {
+		return ctx.Store.AppendLinkToNode(n1ptr,lnk,sttype)
+	}
+
+	ctx.Cache.Forget()
+
+	// Want to make this idempotent, because SQL is not (and not clause)
+
+	if sttype < -EXPRESS || sttype > EXPRESS {
+		return false,fmt.Errorf("%w: %d",ErrSTOutOfBounds,sttype)
+	}
+
+	if n1ptr == lnk.Dst {
+		return false,nil
+	}
+
+	link_table,err := TrySTTypeDBChannel(sttype)
+
+	if err != nil {
+		return false,err
+	}
+
+	args := []interface{}{n1ptr.CPtr,n1ptr.Class}
+	literal := SQLLinkArg(&args,lnk)
+
+	// link_table is one of the fixed channel column names, not user data
+
+	qstr := fmt.Sprintf("UPDATE NODE set %s=array_append(%s,%s) where (NPtr).CPtr = $1 and (NPtr).Chan = $2 and (%s is null or not %s = ANY(%s))",
+		link_table,
+		link_table,
+		literal,
+		link_table,
+		literal,
+		link_table)
+
+	_,err = SQLConn(ctx).Exec(qstr,args...)
+
+	if err != nil {
+		return false,fmt.Errorf("%w: failed to append link: %v\n%s",ErrDBQuery,err,qstr)
+	}
+
+	return true,nil
+}
+
+// **************************************************************************
+
+func CreateDBNodeArrowNode(ctx PoSST, org NodePtr, dst Link, sttype int) bool {
+
+	err := TryCreateDBNodeArrowNode(ctx,org,dst,sttype)
+
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+
+	return true
+}
+
+// **************************************************************************
+
+func TryCreateDBNodeArrowNode(ctx PoSST, org NodePtr, dst Link, sttype int) error {
+
+	if ctx.Store != nil {
+		return ctx.Store.CreateNodeArrowNode(org,dst,sttype)
+	}
+
+	qstr := "SELECT IdempInsertNodeArrowNode(" +
+		"$1," + //infromptr
+		"$2," + //infromchan
+		"$3," + //isttype
+		"$4," + //iarr
+		"$5," + //iwgt
+		"$6::text[]," + //ictx
+		"$7," + //intoptr
+		"$8 " + //intochan,
+		")"
+
+	_,err := SQLConn(ctx).Exec(qstr,
+		org.CPtr,
+		org.Class,
+		sttype,
+		dst.Arr,
+		dst.Wgt,
+		SQLStringArray(dst.Ctx),
+		dst.Dst.CPtr,
+		dst.Dst.Class)
+
+	if err != nil {
+		return fmt.Errorf("%w: failed to make node-arrow-node: %v\n%s",ErrDBQuery,err,qstr)
+	}
+
+	return nil
+}
+
+// **************************************************************************
+
+func TryUploadProvenance(ctx PoSST,file string,nodes []NodePtr,links []LinkKey) error {
+
+	if ctx.Store != nil {
+		return ctx.Store.AddProvenance(file,nodes,links)
+	}
+
+	qstr := "INSERT INTO SourceNode (File,NPtr) SELECT $1,unnest($2::NodePtr[]) ON CONFLICT DO NOTHING"
+
+	_,err := SQLConn(ctx).Exec(qstr,file,SQLNodePtrArray(nodes))
+
+	if err != nil {
+		return fmt.Errorf("%w: Failed to record node provenance: %v\n%s",ErrDBQuery,err,qstr)
+	}
+
+	var from,to []NodePtr
+	var arr []int64
+
+	for l := range links {
+		from = append(from,links[l].NFrom)
+		arr = append(arr,int64(links[l].Arr))
+		to = append(to,links[l].NTo)
+	}
+
+	qstr = "INSERT INTO SourceLink (File,NFrom,Arr,NTo) SELECT $1,f,a,t " +
+		"FROM unnest($2::NodePtr[],$3::int[],$4::NodePtr[]) AS u(f,a,t) ON CONFLICT DO NOTHING"
+
+	_,err = SQLConn(ctx).Exec(qstr,file,SQLNodePtrArray(from),pq.Array(arr),SQLNodePtrArray(to))
+
+	if err != nil {
+		return fmt.Errorf("%w: Failed to record link provenance: %v\n%s",ErrDBQuery,err,qstr)
+	}
+
+	return nil
+}
+
+// **************************************************************************
+
+func TryGetDBProvenance(ctx PoSST,file string) ([]NodePtr,[]NodeArrowNode,error) {
+
+	// What file contributed at its last upload, with the links as they
+	// are stored in the Node table
+
+	if ctx.Store != nil {
+		return ctx.Store.GetProvenance(file)
+	}
+
+	var nodes []NodePtr
+	var links []NodeArrowNode
+
+	row,err := SQLConn(ctx).Query("SELECT NPtr FROM SourceNode WHERE File=$1",file)
+
+	if err != nil {
+		return nil,nil,fmt.Errorf("%w: QUERY GetDBProvenance Failed: %v",ErrDBQuery,err)
+	}
+
+	var whole string
+	var n NodePtr
+
+	for row.Next() {
+		err = row.Scan(&whole)
+		fmt.Sscanf(whole,"(%d,%d)",&n.Class,&n.CPtr)
+		nodes = append(nodes,n)
+	}
+
+	row.Close()
+
+	qstr := fmt.Sprintf("SELECT s.NFrom,lnk FROM SourceLink s JOIN Node n ON n.NPtr=s.NFrom, " +
+		"unnest(n.%s||n.%s||n.%s||n.%s||n.%s||n.%s||n.%s) lnk " +
+		"WHERE s.File=$1 AND (lnk).Arr=s.Arr AND (lnk).Dst=s.NTo",
+		I_MEXPR,I_MCONT,I_MLEAD,I_NEAR,I_PLEAD,I_PCONT,I_PEXPR)
+
+	row,err = SQLConn(ctx).Query(qstr,file)
+
+	if err != nil {
+		return nil,nil,fmt.Errorf("%w: QUERY GetDBProvenance Failed: %v",ErrDBQuery,err)
+	}
+
+	var lnkstr string
+
+	for row.Next() {
+
+		err = row.Scan(&whole,&lnkstr)
+
+		var nan NodeArrowNode
+		fmt.Sscanf(whole,"(%d,%d)",&nan.NFrom.Class,&nan.NFrom.CPtr)
+
+		lnk := ParseSQLLinkString(lnkstr)
+		nan.Arr = lnk.Arr
+		nan.Wgt = lnk.Wgt
+		nan.Ctx = lnk.Ctx
+		nan.NTo = lnk.Dst
+
+		links = append(links,nan)
+	}
+
+	row.Close()
+	return nodes,links,err
+}
+
+// **************************************************************************
+
+func TryRemoveDBSourceContribution(ctx PoSST,file string,nodes []NodePtr,removed,changed []LinkKey) (err error) {
+
+	// In one transaction, delete the removed links unless another file
+	// also makes them, the changed links in any case, then the nodes that
+	// no other file mentions and nothing links to any more, and finally
+	// the file's page map and provenance, which the upload will replace
+
+	if ctx.Store != nil {
+		return ctx.Store.RemoveSourceContribution(file,nodes,removed,changed)
+	}
+
+	ctx.Cache.Forget()
+
+	ctx,owned,err := TryBeginTransaction(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		err = TryEndTransaction(ctx,owned,err)
+	}()
+
+	const claimed = "EXISTS (SELECT 1 FROM SourceLink WHERE File<>$1 AND NFrom=($2::int,$3::int)::NodePtr AND Arr=$4 AND NTo=($5::int,$6::int)::NodePtr)"
+
+	var unlink []string
+
+	for _,col := range []string{I_MEXPR,I_MCONT,I_MLEAD,I_NEAR,I_PLEAD,I_PCONT,I_PEXPR} {
+		unlink = append(unlink,fmt.Sprintf("%s=ARRAY(SELECT lnk FROM unnest(%s) lnk WHERE NOT ((lnk).Arr=$4 AND (lnk).Dst=($5::int,$6::int)::NodePtr))",col,col))
+	}
+
+	qlinks := "DELETE FROM NodeArrowNode WHERE NFrom=($2::int,$3::int)::NodePtr AND Arr=$4 AND NTo=($5::int,$6::int)::NodePtr AND ($7 OR NOT "+claimed+")"
+	qnodes := "UPDATE Node SET "+strings.Join(unlink,",")+" WHERE NPtr=($2::int,$3::int)::NodePtr AND ($7 OR NOT "+claimed+")"
+
+	for _,del := range []struct{ keys []LinkKey; always bool }{{removed,false},{changed,true}} {
+
+		for _,key := range del.keys {
+
+			args := []interface{}{file,key.NFrom.Class,key.NFrom.CPtr,key.Arr,key.NTo.Class,key.NTo.CPtr,del.always}
+
+			for _,qstr := range []string{qlinks,qnodes} {
+
+				_,err = ctx.Tx.Exec(qstr,args...)
+
+				if err != nil {
+					return fmt.Errorf("%w: Failed to remove stale link: %v\n%s",ErrDBQuery,err,qstr)
+				}
+			}
+		}
+	}
+
+	qstr := "DELETE FROM Node WHERE NPtr=($2::int,$3::int)::NodePtr " +
+		"AND NOT EXISTS (SELECT 1 FROM SourceNode WHERE File<>$1 AND NPtr=($2::int,$3::int)::NodePtr) " +
+		"AND NOT EXISTS (SELECT 1 FROM NodeArrowNode WHERE STType<>999 AND (NFrom=($2::int,$3::int)::NodePtr OR NTo=($2::int,$3::int)::NodePtr))"
+
+	for _,nptr := range nodes {
+
+		res,err := ctx.Tx.Exec(qstr,file,nptr.Class,nptr.CPtr)
+
+		if err != nil {
+			return fmt.Errorf("%w: Failed to remove orphaned node: %v\n%s",ErrDBQuery,err,qstr)
+		}
+
+		if deleted,_ := res.RowsAffected(); deleted > 0 {
+			_,err = ctx.Tx.Exec("DELETE FROM NodeArrowNode WHERE NFrom=($1::int,$2::int)::NodePtr",nptr.Class,nptr.CPtr)
+			if err != nil {
+				return fmt.Errorf("%w: Failed to remove orphaned node: %v",ErrDBQuery,err)
+			}
+		}
+	}
+
+	for _,qstr := range []string{"DELETE FROM PageMap WHERE File=$1","DELETE FROM SourceNode WHERE File=$1","DELETE FROM SourceLink WHERE File=$1"} {
+
+		_,err = ctx.Tx.Exec(qstr,file)
+
+		if err != nil {
+			return fmt.Errorf("%w: Failed to remove provenance: %v\n%s",ErrDBQuery,err,qstr)
+		}
+	}
+
+	return nil
+}
+
+// **************************************************************************
+
+func DefineStoredFunctions(ctx PoSST) {
+
+	err := TryDefineStoredFunctions(ctx)
+
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+// **************************************************************************
+
+func DefineStoredFunction(ctx PoSST,qstr string) error {
+
+	_,err := ctx.DB.Exec(qstr)
+
+	if err != nil {
+		return fmt.Errorf("%w: defining postgres function: %v\n%s",ErrDBQuery,err,qstr)
+	}
+
+	return nil
+}
+
+// **************************************************************************
+
+func TryDefineStoredFunctions(ctx PoSST) error {
+
+	// (Re)define all the stored functions, whether or not they have
+	// changed, see TryUpdateStoredFunctions()
+
+	var errs []error
+
+	for _,qstr := range StoredFunctionDefinitions() {
+		errs = append(errs,DefineStoredFunction(ctx,qstr))
+	}
+
+	return errors.Join(errs...)
+}
+
+// **************************************************************************
+
+func StoredFunctionDefinitions() []string {
+
+	var defs []string
+
+	// NB! these functions are in "plpgsql" language, NOT SQL. They look similar but they are DIFFERENT!
+	
+	// Insert a node structure, also an anchor for and containing link arrays
+	
+	cols := I_MEXPR+","+I_MCONT+","+I_MLEAD+","+I_NEAR +","+I_PLEAD+","+I_PCONT+","+I_PEXPR
+
+	qstr := fmt.Sprintf("CREATE OR REPLACE FUNCTION IdempInsertNode(iLi INT, iszchani INT, icptri INT, iSi TEXT, ichapi TEXT)\n" +
+		"RETURNS TABLE (    \n" +
+		"    ret_cptr INTEGER," +
+		"    ret_channel INTEGER" +
+		") AS $fn$ " +
+		"DECLARE \n" +
+		"BEGIN\n" +
+		"  IF NOT EXISTS (SELECT (NPtr).Chan,(NPtr).CPtr FROM Node WHERE lower(s) = lower(iSi)) THEN\n" +
+		"     INSERT INTO Node (Nptr.Chan,Nptr.Cptr,L,S,chap,%s) VALUES (iszchani,icptri,iLi,iSi,ichapi,'{}','{}','{}','{}','{}','{}','{}');" +
+		"  END IF;\n" +
+		"  RETURN QUERY SELECT (NPtr).Chan,(NPtr).CPtr FROM Node WHERE s = iSi;\n" +
+		"END ;\n" +
+		"$fn$ LANGUAGE plpgsql;",cols);
+
+	defs = append(defs,qstr)
+
+	qstr = "CREATE OR REPLACE FUNCTION IdempAppendNode(iLi INT, iszchani INT, iSi TEXT, ichapi TEXT)\n" +
+		"RETURNS TABLE (    \n" +
+		"    ret_cptr INTEGER," +
+		"    ret_channel INTEGER" +
+		") AS $fn$ " +
+		"DECLARE \n" +
+		"    icptri INT = 0;" +
+		"BEGIN\n" +
+		"  IF NOT EXISTS (SELECT (NPtr).Chan,(NPtr).CPtr FROM Node WHERE s = iSi) THEN\n" +
+		"     SELECT max((Nptr).CPtr) INTO icptri FROM Node WHERE (Nptr).Chan=iszchani;\n"+
+		"     INSERT INTO Node (Nptr.Chan,Nptr.Cptr,L,S,chap) VALUES (iszchani,icptri+1,iLi,iSi,ichapi);" +
+		"  END IF;\n" +
+		"  RETURN QUERY SELECT (NPtr).Chan,(NPtr).CPtr FROM Node WHERE s = iSi;\n" +
+		"END ;\n" +
+		"$fn$ LANGUAGE plpgsql;";
+
+	defs = append(defs,qstr)
+
+	// For lookup by arrow
+
+	qstr = "CREATE OR REPLACE FUNCTION IdempInsertNodeArrowNode\n" +
+		"(\n" +
+		"infromptr  int,   \n" +
+		"infromchan int,   \n" +
+		"isttype    int,   \n" +
+		"iarr       int,   \n" +
+		"iwgt       real,  \n" +
+		"ictx       text[],\n" +
+		"intoptr    int,   \n" +
+		"intochan   int    \n" +
+		")\n" +
+
+		"RETURNS real AS $fn$ " +
+
+		"DECLARE \n" +
+		"  ret_wgt real;\n" +
+		"BEGIN\n" +
+
+		"  IF NOT EXISTS (SELECT Wgt FROM NodeArrowNode WHERE (NFrom).Cptr=infromptr AND (NFrom).Chan=infromchan AND Arr=iarr AND (NTo).Cptr=intoptr AND (NTo).Chan=intochan) THEN\n" +
+
+		"     INSERT INTO NodeArrowNode (nfrom.Cptr,nfrom.Chan,sttype,arr,wgt,ctx,nto.Cptr,nto.Chan) \n" +
+		"       VALUES (infromptr,infromchan,isttype,iarr,iwgt,ictx,intoptr,intochan);" +
+
+		"  END IF;\n" +
+		"  SELECT Wgt into ret_wgt FROM NodeArrowNode WHERE (NFrom).Cptr=infromptr AND (NFrom).Chan=infromchan AND Arr=iarr AND (NTo).Cptr=intoptr AND (NTo).Chan=intochan;\n" +
+		"  RETURN ret_wgt;" +
+		"END ;\n" +
+		"$fn$ LANGUAGE plpgsql;";
+
+	defs = append(defs,qstr)
+
+	// Construct an empty link pointing nowhere as a starting node
+
+	qstr = "CREATE OR REPLACE FUNCTION GetSingletonAsLinkArray(start NodePtr)\n"+
+		"RETURNS Link[] AS $fn$\n"+
+		"DECLARE \n"+
+		"    level Link[] := Array[] :: Link[];\n"+
+		"    lnk Link := (0,1.0,Array[]::text[],(0,0));\n"+
+		"BEGIN\n"+
+		" lnk.Dst = start;\n"+
+		" level = array_append(level,lnk);\n"+
+		"RETURN level; \n"+
+		"END ;\n"+
+		"$fn$ LANGUAGE plpgsql;"
+
+	defs = append(defs,qstr)
+
+	// Construct an empty link pointing nowhere as a starting node
+
+	qstr = "CREATE OR REPLACE FUNCTION GetSingletonAsLink(start NodePtr)\n"+
+		"RETURNS Link AS $fn$\n"+
+		"DECLARE \n"+
+		"    lnk Link := (0,1.0,Array[]::text[],(0,0));\n"+
+		"BEGIN\n"+
+		" lnk.Dst = start;\n"+
+		"RETURN lnk; \n"+
+		"END ;\n"+
+		"$fn$ LANGUAGE plpgsql;"
+
+	defs = append(defs,qstr)
+
+	// Construct search by sttype. since table names are static we need a case statement
+
+	qstr = "CREATE OR REPLACE FUNCTION GetNeighboursByType(start NodePtr, sttype int)\n"+
+		"RETURNS Link[] AS $fn$\n"+
+		"DECLARE \n"+
+		"    fwdlinks Link[] := Array[] :: Link[];\n"+
+		"    lnk Link := (0,1.0,Array[]::text[],(0,0));\n"+
+		"BEGIN\n"+
+		"   CASE sttype \n"
+	
+	for st := -EXPRESS; st <= EXPRESS; st++ {
+		qstr += fmt.Sprintf("WHEN %d THEN\n"+
+			"     SELECT %s INTO fwdlinks FROM Node WHERE Nptr=start;\n",st,STTypeDBChannel(st));
+	}
+	qstr += "ELSE RAISE EXCEPTION 'No such sttype %', sttype;\n" +
+		"END CASE;\n" +
+		"    RETURN fwdlinks; \n" +
+		"END ;\n" +
+		"$fn$ LANGUAGE plpgsql;\n"
+
+	defs = append(defs,qstr)
+
+	// Get the nearest neighbours as NPtr, with respect to each of the four STtype
+
+	qstr = fmt.Sprintf("CREATE OR REPLACE FUNCTION GetFwdNodes(start NodePtr,exclude NodePtr[],sttype int)\n"+
+		"RETURNS NodePtr[] AS $fn$\n" +
+		"DECLARE \n" +
+		"    neighbours NodePtr[];\n" +
+		"    fwdlinks Link[];\n" +
+		"    lnk Link;\n" +
+		"BEGIN\n" +
+
+		"    fwdlinks =GetNeighboursByType(start,sttype);\n"+
+
+		"    IF fwdlinks IS NULL THEN\n" +
+		"        RETURN '{}';\n" +
+		"    END IF;\n" +
+
+		"    neighbours := ARRAY[]::NodePtr[];\n" +
+
+		"    FOREACH lnk IN ARRAY fwdlinks\n" +
+		"    LOOP\n"+
+		"      IF lnk.Arr = 0 THEN\n"+
+		"         CONTINUE;"+
+		"      END IF;\n"+
+		"      IF exclude is not NULL AND NOT lnk.dst=ANY(exclude) THEN\n" +
+		"         neighbours := array_append(neighbours, lnk.dst);\n" +
+		"      END IF; \n" +
+		"    END LOOP;\n" +
+
+		"    RETURN neighbours; \n" +
+		"END ;\n" +
+		"$fn$ LANGUAGE plpgsql;\n")
+
+	defs = append(defs,qstr)
+
+	// Basic quick neighbour probe
+
+	qstr = fmt.Sprintf("CREATE OR REPLACE FUNCTION GetFwdLinks(start NodePtr,exclude NodePtr[],sttype int)\n"+
+		"RETURNS Link[] AS $fn$\n" +
+		"DECLARE \n" +
+		"    neighbours Link[];\n" +
+		"    fwdlinks Link[];\n" +
+		"    lnk Link;\n" +
+		"BEGIN\n" +
+
+		"    fwdlinks = GetNeighboursByType(start,sttype);\n"+
+
+		"    IF fwdlinks IS NULL THEN\n" +
+		"        RETURN '{}';\n" +
+		"    END IF;\n" +
+		"    neighbours := ARRAY[]::Link[];\n" +
+		"    FOREACH lnk IN ARRAY fwdlinks\n" +
+		"    LOOP\n"+
+		"      IF lnk.Arr = 0 THEN\n"+
+		"         CONTINUE;"+
+		"      END IF;\n"+
+		"      IF exclude is not NULL AND NOT lnk.dst=ANY(exclude) THEN\n" +
+		"         neighbours := array_append(neighbours, lnk);\n" +
+		"      END IF; \n" + 
+		"    END LOOP;\n" +
+		"    RETURN neighbours; \n" +
+		"END ;\n" +
+		"$fn$ LANGUAGE plpgsql;\n")
+	
+	defs = append(defs,qstr)
+	
+	// Get the forward cone / half-ball as NPtr
+
+	qstr = "CREATE OR REPLACE FUNCTION FwdConeAsNodes(start NodePtr,sttype INT, maxdepth INT)\n"+
+		"RETURNS NodePtr[] AS $fn$\n" +
+		"DECLARE \n" +
+		"    nextlevel NodePtr[];\n" +
+		"    partlevel NodePtr[];\n" +
+		"    level NodePtr[] = ARRAY[start]::NodePtr[];\n" +
+		"    exclude NodePtr[] = ARRAY['(0,0)']::NodePtr[];\n" +
+		"    cone NodePtr[];\n" +
+		"    neigh NodePtr;\n" +
+		"    frn NodePtr;\n" +
+		"    counter int := 0;\n" +
+
+		"BEGIN\n" +
+
+		"LOOP\n" +
+		"  EXIT WHEN counter = maxdepth+1;\n" +
+
+		"  IF level IS NULL THEN\n" +
+		"     RETURN cone;\n" +
+		"  END IF;\n" +
+
+		"  nextlevel := ARRAY[]::NodePtr[];\n" +
+
+		"  FOREACH frn IN ARRAY level "+
+		"  LOOP \n"+
+		"     nextlevel = array_append(nextlevel,frn);\n" +
+		"  END LOOP;\n" +
+
+		"  IF nextlevel IS NULL THEN\n" +
+		"     RETURN cone;\n" +
+		"  END IF;\n" +
+
+		"  FOREACH neigh IN ARRAY nextlevel LOOP \n"+
+		"    IF NOT neigh = ANY(exclude) THEN\n" +
+		"      cone = array_append(cone,neigh);\n" +
+		"      exclude := array_append(exclude,neigh);\n" +
+		"      partlevel := GetFwdNodes(neigh,exclude,sttype);\n" +
+		"    END IF;" +
+		"    IF partlevel IS NOT NULL THEN\n" +
+		"         level = array_cat(level,partlevel);\n"+
+		"    END IF;\n" +
+		"  END LOOP;\n" +
+
+		// Next, continue, foreach
+		"  counter = counter + 1;\n" +
+		"END LOOP;\n" +
+		
+		"RETURN cone; \n" +
+		"END ;\n" +
+		"$fn$ LANGUAGE plpgsql;\n"
+	
+	defs = append(defs,qstr)
+	
+          /* e.g. select unnest(fwdconeaslinks) from FwdConeAsLinks('(4,1)',1,4);
+                           unnest                           
+             ------------------------------------------------------------
+              (0,0,{},"(4,1)")
+              (77,0.34,"{ ""fairy castles"", ""angel air"" }","(4,2)")
+              (77,0.34,"{ ""fairy castles"", ""angel air"" }","(4,3)")
+              (77,0.34,"{ ""steamy hot tubs"" }","(4,5)")
+              (77,0.34,"{ ""fairy castles"", ""angel air"" }","(4,4)")
+              (77,0.34,"{ ""steamy hot tubs"", ""lady gaga"" }","(4,6)")
+             (6 rows)
+
+          */
+
+	qstr = "CREATE OR REPLACE FUNCTION FwdConeAsLinks(start NodePtr,sttype INT,maxdepth INT)\n"+
+		"RETURNS Link[] AS $fn$\n" +
+		"DECLARE \n" +
+		"    nextlevel Link[];\n" +
+		"    partlevel Link[];\n" +
+		"    level Link[] = ARRAY[]::Link[];\n" +
+		"    exclude NodePtr[] = ARRAY['(0,0)']::NodePtr[];\n" +
+		"    cone Link[];\n" +
+		"    neigh Link;\n" +
+		"    frn Link;\n" +
+		"    counter int := 0;\n" +
+
+		"BEGIN\n" +
+
+		"level := GetSingletonAsLinkArray(start);\n"+
+
+		"LOOP\n" +
+		"  EXIT WHEN counter = maxdepth+1;\n" +
+
+		"  IF level IS NULL THEN\n" +
+		"     RETURN cone;\n" +
+		"  END IF;\n" +
+
+		"  nextlevel := ARRAY[]::Link[];\n" +
+
+		"  FOREACH frn IN ARRAY level "+
+		"  LOOP \n"+
+		"     nextlevel = array_append(nextlevel,frn);\n" +
+		"  END LOOP;\n" +
+
+		"  IF nextlevel IS NULL THEN\n" +
+		"     RETURN cone;\n" +
+		"  END IF;\n" +
+
+		"  FOREACH neigh IN ARRAY nextlevel LOOP \n"+
+		"    IF NOT neigh.Dst = ANY(exclude) THEN\n" +
+		"      cone = array_append(cone,neigh);\n" +
+		"      exclude := array_append(exclude,neigh.Dst);\n" +
+		"      partlevel := GetFwdLinks(neigh.Dst,exclude,sttype);\n" +
+		"    END IF;" +
+		"    IF partlevel IS NOT NULL THEN\n" +
+		"         level = array_cat(level,partlevel);\n"+
+		"    END IF;\n" +
+		"  END LOOP;\n" +
+
+		"  counter = counter + 1;\n" +
+		"END LOOP;\n" +
+
+		"RETURN cone; \n" +
+		"END ;\n" +
+		"$fn$ LANGUAGE plpgsql;\n"
+
+	defs = append(defs,qstr)
+
+	// Orthogonal (depth first) paths from origin spreading out. The path
+	// functions are kept for use from psql, the library grows its paths
+	// a level at a time with ExpandPaths() instead
+
+	qstr = "CREATE OR REPLACE FUNCTION FwdPathsAsLinks(start NodePtr,sttype INT,maxdepth INT)\n"+
+		"RETURNS Text AS $fn$\n" +
+		"DECLARE\n" +
+		"   hop Text;\n" +
+		"   path Text;\n"+
+		"   summary_path Text[];\n"+
+		"   exclude NodePtr[] = ARRAY[start]::NodePtr[];\n" +
+		"   ret_paths Text;\n" +
+		"   startlnk Link;"+
+
+		"BEGIN\n" +
+
+		"startlnk := GetSingletonAsLink(start);\n"+
+		"path := Format('%s',startlnk::Text);\n"+
+		"ret_paths := SumFwdPaths(startlnk,path,sttype,1,maxdepth,exclude);" +
+
+		"RETURN ret_paths; \n" +
+		"END ;\n" +
+		"$fn$ LANGUAGE plpgsql;\n"
+
+        // select FwdPathsAsLinks('(4,1)',1,3)
+
+	defs = append(defs,qstr)
+
+	// Return end of path branches as aggregated text summaries
+
+	qstr = "CREATE OR REPLACE FUNCTION SumFwdPaths(start Link,path TEXT, sttype INT,depth int, maxdepth INT,exclude NodePtr[])\n"+
+		"RETURNS Text AS $fn$\n" +
+		"DECLARE \n" + 
+		"    fwdlinks Link[];\n" +
+		"    empty Link[] = ARRAY[]::Link[];\n" +
+		"    lnk Link;\n" +
+		"    fwd Link;\n" +
+		"    ret_paths Text;\n" +
+		"    appendix Text;\n" +
+		"    tot_path Text;\n"+
+		"BEGIN\n" +
+
+		"IF depth = maxdepth THEN\n"+
+		"  ret_paths := Format('%s\n%s',ret_paths,path);\n"+
+		"  RETURN ret_paths;\n"+
+		"END IF;\n"+
+
+		"fwdlinks := GetFwdLinks(start.Dst,exclude,sttype);\n" +
+
+		"FOREACH lnk IN ARRAY fwdlinks LOOP \n" +
+		"   IF NOT lnk.Dst = ANY(exclude) THEN\n"+
+		"      exclude = array_append(exclude,lnk.Dst);\n" +
+		"      IF lnk IS NULL THEN" +
+		          // set end of path as return val
+		"         ret_paths := Format('%s\n%s',ret_paths,path);\n"+
+		"         RETURN ret_paths;"+
+		"      ELSE\n"+
+		          // Add to the path and descend into new link
+		"         tot_path := Format('%s;%s',path,lnk::Text);\n"+
+		"         appendix := SumFwdPaths(lnk,tot_path,sttype,depth+1,maxdepth,exclude);\n" +
+		          // when we return, we reached the end of one path
+		"         IF appendix IS NOT NULL THEN\n"+
+	                     // append full path to list of all paths, separated by newlines
+		"            ret_paths := Format('%s\n%s',ret_paths,appendix);\n"+
+		"         ELSE"+
+		"            ret_paths := Format('%s\n%s',ret_paths,tot_path);"+
+		"         END IF;"+
+		"      END IF;"+
+		"   END IF;"+
+		"END LOOP;"+
+
+		"RETURN ret_paths; \n" +
+		"END ;\n" +
+		"$fn$ LANGUAGE plpgsql;\n"
+
+	defs = append(defs,qstr)
+
+	// Typeless cone searches
+
+	qstr = "CREATE OR REPLACE FUNCTION AllPathsAsLinks(start NodePtr,orientation text,maxdepth INT)\n"+
+		"RETURNS Text AS $fn$\n" +
+		"DECLARE\n" +
+		"   hop Text;\n" +
+		"   path Text;\n"+
+		"   summary_path Text[];\n"+
+		"   exclude NodePtr[] = ARRAY[start]::NodePtr[];\n" +
+		"   ret_paths Text;\n" +
+		"   startlnk Link;"+
+
+		"BEGIN\n" +
+
+		"startlnk := GetSingletonAsLink(start);\n"+
+		"path := Format('%s',startlnk::Text);\n"+
+		"ret_paths := SumAllPaths(startlnk,path,orientation,1,maxdepth,exclude);" +
+		
+		"RETURN ret_paths; \n" +
+		"END ;\n" +
+		"$fn$ LANGUAGE plpgsql;\n"
+	
+        // select AllPathsAsLinks('(4,1)',3)
+
+	defs = append(defs,qstr)
+
+	// SumAllPaths
+
+	qstr = "CREATE OR REPLACE FUNCTION SumAllPaths(start Link,path TEXT,orientation text,depth int, maxdepth INT,exclude NodePtr[])\n"+
+		"RETURNS Text AS $fn$\n" +
+		"DECLARE \n" + 
+		"    fwdlinks Link[];\n" +
+		"    stlinks  Link[];\n" +
+		"    empty Link[] = ARRAY[]::Link[];\n" +
+		"    lnk Link;\n" +
+		"    fwd Link;\n" +
+		"    ret_paths Text;\n" +
+		"    appendix Text;\n" +
+		"    tot_path Text;\n"+
+		"BEGIN\n" +
+
+		"IF depth = maxdepth THEN\n"+
+		"  ret_paths := Format('%s\n%s',ret_paths,path);\n"+
+		"  RETURN ret_paths;\n"+
+		"END IF;\n"+
+
+		// Get *All* in/out Links
+		"CASE \n" +
+		"   WHEN orientation = 'bwd' THEN\n" +
+		"     stlinks := GetFwdLinks(start.Dst,exclude,-3);\n" +
+		"     fwdlinks := array_cat(fwdlinks,stlinks);\n" +
+		"     stlinks := GetFwdLinks(start.Dst,exclude,-2);\n" +
+		"     fwdlinks := array_cat(fwdlinks,stlinks);\n" +
+		"     stlinks := GetFwdLinks(start.Dst,exclude,-1);\n" +
+		"     fwdlinks := array_cat(fwdlinks,stlinks);\n" +
+		"     stlinks := GetFwdLinks(start.Dst,exclude,0);\n" +
+		"     fwdlinks := array_cat(fwdlinks,stlinks);\n" +
+		"   WHEN orientation = 'fwd' THEN\n" +
+		"     stlinks := GetFwdLinks(start.Dst,exclude,0);\n" +
+		"     fwdlinks := array_cat(fwdlinks,stlinks);\n" +
+		"     stlinks := GetFwdLinks(start.Dst,exclude,1);\n" +
+		"     fwdlinks := array_cat(fwdlinks,stlinks);\n" +
+		"     stlinks := GetFwdLinks(start.Dst,exclude,2);\n" +
+		"     fwdlinks := array_cat(fwdlinks,stlinks);\n" +
+		"     stlinks := GetFwdLinks(start.Dst,exclude,3);\n" +
+		"     fwdlinks := array_cat(fwdlinks,stlinks);\n" +
+		"   ELSE\n" +
+		"     stlinks := GetFwdLinks(start.Dst,exclude,-3);\n" +
+		"     fwdlinks := array_cat(fwdlinks,stlinks);\n" +
+		"     stlinks := GetFwdLinks(start.Dst,exclude,-2);\n" +
+		"     fwdlinks := array_cat(fwdlinks,stlinks);\n" +
+		"     stlinks := GetFwdLinks(start.Dst,exclude,-1);\n" +
+		"     fwdlinks := array_cat(fwdlinks,stlinks);\n" +
+		"     stlinks := GetFwdLinks(start.Dst,exclude,0);\n" +
+		"     fwdlinks := array_cat(fwdlinks,stlinks);\n" +
+		"     stlinks := GetFwdLinks(start.Dst,exclude,1);\n" +
+		"     fwdlinks := array_cat(fwdlinks,stlinks);\n" +
+		"     stlinks := GetFwdLinks(start.Dst,exclude,2);\n" +
+		"     fwdlinks := array_cat(fwdlinks,stlinks);\n" +
+		"     stlinks := GetFwdLinks(start.Dst,exclude,3);\n" +
+		"     fwdlinks := array_cat(fwdlinks,stlinks);\n" +
+		"END CASE;\n" +
+
+		"FOREACH lnk IN ARRAY fwdlinks LOOP \n" +
+		"   IF NOT lnk.Dst = ANY(exclude) THEN\n"+
+		"      exclude = array_append(exclude,lnk.Dst);\n" +
+		"      IF lnk IS NULL THEN\n" +
+		"         ret_paths := Format('%s\n%s',ret_paths,path);\n"+
+		"         RETURN ret_paths;"+
+		"      ELSE\n"+
+		"         tot_path := Format('%s;%s',path,lnk::Text);\n"+
+		"         appendix := SumAllPaths(lnk,tot_path,orientation,depth+1,maxdepth,exclude);\n" +
+		"         IF appendix IS NOT NULL THEN\n"+
+		"            ret_paths := Format('%s\n%s',ret_paths,appendix);\n"+
+		"         ELSE\n"+
+		"            ret_paths := Format('%s\n%s',ret_paths,tot_path);"+
+		"         END IF;\n"+
+		"      END IF;\n"+
+		"   END IF;\n"+
+		"END LOOP;\n"+
+
+		"RETURN ret_paths; \n" +
+		"END ;\n" +
+		"$fn$ LANGUAGE plpgsql;\n"
+
+	defs = append(defs,qstr)
+
+	// Check if linkpath representation is just one item
+
+	qstr = "CREATE OR REPLACE FUNCTION empty_path(path text)\n"+
+		"RETURNS boolean AS $fn$\n" +
+		"BEGIN \n" +
+		"   IF strpos(path,';') THEN \n" + // exact match
+		"      RETURN true;\n" +
+		"   END IF;\n" +
+		"RETURN false;\n" +
+		"END ;\n" +
+		"$fn$ LANGUAGE plpgsql;\n"
+
+	defs = append(defs,qstr)
+
+	// Matching context strings with fuzzy criteria
+
+	qstr = "CREATE OR REPLACE FUNCTION match_context(db_set text[],user_set text[])\n"+
+		"RETURNS boolean AS $fn$\n" +
+		"DECLARE\n" +
+		"   db_ref text[];\n" +
+		"   unicode text;\n" +
+		"   item text;\n" +
+		"   try text;\n"+
+		"BEGIN \n" +
+		"IF array_length(user_set,1) IS NULL THEN\n"+
+		"   RETURN true;\n"+
+		"END IF;\n"+
+
+		"IF array_length(db_set,1) IS NULL THEN\n"+
+		"   RETURN true;\n"+
+		"END IF;\n"+
+
+		"FOREACH item IN ARRAY db_set LOOP\n" +
+		"   db_ref := array_append(db_ref,lower(unaccent(item)));\n" +
+		"END LOOP;\n" +
+
+		"FOREACH item IN ARRAY user_set LOOP\n" +
+		"   IF item = 'any' OR item = '' THEN\n"+
+		"     RETURN true;"+
+		"   END IF;"+
+		"  unicode := replace(item,'|','');\n" +
+		"  FOREACH try IN ARRAY db_ref LOOP\n"+
+		"     IF length(substring(try from lower(unicode))) > 3 THEN \n" + // unaccented unicode match
+	        "        RETURN true;\n" +
+		"     END IF;\n" +
+		"  END LOOP;"+
+		"END LOOP;\n" +
+		"RETURN false;\n" +
+		"END ;\n" +
+		"$fn$ LANGUAGE plpgsql;\n"
+
+	defs = append(defs,qstr)
+
+	// Matching integer ranges
+
+	qstr = "CREATE OR REPLACE FUNCTION match_arrows(arr int,user_set int[])\n"+
+		"RETURNS boolean AS $fn$\n" +
+		"BEGIN \n" +
+		"   IF array_length(user_set,1) IS NULL THEN \n" + // empty arrows
+                "      RETURN true;"+
+		"   END IF;"+
+		"   IF arr = ANY(user_set) THEN \n" + // exact match
+		"      RETURN true;\n" +
+		"   END IF;\n" +
+		"RETURN false;\n" +
+		"END ;\n" +
+		"$fn$ LANGUAGE plpgsql;\n"
+
+	defs = append(defs,qstr)
+
+	// Helper to find arrows by type
+
+	qstr = "CREATE OR REPLACE FUNCTION ArrowInList(arrow int,links Link[])\n"+
+		"RETURNS boolean AS $fn$\n"+
+		"DECLARE \n"+
+		"   lnk Link;\n"+
+		"BEGIN\n"+
+		"IF links IS NULL THEN\n"+
+		"   RETURN false;"+
+		"END IF;"+
+		"FOREACH lnk IN ARRAY links LOOP\n"+
+		"  IF lnk.Arr = arrow THEN\n"+
+		"     RETURN true;\n"+
+		"  END IF;\n"+
+		"END LOOP;"+
+		"RETURN false;"+
+		"END ;\n" +
+		"$fn$ LANGUAGE plpgsql;\n"
+
+	defs = append(defs,qstr)
+
+	// NC version
+
+	qstr = "CREATE OR REPLACE FUNCTION ArrowInContextList(arrow int,links Link[],context text[])\n"+
+		"RETURNS boolean AS $fn$\n"+
+		"DECLARE \n"+
+		"   lnk Link;\n"+
+		"BEGIN\n"+
+		"IF links IS NULL THEN\n"+
+		"   RETURN false;"+
+		"END IF;"+
+		"FOREACH lnk IN ARRAY links LOOP\n"+
+		"  IF lnk.Arr = arrow AND match_context(lnk.Ctx::text[],context) THEN\n"+
+		"     RETURN true;\n"+
+		"  END IF;\n"+
+		"END LOOP;"+
+		"RETURN false;"+
+		"END ;\n" +
+		"$fn$ LANGUAGE plpgsql;\n"
+
+	defs = append(defs,qstr)
+
+	// ***********************************
+	// Find the start of story paths, where outgoing nodes match but no incoming
+	// This means we've reached the top of a hierarchy
+	// ***********************************
+
+	// Find the node that sit's at the start/top of a causal chain
+
+	qstr =  "CREATE OR REPLACE FUNCTION GetStoryStartNodes(arrow int,inverse int,sttype int)\n"+
+		"RETURNS NodePtr[] AS $fn$\n"+
+		"DECLARE \n"+
+		"   retval nodeptr[] = ARRAY[]::nodeptr[];\n"+
+		"BEGIN\n"+
+		"   CASE sttype \n"
+	
+	for st := -EXPRESS; st <= EXPRESS; st++ {
+		qstr += fmt.Sprintf("WHEN %d THEN\n"+
+			"   SELECT array_agg(Nptr) into retval FROM Node WHERE ArrowInList(arrow,%s) AND NOT ArrowInList(inverse,%s);\n",st,STTypeDBChannel(st),STTypeDBChannel(-st));
+	}
+	qstr += "ELSE RAISE EXCEPTION 'No such sttype %', sttype;\n" +
+		"END CASE;\n" +
+		"    RETURN retval; \n" +
+		"END ;\n" +
+		"$fn$ LANGUAGE plpgsql;\n"
+
+	defs = append(defs,qstr)
+
+
+	// Find the node that sit's at the start/top of a causal chain
+
+	qstr =  "CREATE OR REPLACE FUNCTION GetNCCStoryStartNodes(arrow int,inverse int,sttype int,chapter text,context text[])\n"+
+		"RETURNS NodePtr[] AS $fn$\n"+
+		"DECLARE \n"+
+		"   retval nodeptr[] = ARRAY[]::nodeptr[];\n"+
+		"BEGIN\n"+
+		"   CASE sttype \n"
+	for st := -EXPRESS; st <= EXPRESS; st++ {
+		qstr += fmt.Sprintf("WHEN %d THEN\n"+
+			"   SELECT array_agg(Nptr) into retval FROM Node WHERE lower(Chap) LIKE lower(chapter) AND ArrowInContextList(arrow,%s,context) AND NOT ArrowInContextList(inverse,%s,context);\n",st,STTypeDBChannel(st),STTypeDBChannel(-st));
+	}
+	qstr += "ELSE RAISE EXCEPTION 'No such sttype %', sttype;\n" +
+		"END CASE;\n" +
+		"    RETURN retval; \n" +
+		"END ;\n" +
+		"$fn$ LANGUAGE plpgsql;\n"
+
+	defs = append(defs,qstr)
+
+	// ...................................................................
+	// Now add in the more complex context/chapter filters in searching
+	// ...................................................................
+
+        // A more detailed path search that includes checks for chapter/context boundaries (NC/C functions)
+
+	qstr = "CREATE OR REPLACE FUNCTION AllNCPathsAsLinks(start NodePtr,chapter text,context text[],orientation text,maxdepth INT)\n"+
+		"RETURNS Text AS $fn$\n" +
+		"DECLARE\n" +
+		"   hop Text;\n" +
+		"   path Text;\n"+
+		"   summary_path Text[];\n"+
+		"   exclude NodePtr[] = ARRAY[start]::NodePtr[];\n" +
+		"   ret_paths Text;\n" +
+		"   startlnk Link;"+
+		"   chp text = Format('%s%s%s','%',chapter,'%');"+
+		"BEGIN\n" +
+		"startlnk := GetSingletonAsLink(start);\n"+
+		"path := Format('%s',startlnk::Text);"+
+		"ret_paths := SumAllNCPaths(startlnk,path,orientation,1,maxdepth,chapter,context,exclude);" +
+
+		"RETURN ret_paths; \n" +
+		"END ;\n" +
+		"$fn$ LANGUAGE plpgsql;\n"
+	
+        // select AllNCPathsAsLinks('(1,46)','chinese','{"food","example"}','fwd',4);
+
+	defs = append(defs,qstr)
+
+	// SumAllNCPaths - a filtering version of the SumAllPaths recursive helper function, slower but more powerful
+
+	qstr = "CREATE OR REPLACE FUNCTION SumAllNCPaths(start Link,path TEXT,orientation text,depth int, maxdepth INT,chapter text,context text[],exclude NodePtr[])\n"+
+		"RETURNS Text AS $fn$\n" +
+		"DECLARE \n" + 
+		"    fwdlinks Link[];\n" +
+		"    stlinks  Link[];\n" +
+		"    empty Link[] = ARRAY[]::Link[];\n" +
+		"    lnk Link;\n" +
+		"    fwd Link;\n" +
+		"    ret_paths Text;\n" +
+		"    appendix Text;\n" +
+		"    tot_path Text;\n"+
+		"BEGIN\n" +
+
+		"IF depth = maxdepth THEN\n"+
+		"  ret_paths := Format('%s\n%s',ret_paths,path);\n"+
+		"  RETURN ret_paths;\n"+
+		"END IF;\n"+
+
+		// We order the link types to respect the geometry of the temporal links
+		// so that (then) will always come last for visual sensemaking
+
+		// Get *All* in/out Links
+		"CASE \n" +
+		"   WHEN orientation = 'bwd' THEN\n" +
+		"     stlinks := GetNCFwdLinks(start.Dst,chapter,context,exclude,-1);\n" +
 		"     fwdlinks := array_cat(fwdlinks,stlinks);\n" +
-		"     stlinks := GetFwdLinks(start.Dst,exclude,-2);\n" +
+		"     stlinks := GetNCFwdLinks(start.Dst,chapter,context,exclude,0);\n" +
 		"     fwdlinks := array_cat(fwdlinks,stlinks);\n" +
-		"     stlinks := GetFwdLinks(start.Dst,exclude,-1);\n" +
+		"     stlinks := GetNCFwdLinks(start.Dst,chapter,context,exclude,-2);\n" +
 		"     fwdlinks := array_cat(fwdlinks,stlinks);\n" +
-		"     stlinks := GetFwdLinks(start.Dst,exclude,0);\n" +
+		"     stlinks := GetNCFwdLinks(start.Dst,chapter,context,exclude,-3);\n" +
 		"     fwdlinks := array_cat(fwdlinks,stlinks);\n" +
 		"   WHEN orientation = 'fwd' THEN\n" +
-		"     stlinks := GetFwdLinks(start.Dst,exclude,0);\n" +
+		"     stlinks := GetNCFwdLinks(start.Dst,chapter,context,exclude,0);\n" +
 		"     fwdlinks := array_cat(fwdlinks,stlinks);\n" +
-		"     stlinks := GetFwdLinks(start.Dst,exclude,1);\n" +
+		"     stlinks := GetNCFwdLinks(start.Dst,chapter,context,exclude,2);\n" +
 		"     fwdlinks := array_cat(fwdlinks,stlinks);\n" +
-		"     stlinks := GetFwdLinks(start.Dst,exclude,2);\n" +
+		"     stlinks := GetNCFwdLinks(start.Dst,chapter,context,exclude,3);\n" +
 		"     fwdlinks := array_cat(fwdlinks,stlinks);\n" +
-		"     stlinks := GetFwdLinks(start.Dst,exclude,3);\n" +
+		"     stlinks := GetNCFwdLinks(start.Dst,chapter,context,exclude,1);\n" +
 		"     fwdlinks := array_cat(fwdlinks,stlinks);\n" +
 		"   ELSE\n" +
-		"     stlinks := GetFwdLinks(start.Dst,exclude,-3);\n" +
+		"     stlinks := GetNCFwdLinks(start.Dst,chapter,context,exclude,-1);\n" +
 		"     fwdlinks := array_cat(fwdlinks,stlinks);\n" +
-		"     stlinks := GetFwdLinks(start.Dst,exclude,-2);\n" +
+		"     stlinks := GetNCFwdLinks(start.Dst,chapter,context,exclude,0);\n" +
 		"     fwdlinks := array_cat(fwdlinks,stlinks);\n" +
-		"     stlinks := GetFwdLinks(start.Dst,exclude,-1);\n" +
+		"     stlinks := GetNCFwdLinks(start.Dst,chapter,context,exclude,2);\n" +
 		"     fwdlinks := array_cat(fwdlinks,stlinks);\n" +
-		"     stlinks := GetFwdLinks(start.Dst,exclude,0);\n" +
+		"     stlinks := GetNCFwdLinks(start.Dst,chapter,context,exclude,-2);\n" +
 		"     fwdlinks := array_cat(fwdlinks,stlinks);\n" +
-		"     stlinks := GetFwdLinks(start.Dst,exclude,1);\n" +
+		"     stlinks := GetNCFwdLinks(start.Dst,chapter,context,exclude,3);\n" +
 		"     fwdlinks := array_cat(fwdlinks,stlinks);\n" +
-		"     stlinks := GetFwdLinks(start.Dst,exclude,2);\n" +
+		"     stlinks := GetNCFwdLinks(start.Dst,chapter,context,exclude,-3);\n" +
 		"     fwdlinks := array_cat(fwdlinks,stlinks);\n" +
-		"     stlinks := GetFwdLinks(start.Dst,exclude,3);\n" +
+		"     stlinks := GetNCFwdLinks(start.Dst,chapter,context,exclude,1);\n" +
 		"     fwdlinks := array_cat(fwdlinks,stlinks);\n" +
+
 		"END CASE;\n" +
 
 		"FOREACH lnk IN ARRAY fwdlinks LOOP \n" +
@@ -1922,14 +3778,18 @@ func DefineStoredFunctions(ctx PoSST) {
 		"      exclude = array_append(exclude,lnk.Dst);\n" +
 		"      IF lnk IS NULL THEN\n" +
 		"         ret_paths := Format('%s\n%s',ret_paths,path);\n"+
-		"         RETURN ret_paths;"+
 		"      ELSE\n"+
+		"         IF context is not NULL AND NOT match_context(lnk.Ctx::text[],context::text[]) THEN\n"+
+                "            CONTINUE;\n"+
+                "         END IF;\n"+
+
 		"         tot_path := Format('%s;%s',path,lnk::Text);\n"+
-		"         appendix := SumAllPaths(lnk,tot_path,orientation,depth+1,maxdepth,exclude);\n" +
+		"         appendix := SumAllNCPaths(lnk,tot_path,orientation,depth+1,maxdepth,chapter,context,exclude);\n" +
+
 		"         IF appendix IS NOT NULL THEN\n"+
 		"            ret_paths := Format('%s\n%s',ret_paths,appendix);\n"+
 		"         ELSE\n"+
-		"            ret_paths := Format('%s\n%s',ret_paths,tot_path);"+
+		"            ret_paths := Format('%s\n%s',ret_paths,tot_path);\n"+
 		"         END IF;\n"+
 		"      END IF;\n"+
 		"   END IF;\n"+
@@ -1939,761 +3799,936 @@ func DefineStoredFunctions(ctx PoSST) {
 		"END ;\n" +
 		"$fn$ LANGUAGE plpgsql;\n"
 
-	row,err = ctx.DB.Query(qstr)
+	defs = append(defs,qstr)
+
+	// ...................................................................
+	// Now add in the more complex context/chapter filters in searching
+	// ...................................................................
+
+        // A more detailed path search that includes checks for chapter/context boundaries (NC/C functions)
+        // with a start set of more than one node
+
+	qstr = "CREATE OR REPLACE FUNCTION AllSuperNCPathsAsLinks(start NodePtr[],chapter text,context text[],orientation text,maxdepth INT)\n"+
+		"RETURNS Text AS $fn$\n" +
+		"DECLARE\n" +
+		"   root Text;\n" +
+		"   path Text;\n"+
+		"   node NodePtr;"+
+		"   summary_path Text[];\n"+
+		"   exclude NodePtr[] = start;\n" +
+		"   ret_paths Text;\n" +
+		"   startlnk Link;"+
+		"   chp text = Format('%s%s%s','%',chapter,'%');"+
+		"BEGIN\n" +
+
+		// Aggregate array of starting set
+		"FOREACH node IN ARRAY start LOOP\n"+
+		"   startlnk := GetSingletonAsLink(node);\n"+
+		"   path := Format('%s',startlnk::Text);"+
+		"   root := SumAllNCPaths(startlnk,path,orientation,1,maxdepth,chapter,context,exclude);" +
+		"ret_paths := Format('%s\n%s',ret_paths,root);\n"+
+		"END LOOP;"+
+
+		"RETURN ret_paths; \n" +
+		"END ;\n" +
+		"$fn$ LANGUAGE plpgsql;\n"
 	
-	if err != nil {
-		fmt.Println("Error defining postgres function:",qstr,err)
-	}
+        // select AllNCPathsAsLinks('(1,46)','chinese','{"food","example"}','fwd',4);
 
-	row.Close()
+	defs = append(defs,qstr)
 
-	// Check if linkpath representation is just one item
+        // An NC/C filtering version of the neighbour scan
 
-	qstr = "CREATE OR REPLACE FUNCTION empty_path(path text)\n"+
-		"RETURNS boolean AS $fn$\n" +
-		"BEGIN \n" +
-		"   IF strpos(path,';') THEN \n" + // exact match
-		"      RETURN true;\n" +
-		"   END IF;\n" +
-		"RETURN false;\n" +
+	qstr = fmt.Sprintf("CREATE OR REPLACE FUNCTION GetNCFwdLinks(start NodePtr,chapter text,context text[],exclude NodePtr[],sttype int)\n"+
+		"RETURNS Link[] AS $fn$\n" +
+		"DECLARE \n" +
+		"    neighbours Link[];\n" +
+		"    fwdlinks Link[];\n" +
+		"    lnk Link;\n" +
+		"BEGIN\n" +
+
+		"    fwdlinks = GetNCNeighboursByType(start,chapter,sttype);\n"+
+
+		"    IF fwdlinks IS NULL THEN\n" +
+		"        RETURN '{}';\n" +
+		"    END IF;\n" +
+		"    neighbours := ARRAY[]::Link[];\n" +
+		"    FOREACH lnk IN ARRAY fwdlinks\n" +
+		"    LOOP\n"+
+
+                "      IF context is not NULL AND NOT match_context(lnk.Ctx::text[],context::text[]) THEN\n"+
+                "         CONTINUE;\n"+
+                "      END IF;\n"+
+		"      IF exclude is not NULL AND NOT lnk.dst=ANY(exclude) THEN\n" +
+		"         neighbours := array_append(neighbours, lnk);\n" +
+		"      END IF; \n" + 
+		"    END LOOP;\n" +
+		"    RETURN neighbours; \n" +
+		"END ;\n" +
+		"$fn$ LANGUAGE plpgsql;\n")
+	
+	defs = append(defs,qstr)
+
+        // This one includes an NCC chapter and context filter so slower! 
+
+	qstr = fmt.Sprintf("CREATE OR REPLACE FUNCTION GetNCCLinks(start NodePtr,exclude NodePtr[],sttype int,chapter text,context text[])\n"+
+		"RETURNS Link[] AS $fn$\n" +
+		"DECLARE \n" +
+		"    neighbours Link[];\n" +
+		"    fwdlinks Link[];\n" +
+		"    lnk Link;\n" +
+		"BEGIN\n" +
+
+		"    fwdlinks =GetNCNeighboursByType(start,chapter,sttype);\n"+
+
+		"    IF fwdlinks IS NULL THEN\n" +
+		"        RETURN '{}';\n" +
+		"    END IF;\n" +
+		"    neighbours := ARRAY[]::Link[];\n" +
+		"    FOREACH lnk IN ARRAY fwdlinks\n" +
+		"    LOOP\n"+
+                "      IF context is not NULL AND NOT match_context(lnk.Ctx,context) THEN\n"+
+                "        CONTINUE;\n"+
+                "      END IF;\n"+
+		"      IF exclude is not NULL AND NOT lnk.dst=ANY(exclude) THEN\n" +
+		"         neighbours := array_append(neighbours, lnk);\n" +
+		"      END IF; \n" + 
+		"    END LOOP;\n" +
+		"    RETURN neighbours; \n" +
+		"END ;\n" +
+		"$fn$ LANGUAGE plpgsql;\n")
+
+
+        // This one includes an NC chapter filter
+
+	qstr = "CREATE OR REPLACE FUNCTION GetNCNeighboursByType(start NodePtr, chapter text,sttype int)\n"+
+		"RETURNS Link[] AS $fn$\n"+
+		"DECLARE \n"+
+		"    fwdlinks Link[] := Array[] :: Link[];\n"+
+		"    lnk Link := (0,1.0,Array[]::text[],(0,0));\n"+
+                "    chp text = Format('%s%s%s','%',chapter,'%');"+
+		"BEGIN\n"+
+
+		"   CASE sttype \n"
+	
+	for st := -EXPRESS; st <= EXPRESS; st++ {
+		qstr += fmt.Sprintf("WHEN %d THEN\n"+
+			"     SELECT %s INTO fwdlinks FROM Node WHERE Nptr=start AND lower(Chap) LIKE lower(chp);\n",st,STTypeDBChannel(st));
+	}
+
+	qstr += "ELSE RAISE EXCEPTION 'No such sttype %', sttype;\n" +
+		"END CASE;\n" +
+
+		"    RETURN fwdlinks; \n" +
 		"END ;\n" +
 		"$fn$ LANGUAGE plpgsql;\n"
 
-	row,err = ctx.DB.Query(qstr)
+        // elect GetNCNeighboursByType('(1,116)','chinese',-1);
+
+
+	defs = append(defs,qstr)
+
+	return defs
+}
+
+// **************************************************************************
+// Retrieve
+// **************************************************************************
+
+func GetDBChaptersMatchingName(ctx PoSST,src string) []string {
+
+	retval,err := TryGetDBChaptersMatchingName(ctx,src)
+
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	return retval
+}
+
+// **************************************************************************
+
+func TryGetDBChaptersMatchingName(ctx PoSST,src string) ([]string,error) {
+
+	if ctx.Store != nil {
+		return ctx.Store.GetChaptersMatchingName(src)
+	}
+
+	var qstr string
+
+	remove_accents,stripped := IsBracketedSearchTerm(src)
+
+	var search string
+
+	if remove_accents {
+		search = "%"+stripped+"%"
+		qstr = "SELECT DISTINCT Chap FROM Node WHERE lower(unaccent(Chap)) LIKE lower($1)"
+	} else {
+		search = "%"+src+"%"
+		qstr = "SELECT DISTINCT Chap FROM Node WHERE lower(Chap) LIKE lower($1)"
+	}
 
+	row, err := ctx.DB.Query(qstr,search)
+	
 	if err != nil {
-		fmt.Println("Error defining postgres function:",qstr,err)
+		return nil,fmt.Errorf("%w: QUERY GetDBChaptersMatchingName: %v",ErrDBQuery,err)
+	}
+
+	var whole string
+	var retval []string
+
+	for row.Next() {		
+		err = row.Scan(&whole)
+		retval = append(retval,whole)
 	}
 
+	sort.Strings(retval)
 	row.Close()
+	return retval,nil
+}
 
-	// Matching context strings with fuzzy criteria
+// **************************************************************************
 
-	qstr = "CREATE OR REPLACE FUNCTION match_context(db_set text[],user_set text[])\n"+
-		"RETURNS boolean AS $fn$\n" +
-		"DECLARE\n" +
-		"   db_ref text[];\n" +
-		"   unicode text;\n" +
-		"   item text;\n" +
-		"   try text;\n"+
-		"BEGIN \n" +
-		"IF array_length(user_set,1) IS NULL THEN\n"+
-		"   RETURN true;\n"+
-		"END IF;\n"+
+func GetDBContextsMatchingName(ctx PoSST,src string) []string {
 
-		"IF array_length(db_set,1) IS NULL THEN\n"+
-		"   RETURN true;\n"+
-		"END IF;\n"+
+	retval,err := TryGetDBContextsMatchingName(ctx,src)
 
-		"FOREACH item IN ARRAY db_set LOOP\n" +
-		"   db_ref := array_append(db_ref,lower(unaccent(item)));\n" +
-		"END LOOP;\n" +
+	if err != nil {
+		fmt.Println(err)
+	}
 
-		"FOREACH item IN ARRAY user_set LOOP\n" +
-		"   IF item = 'any' OR item = '' THEN\n"+
-		"     RETURN true;"+
-		"   END IF;"+
-		"  unicode := replace(item,'|','');\n" +
-		"  FOREACH try IN ARRAY db_ref LOOP\n"+
-		"     IF length(substring(try from lower(unicode))) > 3 THEN \n" + // unaccented unicode match
-	        "        RETURN true;\n" +
-		"     END IF;\n" +
-		"  END LOOP;"+
-		"END LOOP;\n" +
-		"RETURN false;\n" +
-		"END ;\n" +
-		"$fn$ LANGUAGE plpgsql;\n"
+	return retval
+}
+
+// **************************************************************************
+
+func TryGetDBContextsMatchingName(ctx PoSST,src string) ([]string,error) {
+
+	if ctx.Store != nil {
+		return ctx.Store.GetContextsMatchingName(src)
+	}
+
+	var qstr string
 
-	row,err = ctx.DB.Query(qstr)
+	remove_accents,stripped := IsBracketedSearchTerm(src)
+
+	search := src
+
+	if remove_accents {
+		search = stripped
+	}
+
+	qstr = "SELECT DISTINCT Ctx FROM NodeArrowNode WHERE match_context(Ctx,$1::text[])"
+
+	row, err := ctx.DB.Query(qstr,SQLStringArray([]string{search}))
 	
 	if err != nil {
-		fmt.Println("Error defining postgres function:",qstr,err)
+		return nil,fmt.Errorf("%w: QUERY GetDBContextsMatchingName: %v",ErrDBQuery,err)
+	}
+
+	var whole string
+	var retval []string
+	var idemp = make(map[string]int)
+
+	for row.Next() {		
+		err = row.Scan(&whole)
+		a := ParseSQLArrayString(whole)
+		for i := range a {
+			idemp[a[i]]++
+		}
+	}
+
+	for s := range idemp {
+		retval = append(retval,s)
 	}
 
 	row.Close()
 
-	// Matching integer ranges
+	sort.Strings(retval)
+	return retval,nil
 
-	qstr = "CREATE OR REPLACE FUNCTION match_arrows(arr int,user_set int[])\n"+
-		"RETURNS boolean AS $fn$\n" +
-		"BEGIN \n" +
-		"   IF array_length(user_set,1) IS NULL THEN \n" + // empty arrows
-                "      RETURN true;"+
-		"   END IF;"+
-		"   IF arr = ANY(user_set) THEN \n" + // exact match
-		"      RETURN true;\n" +
-		"   END IF;\n" +
-		"RETURN false;\n" +
-		"END ;\n" +
-		"$fn$ LANGUAGE plpgsql;\n"
+}
+
+// **************************************************************************
 
-	row,err = ctx.DB.Query(qstr)
+func GetDBNodePtrMatchingName(ctx PoSST,src,chap string) []NodePtr {
+
+	retval,err := TryGetDBNodePtrMatchingName(ctx,src,chap)
 
 	if err != nil {
-		fmt.Println("Error defining postgres function:",qstr,err)
+		fmt.Println(err)
 	}
 
-	row.Close()
+	return retval
+}
 
-	// Helper to find arrows by type
+// **************************************************************************
 
-	qstr = "CREATE OR REPLACE FUNCTION ArrowInList(arrow int,links Link[])\n"+
-		"RETURNS boolean AS $fn$\n"+
-		"DECLARE \n"+
-		"   lnk Link;\n"+
-		"BEGIN\n"+
-		"IF links IS NULL THEN\n"+
-		"   RETURN false;"+
-		"END IF;"+
-		"FOREACH lnk IN ARRAY links LOOP\n"+
-		"  IF lnk.Arr = arrow THEN\n"+
-		"     RETURN true;\n"+
-		"  END IF;\n"+
-		"END LOOP;"+
-		"RETURN false;"+
-		"END ;\n" +
-		"$fn$ LANGUAGE plpgsql;\n"
+func TryGetDBNodePtrMatchingName(ctx PoSST,src,chap string) ([]NodePtr,error) {
+
+	if ctx.Store != nil {
+		return ctx.Store.GetNodePtrMatchingName(src,chap)
+	}
 
-	row,err = ctx.DB.Query(qstr)
+	var qstr string
 
-	if err != nil {
-		fmt.Println("Error defining postgres function:",qstr,err)
+	if src == "" || src == "empty" {
+		return nil,nil
 	}
+ 
+	var args []interface{}
 
-	row.Close()
+	remove_accents,stripped := IsBracketedSearchTerm(src)
 
-	// NC version
+	if remove_accents {
+		search := "%"+stripped+"%"
+		qstr = "select NPtr from Node where lower(unaccent(S)) LIKE lower("+SQLArg(&args,search)+")"
+	} else {
+		search := "%"+src+"%"
+		qstr = "select NPtr from Node where lower(S) LIKE lower("+SQLArg(&args,search)+")"
+	}
 
-	qstr = "CREATE OR REPLACE FUNCTION ArrowInContextList(arrow int,links Link[],context text[])\n"+
-		"RETURNS boolean AS $fn$\n"+
-		"DECLARE \n"+
-		"   lnk Link;\n"+
-		"BEGIN\n"+
-		"IF links IS NULL THEN\n"+
-		"   RETURN false;"+
-		"END IF;"+
-		"FOREACH lnk IN ARRAY links LOOP\n"+
-		"  IF lnk.Arr = arrow AND match_context(lnk.Ctx::text[],context) THEN\n"+
-		"     RETURN true;\n"+
-		"  END IF;\n"+
-		"END LOOP;"+
-		"RETURN false;"+
-		"END ;\n" +
-		"$fn$ LANGUAGE plpgsql;\n"
+	if chap != "any" && chap != "" {
 
-	row,err = ctx.DB.Query(qstr)
+		remove_accents,stripped := IsBracketedSearchTerm(chap)
+		if remove_accents {
+			chapter := "%"+stripped+"%"
+			qstr += " AND lower(unaccent(chap)) LIKE lower("+SQLArg(&args,chapter)+")"
+		} else {
+			chapter := "%"+chap+"%"
+			qstr += " AND lower(chap) LIKE lower("+SQLArg(&args,chapter)+")"
+		}
+	}
 
+	row, err := ctx.DB.Query(qstr,args...)
+	
 	if err != nil {
-		fmt.Println("Error defining postgres function:",qstr,err)
+		return nil,fmt.Errorf("%w: QUERY GetNodePtrMatchingName Failed: %v",ErrDBQuery,err)
+	}
+
+	var whole string
+	var n NodePtr
+	var retval []NodePtr
+
+	for row.Next() {		
+		err = row.Scan(&whole)
+		fmt.Sscanf(whole,"(%d,%d)",&n.Class,&n.CPtr)
+		retval = append(retval,n)
 	}
 
 	row.Close()
+	return retval,nil
 
-	// ***********************************
-	// Find the start of story paths, where outgoing nodes match but no incoming
-	// This means we've reached the top of a hierarchy
-	// ***********************************
+}
 
-	// Find the node that sit's at the start/top of a causal chain
+// **************************************************************************
 
-	qstr =  "CREATE OR REPLACE FUNCTION GetStoryStartNodes(arrow int,inverse int,sttype int)\n"+
-		"RETURNS NodePtr[] AS $fn$\n"+
-		"DECLARE \n"+
-		"   retval nodeptr[] = ARRAY[]::nodeptr[];\n"+
-		"BEGIN\n"+
-		"   CASE sttype \n"
-	
-	for st := -EXPRESS; st <= EXPRESS; st++ {
-		qstr += fmt.Sprintf("WHEN %d THEN\n"+
-			"   SELECT array_agg(Nptr) into retval FROM Node WHERE ArrowInList(arrow,%s) AND NOT ArrowInList(inverse,%s);\n",st,STTypeDBChannel(st),STTypeDBChannel(-st));
+func TryGetDBNodePtrsByText(ctx PoSST,texts []string) (map[string]NodePtr,error) {
+
+	// Find stored nodes whose text equals one of these, ignoring case
+
+	if ctx.Store != nil {
+		return ctx.Store.GetNodePtrsByText(texts)
 	}
-	qstr += "ELSE RAISE EXCEPTION 'No such sttype %', sttype;\n" +
-		"END CASE;\n" +
-		"    RETURN retval; \n" +
-		"END ;\n" +
-		"$fn$ LANGUAGE plpgsql;\n"
 
-	row,err = ctx.DB.Query(qstr)
-	
+	var lower []string
+
+	for t := range texts {
+		lower = append(lower,strings.ToLower(texts[t]))
+	}
+
+	qstr := "SELECT S,NPtr FROM Node WHERE lower(S)=ANY($1::text[])"
+
+	row, err := SQLConn(ctx).Query(qstr,pq.Array(lower))
+
 	if err != nil {
-		fmt.Println("FAILED \n",qstr,err)
+		return nil,fmt.Errorf("%w: QUERY GetDBNodePtrsByText Failed: %v",ErrDBQuery,err)
+	}
+
+	var text,whole string
+	var n NodePtr
+	var retval = make(map[string]NodePtr)
+
+	for row.Next() {
+		err = row.Scan(&text,&whole)
+		fmt.Sscanf(whole,"(%d,%d)",&n.Class,&n.CPtr)
+		retval[text] = n
 	}
 
 	row.Close()
+	return retval,err
+}
 
+// **************************************************************************
 
-	// Find the node that sit's at the start/top of a causal chain
+func TryGetDBTopNodePtrs(ctx PoSST) (map[int]ClassedNodePtr,error) {
 
-	qstr =  "CREATE OR REPLACE FUNCTION GetNCCStoryStartNodes(arrow int,inverse int,sttype int,chapter text,context text[])\n"+
-		"RETURNS NodePtr[] AS $fn$\n"+
-		"DECLARE \n"+
-		"   retval nodeptr[] = ARRAY[]::nodeptr[];\n"+
-		"BEGIN\n"+
-		"   CASE sttype \n"
-	for st := -EXPRESS; st <= EXPRESS; st++ {
-		qstr += fmt.Sprintf("WHEN %d THEN\n"+
-			"   SELECT array_agg(Nptr) into retval FROM Node WHERE lower(Chap) LIKE lower(chapter) AND ArrowInContextList(arrow,%s,context) AND NOT ArrowInContextList(inverse,%s,context);\n",st,STTypeDBChannel(st),STTypeDBChannel(-st));
+	// The highest pointer in use for each class (channel) of node
+
+	if ctx.Store != nil {
+		return ctx.Store.GetTopNodePtrs()
 	}
-	qstr += "ELSE RAISE EXCEPTION 'No such sttype %', sttype;\n" +
-		"END CASE;\n" +
-		"    RETURN retval; \n" +
-		"END ;\n" +
-		"$fn$ LANGUAGE plpgsql;\n"
 
-	row,err = ctx.DB.Query(qstr)
-	
+	qstr := "SELECT (NPtr).Chan,max((NPtr).CPtr) FROM Node GROUP BY (NPtr).Chan"
+
+	row, err := SQLConn(ctx).Query(qstr)
+
 	if err != nil {
-		fmt.Println("FAILED \n",qstr,err)
+		return nil,fmt.Errorf("%w: QUERY GetDBTopNodePtrs Failed: %v",ErrDBQuery,err)
 	}
 
-	row.Close()
+	var class int
+	var cptr ClassedNodePtr
+	var retval = make(map[int]ClassedNodePtr)
 
-	// ...................................................................
-	// Now add in the more complex context/chapter filters in searching
-	// ...................................................................
+	for row.Next() {
+		err = row.Scan(&class,&cptr)
+		retval[class] = cptr
+	}
 
-        // A more detailed path search that includes checks for chapter/context boundaries (NC/C functions)
+	row.Close()
+	return retval,err
+}
 
-	qstr = "CREATE OR REPLACE FUNCTION AllNCPathsAsLinks(start NodePtr,chapter text,context text[],orientation text,maxdepth INT)\n"+
-		"RETURNS Text AS $fn$\n" +
-		"DECLARE\n" +
-		"   hop Text;\n" +
-		"   path Text;\n"+
-		"   summary_path Text[];\n"+
-		"   exclude NodePtr[] = ARRAY[start]::NodePtr[];\n" +
-		"   ret_paths Text;\n" +
-		"   startlnk Link;"+
-		"   chp text = Format('%s%s%s','%',chapter,'%');"+
-		"BEGIN\n" +
-		"startlnk := GetSingletonAsLink(start);\n"+
-		"path := Format('%s',startlnk::Text);"+
-		"ret_paths := SumAllNCPaths(startlnk,path,orientation,1,maxdepth,chapter,context,exclude);" +
+// **************************************************************************
 
-		"RETURN ret_paths; \n" +
-		"END ;\n" +
-		"$fn$ LANGUAGE plpgsql;\n"
-	
-        // select AllNCPathsAsLinks('(1,46)','chinese','{"food","example"}','fwd',4);
+func GetDBNodePtrMatching(ctx PoSST,nm,chap string,cn []string,arrow []ArrowPtr) []NodePtr {
+
+	retval,err := TryGetDBNodePtrMatching(ctx,nm,chap,cn,arrow)
 
-	row,err = ctx.DB.Query(qstr)
-	
 	if err != nil {
-		fmt.Println("Error defining postgres function:",qstr,err)
+		fmt.Println(err)
 	}
 
-	row.Close()
+	return retval
+}
 
-	// SumAllNCPaths - a filtering version of the SumAllPaths recursive helper function, slower but more powerful
+// **************************************************************************
 
-	qstr = "CREATE OR REPLACE FUNCTION SumAllNCPaths(start Link,path TEXT,orientation text,depth int, maxdepth INT,chapter text,context text[],exclude NodePtr[])\n"+
-		"RETURNS Text AS $fn$\n" +
-		"DECLARE \n" + 
-		"    fwdlinks Link[];\n" +
-		"    stlinks  Link[];\n" +
-		"    empty Link[] = ARRAY[]::Link[];\n" +
-		"    lnk Link;\n" +
-		"    fwd Link;\n" +
-		"    ret_paths Text;\n" +
-		"    appendix Text;\n" +
-		"    tot_path Text;\n"+
-		"BEGIN\n" +
+func TryGetDBNodePtrMatching(ctx PoSST,nm,chap string,cn []string,arrow []ArrowPtr) ([]NodePtr,error) {
 
-		"IF depth = maxdepth THEN\n"+
-		"  ret_paths := Format('%s\n%s',ret_paths,path);\n"+
-		"  RETURN ret_paths;\n"+
-		"END IF;\n"+
+	if ctx.Store != nil {
+		return ctx.Store.GetNodePtrMatching(nm,chap,cn,arrow)
+	}
 
-		// We order the link types to respect the geometry of the temporal links
-		// so that (then) will always come last for visual sensemaking
+	// Match name, context, chapter
 
-		// Get *All* in/out Links
-		"CASE \n" +
-		"   WHEN orientation = 'bwd' THEN\n" +
-		"     stlinks := GetNCFwdLinks(start.Dst,chapter,context,exclude,-1);\n" +
-		"     fwdlinks := array_cat(fwdlinks,stlinks);\n" +
-		"     stlinks := GetNCFwdLinks(start.Dst,chapter,context,exclude,0);\n" +
-		"     fwdlinks := array_cat(fwdlinks,stlinks);\n" +
-		"     stlinks := GetNCFwdLinks(start.Dst,chapter,context,exclude,-2);\n" +
-		"     fwdlinks := array_cat(fwdlinks,stlinks);\n" +
-		"     stlinks := GetNCFwdLinks(start.Dst,chapter,context,exclude,-3);\n" +
-		"     fwdlinks := array_cat(fwdlinks,stlinks);\n" +
-		"   WHEN orientation = 'fwd' THEN\n" +
-		"     stlinks := GetNCFwdLinks(start.Dst,chapter,context,exclude,0);\n" +
-		"     fwdlinks := array_cat(fwdlinks,stlinks);\n" +
-		"     stlinks := GetNCFwdLinks(start.Dst,chapter,context,exclude,2);\n" +
-		"     fwdlinks := array_cat(fwdlinks,stlinks);\n" +
-		"     stlinks := GetNCFwdLinks(start.Dst,chapter,context,exclude,3);\n" +
-		"     fwdlinks := array_cat(fwdlinks,stlinks);\n" +
-		"     stlinks := GetNCFwdLinks(start.Dst,chapter,context,exclude,1);\n" +
-		"     fwdlinks := array_cat(fwdlinks,stlinks);\n" +
-		"   ELSE\n" +
-		"     stlinks := GetNCFwdLinks(start.Dst,chapter,context,exclude,-1);\n" +
-		"     fwdlinks := array_cat(fwdlinks,stlinks);\n" +
-		"     stlinks := GetNCFwdLinks(start.Dst,chapter,context,exclude,0);\n" +
-		"     fwdlinks := array_cat(fwdlinks,stlinks);\n" +
-		"     stlinks := GetNCFwdLinks(start.Dst,chapter,context,exclude,2);\n" +
-		"     fwdlinks := array_cat(fwdlinks,stlinks);\n" +
-		"     stlinks := GetNCFwdLinks(start.Dst,chapter,context,exclude,-2);\n" +
-		"     fwdlinks := array_cat(fwdlinks,stlinks);\n" +
-		"     stlinks := GetNCFwdLinks(start.Dst,chapter,context,exclude,3);\n" +
-		"     fwdlinks := array_cat(fwdlinks,stlinks);\n" +
-		"     stlinks := GetNCFwdLinks(start.Dst,chapter,context,exclude,-3);\n" +
-		"     fwdlinks := array_cat(fwdlinks,stlinks);\n" +
-		"     stlinks := GetNCFwdLinks(start.Dst,chapter,context,exclude,1);\n" +
-		"     fwdlinks := array_cat(fwdlinks,stlinks);\n" +
+	var chap_col, nm_col string
+	var args []interface{}
 
-		"END CASE;\n" +
+	_,cn_stripped := IsBracketedSearchList(cn)
 
-		"FOREACH lnk IN ARRAY fwdlinks LOOP \n" +
-		"   IF NOT lnk.Dst = ANY(exclude) THEN\n"+
-		"      exclude = array_append(exclude,lnk.Dst);\n" +
-		"      IF lnk IS NULL THEN\n" +
-		"         ret_paths := Format('%s\n%s',ret_paths,path);\n"+
-		"      ELSE\n"+
-		"         IF context is not NULL AND NOT match_context(lnk.Ctx::text[],context::text[]) THEN\n"+
-                "            CONTINUE;\n"+
-                "         END IF;\n"+
+	context := SQLArg(&args,SQLStringArray(cn_stripped))
+	arrows := SQLArg(&args,SQLIntArray(Arrow2Int(arrow)))
 
-		"         tot_path := Format('%s;%s',path,lnk::Text);\n"+
-		"         appendix := SumAllNCPaths(lnk,tot_path,orientation,depth+1,maxdepth,chapter,context,exclude);\n" +
+	remove_name_accents,nm_stripped := IsBracketedSearchTerm(nm)
 
-		"         IF appendix IS NOT NULL THEN\n"+
-		"            ret_paths := Format('%s\n%s',ret_paths,appendix);\n"+
-		"         ELSE\n"+
-		"            ret_paths := tot_path;\n"+
-		"         END IF;\n"+
-		"      END IF;\n"+
-		"   END IF;\n"+
-		"END LOOP;\n"+
+	if remove_name_accents {
+		nm_search := "%"+nm_stripped+"%"
+		nm_col = "AND lower(unaccent(S)) LIKE lower("+SQLArg(&args,nm_search)+")"
+	} else {
+		nm_search := "%"+nm+"%"
+		nm_col = "AND lower(S) LIKE lower("+SQLArg(&args,nm_search)+")"
+	}
+
+	if chap != "any" && chap != "" {
+
+		remove_chap_accents,chap_stripped := IsBracketedSearchTerm(chap)
+
+		if remove_chap_accents {
+			chap_search := "%"+chap_stripped+"%"
+			chap_col = "AND lower(unaccent(chap)) LIKE lower("+SQLArg(&args,chap_search)+")"
+		} else {
+			chap_search := "%"+chap+"%"
+			chap_col = "AND lower(chap) LIKE lower("+SQLArg(&args,chap_search)+")"
+		}
+	}
 
-		"RETURN ret_paths; \n" +
-		"END ;\n" +
-		"$fn$ LANGUAGE plpgsql;\n"
+	qstr := fmt.Sprintf("WITH matching_nodes AS "+
+		"  (SELECT NFrom,ctx,match_context(ctx,%s::text[]) AS match,match_arrows(Arr,%s::int[]) AS matcha FROM NodeArrowNode)"+
+		"     SELECT DISTINCT nfrom FROM matching_nodes "+
+		"      JOIN Node ON nptr=nfrom WHERE match=true AND matcha=true %s %s",
+		context,arrows,nm_col,chap_col)
 
-	row,err = ctx.DB.Query(qstr)
+	row, err := ctx.DB.Query(qstr,args...)
 	
 	if err != nil {
-		fmt.Println("Error defining postgres function:",qstr,err)
+		return nil,fmt.Errorf("%w: QUERY GetNodePtrMatchingNCC Failed: %v",ErrDBQuery,err)
 	}
 
-	row.Close()
+	var whole string
+	var n NodePtr
+	var retval []NodePtr
 
-	// ...................................................................
-	// Now add in the more complex context/chapter filters in searching
-	// ...................................................................
+	for row.Next() {		
+		err = row.Scan(&whole)
+		fmt.Sscanf(whole,"(%d,%d)",&n.Class,&n.CPtr)
+		retval = append(retval,n)
+	}
 
-        // A more detailed path search that includes checks for chapter/context boundaries (NC/C functions)
-        // with a start set of more than one node
+	row.Close()
+	return retval,nil
 
-	qstr = "CREATE OR REPLACE FUNCTION AllSuperNCPathsAsLinks(start NodePtr[],chapter text,context text[],orientation text,maxdepth INT)\n"+
-		"RETURNS Text AS $fn$\n" +
-		"DECLARE\n" +
-		"   root Text;\n" +
-		"   path Text;\n"+
-		"   node NodePtr;"+
-		"   summary_path Text[];\n"+
-		"   exclude NodePtr[] = start;\n" +
-		"   ret_paths Text;\n" +
-		"   startlnk Link;"+
-		"   chp text = Format('%s%s%s','%',chapter,'%');"+
-		"BEGIN\n" +
+}
+// **************************************************************************
 
-		// Aggregate array of starting set
-		"FOREACH node IN ARRAY start LOOP\n"+
-		"   startlnk := GetSingletonAsLink(node);\n"+
-		"   path := Format('%s',startlnk::Text);"+
-		"   root := SumAllNCPaths(startlnk,path,orientation,1,maxdepth,chapter,context,exclude);" +
-		"ret_paths := Format('%s\n%s',ret_paths,root);\n"+
-		"END LOOP;"+
+func SearchDBNodeText(ctx PoSST,text,chap string,limit int) []NodeMatch {
 
-		"RETURN ret_paths; \n" +
-		"END ;\n" +
-		"$fn$ LANGUAGE plpgsql;\n"
-	
-        // select AllNCPathsAsLinks('(1,46)','chinese','{"food","example"}','fwd',4);
+	matches,err := TrySearchDBNodeText(ctx,text,chap,limit)
 
-	row,err = ctx.DB.Query(qstr)
-	
 	if err != nil {
-		fmt.Println("Error defining postgres function:",qstr,err)
+		fmt.Println(err)
 	}
 
-	row.Close()
+	return matches
+}
 
-        // An NC/C filtering version of the neighbour scan
+// **************************************************************************
 
-	qstr = fmt.Sprintf("CREATE OR REPLACE FUNCTION GetNCFwdLinks(start NodePtr,chapter text,context text[],exclude NodePtr[],sttype int)\n"+
-		"RETURNS Link[] AS $fn$\n" +
-		"DECLARE \n" +
-		"    neighbours Link[];\n" +
-		"    fwdlinks Link[];\n" +
-		"    lnk Link;\n" +
-		"BEGIN\n" +
+func TrySearchDBNodeText(ctx PoSST,text,chap string,limit int) ([]NodeMatch,error) {
 
-		"    fwdlinks = GetNCNeighboursByType(start,chapter,sttype);\n"+
+	// Like GetDBNodePtrMatchingName(), but ranked by relevance, best first:
+	// an exact match, then the full text search rank (in the language of
+	// search_config), then trigram similarity, so near misses are found too.
+	// Equally relevant nodes are ranked by importance, see UpdateCentrality()
 
-		"    IF fwdlinks IS NULL THEN\n" +
-		"        RETURN '{}';\n" +
-		"    END IF;\n" +
-		"    neighbours := ARRAY[]::Link[];\n" +
-		"    FOREACH lnk IN ARRAY fwdlinks\n" +
-		"    LOOP\n"+
+	if text == "" || text == "empty" {
+		return nil,nil
+	}
 
-                "      IF context is not NULL AND NOT match_context(lnk.Ctx::text[],context::text[]) THEN\n"+
-                "         CONTINUE;\n"+
-                "      END IF;\n"+
-		"      IF exclude is not NULL AND NOT lnk.dst=ANY(exclude) THEN\n" +
-		"         neighbours := array_append(neighbours, lnk);\n" +
-		"      END IF; \n" + 
-		"    END LOOP;\n" +
-		"    RETURN neighbours; \n" +
-		"END ;\n" +
-		"$fn$ LANGUAGE plpgsql;\n")
-	
-	row,err = ctx.DB.Query(qstr)
-	
-	if err != nil {
-		fmt.Println("Error defining postgres function:",qstr,err)
+	if ctx.Store != nil {
+		return ctx.Store.SearchNodeText(text,chap,limit)
 	}
 
-	row.Close()
+	return TrySearchDBNodeTextRanked(ctx,text,chap,nil,nil,limit)
+}
 
-        // This one includes an NCC chapter and context filter so slower! 
+// **************************************************************************
 
-	qstr = fmt.Sprintf("CREATE OR REPLACE FUNCTION GetNCCLinks(start NodePtr,exclude NodePtr[],sttype int,chapter text,context text[])\n"+
-		"RETURNS Link[] AS $fn$\n" +
-		"DECLARE \n" +
-		"    neighbours Link[];\n" +
-		"    fwdlinks Link[];\n" +
-		"    lnk Link;\n" +
-		"BEGIN\n" +
+func SearchDBNodeTextInContext(ctx PoSST,text,chap string,cn []string,arrow []ArrowPtr,limit int) []NodeMatch {
 
-		"    fwdlinks =GetNCNeighboursByType(start,chapter,sttype);\n"+
+	matches,err := TrySearchDBNodeTextInContext(ctx,text,chap,cn,arrow,limit)
 
-		"    IF fwdlinks IS NULL THEN\n" +
-		"        RETURN '{}';\n" +
-		"    END IF;\n" +
-		"    neighbours := ARRAY[]::Link[];\n" +
-		"    FOREACH lnk IN ARRAY fwdlinks\n" +
-		"    LOOP\n"+
-                "      IF context is not NULL AND NOT match_context(lnk.Ctx,context) THEN\n"+
-                "        CONTINUE;\n"+
-                "      END IF;\n"+
-		"      IF exclude is not NULL AND NOT lnk.dst=ANY(exclude) THEN\n" +
-		"         neighbours := array_append(neighbours, lnk);\n" +
-		"      END IF; \n" + 
-		"    END LOOP;\n" +
-		"    RETURN neighbours; \n" +
-		"END ;\n" +
-		"$fn$ LANGUAGE plpgsql;\n")
+	if err != nil {
+		fmt.Println(err)
+	}
 
+	return matches
+}
 
-        // This one includes an NC chapter filter
+// **************************************************************************
 
-	qstr = "CREATE OR REPLACE FUNCTION GetNCNeighboursByType(start NodePtr, chapter text,sttype int)\n"+
-		"RETURNS Link[] AS $fn$\n"+
-		"DECLARE \n"+
-		"    fwdlinks Link[] := Array[] :: Link[];\n"+
-		"    lnk Link := (0,1.0,Array[]::text[],(0,0));\n"+
-                "    chp text = Format('%s%s%s','%',chapter,'%');"+
-		"BEGIN\n"+
+func TrySearchDBNodeTextInContext(ctx PoSST,text,chap string,cn []string,arrow []ArrowPtr,limit int) ([]NodeMatch,error) {
 
-		"   CASE sttype \n"
-	
-	for st := -EXPRESS; st <= EXPRESS; st++ {
-		qstr += fmt.Sprintf("WHEN %d THEN\n"+
-			"     SELECT %s INTO fwdlinks FROM Node WHERE Nptr=start AND lower(Chap) LIKE lower(chp);\n",st,STTypeDBChannel(st));
+	// The ranked version of GetDBNodePtrMatching(), restricted to nodes
+	// with links in the context and with the arrows, if any are given
+
+	if text == "" || text == "empty" {
+		return nil,nil
 	}
 
-	qstr += "ELSE RAISE EXCEPTION 'No such sttype %', sttype;\n" +
-		"END CASE;\n" +
+	var nonempty []string
 
-		"    RETURN fwdlinks; \n" +
-		"END ;\n" +
-		"$fn$ LANGUAGE plpgsql;\n"
+	for _,c := range cn {
+		if strings.TrimSpace(c) != "" {
+			nonempty = append(nonempty,c)
+		}
+	}
 
-        // elect GetNCNeighboursByType('(1,116)','chinese',-1);
+	if ctx.Store == nil {
+		return TrySearchDBNodeTextRanked(ctx,text,chap,nonempty,arrow,limit)
+	}
 
+	matches,err := ctx.Store.SearchNodeText(text,chap,0)
 
-	row,err = ctx.DB.Query(qstr)
-	
 	if err != nil {
-		fmt.Println("Error defining postgres function:",qstr,err)
+		return nil,err
 	}
 
-	row.Close()
+	if len(nonempty) > 0 || len(arrow) > 0 {
+
+		nptrs,err := TryGetDBNodePtrMatching(ctx,text,chap,cn,arrow)
 
+		if err != nil {
+			return nil,err
+		}
+
+		var allowed = make(map[NodePtr]bool)
+
+		for _,nptr := range nptrs {
+			allowed[nptr] = true
+		}
+
+		var filtered []NodeMatch
+
+		for _,m := range matches {
+			if allowed[m.NPtr] {
+				filtered = append(filtered,m)
+			}
+		}
+
+		matches = filtered
+	}
+
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+
+	return matches,nil
 }
 
-// **************************************************************************
-// Retrieve
 // **************************************************************************
 
-func GetDBChaptersMatchingName(ctx PoSST,src string) []string {
+func TrySearchDBNodeTextRanked(ctx PoSST,text,chap string,cn []string,arrow []ArrowPtr,limit int) ([]NodeMatch,error) {
 
-	var qstr string
+	// The query behind both searches, with the context and arrows in the
+	// SQL so that the ranking and limit apply to the nodes that qualify
 
-	remove_accents,stripped := IsBracketedSearchTerm(src)
+	var args []interface{}
+	var s,search string
+
+	remove_accents,stripped := IsBracketedSearchTerm(text)
 
 	if remove_accents {
-		search := "%"+stripped+"%"
-		qstr = fmt.Sprintf("SELECT DISTINCT Chap FROM Node WHERE lower(unaccent(Chap)) LIKE lower('%s')",search)
+		s = "lower(unaccent(S))"
+		search = "lower(unaccent("+SQLArg(&args,stripped)+"))"
 	} else {
-		search := "%"+src+"%"
-		qstr = fmt.Sprintf("SELECT DISTINCT Chap FROM Node WHERE lower(Chap) LIKE lower('%s')",search)
+		s = "lower(S)"
+		search = "lower("+SQLArg(&args,text)+")"
 	}
 
-	row, err := ctx.DB.Query(qstr)
-	
-	if err != nil {
-		fmt.Println("QUERY GetDBChaptersMatchingName",err)
-	}
+	qstr := "WITH q AS (SELECT websearch_to_tsquery(sst_search_language(),"+search+") AS tsq) " +
+		"SELECT NPtr,S,Chap,((CASE WHEN "+s+"="+search+" THEN 1.0 ELSE 0.0 END)+ts_rank_cd(Search,q.tsq)+similarity("+s+","+search+"))::float8 AS rank," +
+		"COALESCE((SELECT c.PageRank FROM Centrality c WHERE c.Scope='' AND c.NPtr=Node.NPtr),0)::float8 AS importance " +
+		"FROM Node,q WHERE (Search @@ q.tsq OR "+s+" LIKE '%'||"+search+"||'%' OR "+s+" % "+search+")"
 
-	var whole string
-	var retval []string
+	if chap != "any" && chap != "" {
 
-	for row.Next() {		
-		err = row.Scan(&whole)
-		retval = append(retval,whole)
+		remove_accents,stripped := IsBracketedSearchTerm(chap)
+
+		if remove_accents {
+			qstr += " AND lower(unaccent(chap)) LIKE lower("+SQLArg(&args,"%"+stripped+"%")+")"
+		} else {
+			qstr += " AND lower(chap) LIKE lower("+SQLArg(&args,"%"+chap+"%")+")"
+		}
 	}
 
-	sort.Strings(retval)
-	row.Close()
-	return retval
-}
+	if len(cn) > 0 || len(arrow) > 0 {
 
-// **************************************************************************
+		// Only nodes with links in the context, by one of the arrows
 
-func GetDBContextsMatchingName(ctx PoSST,src string) []string {
+		_,cn_stripped := IsBracketedSearchList(cn)
 
-	var qstr string
+		qstr += " AND EXISTS (SELECT 1 FROM NodeArrowNode l WHERE l.NFrom=Node.NPtr AND l.STType<>999" +
+			" AND match_context(l.Ctx,"+SQLArg(&args,SQLStringArray(cn_stripped))+"::text[])" +
+			" AND match_arrows(l.Arr,"+SQLArg(&args,SQLIntArray(Arrow2Int(arrow)))+"::int[]))"
+	}
 
-	remove_accents,stripped := IsBracketedSearchTerm(src)
+	qstr += " ORDER BY rank DESC,importance DESC,length(S),S"
 
-	if remove_accents {
-		search := stripped
-		qstr = fmt.Sprintf("SELECT DISTINCT Ctx FROM NodeArrowNode WHERE match_context(Ctx,'{%s}')",search)
-	} else {
-		search := src
-		qstr = fmt.Sprintf("SELECT DISTINCT Ctx FROM NodeArrowNode WHERE match_context(Ctx,'{%s}')",search)
+	if limit > 0 {
+		qstr += " LIMIT "+SQLArg(&args,limit)
 	}
 
-	row, err := ctx.DB.Query(qstr)
-	
+	row,err := SQLConn(ctx).Query(qstr,args...)
+
 	if err != nil {
-		fmt.Println("QUERY GetDBChaptersMatchingName",err)
+		return nil,fmt.Errorf("%w: QUERY SearchNodeText Failed: %v\n%s",ErrDBQuery,err,qstr)
 	}
 
-	var whole string
-	var retval []string
-	var idemp = make(map[string]int)
+	var retval []NodeMatch
 
-	for row.Next() {		
-		err = row.Scan(&whole)
-		a := ParseSQLArrayString(whole)
-		for i := range a {
-			idemp[a[i]]++
+	for row.Next() {
+
+		var whole string
+		var m NodeMatch
+
+		err = row.Scan(&whole,&m.S,&m.Chap,&m.Rank,&m.Importance)
+
+		if err != nil {
+			row.Close()
+			return nil,fmt.Errorf("%w: QUERY SearchNodeText Failed: %v",ErrDBQuery,err)
 		}
-	}
 
-	for s := range idemp {
-		retval = append(retval,s)
+		fmt.Sscanf(whole,"(%d,%d)",&m.NPtr.Class,&m.NPtr.CPtr)
+		m.Highlight = HighlightText(m.S,text)
+		retval = append(retval,m)
 	}
 
 	row.Close()
+	return retval,nil
+}
 
-	sort.Strings(retval)
-	return retval
+// **************************************************************************
+
+func NodeMatchPtrs(matches []NodeMatch) []NodePtr {
 
+	var nptrs []NodePtr
+
+	for _,m := range matches {
+		nptrs = append(nptrs,m.NPtr)
+	}
+
+	return nptrs
 }
 
 // **************************************************************************
 
-func GetDBNodePtrMatchingName(ctx PoSST,src,chap string) []NodePtr {
+func HighlightText(s,search string) string {
 
-	var qstr string
+	// Mark the words of a search in s, ignoring case (and accents for a
+	// bracketed search term), between HIGHLIGHT_ON and HIGHLIGHT_OFF
 
-	if src == "" || src == "empty" {
-		return nil
-	}
- 
-	remove_accents,stripped := IsBracketedSearchTerm(src)
+	remove_accents,stripped := IsBracketedSearchTerm(search)
 
-	if remove_accents {
-		search := "%"+stripped+"%"
-		qstr = fmt.Sprintf("select NPtr from Node where lower(unaccent(S)) LIKE lower('%s')",search)
-	} else {
-		search := "%"+src+"%"
-		qstr = fmt.Sprintf("select NPtr from Node where lower(S) LIKE lower('%s')",search)
-	}
+	fold := func(r rune) rune {
 
-	if chap != "any" && chap != "" {
+		r = unicode.ToLower(r)
 
-		remove_accents,stripped := IsBracketedSearchTerm(chap)
 		if remove_accents {
-			chapter := "%"+stripped+"%"
-			qstr += fmt.Sprintf(" AND lower(unaccent(chap)) LIKE '%s'",chapter)
-		} else {
-			chapter := "%"+chap+"%"
-			qstr += fmt.Sprintf(" AND lower(chap) LIKE '%s'",chapter)
+			if u := []rune(Unaccent(string(r))); len(u) == 1 {
+				r = u[0]
+			}
 		}
+
+		return r
 	}
 
-	row, err := ctx.DB.Query(qstr)
-	
-	if err != nil {
-		fmt.Println("QUERY GetNodePtrMatchingName Failed",err)
+	text := []rune(s)
+	folded := make([]rune,len(text))
+
+	for i,r := range text {
+		folded[i] = fold(r)
 	}
 
-	var whole string
-	var n NodePtr
-	var retval []NodePtr
+	var marked = make([]bool,len(text))
 
-	for row.Next() {		
-		err = row.Scan(&whole)
-		fmt.Sscanf(whole,"(%d,%d)",&n.Class,&n.CPtr)
-		retval = append(retval,n)
+	for _,word := range strings.Fields(stripped) {
+
+		w := []rune(word)
+
+		for i := range w {
+			w[i] = fold(w[i])
+		}
+
+		for i := 0; i+len(w) <= len(folded); i++ {
+
+			if string(folded[i:i+len(w)]) == string(w) {
+				for j := i; j < i+len(w); j++ {
+					marked[j] = true
+				}
+			}
+		}
 	}
 
-	row.Close()
-	return retval
+	var hl strings.Builder
+
+	for i,r := range text {
+
+		if marked[i] && (i == 0 || !marked[i-1]) {
+			hl.WriteString(HIGHLIGHT_ON)
+		}
+
+		hl.WriteRune(r)
+
+		if marked[i] && (i == len(text)-1 || !marked[i+1]) {
+			hl.WriteString(HIGHLIGHT_OFF)
+		}
+	}
 
+	return hl.String()
 }
 
-// **************************************************************************
 
-func GetDBNodePtrMatching(ctx PoSST,nm,chap string,cn []string,arrow []ArrowPtr) []NodePtr {
+// **************************************************************************
 
-	// Match name, context, chapter
+func GetDBNodeByNodePtr(ctx PoSST,db_nptr NodePtr) Node {
 
-	var chap_col, nm_col string
-	var context string
+	n,err := TryGetDBNodeByNodePtr(ctx,db_nptr)
 
-	remove_name_accents,nm_stripped := IsBracketedSearchTerm(nm)
+	if err != nil {
+		fmt.Println("GetDBNodeByNodePtr Failed:",err)
 
-	if remove_name_accents {
-		nm_search := "%"+nm_stripped+"%"
-		nm_col = fmt.Sprintf("AND lower(unaccent(S)) LIKE lower('%s')",nm_search)
-	} else {
-		nm_search := "%"+nm+"%"
-		nm_col = fmt.Sprintf("AND lower(S) LIKE lower('%s')",nm_search)
+		if errors.Is(err,ErrTooManyMatches) {
+			os.Exit(-1)
+		}
 	}
 
-	if chap != "any" && chap != "" {
+	return n
+}
 
-		remove_chap_accents,chap_stripped := IsBracketedSearchTerm(chap)
+// **************************************************************************
 
-		if remove_chap_accents {
-			chap_search := "%"+chap_stripped+"%"
-			chap_col = fmt.Sprintf("AND lower(unaccent(chap)) LIKE lower('%s')",chap_search)
-		} else {
-			chap_search := "%"+chap+"%"
-			chap_col = fmt.Sprintf("AND lower(chap) LIKE lower('%s')",chap_search)
-		}
+func TryGetDBNodeByNodePtr(ctx PoSST,db_nptr NodePtr) (Node,error) {
+
+	if ctx.Store != nil {
+		return ctx.Store.GetNodeByNodePtr(db_nptr)
 	}
 
-	_,cn_stripped := IsBracketedSearchList(cn)
-	context = FormatSQLStringArray(cn_stripped)
+	nodes,err := TryGetDBNodesByNodePtrs(ctx,[]NodePtr{db_nptr})
 
-	arrows := FormatSQLIntArray(Arrow2Int(arrow))
+	n := nodes[db_nptr]
+	n.NPtr = db_nptr
+	return n,err
+}
 
-	qstr := fmt.Sprintf("WITH matching_nodes AS "+
-		"  (SELECT NFrom,ctx,match_context(ctx,%s) AS match,match_arrows(Arr,%s) AS matcha FROM NodeArrowNode)"+
-		"     SELECT DISTINCT nfrom FROM matching_nodes "+
-		"      JOIN Node ON nptr=nfrom WHERE match=true AND matcha=true %s %s",
-		context,arrows,nm_col,chap_col)
+// **************************************************************************
+
+func GetDBNodesByNodePtrs(ctx PoSST,nptrs []NodePtr) map[NodePtr]Node {
+
+	nodes,err := TryGetDBNodesByNodePtrs(ctx,nptrs)
 
-	row, err := ctx.DB.Query(qstr)
-	
 	if err != nil {
-		fmt.Println("QUERY GetNodePtrMatchingNCC Failed",err,qstr)
+		fmt.Println("GetDBNodesByNodePtrs Failed:",err)
 	}
 
-	var whole string
-	var n NodePtr
-	var retval []NodePtr
+	return nodes
+}
 
-	for row.Next() {		
-		err = row.Scan(&whole)
-		fmt.Sscanf(whole,"(%d,%d)",&n.Class,&n.CPtr)
-		retval = append(retval,n)
+// **************************************************************************
+
+func TryGetDBNodesByNodePtrs(ctx PoSST,nptrs []NodePtr) (map[NodePtr]Node,error) {
+
+	// Fetch a whole cone or path set in one round trip instead of one query
+	// per node. Pointers that don't exist are simply absent from the map
+
+	if ctx.Store != nil {
+		return ctx.Store.GetNodesByNodePtrs(nptrs)
 	}
 
-	row.Close()
-	return retval
+	nodes := make(map[NodePtr]Node)
+	asked := make(map[NodePtr]bool)
 
-}
+	var missing []NodePtr
 
-// **************************************************************************
+	for _,nptr := range nptrs {
 
-func GetDBNodeByNodePtr(ctx PoSST,db_nptr NodePtr) Node {
+		if asked[nptr] {
+			continue
+		}
+
+		asked[nptr] = true
 
-	im_nptr,cached := NODE_CACHE[db_nptr]
+		if n,cached := ctx.Cache.Get(nptr); cached {
+			nodes[nptr] = n
+		} else {
+			missing = append(missing,nptr)
+		}
+	}
 
-	if cached {
-		return GetNodeFromPtr(im_nptr)
+	if len(missing) == 0 {
+		return nodes,nil
 	}
 
 	// This ony works if we insert non-null arrays in initialization
 	cols := I_MEXPR+","+I_MCONT+","+I_MLEAD+","+I_NEAR +","+I_PLEAD+","+I_PCONT+","+I_PEXPR
-	qstr := fmt.Sprintf("select L,S,Chap,%s from Node where NPtr='(%d,%d)'::NodePtr",cols,db_nptr.Class,db_nptr.CPtr)
-
-	row, err := ctx.DB.Query(qstr)
+	qstr := fmt.Sprintf("SELECT NPtr,L,S,Chap,%s FROM Node WHERE NPtr = ANY($1::NodePtr[])",cols)
 
-	var n Node
-	var count int = 0
+	row, err := SQLConn(ctx).Query(qstr,SQLNodePtrArray(missing))
 
 	if err != nil {
-		fmt.Println("GetDBNodeByNodePointer Failed:",err)
-		return n
+		return nodes,fmt.Errorf("%w: QUERY GetNodesByNodePtrs Failed: %v",ErrDBQuery,err)
 	}
 
 	var whole [ST_TOP]string
+	var nptr string
 
 	// NB, there seems to be a bug in the SQL package, which cannot always populate the links, so try not to
 	//     rely on this and work around when needed using GetEntireCone(any,2..) separately
 
 	for row.Next() {
-		err = row.Scan(&n.L,&n.S,&n.Chap,&whole[0],&whole[1],&whole[2],&whole[3],&whole[4],&whole[5],&whole[6])
+
+		var n Node
+
+		err = row.Scan(&nptr,&n.L,&n.S,&n.Chap,&whole[0],&whole[1],&whole[2],&whole[3],&whole[4],&whole[5],&whole[6])
+
+		if err != nil {
+			row.Close()
+			return nodes,fmt.Errorf("%w: QUERY GetNodesByNodePtrs Failed: %v",ErrDBQuery,err)
+		}
+
+		fmt.Sscanf(nptr,"(%d,%d)",&n.NPtr.Class,&n.NPtr.CPtr)
 
 		for i := 0; i < ST_TOP; i++ {
 			n.I[i] = ParseLinkArray(whole[i])
 		}
-		count++
-	}
 
-	if count > 1 {
-		fmt.Println("GetDBNodeByNodePtr returned too many matches (multi-model conflict?):",count,"for ptr",db_nptr)
-		os.Exit(-1)
+		if _,dup := nodes[n.NPtr]; dup {
+			row.Close()
+			return nodes,fmt.Errorf("%w: more than one node for ptr %v",ErrTooManyMatches,n.NPtr)
+		}
+
+		nodes[n.NPtr] = n
 	}
 
 	row.Close()
 
-	if !cached {
-		CacheNode(n)
+	// Don't remember what we see inside a transaction, it might yet be rolled back
+
+	if ctx.Tx == nil {
+		for _,nptr := range missing {
+			if n,found := nodes[nptr]; found {
+				CacheNode(ctx,n)
+			}
+		}
 	}
 
-	n.NPtr = db_nptr
-	return n
+	return nodes,nil
+}
+
+// **************************************************************************
+
+func LinkPathNodePtrs(paths [][]Link) []NodePtr {
+
+	// The distinct nodes visited by a set of paths, for GetDBNodesByNodePtrs()
+
+	var nptrs []NodePtr
+	seen := make(map[NodePtr]bool)
+
+	for p := range paths {
+		for l := range paths[p] {
+			if !seen[paths[p][l].Dst] {
+				seen[paths[p][l].Dst] = true
+				nptrs = append(nptrs,paths[p][l].Dst)
+			}
+		}
+	}
+
+	return nptrs
 }
 
 // **************************************************************************
 
 func GetDBArrowsWithArrowName(ctx PoSST,s string) ArrowPtr {
 
+	arr,err := TryGetDBArrowsWithArrowName(ctx,s)
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	return arr
+}
+
+// **************************************************************************
+
+func TryGetDBArrowsWithArrowName(ctx PoSST,s string) (ArrowPtr,error) {
+
 	if ARROW_DIRECTORY_TOP == 0 {
-		DownloadArrowsFromDB(ctx)
+		err := TryDownloadArrowsFromDB(ctx)
+		if err != nil {
+			return -1,err
+		}
 	}
 
 	for a := range ARROW_DIRECTORY {
 		if s == ARROW_DIRECTORY[a].Long || s == ARROW_DIRECTORY[a].Short {
-			return ARROW_DIRECTORY[a].Ptr
+			return ARROW_DIRECTORY[a].Ptr,nil
 		}
 	}
 
-	fmt.Println("No such arrow found in database:",s)
-	os.Exit(-1)
-	return -1
+	return -1,fmt.Errorf("%w: (%s) not found in database",ErrNoSuchArrow,s)
 }
 
 // **************************************************************************
@@ -2719,28 +4754,46 @@ func GetDBArrowsMatchingArrowName(ctx PoSST,s string) []ArrowPtr {
 
 func GetDBNodeArrowNodeMatchingArrowPtrs(ctx PoSST,chap string,cn []string,arrows []ArrowPtr) []NodeArrowNode {
 
+	retval,err := TryGetDBNodeArrowNodeMatchingArrowPtrs(ctx,chap,cn,arrows)
+
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	return retval
+}
+
+// **************************************************************************
+
+func TryGetDBNodeArrowNodeMatchingArrowPtrs(ctx PoSST,chap string,cn []string,arrows []ArrowPtr) ([]NodeArrowNode,error) {
+
+	if ctx.Store != nil {
+		return ctx.Store.GetNodeArrowNodeMatchingArrowPtrs(chap,cn,arrows)
+	}
+
 	var intarrows []int
 
 	for i := range arrows {
 		intarrows = append(intarrows,int(arrows[i]))
 	}
 
-	qstr := fmt.Sprintf("SELECT NFrom,STType,Arr,Wgt,Ctx,NTo FROM NodeArrowNode where Arr=ANY(%s::int[])",FormatSQLIntArray(intarrows))
+	qstr := "SELECT NFrom,STType,Arr,Wgt,Ctx,NTo FROM NodeArrowNode where Arr=ANY($1::int[])"
+	args := []interface{}{SQLIntArray(intarrows)}
 
 	if cn != nil {
-		context := FormatSQLStringArray(cn)
 		chapter := "%"+chap+"%"
 		
-		qstr = fmt.Sprintf("WITH matching_rel AS "+
-			" (SELECT NFrom,STType,Arr,Wgt,Ctx,NTo,match_context(ctx,%s) AS match FROM NodeArrowNode)"+
+		qstr = "WITH matching_rel AS "+
+			" (SELECT NFrom,STType,Arr,Wgt,Ctx,NTo,match_context(ctx,$1::text[]) AS match FROM NodeArrowNode)"+
 			"   SELECT DISTINCT NFrom,STType,Arr,Wgt,Ctx,NTo FROM matching_rel "+
-			"    JOIN Node ON nptr=nfrom WHERE match=true AND lower(chap) LIKE lower('%s')",context,chapter)	
+			"    JOIN Node ON nptr=nfrom WHERE match=true AND lower(chap) LIKE lower($2)"
+		args = []interface{}{SQLStringArray(cn),chapter}
 	}
 
-	row, err := ctx.DB.Query(qstr)
+	row, err := ctx.DB.Query(qstr,args...)
 	
 	if err != nil {
-		fmt.Println("GetDBNodeArrowNodeMatchingArrowPtrs Failed:",err,qstr)
+		return nil,fmt.Errorf("%w: GetDBNodeArrowNodeMatchingArrowPtrs Failed: %v",ErrDBQuery,err)
 	}
 
 	var from_node string
@@ -2772,32 +4825,145 @@ func GetDBNodeArrowNodeMatchingArrowPtrs(ctx PoSST,chap string,cn []string,arrow
 
 	row.Close()
 
-	return nanlist
+	return nanlist,nil
+}
+
+// **************************************************************************
+
+func GetDBLinksMatching(ctx PoSST,chap string,cn []string,arrows []ArrowPtr) []NodeArrowNode {
+
+	links,err := TryGetDBLinksMatching(ctx,chap,cn,arrows)
+
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	return links
+}
+
+// **************************************************************************
+
+func TryGetDBLinksMatching(ctx PoSST,chap string,cn []string,arrows []ArrowPtr) ([]NodeArrowNode,error) {
+
+	// The links whose ends are both in the chapter, in the context and with
+	// one of the arrows, each filter ignored when empty (or "any")
+
+	if ctx.Store != nil {
+		return ctx.Store.GetLinksMatching(chap,cn,arrows)
+	}
+
+	var args []interface{}
+
+	qstr := "SELECT l.NFrom,l.STType,l.Arr,l.Wgt,l.Ctx,l.NTo FROM NodeArrowNode l"
+	where := " WHERE l.STType<>999"  // not the placeholders of nodes without links
+
+	if len(arrows) > 0 {
+
+		var intarrows []int
+
+		for i := range arrows {
+			intarrows = append(intarrows,int(arrows[i]))
+		}
+
+		where += " AND l.Arr=ANY("+SQLArg(&args,SQLIntArray(intarrows))+"::int[])"
+	}
+
+	if len(cn) > 0 {
+		where += " AND match_context(l.Ctx,"+SQLArg(&args,SQLStringArray(cn))+"::text[])"
+	}
+
+	if chap != "any" && chap != "" {
+
+		qstr += " JOIN Node a ON a.NPtr=l.NFrom JOIN Node b ON b.NPtr=l.NTo"
+
+		remove_accents,stripped := IsBracketedSearchTerm(chap)
+
+		if remove_accents {
+			like := "lower("+SQLArg(&args,"%"+stripped+"%")+")"
+			where += " AND lower(unaccent(a.Chap)) LIKE "+like+" AND lower(unaccent(b.Chap)) LIKE "+like
+		} else {
+			like := "lower("+SQLArg(&args,"%"+chap+"%")+")"
+			where += " AND lower(a.Chap) LIKE "+like+" AND lower(b.Chap) LIKE "+like
+		}
+	}
+
+	row,err := SQLConn(ctx).Query(qstr+where,args...)
+
+	if err != nil {
+		return nil,fmt.Errorf("%w: GetDBLinksMatching Failed: %v",ErrDBQuery,err)
+	}
+
+	var from_node,to_node,actx string
+	var st,arr int
+	var wgt float64
+	var nanlist []NodeArrowNode
+
+	for row.Next() {
+
+		var nan NodeArrowNode
+
+		err = row.Scan(&from_node,&st,&arr,&wgt,&actx,&to_node)
+
+		if err != nil {
+			row.Close()
+			return nil,fmt.Errorf("%w: GetDBLinksMatching Failed: %v",ErrDBQuery,err)
+		}
+
+		fmt.Sscanf(from_node,"(%d,%d)",&nan.NFrom.Class,&nan.NFrom.CPtr)
+		fmt.Sscanf(to_node,"(%d,%d)",&nan.NTo.Class,&nan.NTo.CPtr)
+
+		nan.STType = st
+		nan.Arr = ArrowPtr(arr)
+		nan.Wgt = wgt
+		nan.Ctx = ParseSQLArrayString(actx)
+
+		nanlist = append(nanlist,nan)
+	}
+
+	row.Close()
+
+	return nanlist,nil
 }
 
 // **************************************************************************
 
 func GetDBNodeContextsMatchingArrow(ctx PoSST,searchtext string,chap string,cn []string,arrow []ArrowPtr,page int) []QNodePtr {
+
+	retval,err := TryGetDBNodeContextsMatchingArrow(ctx,searchtext,chap,cn,arrow,page)
+
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	return retval
+}
+
+// **************************************************************************
+
+func TryGetDBNodeContextsMatchingArrow(ctx PoSST,searchtext string,chap string,cn []string,arrow []ArrowPtr,page int) ([]QNodePtr,error) {
+
+	if ctx.Store != nil {
+		return ctx.Store.GetNodeContextsMatchingArrow(searchtext,chap,cn,arrow,page)
+	}
+
 	var qstr string
 
-	context := FormatSQLStringArray(cn)
 	chapter := "%"+chap+"%"
-	arrows := FormatSQLIntArray(Arrow2Int(arrow))
 
 	const hits_per_page = 30
 	offset := (page-1) * hits_per_page;
 
 	// sufficient to search NFrom to get all nodes in context, as +/- relations complete
 	
-	qstr = fmt.Sprintf("WITH matching_nodes AS \n"+
-		" (SELECT DISTINCT NFrom,Arr,Ctx,match_context(Ctx,%s) AS matchc,match_arrows(Arr,%s) AS matcha FROM NodeArrowNode)\n"+
+	qstr = "WITH matching_nodes AS \n"+
+		" (SELECT DISTINCT NFrom,Arr,Ctx,match_context(Ctx,$1::text[]) AS matchc,match_arrows(Arr,$2::int[]) AS matcha FROM NodeArrowNode)\n"+
 		"   SELECT DISTINCT NFrom,Ctx,Chap FROM matching_nodes \n"+
-		"    JOIN Node ON nptr=nfrom WHERE matchc=true AND matcha=true AND lower(Chap) LIKE lower('%s') ORDER BY Ctx,NFrom DESC OFFSET %d LIMIT %d",context,arrows,chapter,offset,hits_per_page)
+		"    JOIN Node ON nptr=nfrom WHERE matchc=true AND matcha=true AND lower(Chap) LIKE lower($3) ORDER BY Ctx,NFrom DESC OFFSET $4 LIMIT $5"
 
-	row, err := ctx.DB.Query(qstr)
+	row, err := ctx.DB.Query(qstr,SQLStringArray(cn),SQLIntArray(Arrow2Int(arrow)),chapter,offset,hits_per_page)
 
 	if err != nil {
-		fmt.Println("GetDBNodeArrowNodeByContext Failed:",err,qstr)
+		return nil,fmt.Errorf("%w: GetDBNodeArrowNodeByContext Failed: %v",ErrDBQuery,err)
 	}
 
 	var return_value []QNodePtr
@@ -2827,29 +4993,52 @@ func GetDBNodeContextsMatchingArrow(ctx PoSST,searchtext string,chap string,cn [
 	}
 
 	row.Close()
-	return return_value
+	return return_value,nil
 }
 
 // **************************************************************************
 
 func GetNodesStartingStoriesForArrow(ctx PoSST,arrow string) []NodePtr {
 
+	retval,err := TryGetNodesStartingStoriesForArrow(ctx,arrow)
+
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	return retval
+}
+
+// **************************************************************************
+
+func TryGetNodesStartingStoriesForArrow(ctx PoSST,arrow string) ([]NodePtr,error) {
+
 	// Find the head / starting node matching an arrow sequence.
 	// It has outgoing (+sttype) but not incoming (-sttype) arrow
 
-	var matches []NodePtr
-
 	arrowptr := GetDBArrowsWithArrowName(ctx,arrow)
 
 	sttype := STIndexToSTType(ARROW_DIRECTORY[arrowptr].STAindex)
 
-	qstr := fmt.Sprintf("select GetStoryStartNodes(%d,%d,%d)",arrowptr,INVERSE_ARROWS[arrowptr],sttype)
+	return TryGetDBStoryStartNodes(ctx,arrowptr,INVERSE_ARROWS[arrowptr],sttype)
+}
+
+// **************************************************************************
+
+func TryGetDBStoryStartNodes(ctx PoSST,arrowptr,inverse ArrowPtr,sttype int) ([]NodePtr,error) {
+
+	if ctx.Store != nil {
+		return ctx.Store.GetStoryStartNodes(arrowptr,inverse,sttype)
+	}
+
+	var matches []NodePtr
+
+	qstr := "select GetStoryStartNodes($1,$2,$3)"
 		
-	row,err := ctx.DB.Query(qstr)
+	row,err := ctx.DB.Query(qstr,arrowptr,inverse,sttype)
 	
 	if err != nil {
-		fmt.Println("GetNodesStartingStoriesForArrow failed\n",qstr,err)
-		return nil
+		return nil,fmt.Errorf("%w: GetNodesStartingStoriesForArrow failed: %v",ErrDBQuery,err)
 	}
 	
 	var nptrstring string
@@ -2861,32 +5050,54 @@ func GetNodesStartingStoriesForArrow(ctx PoSST,arrow string) []NodePtr {
 	
 	row.Close()
 
-	return matches
+	return matches,nil
 }
 
 // **************************************************************************
 
 func GetNCCNodesStartingStoriesForArrow(ctx PoSST,arrow string,chapter string,context []string) []NodePtr {
 
+	retval,err := TryGetNCCNodesStartingStoriesForArrow(ctx,arrow,chapter,context)
+
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	return retval
+}
+
+// **************************************************************************
+
+func TryGetNCCNodesStartingStoriesForArrow(ctx PoSST,arrow string,chapter string,context []string) ([]NodePtr,error) {
+
 	// Filtered version of function
 	// Find the head / starting node matching an arrow sequence.
 	// It has outgoing (+sttype) but not incoming (-sttype) arrow
 
-	var matches []NodePtr
-
 	arrowptr := GetDBArrowsWithArrowName(ctx,arrow)
 
 	sttype := STIndexToSTType(ARROW_DIRECTORY[arrowptr].STAindex)
 
+	return TryGetDBNCCStoryStartNodes(ctx,arrowptr,INVERSE_ARROWS[arrowptr],sttype,chapter,context)
+}
+
+// **************************************************************************
+
+func TryGetDBNCCStoryStartNodes(ctx PoSST,arrowptr,inverse ArrowPtr,sttype int,chapter string,context []string) ([]NodePtr,error) {
+
+	if ctx.Store != nil {
+		return ctx.Store.GetNCCStoryStartNodes(arrowptr,inverse,sttype,chapter,context)
+	}
+
+	var matches []NodePtr
+
 	chp := "%"+chapter+"%"
-	cntx := FormatSQLStringArray(context)
 	
-	qstr := fmt.Sprintf("select GetNCCStoryStartNodes(%d,%d,%d,'%s',%s)",arrowptr,INVERSE_ARROWS[arrowptr],sttype,chp,cntx)
-	row,err := ctx.DB.Query(qstr)
+	qstr := "select GetNCCStoryStartNodes($1,$2,$3,$4,$5::text[])"
+	row,err := ctx.DB.Query(qstr,arrowptr,inverse,sttype,chp,SQLStringArray(context))
 	
 	if err != nil {
-		fmt.Println("GetNodesNCCStartingStoriesForArrow failed\n",qstr,err)
-		return nil
+		return nil,fmt.Errorf("%w: GetNodesNCCStartingStoriesForArrow failed: %v",ErrDBQuery,err)
 	}
 	
 	var nptrstring string
@@ -2898,15 +5109,31 @@ func GetNCCNodesStartingStoriesForArrow(ctx PoSST,arrow string,chapter string,co
 	}
 	
 	row.Close()
-	return matches
+	return matches,nil
+}
+
+// **************************************************************************
+
+func GetDBArrowByName(ctx PoSST,name string) ArrowPtr {
+
+	ptr,err := TryGetDBArrowByName(ctx,name)
+
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	return ptr
 }
 
 // **************************************************************************
 
-func GetDBArrowByName(ctx PoSST,name string) ArrowPtr {
+func TryGetDBArrowByName(ctx PoSST,name string) (ArrowPtr,error) {
 
 	if ARROW_DIRECTORY_TOP == 0 {
-		DownloadArrowsFromDB(ctx)
+		err := TryDownloadArrowsFromDB(ctx)
+		if err != nil {
+			return 0,err
+		}
 	}
 
 	ptr, ok := ARROW_SHORT_DIR[name]
@@ -2917,145 +5144,306 @@ func GetDBArrowByName(ctx PoSST,name string) ArrowPtr {
 		ptr, ok = ARROW_LONG_DIR[name]
 		
 		if !ok {
-			ptr, ok = ARROW_SHORT_DIR[name]
-			
-			// If not, then check longname
-			
-			if !ok {
-				ptr, ok = ARROW_LONG_DIR[name]
-				fmt.Println(ERR_NO_SUCH_ARROW,"("+name+") - no arrows defined in database yet?")
-			}
+			return ptr,fmt.Errorf("%w: (%s) - no arrows defined in database yet?",ErrNoSuchArrow,name)
 		}
 	}
 
-	return ptr
+	return ptr,nil
 }
 
 // **************************************************************************
 
 func GetDBArrowByPtr(ctx PoSST,arrowptr ArrowPtr) ArrowDirectory {
 
-	if ARROW_DIRECTORY_TOP > 0 {
-		a := ARROW_DIRECTORY[arrowptr]
-		return a
+	a,err := TryGetDBArrowByPtr(ctx,arrowptr)
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
 	}
 
-	DownloadArrowsFromDB(ctx)
+	return a
+}
+
+// **************************************************************************
+
+func TryGetDBArrowByPtr(ctx PoSST,arrowptr ArrowPtr) (ArrowDirectory,error) {
 
-	if len(ARROW_DIRECTORY) < int(arrowptr) {
-		fmt.Println(ERR_NO_SUCH_ARROW,"(",arrowptr,")")
-		os.Exit(-1)
+	var none ArrowDirectory
+
+	if ARROW_DIRECTORY_TOP == 0 {
+		err := TryDownloadArrowsFromDB(ctx)
+		if err != nil {
+			return none,err
+		}
 	}
 
-	return ARROW_DIRECTORY[arrowptr]
+	if arrowptr < 0 || int(arrowptr) >= len(ARROW_DIRECTORY) {
+		return none,fmt.Errorf("%w: (%d)",ErrNoSuchArrow,arrowptr)
+	}
 
+	return ARROW_DIRECTORY[arrowptr],nil
 }
 
 // **************************************************************************
 
 func GetDBPageMap(ctx PoSST,chap string,cn []string,page int) []PageMap {
 
+	retval,err := TryGetDBPageMap(ctx,chap,cn,page)
+
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	return retval
+}
+
+// **************************************************************************
+
+func TryGetDBPageMap(ctx PoSST,chap string,cn []string,page int) ([]PageMap,error) {
+
+	if ctx.Store != nil {
+		return ctx.Store.GetPageMap(chap,cn,page)
+	}
+
 	var qstr string
 
-	context := FormatSQLStringArray(cn)
 	chapter := "%"+chap+"%"
 
 	const hits_per_page = 30
 	offset := (page-1) * hits_per_page;
 
-	qstr = fmt.Sprintf("SELECT DISTINCT Chap,Ctx,Line,Path FROM PageMap\n"+
-		"WHERE match_context(Ctx,%s)=true AND lower(Chap) LIKE lower('%s') ORDER BY Line OFFSET %d LIMIT %d",
-		context,chapter,offset,hits_per_page)
+	qstr = "SELECT DISTINCT Chap,Ctx,Line,Path,COALESCE(File,'') FROM PageMap\n"+
+		"WHERE match_context(Ctx,$1::text[])=true AND lower(Chap) LIKE lower($2) ORDER BY Line OFFSET $3 LIMIT $4"
 
-	row, err := ctx.DB.Query(qstr)
+	row, err := ctx.DB.Query(qstr,SQLStringArray(cn),chapter,offset,hits_per_page)
 
 	if err != nil {
-		fmt.Println("GetDBPageMap Failed:",err,qstr)
+		return nil,fmt.Errorf("%w: GetDBPageMap Failed: %v",ErrDBQuery,err)
 	}
 
+	var context string
 	var path string
 	var pagemap []PageMap
 	var line int
 	for row.Next() {		
 
 		var event PageMap
-		err = row.Scan(&chap,&context,&line,&path)
+		err = row.Scan(&chap,&context,&line,&path,&event.File)
 
 		if err != nil {
-			fmt.Println("Error reading GetDBPageMap",err)
+			return nil,fmt.Errorf("%w: Error reading GetDBPageMap: %v",ErrDBQuery,err)
 		}
 
 		event.Path = ParseMapLinkArray(path)
 
 		event.Chapter = chap
 		event.Context = ParseSQLArrayString(context)
+		event.Line = line
 		pagemap = append(pagemap,event)
 	}
 
 	row.Close()
-	return pagemap
+	return pagemap,nil
 }
 
 // **************************************************************************
 // Retrieval
 // **************************************************************************
 
-func CacheNode(n Node) {
+type NodeCache struct {  // read-through cache for GetDBNodesByNodePtrs()
+
+	mu      sync.Mutex
+	nodes   map[NodePtr]Node
+	started time.Time
+}
+
+// Long running programs, like the web server, should eventually see
+// changes that other programs upload, so the cache is emptied when it
+// gets old or large. Changes made through the same PoSST empty it at once
+
+var NODE_CACHE_LIFETIME = time.Minute
+var NODE_CACHE_LIMIT = 100000
+
+// **************************************************************************
+
+func NewNodeCache() *NodeCache {
+
+	var c NodeCache
+	c.nodes = make(map[NodePtr]Node)
+	c.started = time.Now()
+	return &c
+}
+
+// **************************************************************************
+
+func (c *NodeCache) Get(nptr NodePtr) (Node,bool) {
+
+	if c == nil {
+		return Node{},false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.started) > NODE_CACHE_LIFETIME {
+		c.reset()
+	}
+
+	n,cached := c.nodes[nptr]
+	return n,cached
+}
+
+// **************************************************************************
+
+func (c *NodeCache) Put(n Node) {
+
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.nodes) >= NODE_CACHE_LIMIT {
+		c.reset()
+	}
+
+	c.nodes[n.NPtr] = n
+}
+
+// **************************************************************************
+
+func (c *NodeCache) Forget() {
+
+	// Call after any change to nodes or their links
+
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reset()
+}
+
+// **************************************************************************
+
+func (c *NodeCache) reset() {
+
+	if len(c.nodes) > 0 {
+		c.nodes = make(map[NodePtr]Node)
+	}
+
+	c.started = time.Now()
+}
+
+// **************************************************************************
+
+func CacheNode(ctx PoSST,n Node) {
 
-	NODE_CACHE[n.NPtr] = AppendTextToDirectory(n,RunErr)
+	ctx.Cache.Put(n)
 }
 
 // **************************************************************************
 
 func DownloadArrowsFromDB(ctx PoSST) {
 
+	err := TryDownloadArrowsFromDB(ctx)
+
+	if err != nil {
+		fmt.Println(err)
+
+		if errors.Is(err,ErrArrowMismatch) {
+			os.Exit(-1)
+		}
+	}
+}
+
+// **************************************************************************
+
+func TryDownloadArrowsFromDB(ctx PoSST) error {
+
+	if ctx.Store != nil {
+		return ctx.Store.DownloadArrows()
+	}
+
+	arrows,inverses,err := TryGetDBArrowDirectory(ctx)
+
+	if err != nil {
+		return err
+	}
+
 	// These must be ordered to match in-memory array
 
-	qstr := fmt.Sprintf("SELECT STAindex,Long,Short,ArrPtr FROM ArrowDirectory ORDER BY ArrPtr")
+	for a := range arrows {
+
+		ad := arrows[a]
 
-	row, err := ctx.DB.Query(qstr)
+		ARROW_DIRECTORY = append(ARROW_DIRECTORY,ad)
+		ARROW_SHORT_DIR[ad.Short] = ARROW_DIRECTORY_TOP
+		ARROW_LONG_DIR[ad.Long] = ARROW_DIRECTORY_TOP
+
+		if ad.Ptr != ARROW_DIRECTORY_TOP {
+			return fmt.Errorf("%w: %v %d %d",ErrArrowMismatch,ad,ad.Ptr,ARROW_DIRECTORY_TOP)
+		}
+
+		ARROW_DIRECTORY_TOP++
+	}
+
+	for plus,minus := range inverses {
+		INVERSE_ARROWS[plus] = minus
+	}
+
+	return nil
+}
+
+// **************************************************************************
+
+func TryGetDBArrowDirectory(ctx PoSST) ([]ArrowDirectory,map[ArrowPtr]ArrowPtr,error) {
+
+	// The stored arrows in ArrPtr order, and their inverses
+
+	if ctx.Store != nil {
+		return ctx.Store.GetArrowDirectory()
+	}
+
+	qstr := "SELECT STAindex,Long,Short,ArrPtr FROM ArrowDirectory ORDER BY ArrPtr"
+
+	row, err := SQLConn(ctx).Query(qstr)
 	
 	if err != nil {
-		fmt.Println("QUERY Download Arrows Failed",err)
+		return nil,nil,fmt.Errorf("%w: QUERY Download Arrows Failed: %v",ErrDBQuery,err)
 	}
 
-	var staidx int
-	var long string
-	var short string
-	var ptr ArrowPtr
+	var arrows []ArrowDirectory
 	var ad ArrowDirectory
 
 	for row.Next() {		
-		err = row.Scan(&staidx,&long,&short,&ptr)
-		ad.STAindex = staidx
-		ad.Long = long
-		ad.Short = short
-		ad.Ptr = ptr
 
-		ARROW_DIRECTORY = append(ARROW_DIRECTORY,ad)
-		ARROW_SHORT_DIR[short] = ARROW_DIRECTORY_TOP
-		ARROW_LONG_DIR[long] = ARROW_DIRECTORY_TOP
+		err = row.Scan(&ad.STAindex,&ad.Long,&ad.Short,&ad.Ptr)
 
-		if ad.Ptr != ARROW_DIRECTORY_TOP {
-			fmt.Println(ERR_MEMORY_DB_ARROW_MISMATCH,ad,ad.Ptr,ARROW_DIRECTORY_TOP)
-			os.Exit(-1)
+		if err != nil {
+			row.Close()
+			return nil,nil,fmt.Errorf("%w: QUERY Download Arrows Failed: %v",ErrDBQuery,err)
 		}
 
-		ARROW_DIRECTORY_TOP++
+		arrows = append(arrows,ad)
 	}
 
 	row.Close()
 
 	// Get Inverses
 
-	qstr = fmt.Sprintf("SELECT Plus,Minus FROM ArrowInverses ORDER BY Plus")
+	qstr = "SELECT Plus,Minus FROM ArrowInverses ORDER BY Plus"
 
-	row, err = ctx.DB.Query(qstr)
+	row, err = SQLConn(ctx).Query(qstr)
 	
 	if err != nil {
-		fmt.Println("QUERY Download Inverses Failed",err)
+		return nil,nil,fmt.Errorf("%w: QUERY Download Inverses Failed: %v",ErrDBQuery,err)
 	}
 
+	var inverses = make(map[ArrowPtr]ArrowPtr)
 	var plus,minus ArrowPtr
 
 	for row.Next() {		
@@ -3063,23 +5451,44 @@ func DownloadArrowsFromDB(ctx PoSST) {
 		err = row.Scan(&plus,&minus)
 
 		if err != nil {
-			fmt.Println("QUERY Download Arrows Failed",err)
+			row.Close()
+			return nil,nil,fmt.Errorf("%w: QUERY Download Inverses Failed: %v",ErrDBQuery,err)
 		}
 
-		INVERSE_ARROWS[plus] = minus
+		inverses[plus] = minus
 	}
+
+	row.Close()
+	return arrows,inverses,nil
 }
 
 // **************************************************************************
 
 func GetFwdConeAsNodes(ctx PoSST, start NodePtr, sttype,depth int) []NodePtr {
 
-	qstr := fmt.Sprintf("select unnest(fwdconeasnodes) from FwdConeAsNodes('(%d,%d)',%d,%d);",start.Class,start.CPtr,sttype,depth)
+	retval,err := TryGetFwdConeAsNodes(ctx,start,sttype,depth)
 
-	row, err := ctx.DB.Query(qstr)
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	return retval
+}
+
+// **************************************************************************
+
+func TryGetFwdConeAsNodes(ctx PoSST, start NodePtr, sttype,depth int) ([]NodePtr,error) {
+
+	if ctx.Store != nil {
+		return ctx.Store.GetFwdConeAsNodes(start,sttype,depth)
+	}
+
+	qstr := "select unnest(fwdconeasnodes) from FwdConeAsNodes(($1::int,$2::int)::NodePtr,$3,$4);"
+
+	row, err := ctx.DB.Query(qstr,start.Class,start.CPtr,sttype,depth)
 	
 	if err != nil {
-		fmt.Println("QUERY to FwdConeAsNodes Failed",err)
+		return nil,fmt.Errorf("%w: QUERY to FwdConeAsNodes Failed: %v",ErrDBQuery,err)
 	}
 
 	var whole string
@@ -3093,21 +5502,38 @@ func GetFwdConeAsNodes(ctx PoSST, start NodePtr, sttype,depth int) []NodePtr {
 	}
 
 	row.Close()
-	return retval
+	return retval,nil
 }
 
 // **************************************************************************
 
 func GetFwdConeAsLinks(ctx PoSST, start NodePtr, sttype,depth int) []Link {
 
+	retval,err := TryGetFwdConeAsLinks(ctx,start,sttype,depth)
+
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	return retval
+}
+
+// **************************************************************************
+
+func TryGetFwdConeAsLinks(ctx PoSST, start NodePtr, sttype,depth int) ([]Link,error) {
+
+	if ctx.Store != nil {
+		return ctx.Store.GetFwdConeAsLinks(start,sttype,depth)
+	}
+
 	// This function may be misleading as it doesn't respect paths
 
-	qstr := fmt.Sprintf("select unnest(fwdconeaslinks) from FwdConeAsLinks('(%d,%d)',%d,%d);",start.Class,start.CPtr,sttype,depth)
+	qstr := "select unnest(fwdconeaslinks) from FwdConeAsLinks(($1::int,$2::int)::NodePtr,$3,$4);"
 
-	row, err := ctx.DB.Query(qstr)
+	row, err := ctx.DB.Query(qstr,start.Class,start.CPtr,sttype,depth)
 	
 	if err != nil {
-		fmt.Println("QUERY to FwdConeAsLinks Failed",err)
+		return nil,fmt.Errorf("%w: QUERY to FwdConeAsLinks Failed: %v",ErrDBQuery,err)
 	}
 
 	var whole string
@@ -3121,117 +5547,120 @@ func GetFwdConeAsLinks(ctx PoSST, start NodePtr, sttype,depth int) []Link {
 
 	row.Close()
 
-	return retval
+	return retval,nil
 }
 
 // **************************************************************************
 
 func GetFwdPathsAsLinks(ctx PoSST, start NodePtr, sttype,depth int) ([][]Link,int) {
 
-	qstr := fmt.Sprintf("select FwdPathsAsLinks from FwdPathsAsLinks('(%d,%d)',%d,%d);",start.Class,start.CPtr,sttype,depth)
+	r0,r1,err := TryGetFwdPathsAsLinks(ctx,start,sttype,depth)
 
-	row, err := ctx.DB.Query(qstr)
-	
 	if err != nil {
-		fmt.Println("QUERY to FwdPathsAsLinks Failed",err)
+		fmt.Println(err)
 	}
 
-	var whole string
-	var retval [][]Link
+	return r0,r1
+}
 
-	for row.Next() {		
-		err = row.Scan(&whole)
-		retval = ParseLinkPath(whole)
+// **************************************************************************
+
+func TryGetFwdPathsAsLinks(ctx PoSST, start NodePtr, sttype,depth int) ([][]Link,int,error) {
+
+	if ctx.Store != nil {
+		return ctx.Store.GetFwdPathsAsLinks(start,sttype,depth)
 	}
 
-	row.Close()
-	return retval,len(retval)
+	retval,err := ExpandPaths(DBNodeFetcher(ctx),[]NodePtr{start},[]int{sttype},depth,"",nil)
+
+	return retval,len(retval),err
 }
 
 // **************************************************************************
 
 func GetEntireConePathsAsLinks(ctx PoSST,orientation string,start NodePtr,depth int) ([][]Link,int) {
 
-	// orientation should be "fwd" or "bwd" else "both"
-
-	qstr := fmt.Sprintf("select AllPathsAsLinks from AllPathsAsLinks('(%d,%d)','%s',%d);",
-		start.Class,start.CPtr,orientation,depth)
-
-	row, err := ctx.DB.Query(qstr)
+	r0,r1,err := TryGetEntireConePathsAsLinks(ctx,orientation,start,depth)
 
 	if err != nil {
-		fmt.Println("QUERY to AllPathsAsLinks Failed",err,qstr)
+		fmt.Println(err)
 	}
 
-	var whole string
-	var retval [][]Link
+	return r0,r1
+}
 
-	for row.Next() {		
-		err = row.Scan(&whole)
-		retval = ParseLinkPath(whole)
+// **************************************************************************
+
+func TryGetEntireConePathsAsLinks(ctx PoSST,orientation string,start NodePtr,depth int) ([][]Link,int,error) {
+
+	if ctx.Store != nil {
+		return ctx.Store.GetEntireConePathsAsLinks(orientation,start,depth)
 	}
 
-	row.Close()
+	// orientation should be "fwd" or "bwd" else "both"
 
-	sort.Slice(retval, func(i,j int) bool {
-		return len(retval[i]) < len(retval[j])
-	})
+	retval,err := ExpandPaths(DBNodeFetcher(ctx),[]NodePtr{start},ConeSTTypes(orientation),depth,"",nil)
+
+	SortPathsByLength(retval)
 
-	return retval,len(retval)
+	return retval,len(retval),err
 }
 
 // **************************************************************************
 
 func GetEntireNCConePathsAsLinks(ctx PoSST,orientation string,start NodePtr,depth int,chapter string,context []string) ([][]Link,int) {
 
-	// orientation should be "fwd" or "bwd" else "both"
-
-	qstr := fmt.Sprintf("select AllNCPathsAsLinks from AllNCPathsAsLinks('(%d,%d)','%s',%s,'%s',%d);",
-		start.Class,start.CPtr,chapter,FormatSQLStringArray(context),orientation,depth)
-
-	row, err := ctx.DB.Query(qstr)
+	r0,r1,err := TryGetEntireNCConePathsAsLinks(ctx,orientation,start,depth,chapter,context)
 
 	if err != nil {
-		fmt.Println("QUERY to AllNCPathsAsLinks Failed",err,qstr)
+		fmt.Println(err)
 	}
 
-	var whole string
-	var retval [][]Link
+	return r0,r1
+}
 
-	for row.Next() {		
-		err = row.Scan(&whole)
-		retval = ParseLinkPath(whole)
+// **************************************************************************
+
+func TryGetEntireNCConePathsAsLinks(ctx PoSST,orientation string,start NodePtr,depth int,chapter string,context []string) ([][]Link,int,error) {
+
+	if ctx.Store != nil {
+		return ctx.Store.GetEntireNCConePathsAsLinks(orientation,start,depth,chapter,context)
 	}
 
-	row.Close()
-	return retval,len(retval)
+	// orientation should be "fwd" or "bwd" else "both"
+
+	retval,err := ExpandPaths(DBNodeFetcher(ctx),[]NodePtr{start},NCConeSTTypes(orientation),depth,chapter,context)
+
+	return retval,len(retval),err
 }
 
 // **************************************************************************
 
 func GetEntireNCSuperConePathsAsLinks(ctx PoSST,orientation string,start []NodePtr,depth int,chapter string,context []string) ([][]Link,int) {
-	// orientation should be "fwd" or "bwd" else "both"
 
-	qstr := fmt.Sprintf("select AllSuperNCPathsAsLinks(%s,'%s',%s,'%s',%d);",FormatSQLNodePtrArray(start),
-		chapter,FormatSQLStringArray(context),orientation,depth)
-
-	row, err := ctx.DB.Query(qstr)
+	r0,r1,err := TryGetEntireNCSuperConePathsAsLinks(ctx,orientation,start,depth,chapter,context)
 
 	if err != nil {
-		fmt.Println("QUERY to AllSuperNCPathsAsLinks Failed",err,qstr)
+		fmt.Println(err)
 		os.Exit(-1)
 	}
 
-	var whole string
-	var retval [][]Link
+	return r0,r1
+}
 
-	for row.Next() {		
-		err = row.Scan(&whole)
-		retval = ParseLinkPath(whole)
+// **************************************************************************
+
+func TryGetEntireNCSuperConePathsAsLinks(ctx PoSST,orientation string,start []NodePtr,depth int,chapter string,context []string) ([][]Link,int,error) {
+
+	if ctx.Store != nil {
+		return ctx.Store.GetEntireNCSuperConePathsAsLinks(orientation,start,depth,chapter,context)
 	}
 
-	row.Close()
-	return retval,len(retval)
+	// orientation should be "fwd" or "bwd" else "both"
+
+	retval,err := ExpandPaths(DBNodeFetcher(ctx),start,NCConeSTTypes(orientation),depth,chapter,context)
+
+	return retval,len(retval),err
 }
 
 // **************************************************************************
@@ -3293,7 +5722,7 @@ func GetPathTransverseSuperNodes(ctx PoSST,solutions [][]Link,maxdepth int) [][]
 
 			for p_j := p_i+1; p_j < len(solutions); p_j++ {
 
-				if depth < 1 || depth > len(solutions[p_j])-2 {
+				if depth < 1 || depth > len(solutions[p_i])-2 || depth > len(solutions[p_j])-2 {
 					break
 				}
 
@@ -3547,6 +5976,8 @@ func GetNodeOrbit(ctx PoSST,nptr NodePtr,exclude_vector string) [ST_TOP][]Orbit
 
 	sweep,_ := GetEntireConePathsAsLinks(ctx,"any",nptr,probe_radius)
 
+	nodes := GetDBNodesByNodePtrs(ctx,LinkPathNodePtrs(sweep))
+
 	var notes [ST_TOP][]Orbit
 
 	// Organize by the leading nearest-neighbour by vector/link type
@@ -3567,7 +5998,7 @@ func GetNodeOrbit(ctx PoSST,nptr NodePtr,exclude_vector string) [ST_TOP][]Orbit
 				arrow := GetDBArrowByPtr(ctx,start.Arr)
 
 				if arrow.STAindex == stindex {
-					txt := GetDBNodeByNodePtr(ctx,start.Dst)
+					txt := nodes[start.Dst]
 					var nt Orbit
 					nt.Arrow = arrow.Long
                                         nt.STindex = arrow.STAindex
@@ -3587,7 +6018,7 @@ func GetNodeOrbit(ctx PoSST,nptr NodePtr,exclude_vector string) [ST_TOP][]Orbit
 						arprev := STIndexToSTType(arrow.STAindex)
 						next := sweep[angle][depth]
 						arrow = GetDBArrowByPtr(ctx,next.Arr)
-						subtxt := GetDBNodeByNodePtr(ctx,next.Dst)
+						subtxt := nodes[next.Dst]
 
 						if arrow.Long == exclude_vector || arrow.Short == exclude_vector {
 							break
@@ -3820,7 +6251,8 @@ func PrintLinkPath(ctx PoSST, cone [][]Link, p int, prefix string,chapter string
 
 	if len(cone[p]) > 1 {
 
-		path_start := GetDBNodeByNodePtr(ctx,cone[p][0].Dst)		
+		nodes := GetDBNodesByNodePtrs(ctx,LinkPathNodePtrs(cone[p:p+1]))
+		path_start := nodes[cone[p][0].Dst]		
 		
 		start_shown := false
 
@@ -3844,7 +6276,7 @@ func PrintLinkPath(ctx PoSST, cone [][]Link, p int, prefix string,chapter string
 				start_shown = true
 			}
 
-			nextnode := GetDBNodeByNodePtr(ctx,cone[p][l].Dst)
+			nextnode := nodes[cone[p][l].Dst]
 
 			if !SimilarString(nextnode.Chap,chapter) {
 				break
@@ -3876,7 +6308,7 @@ func PrintLinkPath(ctx PoSST, cone [][]Link, p int, prefix string,chapter string
 		for s := range stpath {
 			fmt.Print(" -(",stpath[s],")-> ")
 		}
-		fmt.Println(". \n")
+		fmt.Print(". \n\n")
 	}
 }
 
@@ -3906,9 +6338,11 @@ func JSONCone(ctx PoSST, cone [][]Link,chapter string,context []string) string {
 
 	var jstr string = "["
 
+	nodes := GetDBNodesByNodePtrs(ctx,LinkPathNodePtrs(cone))
+
 	for p := 0; p < len(cone); p++ {
 
-		path_start := GetDBNodeByNodePtr(ctx,cone[p][0].Dst)		
+		path_start := nodes[cone[p][0].Dst]		
 		
 		start_shown := false
 
@@ -3920,7 +6354,7 @@ func JSONCone(ctx PoSST, cone [][]Link,chapter string,context []string) string {
 				return "[]"
 			}
 
-			nextnode := GetDBNodeByNodePtr(ctx,cone[p][l].Dst)
+			nextnode := nodes[cone[p][l].Dst]
 
 			if !SimilarString(nextnode.Chap,chapter) {
 				break
@@ -3951,51 +6385,108 @@ func JSONCone(ctx PoSST, cone [][]Link,chapter string,context []string) string {
 
 		}
 
-		encoded, _ := json.Marshal(path)
-		jstr += fmt.Sprintf("%s",string(encoded))
+		encoded, _ := json.Marshal(path)
+		jstr += fmt.Sprintf("%s",string(encoded))
+
+		if p < len(cone)-1 {
+			jstr += ",\n"
+		}
+	}
+
+	jstr += "]"
+
+	return jstr
+}
+
+// **************************************************************************
+
+func JSON_TableOfContents(ctx PoSST,chap string,cn []string) string {
+
+	toc,err := TryGetDBTableOfContents(ctx,chap,cn)
+
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	// JSON
+
+	json_toc := "{ \"TOC\": ["
+	var order []string
+
+	for keys := range toc {
+		order = append(order,keys)
+	}
+
+	sort.Strings(order)
+
+	for key := 0; key < len(order); key++ {
+		json_toc += fmt.Sprintf("{\n\"Chapter\": \"%s\",\n",order[key])
+
+		var idemp = make(map[string]bool)
+		var list []string
+
+		for s := range toc[order[key]] {
+			idemp[toc[order[key]][s]] = true
+		}
+		
+		for vals := range idemp {
+			list = append(list,vals)
+		}
+
+		sort.Strings(list)
+		arr,_ := json.Marshal(list)
 
-		if p < len(cone)-1 {
-			jstr += ",\n"
+		json_toc += fmt.Sprintf("\"Contexts\": %s\n",string(arr))
+		json_toc += "}"
+		if key != len(order)-1 {
+			json_toc += ",\n"
 		}
 	}
 
-	jstr += "]"
-
-	return jstr
+	json_toc += " ]}"
+	
+	return json_toc
 }
 
 // **************************************************************************
 
-func JSON_TableOfContents(ctx PoSST,chap string,cn []string) string {
+func TryGetDBTableOfContents(ctx PoSST,chap string,cn []string) (map[string][]string,error) {
+
+	// Map of chapter name to the (unsorted, repeated) context strings used in it
+
+	if ctx.Store != nil {
+		return ctx.Store.GetTableOfContents(chap,cn)
+	}
 
 	chap_col := ""
 
+	_,cn_stripped := IsBracketedSearchList(cn)
+
+	args := []interface{}{SQLStringArray(cn_stripped)}
+
 	if chap != "any" && chap != "" {
 
 		remove_chap_accents,chap_stripped := IsBracketedSearchTerm(chap)
 
 		if remove_chap_accents {
 			chap_search := "%"+chap_stripped+"%"
-			chap_col = fmt.Sprintf("AND lower(unaccent(chap)) LIKE lower('%s')",chap_search)
+			chap_col = "AND lower(unaccent(chap)) LIKE lower("+SQLArg(&args,chap_search)+")"
 		} else {
 			chap_search := "%"+chap+"%"
-			chap_col = fmt.Sprintf("AND lower(chap) LIKE lower('%s')",chap_search)
+			chap_col = "AND lower(chap) LIKE lower("+SQLArg(&args,chap_search)+")"
 		}
 	}
 
-	_,cn_stripped := IsBracketedSearchList(cn)
-	context := FormatSQLStringArray(cn_stripped)
-
 	qstr := fmt.Sprintf("WITH matching_nodes AS "+
-		"  (SELECT NFrom,ctx,match_context(ctx,%s) AS match FROM NodeArrowNode)"+
+		"  (SELECT NFrom,ctx,match_context(ctx,$1::text[]) AS match FROM NodeArrowNode)"+
 		"     SELECT DISTINCT chap,ctx FROM matching_nodes "+
 		"      JOIN Node ON nptr=nfrom WHERE match=true %s",
-		context,chap_col)
-fmt.Println(qstr)
-	row, err := ctx.DB.Query(qstr)
+		chap_col)
+
+	row, err := ctx.DB.Query(qstr,args...)
 	
 	if err != nil {
-		fmt.Println("QUERY TableOfContents Failed",err,qstr)
+		return nil,fmt.Errorf("%w: QUERY TableOfContents Failed: %v\n%s",ErrDBQuery,err,qstr)
 	}
 
 	var rchap,rcontext string
@@ -4015,45 +6506,8 @@ fmt.Println(qstr)
 		}
 	}
 
-	// JSON
-
-	json_toc := "{ \"TOC\": ["
-	var order []string
-
-	for keys := range toc {
-		order = append(order,keys)
-	}
-
-	sort.Strings(order)
-
-	for key := 0; key < len(order); key++ {
-		json_toc += fmt.Sprintf("{\n\"Chapter\": \"%s\",\n",order[key])
-
-		var idemp = make(map[string]bool)
-		var list []string
-
-		for s := range toc[order[key]] {
-			idemp[toc[order[key]][s]] = true
-		}
-		
-		for vals := range idemp {
-			list = append(list,vals)
-		}
-
-		sort.Strings(list)
-		arr,_ := json.Marshal(list)
-
-		json_toc += fmt.Sprintf("\"Contexts\": %s\n",string(arr))
-		json_toc += "}"
-		if key != len(order)-1 {
-			json_toc += ",\n"
-		}
-	}
-
-	json_toc += " ]}"
-	
 	row.Close()
-	return json_toc
+	return toc,nil
 }
 
 // **************************************************************************
@@ -4116,6 +6570,23 @@ func JSONPage(ctx PoSST, maplines []PageMap) string {
 
 func GetAppointmentArrayByArrow(ctx PoSST, context []string,chapter string) map[ArrowPtr][]NodePtr {
 
+	retval,err := TryGetAppointmentArrayByArrow(ctx,context,chapter)
+
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	return retval
+}
+
+// **************************************************************************
+
+func TryGetAppointmentArrayByArrow(ctx PoSST, context []string,chapter string) (map[ArrowPtr][]NodePtr,error) {
+
+	if ctx.Store != nil {
+		return ctx.Store.GetAppointmentArrayByArrow(context,chapter)
+	}
+
           /* arr |             x             
             -----+---------------------------
               18 | {"(2,4)","(3,4)","(4,4)"}
@@ -4130,16 +6601,18 @@ func GetAppointmentArrayByArrow(ctx PoSST, context []string,chapter string) map[
 
 	qstr := "SELECT arr,array_agg(DISTINCT NTo) FROM NodeArrowNode"
 
+	var args []interface{}
+
 	if context != nil {
-		qstr += fmt.Sprintf(" WHERE match_context(ctx,%s)",FormatSQLStringArray(context))
+		qstr += " WHERE match_context(ctx,"+SQLArg(&args,SQLStringArray(context))+"::text[])"
 	}
 
 	qstr += " GROUP BY arr"
 
-	row, err := ctx.DB.Query(qstr)
+	row, err := ctx.DB.Query(qstr,args...)
 	
 	if err != nil {
-		fmt.Println("QUERY GetAppointmentArrayByArrow Failed",err,qstr)
+		return nil,fmt.Errorf("%w: QUERY GetAppointmentArrayByArrow Failed: %v\n%s",ErrDBQuery,err,qstr)
 	}
 
 	var arry string
@@ -4153,13 +6626,29 @@ func GetAppointmentArrayByArrow(ctx PoSST, context []string,chapter string) map[
 
 	row.Close()
 	
-	return retval
+	return retval,nil
 }
 
 // **************************************************************************
 
 func GetAppointmentArrayBySSType(ctx PoSST) map[int][]NodePtr {
 
+	retval,err := TryGetAppointmentArrayBySSType(ctx)
+
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	return retval
+}
+
+// **************************************************************************
+
+func TryGetAppointmentArrayBySSType(ctx PoSST) (map[int][]NodePtr,error) {
+
+	if ctx.Store != nil {
+		return ctx.Store.GetAppointmentArrayBySSType()
+	}
 
           /* sttype |             array_agg             
              --------+-----------------------------------
@@ -4176,7 +6665,7 @@ func GetAppointmentArrayBySSType(ctx PoSST) map[int][]NodePtr {
 	row, err := ctx.DB.Query(qstr)
 	
 	if err != nil {
-		fmt.Println("QUERY GetAppointmentArrayByArrow Failed",err)
+		return nil,fmt.Errorf("%w: QUERY GetAppointmentArrayBySSType Failed: %v",ErrDBQuery,err)
 	}
 
 	var arry string
@@ -4190,13 +6679,30 @@ func GetAppointmentArrayBySSType(ctx PoSST) map[int][]NodePtr {
 
 	row.Close()
 	
-	return retval
+	return retval,nil
 }
 
 // **************************************************************************
 
 func GetAppointmentHistogramByArrow(ctx PoSST) map[ArrowPtr]int {
 
+	retval,err := TryGetAppointmentHistogramByArrow(ctx)
+
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	return retval
+}
+
+// **************************************************************************
+
+func TryGetAppointmentHistogramByArrow(ctx PoSST) (map[ArrowPtr]int,error) {
+
+	if ctx.Store != nil {
+		return ctx.Store.GetAppointmentHistogramByArrow()
+	}
+
 /* arr | count 
 -----+-------
   18 |     3
@@ -4212,7 +6718,7 @@ func GetAppointmentHistogramByArrow(ctx PoSST) map[ArrowPtr]int {
 	row, err := ctx.DB.Query(qstr)
 	
 	if err != nil {
-		fmt.Println("QUERY GetAppointmentArrayByArrow Failed",err)
+		return nil,fmt.Errorf("%w: QUERY GetAppointmentHistogramByArrow Failed: %v",ErrDBQuery,err)
 	}
 
 	var freq int
@@ -4226,13 +6732,30 @@ func GetAppointmentHistogramByArrow(ctx PoSST) map[ArrowPtr]int {
 
 	row.Close()
 	
-	return retval
+	return retval,nil
 }
 
 // **************************************************************************
 
 func GetAppointmentHistogramBySSType(ctx PoSST) map[int]int {
 
+	retval,err := TryGetAppointmentHistogramBySSType(ctx)
+
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	return retval
+}
+
+// **************************************************************************
+
+func TryGetAppointmentHistogramBySSType(ctx PoSST) (map[int]int,error) {
+
+	if ctx.Store != nil {
+		return ctx.Store.GetAppointmentHistogramBySSType()
+	}
+
 /* sttype | x 
 --------+---
       1 | 4
@@ -4247,7 +6770,7 @@ func GetAppointmentHistogramBySSType(ctx PoSST) map[int]int {
 	row, err := ctx.DB.Query(qstr)
 	
 	if err != nil {
-		fmt.Println("QUERY GetAppointmentArrayByArrow Failed",err)
+		return nil,fmt.Errorf("%w: QUERY GetAppointmentHistogramBySSType Failed: %v",ErrDBQuery,err)
 	}
 
 	var freq int
@@ -4258,13 +6781,32 @@ func GetAppointmentHistogramBySSType(ctx PoSST) map[int]int {
 		err = row.Scan(&sttype,&freq)
 		retval[sttype] = freq
 	}
-	return retval
+
+	row.Close()
+	return retval,nil
 }
 
 // **************************************************************************
 
 func GetAppointmentNodesByArrow(ctx PoSST) []ArrowAppointment {
 
+	retval,err := TryGetAppointmentNodesByArrow(ctx)
+
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	return retval
+}
+
+// **************************************************************************
+
+func TryGetAppointmentNodesByArrow(ctx PoSST) ([]ArrowAppointment,error) {
+
+	if ctx.Store != nil {
+		return ctx.Store.GetAppointmentNodesByArrow()
+	}
+
 /*  nfrom | arr | array_agg 
 -------+-----+-----------
  (4,0) |  18 | {"(2,4)"}
@@ -4284,7 +6826,7 @@ func GetAppointmentNodesByArrow(ctx PoSST) []ArrowAppointment {
 	row, err := ctx.DB.Query(qstr)
 	
 	if err != nil {
-		fmt.Println("QUERY GetAppointmentArrayByArrow Failed",err)
+		return nil,fmt.Errorf("%w: QUERY GetAppointmentNodesByArrow Failed: %v",ErrDBQuery,err)
 	}
 
 	var nptr,arry string
@@ -4300,13 +6842,30 @@ func GetAppointmentNodesByArrow(ctx PoSST) []ArrowAppointment {
 
 	row.Close()
 
-	return retval
+	return retval,nil
 }
 
 // **************************************************************************
 
 func GetAppointmentNodesBySTType(ctx PoSST) []STTypeAppointment {
 
+	retval,err := TryGetAppointmentNodesBySTType(ctx)
+
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	return retval
+}
+
+// **************************************************************************
+
+func TryGetAppointmentNodesBySTType(ctx PoSST) ([]STTypeAppointment,error) {
+
+	if ctx.Store != nil {
+		return ctx.Store.GetAppointmentNodesBySTType()
+	}
+
 /*  nfrom | sttype | array_agg 
 -------+--------+-----------
  (3,0) |     -3 | {"(4,4)"}
@@ -4326,7 +6885,7 @@ func GetAppointmentNodesBySTType(ctx PoSST) []STTypeAppointment {
 	row, err := ctx.DB.Query(qstr)
 	
 	if err != nil {
-		fmt.Println("QUERY GetAppointmentArrayBySTType Failed",err)
+		return nil,fmt.Errorf("%w: QUERY GetAppointmentNodesBySTType Failed: %v",ErrDBQuery,err)
 	}
 
 	var retval []STTypeAppointment
@@ -4342,7 +6901,7 @@ func GetAppointmentNodesBySTType(ctx PoSST) []STTypeAppointment {
 	}
 
 	row.Close()
-	return retval
+	return retval,nil
 }
 
 // **************************************************************************
@@ -4353,8 +6912,10 @@ func TallyPath(ctx PoSST,path []Link,between map[string]int) map[string]int {
 
 	// count how often each node appears in the different path solutions
 
+	nodes := GetDBNodesByNodePtrs(ctx,LinkPathNodePtrs([][]Link{path}))
+
 	for leg := range path {
-		n := GetDBNodeByNodePtr(ctx,path[leg].Dst)
+		n := nodes[path[leg].Dst]
 		between[n.S]++
 	}
 
@@ -4424,7 +6985,9 @@ func SuperNodesByConicPath(solutions [][]Link, maxdepth int) [][]NodePtr {
 
 			for p_j := p_i+1; p_j < len(solutions); p_j++ {
 
-				if depth < 1 || depth > len(solutions[p_j])-2 {
+				// Weighted paths can differ in length
+
+				if depth < 1 || depth > len(solutions[p_i])-2 || depth > len(solutions[p_j])-2 {
 					break
 				}
 
@@ -4445,6 +7008,14 @@ func SuperNodes(ctx PoSST,solutions [][]Link, maxdepth int) string {
 
 	supernodes := SuperNodesByConicPath(solutions,maxdepth)
 
+	var nptrs []NodePtr
+
+	for g := range supernodes {
+		nptrs = append(nptrs,supernodes[g]...)
+	}
+
+	nodes := GetDBNodesByNodePtrs(ctx,nptrs)
+
 	var retval string
 
 	for g := range supernodes {
@@ -4452,7 +7023,7 @@ func SuperNodes(ctx PoSST,solutions [][]Link, maxdepth int) string {
 		super := ""
 
 		for n := range supernodes[g] {
-			node := GetDBNodeByNodePtr(ctx,supernodes[g][n])
+			node := nodes[supernodes[g][n]]
 			super += fmt.Sprintf("%s",node.S)
 			if n < len(supernodes[g])-1 {
 				super += ", "
@@ -4478,6 +7049,19 @@ func SQLEscape(s string) string {
 
 // **************************************************************************
 
+func SQLArrayItem(s string) string {
+
+	// An element of a postgres array literal, in quotes, with any
+	// quote or backslash in it escaped as the array syntax needs
+
+	s = strings.Replace(s,`\`,`\\`,-1)
+	s = strings.Replace(s,`"`,`\"`,-1)
+
+	return "\""+s+"\""
+}
+
+// **************************************************************************
+
 func Array2Str(arr []string) string {
 
 	var s string
@@ -4546,9 +7130,23 @@ func ParseSQLArrayString(whole_array string) []string {
 	var items []string
 	var item []rune
 	var protected = false
+	var escaped = false
 
 	for u := range uni_array {
 
+		// \" and \\ inside quotes, see SQLArrayItem()
+
+		if escaped {
+			item = append(item,uni_array[u])
+			escaped = false
+			continue
+		}
+
+		if protected && uni_array[u] == '\\' {
+			escaped = true
+			continue
+		}
+
 		if uni_array[u] == '"' {
 			protected = !protected
 			continue
@@ -4616,7 +7214,7 @@ func FormatSQLStringArray(array []string) string {
 	var ret string = "'{ "
 	
 	for i := 0; i < len(array); i++ {
-		ret += fmt.Sprintf("\"%s\"",SQLEscape(array[i]))
+		ret += SQLEscape(SQLArrayItem(array[i]))
 	    if i < len(array)-1 {
 	    ret += ", "
 	    }
@@ -4651,6 +7249,108 @@ func FormatSQLNodePtrArray(array []NodePtr) string {
 
 // **************************************************************************
 
+func SQLArg(args *[]interface{},v interface{}) string {
+
+	// Append a query parameter and return its $n placeholder, for
+	// queries whose WHERE clauses are assembled piecewise
+
+	*args = append(*args,v)
+	return fmt.Sprintf("$%d",len(*args))
+}
+
+// **************************************************************************
+
+func SQLIntArray(array []int) interface{} {
+
+	// Parameter version of FormatSQLIntArray, bind as $n::int[]
+
+	ret := make([]int64,len(array))
+
+	for i := range array {
+		ret[i] = int64(array[i])
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i] < ret[j]
+	})
+
+	return pq.Array(ret)
+}
+
+// **************************************************************************
+
+func SQLStringArray(array []string) interface{} {
+
+	// Parameter version of FormatSQLStringArray, bind as $n::text[]
+
+	ret := make([]string,len(array))
+	copy(ret,array)
+
+	sort.Strings(ret) // Avoids ambiguities in db comparisons
+
+	return pq.Array(ret)
+}
+
+// **************************************************************************
+
+func SQLNodePtrArray(array []NodePtr) interface{} {
+
+	// Parameter version of FormatSQLNodePtrArray, bind as $n::NodePtr[]
+
+	ret := make([]string,len(array))
+
+	for i := range array {
+		ret[i] = fmt.Sprintf("(%d,%d)",array[i].Class,array[i].CPtr)
+	}
+
+	return pq.Array(ret)
+}
+
+// **************************************************************************
+
+func SQLLinkArg(args *[]interface{},lnk Link) string {
+
+	// Bind the fields of a Link and return a composite expression for it
+
+	return fmt.Sprintf("ROW(%s::int,%s::real,%s::text,ROW(%s::int,%s::int)::NodePtr)::Link",
+		SQLArg(args,lnk.Arr),
+		SQLArg(args,lnk.Wgt),
+		SQLArg(args,SQLLinkContext(lnk.Ctx)),
+		SQLArg(args,lnk.Dst.Class),
+		SQLArg(args,lnk.Dst.CPtr))
+}
+
+// **************************************************************************
+
+func SQLLinkContext(context []string) string {
+
+	// The Ctx column of a Link is stored as text in the same form as
+	// FormatSQLStringArray, which also reads as a postgres text[]
+
+	var ctxstr string = "{ "
+
+	sorted := make([]string,len(context))
+	copy(sorted,context)
+	sort.Strings(sorted)
+
+	for i := 0; i < len(sorted); i++ {
+		ctxstr += SQLArrayItem(sorted[i])
+		if i < len(sorted)-1 {
+			ctxstr += ", "
+		}
+	}
+
+	ctxstr += " }"
+
+	if len(sorted) == 0 {
+		ctxstr = "{ }"
+	}
+
+	return ctxstr
+}
+
+// **************************************************************************
+
 func ParseSQLLinkString(s string) Link {
 
         // e.g. (77,0.34,"{ ""fairy castles"", ""angel air"" }","(4,2)")
@@ -4830,9 +7530,23 @@ func StorageClass(s string) (int,int) {
 
 func DiracNotation(s string) (bool,string,string,string) {
 
+	isdirac,begin,end,context,err := TryDiracNotation(s)
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	return isdirac,begin,end,context
+}
+
+// **************************************************************************
+
+func TryDiracNotation(s string) (bool,string,string,string,error) {
+
 	var begin,end,context string
 
-	if s[0] == '<' && s[len(s)-1] == '>' {
+	if len(s) > 1 && s[0] == '<' && s[len(s)-1] == '>' {
 		matrix := s[1:len(s)-1]
 		params := strings.Split(matrix,"|")
 		
@@ -4846,14 +7560,13 @@ func DiracNotation(s string) (bool,string,string,string) {
 			context = params[1]
 			end = params[2]			
 		default:
-			fmt.Println("Bad Dirac notation, should be <a|b> or <a|context|b>")
-			os.Exit(-1)
+			return false,"","","",fmt.Errorf("%w: %s",ErrBadDiracNotation,s)
 		}
 	} else {
-		return false,"","",""
+		return false,"","","",nil
 	}
 
-	return true,begin,end,context
+	return true,begin,end,context,nil
 }
 
 // **************************************************************************
@@ -4862,6 +7575,20 @@ func DiracNotation(s string) (bool,string,string,string) {
 
 func STTypeDBChannel(sttype int) string {
 
+	link_channel,err := TrySTTypeDBChannel(sttype)
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	return link_channel
+}
+
+// **************************************************************************
+
+func TrySTTypeDBChannel(sttype int) (string,error) {
+
 	// This expects the range for sttype to be unshifted 0,+/-
 
 	var link_channel string
@@ -4882,11 +7609,10 @@ func STTypeDBChannel(sttype int) string {
 	case -EXPRESS:
 		link_channel = I_MEXPR
 	default:
-		fmt.Println(ERR_ILLEGAL_LINK_CLASS,sttype)
-		os.Exit(-1)
+		return "",fmt.Errorf("%w: %d",ErrIllegalLinkClass,sttype)
 	}
 
-	return link_channel
+	return link_channel,nil
 }
 
 // **************************************************************************
@@ -0,0 +1,567 @@
+//**************************************************************
+//
+// centrality.go
+//
+// Graph-wide importance of nodes: PageRank, eigenvector and
+// betweenness centrality over the stored links, kept in the
+// Centrality table so that searches can rank by them
+//
+//**************************************************************
+
+package SSTorytime
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+//**************************************************************
+
+const CENTRALITY_TABLE = "CREATE TABLE IF NOT EXISTS Centrality " +
+	"( " +
+	"Scope       text,    " +  // see CentralityScope(), "" for the whole graph
+	"NPtr        NodePtr, " +
+	"PageRank    real,    " +
+	"Eigenvector real,    " +
+	"Betweenness real,    " +
+	"Primary Key(Scope,NPtr)" +
+	")"
+
+//**************************************************************
+
+var (
+	PAGERANK_DAMPING = 0.85
+	CENTRALITY_MAX_ITERATIONS = 100
+	CENTRALITY_TOLERANCE = 1e-9
+)
+
+//**************************************************************
+
+type Centrality struct {
+
+	NPtr        NodePtr
+	PageRank    float64  // sums to 1 over the scope
+	Eigenvector float64  // scaled so that the largest is 1
+	Betweenness float64  // scaled so that the largest is 1
+}
+
+//**************************************************************
+
+func CentralityScope(chap string,cn []string,arrows []ArrowPtr) string {
+
+	// A canonical name for the subgraph the scores were computed over,
+	// so the same filters find the same saved scores
+
+	var parts []string
+
+	if chap != "any" && chap != "" {
+		parts = append(parts,"chapter="+strings.ToLower(chap))
+	}
+
+	var context []string
+
+	for _,c := range cn {
+		c = strings.TrimSpace(c)
+		if c != "" && c != "any" {
+			context = append(context,strings.ToLower(c))
+		}
+	}
+
+	if len(context) > 0 {
+		sort.Strings(context)
+		parts = append(parts,"context="+strings.Join(context,","))
+	}
+
+	if len(arrows) > 0 {
+
+		var arr []int
+
+		for _,a := range arrows {
+			arr = append(arr,int(a))
+		}
+
+		sort.Ints(arr)
+
+		var s []string
+
+		for _,a := range arr {
+			s = append(s,fmt.Sprint(a))
+		}
+
+		parts = append(parts,"arrows="+strings.Join(s,","))
+	}
+
+	return strings.Join(parts,";")
+}
+
+//**************************************************************
+
+func ComputeCentrality(ctx PoSST,chap string,cn []string,arrows []ArrowPtr) []Centrality {
+
+	scores,err := TryComputeCentrality(ctx,chap,cn,arrows)
+
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	return scores
+}
+
+//**************************************************************
+
+func TryComputeCentrality(ctx PoSST,chap string,cn []string,arrows []ArrowPtr) ([]Centrality,error) {
+
+	// Score every node with links in the chapter, context and arrows,
+	// most important (by PageRank) first. With no arrows, follow the
+	// forward direction of every link, as the inverses add nothing new
+
+	links,err := TryGetDBLinksMatching(ctx,chap,cn,arrows)
+
+	if err != nil {
+		return nil,err
+	}
+
+	if len(arrows) == 0 {
+
+		var fwd []NodeArrowNode
+
+		for _,l := range links {
+			if l.STType >= 0 {
+				fwd = append(fwd,l)
+			}
+		}
+
+		links = fwd
+	}
+
+	return GraphCentrality(links),nil
+}
+
+//**************************************************************
+
+func UpdateCentrality(ctx PoSST,chap string,cn []string,arrows []ArrowPtr) []Centrality {
+
+	scores,err := TryUpdateCentrality(ctx,chap,cn,arrows)
+
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	return scores
+}
+
+//**************************************************************
+
+func TryUpdateCentrality(ctx PoSST,chap string,cn []string,arrows []ArrowPtr) ([]Centrality,error) {
+
+	// Compute and save the scores, replacing any from before
+
+	scores,err := TryComputeCentrality(ctx,chap,cn,arrows)
+
+	if err != nil {
+		return nil,err
+	}
+
+	err = TrySaveDBCentrality(ctx,CentralityScope(chap,cn,arrows),scores)
+
+	return scores,err
+}
+
+//**************************************************************
+
+func GraphCentrality(links []NodeArrowNode) []Centrality {
+
+	// Links are directed from NFrom to NTo and weighted by Wgt. Parallel
+	// links add up, and a node linked to itself gains nothing from it
+
+	var index = make(map[NodePtr]int)
+	var nodes []NodePtr
+
+	for _,l := range links {
+		for _,n := range []NodePtr{l.NFrom,l.NTo} {
+			if _,known := index[n]; !known {
+				index[n] = len(nodes)
+				nodes = append(nodes,n)
+			}
+		}
+	}
+
+	sort.Slice(nodes, func(i,j int) bool {
+		return NodePtrLess(nodes[i],nodes[j])
+	})
+
+	for i,n := range nodes {
+		index[n] = i
+	}
+
+	dim := len(nodes)
+	out := make([]map[int]float64,dim)
+
+	for i := range out {
+		out[i] = make(map[int]float64)
+	}
+
+	for _,l := range links {
+
+		from,to := index[l.NFrom],index[l.NTo]
+
+		if from != to {
+			out[from][to] += l.Wgt
+		}
+	}
+
+	pagerank := pageRank(out)
+	eigen := eigenvectorCentrality(out)
+	between := betweennessCentrality(out)
+
+	var scores = make([]Centrality,dim)
+
+	for i := range nodes {
+		scores[i] = Centrality{NPtr: nodes[i],PageRank: pagerank[i],Eigenvector: eigen[i],Betweenness: between[i]}
+	}
+
+	sort.SliceStable(scores, func(i,j int) bool {
+		return scores[i].PageRank > scores[j].PageRank
+	})
+
+	return scores
+}
+
+//**************************************************************
+
+func pageRank(out []map[int]float64) []float64 {
+
+	// The random walker follows links in proportion to their weight, and
+	// jumps anywhere from a node with nowhere to go, or at random with
+	// probability 1-PAGERANK_DAMPING
+
+	dim := len(out)
+	rank := MakeVector(dim,1.0/float64(dim))
+	total := make([]float64,dim)
+
+	for i := range out {
+		for _,w := range out[i] {
+			total[i] += w
+		}
+	}
+
+	for iter := 0; iter < CENTRALITY_MAX_ITERATIONS; iter++ {
+
+		next := MakeVector(dim,0)
+		dangling := 0.0
+
+		for i := range out {
+
+			if total[i] <= 0 {
+				dangling += rank[i]
+				continue
+			}
+
+			for j,w := range out[i] {
+				next[j] += rank[i] * w / total[i]
+			}
+		}
+
+		var diff float64
+
+		for i := range next {
+			next[i] = (1-PAGERANK_DAMPING)/float64(dim) + PAGERANK_DAMPING * (next[i] + dangling/float64(dim))
+			diff += math.Abs(next[i]-rank[i])
+		}
+
+		rank = next
+
+		if diff < CENTRALITY_TOLERANCE {
+			break
+		}
+	}
+
+	return rank
+}
+
+//**************************************************************
+
+func eigenvectorCentrality(out []map[int]float64) []float64 {
+
+	// Power iteration on the undirected graph, as ComputeEVC() in N4L,
+	// with each node also counting itself so that it converges on
+	// bipartite graphs like chains and stars
+
+	dim := len(out)
+	adj := make([]map[int]float64,dim)
+
+	for i := range adj {
+		adj[i] = make(map[int]float64)
+	}
+
+	for i := range out {
+		for j,w := range out[i] {
+			adj[i][j] += w
+			adj[j][i] += w
+		}
+	}
+
+	v := MakeVector(dim,1.0)
+
+	for iter := 0; iter < CENTRALITY_MAX_ITERATIONS; iter++ {
+
+		next := make([]float64,dim)
+		copy(next,v)
+
+		for i := range adj {
+			for j,w := range adj[i] {
+				next[i] += w * v[j]
+			}
+		}
+
+		next = ScaleToMax(next)
+
+		var diff float64
+
+		for i := range next {
+			diff += math.Abs(next[i]-v[i])
+		}
+
+		v = next
+
+		if diff < CENTRALITY_TOLERANCE {
+			break
+		}
+	}
+
+	return v
+}
+
+//**************************************************************
+
+func betweennessCentrality(out []map[int]float64) []float64 {
+
+	// Brandes' algorithm, counting the shortest directed paths (in hops)
+	// between every pair of nodes that pass through each node
+
+	dim := len(out)
+	between := make([]float64,dim)
+
+	next := make([][]int,dim)
+
+	for i := range out {
+		for j := range out[i] {
+			next[i] = append(next[i],j)
+		}
+		sort.Ints(next[i])
+	}
+
+	for s := 0; s < dim; s++ {
+
+		var stack []int
+
+		pred := make([][]int,dim)
+		sigma := make([]float64,dim)
+		dist := make([]int,dim)
+
+		for i := range dist {
+			dist[i] = -1
+		}
+
+		sigma[s] = 1
+		dist[s] = 0
+		queue := []int{s}
+
+		for len(queue) > 0 {
+
+			v := queue[0]
+			queue = queue[1:]
+			stack = append(stack,v)
+
+			for _,w := range next[v] {
+
+				if dist[w] < 0 {
+					dist[w] = dist[v] + 1
+					queue = append(queue,w)
+				}
+
+				if dist[w] == dist[v] + 1 {
+					sigma[w] += sigma[v]
+					pred[w] = append(pred[w],v)
+				}
+			}
+		}
+
+		delta := make([]float64,dim)
+
+		for i := len(stack)-1; i >= 0; i-- {
+
+			w := stack[i]
+
+			for _,v := range pred[w] {
+				delta[v] += sigma[v] / sigma[w] * (1 + delta[w])
+			}
+
+			if w != s {
+				between[w] += delta[w]
+			}
+		}
+	}
+
+	return ScaleToMax(between)
+}
+
+//**************************************************************
+
+func MakeVector(dim int,init_value float64) []float64 {
+
+	var v = make([]float64,dim)
+
+	for i := range v {
+		v[i] = init_value
+	}
+
+	return v
+}
+
+//**************************************************************
+
+func ScaleToMax(v []float64) []float64 {
+
+	// Divide by the largest element, if there is one above zero
+
+	var max float64
+
+	for i := range v {
+		if v[i] > max {
+			max = v[i]
+		}
+	}
+
+	if max > 0 {
+		for i := range v {
+			v[i] /= max
+		}
+	}
+
+	return v
+}
+
+//**************************************************************
+
+func SaveDBCentrality(ctx PoSST,scope string,scores []Centrality) {
+
+	err := TrySaveDBCentrality(ctx,scope,scores)
+
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+//**************************************************************
+
+func TrySaveDBCentrality(ctx PoSST,scope string,scores []Centrality) (err error) {
+
+	// Replace the scores for the scope in one transaction
+
+	if ctx.Store != nil {
+		return ctx.Store.SaveCentrality(scope,scores)
+	}
+
+	ctx,owned,err := TryBeginTransaction(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		err = TryEndTransaction(ctx,owned,err)
+	}()
+
+	_,err = SQLConn(ctx).Exec("DELETE FROM Centrality WHERE Scope=$1",scope)
+
+	if err != nil {
+		return fmt.Errorf("%w: SaveDBCentrality Failed: %v",ErrDBQuery,err)
+	}
+
+	if len(scores) == 0 {
+		return nil
+	}
+
+	var nptrs []NodePtr
+	var pagerank,eigen,between []float64
+
+	for _,c := range scores {
+		nptrs = append(nptrs,c.NPtr)
+		pagerank = append(pagerank,c.PageRank)
+		eigen = append(eigen,c.Eigenvector)
+		between = append(between,c.Betweenness)
+	}
+
+	// Bind as pq.Array, as the columns have to stay in step
+
+	qstr := "INSERT INTO Centrality (Scope,NPtr,PageRank,Eigenvector,Betweenness) " +
+		"SELECT $1,u.n,u.pr,u.ev,u.bt FROM unnest($2::NodePtr[],$3::float8[],$4::float8[],$5::float8[]) AS u(n,pr,ev,bt)"
+
+	_,err = SQLConn(ctx).Exec(qstr,scope,SQLNodePtrArray(nptrs),pq.Array(pagerank),pq.Array(eigen),pq.Array(between))
+
+	if err != nil {
+		return fmt.Errorf("%w: SaveDBCentrality Failed: %v",ErrDBQuery,err)
+	}
+
+	return nil
+}
+
+//**************************************************************
+
+func GetDBCentrality(ctx PoSST,scope string) []Centrality {
+
+	scores,err := TryGetDBCentrality(ctx,scope)
+
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	return scores
+}
+
+//**************************************************************
+
+func TryGetDBCentrality(ctx PoSST,scope string) ([]Centrality,error) {
+
+	// The saved scores for the scope, most important first, or none
+	// if they have not been computed
+
+	if ctx.Store != nil {
+		return ctx.Store.GetCentrality(scope)
+	}
+
+	qstr := "SELECT NPtr,PageRank,Eigenvector,Betweenness FROM Centrality WHERE Scope=$1 ORDER BY PageRank DESC,NPtr"
+
+	row,err := SQLConn(ctx).Query(qstr,scope)
+
+	if err != nil {
+		return nil,fmt.Errorf("%w: GetDBCentrality Failed: %v",ErrDBQuery,err)
+	}
+
+	var scores []Centrality
+
+	for row.Next() {
+
+		var whole string
+		var c Centrality
+
+		err = row.Scan(&whole,&c.PageRank,&c.Eigenvector,&c.Betweenness)
+
+		if err != nil {
+			row.Close()
+			return nil,fmt.Errorf("%w: GetDBCentrality Failed: %v",ErrDBQuery,err)
+		}
+
+		fmt.Sscanf(whole,"(%d,%d)",&c.NPtr.Class,&c.NPtr.CPtr)
+		scores = append(scores,c)
+	}
+
+	row.Close()
+
+	return scores,nil
+}
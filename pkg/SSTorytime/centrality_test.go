@@ -0,0 +1,158 @@
+//**************************************************************
+//
+// centrality_test.go
+//
+// PageRank, eigenvector and betweenness centrality on small
+// graphs whose scores are known by hand
+//
+//**************************************************************
+
+package SSTorytime
+
+import (
+	"math"
+	"testing"
+)
+
+//**************************************************************
+
+func testGraph(edges [][2]int,both bool) []NodeArrowNode {
+
+	// Nodes are numbered, links weigh 1, both ways if asked
+
+	var links []NodeArrowNode
+
+	for _,e := range edges {
+
+		from := NodePtr{Class: N1GRAM,CPtr: ClassedNodePtr(e[0])}
+		to := NodePtr{Class: N1GRAM,CPtr: ClassedNodePtr(e[1])}
+
+		links = append(links,NodeArrowNode{NFrom: from,Wgt: 1,NTo: to})
+
+		if both {
+			links = append(links,NodeArrowNode{NFrom: to,Wgt: 1,NTo: from})
+		}
+	}
+
+	return links
+}
+
+//**************************************************************
+
+func scoresByNode(scores []Centrality) map[ClassedNodePtr]Centrality {
+
+	var byptr = make(map[ClassedNodePtr]Centrality)
+
+	for _,c := range scores {
+		byptr[c.NPtr.CPtr] = c
+	}
+
+	return byptr
+}
+
+//**************************************************************
+
+func checkScores(t *testing.T,what string,got map[ClassedNodePtr]Centrality,score func(Centrality) float64,want map[ClassedNodePtr]float64) {
+
+	t.Helper()
+
+	for n,w := range want {
+		if g := score(got[n]); math.Abs(g-w) > 1e-6 {
+			t.Errorf("%s of node %d is %.8f, expected %.8f",what,n,g,w)
+		}
+	}
+}
+
+//**************************************************************
+
+func pagerank(c Centrality) float64    { return c.PageRank }
+func eigenvector(c Centrality) float64 { return c.Eigenvector }
+func betweenness(c Centrality) float64 { return c.Betweenness }
+
+//**************************************************************
+
+func TestCentralityChain(t *testing.T) {
+
+	// 1 -> 2 -> 3, where 3 leads nowhere
+
+	scores := scoresByNode(GraphCentrality(testGraph([][2]int{{1,2},{2,3}},false)))
+
+	checkScores(t,"PageRank",scores,pagerank,map[ClassedNodePtr]float64{
+		1: 0.18441678, 2: 0.34117105, 3: 0.47441217,
+	})
+
+	// The path graph plus self, with eigenvector (1,√2,1)
+
+	checkScores(t,"eigenvector",scores,eigenvector,map[ClassedNodePtr]float64{
+		1: 1/math.Sqrt2, 2: 1, 3: 1/math.Sqrt2,
+	})
+
+	// Only 2 is between others
+
+	checkScores(t,"betweenness",scores,betweenness,map[ClassedNodePtr]float64{
+		1: 0, 2: 1, 3: 0,
+	})
+}
+
+//**************************************************************
+
+func TestCentralityStar(t *testing.T) {
+
+	// 1 linked both ways to each of 2, 3 and 4
+
+	scores := scoresByNode(GraphCentrality(testGraph([][2]int{{1,2},{1,3},{1,4}},true)))
+
+	checkScores(t,"PageRank",scores,pagerank,map[ClassedNodePtr]float64{
+		1: 0.47972973, 2: 0.17342342, 3: 0.17342342, 4: 0.17342342,
+	})
+
+	// The star plus self, with eigenvector (√3,1,1,1)
+
+	checkScores(t,"eigenvector",scores,eigenvector,map[ClassedNodePtr]float64{
+		1: 1, 2: 1/math.Sqrt(3), 3: 1/math.Sqrt(3), 4: 1/math.Sqrt(3),
+	})
+
+	// Every path between two leaves goes through the middle
+
+	checkScores(t,"betweenness",scores,betweenness,map[ClassedNodePtr]float64{
+		1: 1, 2: 0, 3: 0, 4: 0,
+	})
+}
+
+//**************************************************************
+
+func TestCentralityTwoTriangles(t *testing.T) {
+
+	// Two separate cycles, 1 -> 2 -> 3 -> 1 and 4 -> 5 -> 6 -> 4, in
+	// which no node stands out, and neither does either triangle
+
+	scores := scoresByNode(GraphCentrality(testGraph([][2]int{{1,2},{2,3},{3,1},{4,5},{5,6},{6,4}},false)))
+
+	var want_pr = make(map[ClassedNodePtr]float64)
+	var want_one = make(map[ClassedNodePtr]float64)
+
+	for n := ClassedNodePtr(1); n <= 6; n++ {
+		want_pr[n] = 1.0/6
+		want_one[n] = 1
+	}
+
+	if len(scores) != 6 {
+		t.Fatalf("expected scores for 6 nodes, got %d",len(scores))
+	}
+
+	checkScores(t,"PageRank",scores,pagerank,want_pr)
+	checkScores(t,"eigenvector",scores,eigenvector,want_one)
+
+	// Each node is on the one path between the other two of its triangle
+
+	checkScores(t,"betweenness",scores,betweenness,want_one)
+}
+
+//**************************************************************
+
+func TestCentralityEmpty(t *testing.T) {
+
+	if scores := GraphCentrality(nil); len(scores) != 0 {
+		t.Errorf("expected no scores for no links, got %v",scores)
+	}
+}
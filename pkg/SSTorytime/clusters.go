@@ -0,0 +1,512 @@
+//**************************************************************
+//
+// clusters.go
+//
+// Which parts of the graph are disconnected islands (connected
+// components) and which are tightly knit (communities, by the
+// Louvain method of maximizing modularity)
+//
+//**************************************************************
+
+package SSTorytime
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+//**************************************************************
+
+type Cluster struct {
+
+	Id    int
+	Nodes []NodePtr
+	Links int       // links with both ends in the cluster
+}
+
+//**************************************************************
+
+type GraphClusters struct {
+
+	Components  []Cluster  // largest first
+	Communities []Cluster  // largest first, each inside one component
+	Modularity  float64    // of the communities, from -1/2 to 1
+}
+
+//**************************************************************
+
+func GetGraphClusters(ctx PoSST,chap string,cn []string,sttypes []int) GraphClusters {
+
+	clusters,err := TryGetGraphClusters(ctx,chap,cn,sttypes)
+
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	return clusters
+}
+
+//**************************************************************
+
+func TryGetGraphClusters(ctx PoSST,chap string,cn []string,sttypes []int) (GraphClusters,error) {
+
+	// Cluster the nodes linked in the chapter and context, by links of
+	// the given STtypes (all if none). Clusters ignore the direction of
+	// links, so an STtype and its inverse are the same
+
+	links,err := TryGetDBLinksMatching(ctx,chap,cn,nil)
+
+	if err != nil {
+		return GraphClusters{},err
+	}
+
+	var channels = make(map[int]bool)
+
+	for _,st := range sttypes {
+		if st < 0 {
+			st = -st
+		}
+		channels[st] = true
+	}
+
+	var selected []NodeArrowNode
+
+	for _,l := range links {
+
+		if l.STType < 0 {
+			continue
+		}
+
+		if len(channels) > 0 && !channels[l.STType] {
+			continue
+		}
+
+		selected = append(selected,l)
+	}
+
+	return ClusterLinks(selected),nil
+}
+
+//**************************************************************
+
+func ClusterLinks(links []NodeArrowNode) GraphClusters {
+
+	// The same arrow between the same two nodes, in either direction,
+	// counts once, as a NEAR link is stored both ways
+
+	var index = make(map[NodePtr]int)
+	var nodes []NodePtr
+
+	for _,l := range links {
+		for _,n := range []NodePtr{l.NFrom,l.NTo} {
+			if _,known := index[n]; !known {
+				index[n] = len(nodes)
+				nodes = append(nodes,n)
+			}
+		}
+	}
+
+	sort.Slice(nodes, func(i,j int) bool {
+		return NodePtrLess(nodes[i],nodes[j])
+	})
+
+	for i,n := range nodes {
+		index[n] = i
+	}
+
+	type edge struct {
+		a,b int
+		arr ArrowPtr
+	}
+
+	var weights = make(map[edge]float64)
+
+	for _,l := range links {
+
+		a,b := index[l.NFrom],index[l.NTo]
+
+		if a == b {
+			continue
+		}
+
+		if a > b {
+			a,b = b,a
+		}
+
+		e := edge{a,b,l.Arr}
+
+		if w,seen := weights[e]; !seen || l.Wgt > w {
+			weights[e] = l.Wgt
+		}
+	}
+
+	dim := len(nodes)
+	adj := make([]map[int]float64,dim)
+
+	for i := range adj {
+		adj[i] = make(map[int]float64)
+	}
+
+	var count = make(map[[2]int]int)
+
+	for e,w := range weights {
+		adj[e.a][e.b] += w
+		adj[e.b][e.a] += w
+		count[[2]int{e.a,e.b}]++
+	}
+
+	component := connectedComponents(adj)
+	community := louvainCommunities(adj)
+
+	var clusters GraphClusters
+
+	clusters.Components = groupClusters(nodes,component,count)
+	clusters.Communities = groupClusters(nodes,community,count)
+	clusters.Modularity = modularity(adj,community)
+
+	return clusters
+}
+
+//**************************************************************
+
+func ClusterMembership(clusters []Cluster) map[NodePtr]int {
+
+	// The Id of the cluster each node belongs to
+
+	var member = make(map[NodePtr]int)
+
+	for _,c := range clusters {
+		for _,n := range c.Nodes {
+			member[n] = c.Id
+		}
+	}
+
+	return member
+}
+
+//**************************************************************
+
+func connectedComponents(adj []map[int]float64) []int {
+
+	var label = make([]int,len(adj))
+
+	for i := range label {
+		label[i] = -1
+	}
+
+	var next int
+
+	for s := range adj {
+
+		if label[s] >= 0 {
+			continue
+		}
+
+		label[s] = next
+		queue := []int{s}
+
+		for len(queue) > 0 {
+
+			v := queue[0]
+			queue = queue[1:]
+
+			for w := range adj[v] {
+				if label[w] < 0 {
+					label[w] = next
+					queue = append(queue,w)
+				}
+			}
+		}
+
+		next++
+	}
+
+	return label
+}
+
+//**************************************************************
+
+func louvainCommunities(adj []map[int]float64) []int {
+
+	// Move each node to the neighbouring community that most increases
+	// the modularity until none moves, then merge the communities into
+	// single nodes and start again, until nothing changes
+
+	var member = make([]int,len(adj))
+
+	for i := range member {
+		member[i] = i
+	}
+
+	graph := adj
+
+	for {
+		community,moved := louvainLevel(graph)
+
+		if !moved {
+			break
+		}
+
+		// Renumber the communities 0,1,2... in order of first member
+
+		var renumber = make(map[int]int)
+
+		for _,c := range community {
+			if _,known := renumber[c]; !known {
+				renumber[c] = len(renumber)
+			}
+		}
+
+		for i := range member {
+			member[i] = renumber[community[member[i]]]
+		}
+
+		merged := make([]map[int]float64,len(renumber))
+
+		for i := range merged {
+			merged[i] = make(map[int]float64)
+		}
+
+		for i := range graph {
+			for j,w := range graph[i] {
+				merged[renumber[community[i]]][renumber[community[j]]] += w
+			}
+		}
+
+		graph = merged
+	}
+
+	return member
+}
+
+//**************************************************************
+
+func louvainLevel(adj []map[int]float64) ([]int,bool) {
+
+	dim := len(adj)
+
+	var degree = make([]float64,dim)
+	var total float64
+
+	for i := range adj {
+		for _,w := range adj[i] {
+			degree[i] += w
+		}
+		total += degree[i]
+	}
+
+	var community = make([]int,dim)
+	var tot = make([]float64,dim)
+
+	for i := range community {
+		community[i] = i
+		tot[i] = degree[i]
+	}
+
+	if total == 0 {
+		return community,false
+	}
+
+	// Visit neighbours in a fixed order, so the result is repeatable
+
+	var order = make([][]int,dim)
+
+	for i := range adj {
+		for j := range adj[i] {
+			if j != i {
+				order[i] = append(order[i],j)
+			}
+		}
+		sort.Ints(order[i])
+	}
+
+	moved := false
+
+	for improved := true; improved; {
+
+		improved = false
+
+		for i := 0; i < dim; i++ {
+
+			old := community[i]
+			tot[old] -= degree[i]
+
+			var to = make(map[int]float64)
+			var candidates []int
+
+			for _,j := range order[i] {
+				c := community[j]
+				if _,known := to[c]; !known {
+					candidates = append(candidates,c)
+				}
+				to[c] += adj[i][j]
+			}
+
+			best := old
+			best_gain := to[old] - tot[old] * degree[i] / total
+
+			for _,c := range candidates {
+
+				gain := to[c] - tot[c] * degree[i] / total
+
+				if gain > best_gain + 1e-12 {
+					best = c
+					best_gain = gain
+				}
+			}
+
+			community[i] = best
+			tot[best] += degree[i]
+
+			if best != old {
+				improved = true
+				moved = true
+			}
+		}
+	}
+
+	return community,moved
+}
+
+//**************************************************************
+
+func modularity(adj []map[int]float64,community []int) float64 {
+
+	var total float64
+	var inside = make(map[int]float64)
+	var tot = make(map[int]float64)
+
+	for i := range adj {
+		for j,w := range adj[i] {
+			total += w
+			tot[community[i]] += w
+			if community[i] == community[j] {
+				inside[community[i]] += w
+			}
+		}
+	}
+
+	if total == 0 {
+		return 0
+	}
+
+	var q float64
+
+	for c := range tot {
+		q += inside[c] / total - (tot[c] / total) * (tot[c] / total)
+	}
+
+	return q
+}
+
+//**************************************************************
+
+func groupClusters(nodes []NodePtr,label []int,count map[[2]int]int) []Cluster {
+
+	var group = make(map[int]*Cluster)
+
+	for i,l := range label {
+
+		c,exists := group[l]
+
+		if !exists {
+			c = &Cluster{}
+			group[l] = c
+		}
+
+		c.Nodes = append(c.Nodes,nodes[i])
+	}
+
+	for pair,n := range count {
+		if label[pair[0]] == label[pair[1]] {
+			group[label[pair[0]]].Links += n
+		}
+	}
+
+	var clusters []Cluster
+
+	for _,c := range group {
+		clusters = append(clusters,*c)
+	}
+
+	// Nodes are already in order, so order by size and then first node
+
+	sort.Slice(clusters, func(i,j int) bool {
+		if len(clusters[i].Nodes) != len(clusters[j].Nodes) {
+			return len(clusters[i].Nodes) > len(clusters[j].Nodes)
+		}
+		return NodePtrLess(clusters[i].Nodes[0],clusters[j].Nodes[0])
+	})
+
+	for i := range clusters {
+		clusters[i].Id = i
+	}
+
+	return clusters
+}
+
+//**************************************************************
+
+func JSONClusters(ctx PoSST,clusters GraphClusters) string {
+
+	// Cluster membership by node name, for the web interface
+
+	type member struct {
+		NClass int
+		NCPtr  int
+		Name   string
+		Chap   string
+	}
+
+	type cluster struct {
+		Id    int
+		Links int
+		Nodes []member
+	}
+
+	var nptrs []NodePtr
+
+	for _,c := range clusters.Components {
+		nptrs = append(nptrs,c.Nodes...)
+	}
+
+	names := GetDBNodesByNodePtrs(ctx,nptrs)
+
+	encode := func(list []Cluster) []cluster {
+
+		var out = []cluster{}
+
+		for _,c := range list {
+
+			var jc = cluster{Id: c.Id,Links: c.Links}
+
+			for _,n := range c.Nodes {
+				jc.Nodes = append(jc.Nodes,member{n.Class,int(n.CPtr),names[n].S,names[n].Chap})
+			}
+
+			out = append(out,jc)
+		}
+
+		return out
+	}
+
+	var reply struct {
+		Modularity  float64
+		Components  []cluster
+		Communities []cluster
+	}
+
+	reply.Modularity = clusters.Modularity
+	reply.Components = encode(clusters.Components)
+	reply.Communities = encode(clusters.Communities)
+
+	jstr,err := json.Marshal(reply)
+
+	if err != nil {
+		fmt.Println("JSONClusters",err)
+		return "{}"
+	}
+
+	return string(jstr)
+}
@@ -0,0 +1,162 @@
+//**************************************************************
+//
+// clusters_test.go
+//
+// Components, Louvain communities and modularity of small
+// graphs whose clusters are known by hand
+//
+//**************************************************************
+
+package SSTorytime
+
+import (
+	"math"
+	"testing"
+)
+
+//**************************************************************
+
+func clusterSizes(clusters []Cluster) ([]int,[]int) {
+
+	var nodes,links []int
+
+	for _,c := range clusters {
+		nodes = append(nodes,len(c.Nodes))
+		links = append(links,c.Links)
+	}
+
+	return nodes,links
+}
+
+//**************************************************************
+
+func checkClusters(t *testing.T,what string,clusters []Cluster,nodes,links []int) {
+
+	t.Helper()
+
+	n,l := clusterSizes(clusters)
+
+	if len(n) != len(nodes) {
+		t.Fatalf("%s: %d clusters of %v nodes, expected %d of %v",what,len(n),n,len(nodes),nodes)
+	}
+
+	for i := range nodes {
+		if n[i] != nodes[i] || l[i] != links[i] {
+			t.Errorf("%s %d has %d nodes and %d links, expected %d and %d",what,i,n[i],l[i],nodes[i],links[i])
+		}
+	}
+}
+
+//**************************************************************
+
+func sameCluster(clusters []Cluster,members ...int) bool {
+
+	member := ClusterMembership(clusters)
+	first := member[NodePtr{Class: N1GRAM,CPtr: ClassedNodePtr(members[0])}]
+
+	for _,m := range members[1:] {
+		if member[NodePtr{Class: N1GRAM,CPtr: ClassedNodePtr(m)}] != first {
+			return false
+		}
+	}
+
+	return true
+}
+
+//**************************************************************
+
+func TestClustersTwoTriangles(t *testing.T) {
+
+	// 1-2-3 and 4-5-6, with nothing between them
+
+	clusters := ClusterLinks(testGraph([][2]int{{1,2},{2,3},{3,1},{4,5},{5,6},{6,4}},false))
+
+	checkClusters(t,"component",clusters.Components,[]int{3,3},[]int{3,3})
+	checkClusters(t,"community",clusters.Communities,[]int{3,3},[]int{3,3})
+
+	if !sameCluster(clusters.Components,1,2,3) || !sameCluster(clusters.Components,4,5,6) || sameCluster(clusters.Components,1,4) {
+		t.Errorf("components %v",clusters.Components)
+	}
+
+	// Each triangle has half the links, and half the degree: 2(1/2 - 1/4)
+
+	if math.Abs(clusters.Modularity-0.5) > 1e-9 {
+		t.Errorf("modularity %.6f, expected 0.5",clusters.Modularity)
+	}
+}
+
+//**************************************************************
+
+func TestClustersJoinedTriangles(t *testing.T) {
+
+	// 1-2-3 and 4-5-6, joined by 3-4: one component, two communities
+
+	clusters := ClusterLinks(testGraph([][2]int{{1,2},{2,3},{3,1},{4,5},{5,6},{6,4},{3,4}},false))
+
+	checkClusters(t,"component",clusters.Components,[]int{6},[]int{7})
+	checkClusters(t,"community",clusters.Communities,[]int{3,3},[]int{3,3})
+
+	if !sameCluster(clusters.Communities,1,2,3) || !sameCluster(clusters.Communities,4,5,6) || sameCluster(clusters.Communities,3,4) {
+		t.Errorf("communities %v",clusters.Communities)
+	}
+
+	// 7 links, 3 inside each triangle, whose degrees add up to 7:
+	// 2(3/7 - (7/14)^2) = 5/14
+
+	if math.Abs(clusters.Modularity-5.0/14.0) > 1e-9 {
+		t.Errorf("modularity %.6f, expected 5/14",clusters.Modularity)
+	}
+}
+
+//**************************************************************
+
+func TestClustersEmpty(t *testing.T) {
+
+	clusters := ClusterLinks(nil)
+
+	if len(clusters.Components) != 0 || len(clusters.Communities) != 0 || clusters.Modularity != 0 {
+		t.Errorf("clusters of nothing %+v",clusters)
+	}
+
+	if c := louvainCommunities(nil); len(c) != 0 {
+		t.Errorf("communities of nothing %v",c)
+	}
+
+	if q := modularity(nil,nil); q != 0 {
+		t.Errorf("modularity of nothing %f",q)
+	}
+}
+
+//**************************************************************
+
+func TestClustersSelfLoops(t *testing.T) {
+
+	// A loop joins a node to nothing else: 1-2-3 with a loop on 1,
+	// and 4 with only a loop, which is a cluster of its own
+
+	clusters := ClusterLinks(testGraph([][2]int{{1,2},{2,3},{3,1},{1,1},{4,4}},false))
+
+	checkClusters(t,"component",clusters.Components,[]int{3,1},[]int{3,0})
+	checkClusters(t,"community",clusters.Communities,[]int{3,1},[]int{3,0})
+
+	// All the links are inside the one triangle
+
+	if math.Abs(clusters.Modularity) > 1e-9 {
+		t.Errorf("modularity %.6f, expected 0",clusters.Modularity)
+	}
+
+	// Louvain itself keeps a loop inside the node's own community
+
+	adj := []map[int]float64{{0: 1,1: 1},{0: 1}}
+	community := louvainCommunities(adj)
+
+	if len(community) != 2 || community[0] != community[1] {
+		t.Errorf("communities %v, expected one",community)
+	}
+
+	// All the weight is inside the one community
+
+	if q := modularity(adj,community); math.Abs(q) > 1e-9 {
+		t.Errorf("modularity of one community %.6f, expected 0",q)
+	}
+}
@@ -0,0 +1,742 @@
+//**************************************************************
+//
+// decompile.go
+//
+// Recover N4L notes from the stored graph. The page map says
+// which links were written together on each line, and in what
+// order, so those lines are rebuilt first; whatever links are
+// left over (sequences, annotations, links made through the API)
+// are written after them, one per line
+//
+//**************************************************************
+
+package SSTorytime
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//**************************************************************
+
+type N4LLine struct {
+
+	Chap   string
+	Ctx    []string    // sorted, only meaningful if CtxSet
+	CtxSet bool        // false for a line without links
+	First  NodePtr
+	Steps  []Link      // to Dst from the one before, Ctx added to the arrow
+	Seq    bool        // follows the line before by (then), in _sequence_ mode
+}
+
+//**************************************************************
+
+type n4lDecompiler struct {
+
+	ctx       PoSST
+	remaining map[LinkKey]NodeArrowNode
+	inverse   map[LinkKey][]string  // the context of the other half
+	nodes     map[NodePtr]Node
+	problems  []string
+}
+
+//**************************************************************
+
+func DecompileN4L(ctx PoSST,chap string) string {
+
+	text,_,err := TryDecompileN4L(ctx,chap)
+
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	return text
+}
+
+//**************************************************************
+
+func TryDecompileN4L(ctx PoSST,chap string) (string,[]string,error) {
+
+	// The N4L for a chapter (all if empty), and a list of anything that
+	// could not be written, which is also left as comments in the text
+
+	var d n4lDecompiler
+
+	d.ctx = ctx
+	d.remaining = make(map[LinkKey]NodeArrowNode)
+	d.inverse = make(map[LinkKey][]string)
+
+	if chap == "any" {
+		chap = ""
+	}
+
+	if ARROW_DIRECTORY_TOP == 0 {
+
+		err := TryDownloadArrowsFromDB(ctx)
+
+		if err != nil {
+			return "",nil,err
+		}
+	}
+
+	links,err := TryGetDBLinksMatching(ctx,chap,nil,nil)
+
+	if err != nil {
+		return "",nil,err
+	}
+
+	// Each link is stored both ways, keep the forward one's weight
+
+	sort.SliceStable(links, func(i,j int) bool {
+		return links[i].STType >= 0 && links[j].STType < 0
+	})
+
+	var nptrs []NodePtr
+
+	for _,l := range links {
+
+		key := N4LCanonicalLink(l.NFrom,l.Arr,l.NTo)
+
+		if _,seen := d.remaining[key]; seen {
+			if _,paired := d.inverse[key]; !paired {
+				d.inverse[key] = n4lContext(l.Ctx)
+			}
+			continue
+		}
+
+		l.NFrom,l.Arr,l.NTo = key.NFrom,key.Arr,key.NTo
+		l.Ctx = n4lContext(l.Ctx)
+		d.remaining[key] = l
+		nptrs = append(nptrs,l.NFrom,l.NTo)
+	}
+
+	var pagemap []PageMap
+
+	for page := 1; ; page++ {
+
+		lines,err := TryGetDBPageMap(ctx,chap,nil,page)
+
+		if err != nil {
+			return "",nil,err
+		}
+
+		if len(lines) == 0 {
+			break
+		}
+
+		pagemap = append(pagemap,lines...)
+	}
+
+	// Back into file order
+
+	sort.SliceStable(pagemap, func(i,j int) bool {
+		if pagemap[i].File != pagemap[j].File {
+			return pagemap[i].File < pagemap[j].File
+		}
+		return pagemap[i].Line < pagemap[j].Line
+	})
+
+	for _,pm := range pagemap {
+		for _,l := range pm.Path {
+			nptrs = append(nptrs,l.Dst)
+		}
+	}
+
+	d.nodes,err = TryGetDBNodesByNodePtrs(ctx,nptrs)
+
+	if err != nil {
+		return "",nil,err
+	}
+
+	lines := d.pageMapLines(pagemap)
+	lines = append(lines,d.sequenceLines()...)
+	lines = append(lines,d.leftoverLines()...)
+	lines = append(lines,d.unlinkedLines(pagemap,lines)...)
+
+	var b strings.Builder
+
+	b.WriteString("# N4L recovered from the stored graph")
+
+	if chap != "" {
+		fmt.Fprintf(&b,", chapter %s",strings.ReplaceAll(chap,"\n"," "))
+	}
+
+	b.WriteString("\n")
+
+	d.write(&b,lines)
+
+	return b.String(),d.problems,nil
+}
+
+//**************************************************************
+
+func N4LCanonicalLink(from NodePtr,arr ArrowPtr,to NodePtr) LinkKey {
+
+	// The forward arrow, and one of the two ways round for NEAR links,
+	// so both halves of a stored link have the same key
+
+	sttype := NEAR
+
+	if arr >= 0 && int(arr) < len(ARROW_DIRECTORY) {
+		sttype = STIndexToSTType(ARROW_DIRECTORY[arr].STAindex)
+	}
+
+	if sttype < 0 {
+
+		if inverse,ok := INVERSE_ARROWS[arr]; ok {
+			arr = inverse
+		}
+
+		from,to = to,from
+	}
+
+	if sttype == NEAR {
+
+		if NodePtrLess(to,from) {
+			from,to = to,from
+		}
+
+		if inverse,ok := INVERSE_ARROWS[arr]; ok && inverse < arr {
+			arr = inverse
+		}
+	}
+
+	return LinkKey{NFrom: from,Arr: arr,NTo: to}
+}
+
+//**************************************************************
+
+func N4LItem(s string) (string,bool) {
+
+	// Write a node's text so N4L reads it back unchanged, quoting it if
+	// it would otherwise be cut short. N4L looks at the first character
+	// even inside quotes, so some texts can't be written at all
+
+	if s == "" || s != strings.TrimSpace(s) {
+		return s,false
+	}
+
+	switch s[0] {
+	case '+','-',':','(','@','"':
+		return s,false
+	case '$':
+		if f := strings.Fields(s)[0]; f != "$" && f != "$$" {
+			return s,false   // would be an alias
+		}
+	}
+
+	if s[0] != '"' && s[0] != '\'' && !strings.ContainsAny(s,"()#\n") && !strings.Contains(s,"//") {
+		return s,true
+	}
+
+	if !strings.Contains(s,"\"") {
+		return "\""+s+"\"",true
+	}
+
+	if !strings.Contains(s,"'") {
+		return "'"+s+"'",true
+	}
+
+	return s,false
+}
+
+//**************************************************************
+
+func n4lContext(cn []string) []string {
+
+	var sorted []string
+
+	for _,c := range cn {
+		if c != "" && c != "_sequence_" {
+			sorted = append(sorted,c)
+		}
+	}
+
+	sort.Strings(sorted)
+	return sorted
+}
+
+//**************************************************************
+
+func n4lSameContext(a,b []string) bool {
+
+	return strings.Join(a,",") == strings.Join(b,",")
+}
+
+//**************************************************************
+
+func (d *n4lDecompiler) split(key LinkKey,nan NodeArrowNode) ([]string,[]string) {
+
+	// An arrow like (rents, 20/month) adds to the context of the forward
+	// half only, so what the inverse half lacks was written on the arrow
+
+	base,paired := d.inverse[key]
+
+	if !paired {
+		return nan.Ctx,nil
+	}
+
+	var in = make(map[string]bool)
+	var extra []string
+
+	for _,c := range base {
+		in[c] = true
+	}
+
+	for _,c := range nan.Ctx {
+		if !in[c] {
+			extra = append(extra,c)
+		}
+	}
+
+	return base,extra
+}
+
+//**************************************************************
+
+func (d *n4lDecompiler) take(from NodePtr,link Link,line *N4LLine) bool {
+
+	// Claim a stored link for this line, if it is still unwritten and
+	// can be written here
+
+	key := N4LCanonicalLink(from,link.Arr,link.Dst)
+	nan,ok := d.remaining[key]
+
+	if !ok || key.NFrom == key.NTo {
+		return false
+	}
+
+	forward := key.Arr == link.Arr && key.NFrom == from
+	base,extra := d.split(key,nan)
+
+	// Only the forward arrow carries the weight and extra context
+
+	if !forward && (nan.Wgt != 1 || len(extra) > 0) {
+		return false
+	}
+
+	if line.CtxSet && !n4lSameContext(line.Ctx,base) {
+		return false
+	}
+
+	delete(d.remaining,key)
+
+	if !line.CtxSet {
+		line.Ctx,line.CtxSet = base,true
+	}
+
+	link.Wgt,link.Ctx = 1,nil
+
+	if forward {
+		link.Wgt,link.Ctx = nan.Wgt,extra
+	}
+
+	line.Steps = append(line.Steps,link)
+
+	return true
+}
+
+//**************************************************************
+
+func (d *n4lDecompiler) pageMapLines(pagemap []PageMap) []N4LLine {
+
+	var lines []N4LLine
+
+	for _,pm := range pagemap {
+
+		if len(pm.Path) == 0 {
+			continue
+		}
+
+		line := N4LLine{Chap: pm.Chapter,First: pm.Path[0].Dst}
+		lone := len(pm.Path) == 1
+
+		// A path is a chain, unless something on the line didn't link
+		// from the item before it, so start again there
+
+		for i := 1; i < len(pm.Path); i++ {
+
+			if d.take(pm.Path[i-1].Dst,pm.Path[i],&line) {
+				continue
+			}
+
+			if len(line.Steps) > 0 {
+				lines = append(lines,line)
+			}
+
+			line = N4LLine{Chap: pm.Chapter,First: pm.Path[i].Dst}
+		}
+
+		if len(line.Steps) > 0 || lone {
+			lines = append(lines,line)
+		}
+	}
+
+	// Consecutive lines joined by (then) were written in _sequence_ mode
+
+	then,defined := ARROW_SHORT_DIR["then"]
+
+	if !defined {
+		return lines
+	}
+
+	for k := 1; k < len(lines); k++ {
+
+		prev,this := lines[k-1],&lines[k]
+
+		if prev.Chap != this.Chap || d.nodes[prev.First].S == d.nodes[this.First].S {
+			continue
+		}
+
+		key := N4LCanonicalLink(prev.First,then,this.First)
+		nan,ok := d.remaining[key]
+
+		if !ok || nan.Wgt != 1 || key.NFrom != prev.First {
+			continue
+		}
+
+		base,extra := d.split(key,nan)
+
+		if len(extra) > 0 || this.CtxSet && !n4lSameContext(this.Ctx,base) {
+			continue
+		}
+
+		delete(d.remaining,key)
+
+		this.Seq = true
+
+		if !this.CtxSet {
+			this.Ctx,this.CtxSet = base,true
+		}
+	}
+
+	return lines
+}
+
+//**************************************************************
+
+func (d *n4lDecompiler) sequenceLines() []N4LLine {
+
+	// Chains of three or more items by (then), in the same chapter and
+	// context, go back into _sequence_ mode
+
+	then,defined := ARROW_SHORT_DIR["then"]
+
+	if !defined {
+		return nil
+	}
+
+	var next = make(map[NodePtr][]LinkKey)
+	var incoming = make(map[NodePtr]int)
+
+	for key,nan := range d.remaining {
+		if _,extra := d.split(key,nan); key.Arr == then && nan.Wgt == 1 && len(extra) == 0 {
+			next[key.NFrom] = append(next[key.NFrom],key)
+			incoming[key.NTo]++
+		}
+	}
+
+	var starts []NodePtr
+
+	for n := range next {
+		sort.Slice(next[n], func(i,j int) bool {
+			return NodePtrLess(next[n][i].NTo,next[n][j].NTo)
+		})
+		starts = append(starts,n)
+	}
+
+	// Chain heads first, then whatever is left of any loops
+
+	sort.Slice(starts, func(i,j int) bool {
+		if (incoming[starts[i]] == 0) != (incoming[starts[j]] == 0) {
+			return incoming[starts[i]] == 0
+		}
+		return NodePtrLess(starts[i],starts[j])
+	})
+
+	var lines []N4LLine
+
+	for _,start := range starts {
+
+		for {
+			var chain []LinkKey
+
+			chap := d.nodes[start].Chap
+			here := start
+			cn := []string(nil)
+
+			for {
+				var found bool
+
+				for _,key := range next[here] {
+
+					nan,ok := d.remaining[key]
+
+					if !ok || d.nodes[key.NTo].Chap != chap {
+						continue
+					}
+
+					base,_ := d.split(key,nan)
+
+					if len(chain) > 0 && !n4lSameContext(cn,base) {
+						continue
+					}
+
+					cn = base
+					chain = append(chain,key)
+					delete(d.remaining,key)
+					here = key.NTo
+					found = true
+					break
+				}
+
+				if !found {
+					break
+				}
+			}
+
+			if len(chain) == 0 {
+				break
+			}
+
+			if len(chain) < 2 {
+				d.remaining[chain[0]] = NodeArrowNode{NFrom: chain[0].NFrom,STType: LEADSTO,Arr: then,Wgt: 1,Ctx: cn,NTo: chain[0].NTo}
+				break
+			}
+
+			lines = append(lines,N4LLine{Chap: chap,Ctx: cn,CtxSet: true,First: chain[0].NFrom})
+
+			for _,key := range chain {
+				lines = append(lines,N4LLine{Chap: chap,Ctx: cn,CtxSet: true,First: key.NTo,Seq: true})
+			}
+		}
+	}
+
+	return lines
+}
+
+//**************************************************************
+
+func (d *n4lDecompiler) leftoverLines() []N4LLine {
+
+	// One link per line, by chapter and context
+
+	var lines []N4LLine
+
+	for key,nan := range d.remaining {
+
+		var line N4LLine
+
+		base,extra := d.split(key,nan)
+
+		line.Chap = d.nodes[key.NFrom].Chap
+		line.Ctx,line.CtxSet = base,true
+		line.First = key.NFrom
+		line.Steps = []Link{{Arr: key.Arr,Wgt: nan.Wgt,Ctx: extra,Dst: key.NTo}}
+
+		lines = append(lines,line)
+	}
+
+	sort.Slice(lines, func(i,j int) bool {
+		a,b := lines[i],lines[j]
+		if a.Chap != b.Chap {
+			return a.Chap < b.Chap
+		}
+		if ca,cb := strings.Join(a.Ctx,","),strings.Join(b.Ctx,","); ca != cb {
+			return ca < cb
+		}
+		if a.First != b.First {
+			return NodePtrLess(a.First,b.First)
+		}
+		if a.Steps[0].Arr != b.Steps[0].Arr {
+			return a.Steps[0].Arr < b.Steps[0].Arr
+		}
+		return NodePtrLess(a.Steps[0].Dst,b.Steps[0].Dst)
+	})
+
+	d.remaining = make(map[LinkKey]NodeArrowNode)
+
+	return lines
+}
+
+//**************************************************************
+
+func (d *n4lDecompiler) unlinkedLines(pagemap []PageMap,lines []N4LLine) []N4LLine {
+
+	// Items of the page map on no line yet, because their links were
+	// all to texts not stored (another capitalization of one that is),
+	// each on a line of its own, so as not to lose them
+
+	var written = make(map[NodePtr]bool)
+
+	for _,line := range lines {
+		written[line.First] = true
+		for _,step := range line.Steps {
+			written[step.Dst] = true
+		}
+	}
+
+	var unlinked []N4LLine
+
+	for _,pm := range pagemap {
+		for _,leg := range pm.Path {
+			if _,stored := d.nodes[leg.Dst]; stored && !written[leg.Dst] {
+				written[leg.Dst] = true
+				unlinked = append(unlinked,N4LLine{Chap: pm.Chapter,First: leg.Dst})
+			}
+		}
+	}
+
+	return unlinked
+}
+
+//**************************************************************
+
+func (d *n4lDecompiler) lost(b *strings.Builder,why,what string) {
+
+	what = strings.ReplaceAll(what,"\n"," ")
+	d.problems = append(d.problems,why+": "+what)
+	fmt.Fprintf(b,"# LOST (%s): %s\n",why,what)
+}
+
+//**************************************************************
+
+func (d *n4lDecompiler) write(b *strings.Builder,lines []N4LLine) {
+
+	// The parser's chapter, context and sequence state are followed,
+	// so each is only written when it changes
+
+	var chapter string
+	var state []string
+	var started,seqmode,chapter_ok bool
+
+	for k,line := range lines {
+
+		if !started || line.Chap != chapter {
+
+			started = true
+			chapter = line.Chap
+			seqmode = false
+			chapter_ok = line.Chap != "" && line.Chap[0] != ':' && !strings.ContainsAny(line.Chap,"(#\n") && !strings.Contains(line.Chap,"//")
+
+			if chapter_ok {
+				fmt.Fprintf(b,"\n-%s\n",line.Chap)
+			} else {
+				d.lost(b,"chapter name",line.Chap)
+			}
+		}
+
+		text,why := d.lineText(line)
+
+		if !chapter_ok || why != "" {
+			if chapter_ok {
+				d.lost(b,why,text)
+			}
+			continue
+		}
+
+		if line.CtxSet && !n4lSameContext(line.Ctx,state) {
+			state = d.writeContext(b,state,line.Ctx)
+		}
+
+		starts := !line.Seq && k+1 < len(lines) && lines[k+1].Seq && lines[k+1].Chap == line.Chap
+
+		if seqmode && !line.Seq {
+			b.WriteString("-:: _sequence_ ::\n")
+			seqmode = false
+		}
+
+		if starts {
+			b.WriteString("+:: _sequence_ ::\n")
+			seqmode = true
+		}
+
+		b.WriteString(text+"\n")
+	}
+}
+
+//**************************************************************
+
+func (d *n4lDecompiler) writeContext(b *strings.Builder,state,cn []string) []string {
+
+	var items []string
+
+	for _,c := range cn {
+		if strings.ContainsAny(c,",|:()") {
+			d.lost(b,"context",c)
+		} else {
+			items = append(items,c)
+		}
+	}
+
+	switch {
+
+	case len(items) > 0:
+		fmt.Fprintf(b,"\n :: %s ::\n\n",strings.Join(items,", "))
+
+	case len(state) > 0:
+		fmt.Fprintf(b,"\n -:: %s ::\n\n",strings.Join(state,", "))
+	}
+
+	return cn
+}
+
+//**************************************************************
+
+func (d *n4lDecompiler) lineText(line N4LLine) (string,string) {
+
+	// The line, and why it can't be written if it can't
+
+	var parts []string
+	var why string
+
+	item := func(nptr NodePtr) {
+
+		node,known := d.nodes[nptr]
+		s,ok := N4LItem(node.S)
+
+		if (!known || !ok) && why == "" {
+			why = "node text"
+		}
+
+		parts = append(parts,s)
+	}
+
+	item(line.First)
+
+	from := line.First
+
+	for _,step := range line.Steps {
+
+		arrow := ARROW_DIRECTORY[step.Arr].Short
+
+		if step.Wgt != 1 {
+			arrow += ","+strconv.FormatFloat(step.Wgt,'g',-1,64)
+		}
+
+		for _,c := range step.Ctx {
+
+			// Anything like a number would be read as the weight
+
+			if _,err := strconv.ParseFloat(c,64); (err == nil || strings.ContainsAny(c,",()")) && why == "" {
+				why = "arrow context"
+			}
+
+			arrow += ","+c
+		}
+
+		// N4L refuses to link an item to itself
+
+		if step.Dst == from && why == "" {
+			why = "link to itself"
+		}
+
+		parts = append(parts,"("+arrow+")")
+		item(step.Dst)
+		from = step.Dst
+	}
+
+	return strings.Join(parts," "),why
+}
@@ -0,0 +1,437 @@
+//**************************************************************
+//
+// export.go
+//
+// Turn a result set (a chapter, a cone, a set of path solutions)
+// into a self-contained graph, and write it in formats that other
+// tools can draw or load
+//
+//**************************************************************
+
+package SSTorytime
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+//**************************************************************
+
+type ExportEdge struct {
+
+	From   NodePtr
+	To     NodePtr
+	Arr    ArrowPtr
+	STType int       // never negative, inverse arrows are turned around
+	Wgt    float64
+	Ctx    []string
+}
+
+//**************************************************************
+
+type ExportGraph struct {
+
+	Title    string
+	Nodes    []Node        // in NodePtr order
+	Edges    []ExportEdge
+	Arrows   map[ArrowPtr]ArrowDirectory  // those used, and their inverses
+	Inverses map[ArrowPtr]ArrowPtr
+}
+
+//**************************************************************
+
+func ExportFormats() []string {
+
+	return []string{"dot","turtle","jsonld","graphml","gexf"}
+}
+
+//**************************************************************
+
+func ExportMIMEType(format string) string {
+
+	switch strings.ToLower(format) {
+
+	case "dot":
+		return "text/vnd.graphviz"
+	case "turtle","ttl":
+		return "text/turtle"
+	case "jsonld","json-ld":
+		return "application/ld+json"
+	case "graphml":
+		return "application/graphml+xml"
+	case "gexf":
+		return "application/gexf+xml"
+	}
+
+	return "text/plain"
+}
+
+//**************************************************************
+
+func ExportChapter(ctx PoSST,chap string,cn []string) ExportGraph {
+
+	g,err := TryExportChapter(ctx,chap,cn)
+
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	return g
+}
+
+//**************************************************************
+
+func TryExportChapter(ctx PoSST,chap string,cn []string) (ExportGraph,error) {
+
+	// All the links with both ends in the chapter and a context
+
+	return TryExportMatching(ctx,chap,cn,nil)
+}
+
+//**************************************************************
+
+func ExportMatching(ctx PoSST,chap string,cn []string,arrows []ArrowPtr) ExportGraph {
+
+	g,err := TryExportMatching(ctx,chap,cn,arrows)
+
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	return g
+}
+
+//**************************************************************
+
+func TryExportMatching(ctx PoSST,chap string,cn []string,arrows []ArrowPtr) (ExportGraph,error) {
+
+	// As TryExportChapter, but only the links made by the given arrows
+	// (all if none). Either an arrow or its inverse picks out a link
+
+	links,err := TryGetDBLinksMatching(ctx,chap,cn,arrows)
+
+	if err != nil {
+		return ExportGraph{},err
+	}
+
+	title := chap
+
+	if chap == "" || chap == "any" {
+		title = "SSTorytime"
+	}
+
+	return TryExportLinks(ctx,title,links)
+}
+
+//**************************************************************
+
+func ExportPaths(ctx PoSST,title string,paths [][]Link) ExportGraph {
+
+	g,err := TryExportPaths(ctx,title,paths)
+
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	return g
+}
+
+//**************************************************************
+
+func TryExportPaths(ctx PoSST,title string,paths [][]Link) (ExportGraph,error) {
+
+	// The links along paths from a cone or a path solution, where each
+	// path starts with its first node as a link with no arrow
+
+	var links []NodeArrowNode
+
+	for _,path := range paths {
+		for i := 1; i < len(path); i++ {
+
+			arrow,err := TryGetDBArrowByPtr(ctx,path[i].Arr)
+
+			if err != nil {
+				return ExportGraph{},err
+			}
+
+			var nan NodeArrowNode
+
+			nan.NFrom = path[i-1].Dst
+			nan.NTo = path[i].Dst
+			nan.Arr = path[i].Arr
+			nan.STType = STIndexToSTType(arrow.STAindex)
+			nan.Wgt = float64(path[i].Wgt)
+			nan.Ctx = path[i].Ctx
+
+			links = append(links,nan)
+		}
+	}
+
+	return TryExportLinks(ctx,title,links)
+}
+
+//**************************************************************
+
+func TryExportLinks(ctx PoSST,title string,links []NodeArrowNode) (ExportGraph,error) {
+
+	// Links come in pairs with their inverses, so keep only the forward
+	// one, and one of the two ways round for NEAR links
+
+	var g ExportGraph
+	var seen = make(map[LinkKey]bool)
+	var nptrs []NodePtr
+	var known = make(map[NodePtr]bool)
+
+	g.Title = title
+	g.Arrows = make(map[ArrowPtr]ArrowDirectory)
+	g.Inverses = make(map[ArrowPtr]ArrowPtr)
+
+	if len(links) > 0 && ARROW_DIRECTORY_TOP == 0 {
+
+		err := TryDownloadArrowsFromDB(ctx)
+
+		if err != nil {
+			return g,err
+		}
+	}
+
+	for _,l := range links {
+
+		var e ExportEdge
+
+		e.From,e.To,e.Arr,e.STType = l.NFrom,l.NTo,l.Arr,l.STType
+		e.Wgt,e.Ctx = l.Wgt,l.Ctx
+
+		if e.STType < 0 {
+
+			if inverse,ok := INVERSE_ARROWS[e.Arr]; ok {
+				e.Arr = inverse
+			}
+
+			e.From,e.To = e.To,e.From
+			e.STType = -e.STType
+		}
+
+		if e.STType == NEAR {
+
+			if NodePtrLess(e.To,e.From) {
+				e.From,e.To = e.To,e.From
+			}
+
+			if inverse,ok := INVERSE_ARROWS[e.Arr]; ok && inverse < e.Arr {
+				e.Arr = inverse
+			}
+		}
+
+		key := LinkKey{NFrom: e.From,Arr: e.Arr,NTo: e.To}
+
+		if seen[key] {
+			continue
+		}
+
+		seen[key] = true
+		g.Edges = append(g.Edges,e)
+
+		if e.Arr >= 0 && int(e.Arr) < len(ARROW_DIRECTORY) {
+
+			g.Arrows[e.Arr] = ARROW_DIRECTORY[e.Arr]
+
+			if inverse,ok := INVERSE_ARROWS[e.Arr]; ok && int(inverse) < len(ARROW_DIRECTORY) {
+				g.Arrows[inverse] = ARROW_DIRECTORY[inverse]
+				g.Inverses[e.Arr] = inverse
+				g.Inverses[inverse] = e.Arr
+			}
+		}
+
+		for _,n := range []NodePtr{e.From,e.To} {
+			if !known[n] {
+				known[n] = true
+				nptrs = append(nptrs,n)
+			}
+		}
+	}
+
+	nodes,err := TryGetDBNodesByNodePtrs(ctx,nptrs)
+
+	if err != nil {
+		return g,err
+	}
+
+	for _,nptr := range nptrs {
+		if n,ok := nodes[nptr]; ok {
+			g.Nodes = append(g.Nodes,n)
+		}
+	}
+
+	sort.Slice(g.Nodes, func(i,j int) bool {
+		return NodePtrLess(g.Nodes[i].NPtr,g.Nodes[j].NPtr)
+	})
+
+	sort.SliceStable(g.Edges, func(i,j int) bool {
+		if g.Edges[i].From != g.Edges[j].From {
+			return NodePtrLess(g.Edges[i].From,g.Edges[j].From)
+		}
+		if g.Edges[i].To != g.Edges[j].To {
+			return NodePtrLess(g.Edges[i].To,g.Edges[j].To)
+		}
+		return g.Edges[i].Arr < g.Edges[j].Arr
+	})
+
+	return g,nil
+}
+
+//**************************************************************
+
+func SelectExportArrows(g ExportGraph,arrows []ArrowPtr) ExportGraph {
+
+	// Keep only the edges made by the given arrows, or their inverses,
+	// and the nodes they join. No arrows keeps everything
+
+	if len(arrows) == 0 {
+		return g
+	}
+
+	var wanted = make(map[ArrowPtr]bool)
+
+	for _,a := range arrows {
+
+		wanted[a] = true
+
+		if inverse,ok := INVERSE_ARROWS[a]; ok {
+			wanted[inverse] = true
+		}
+	}
+
+	var selected = g
+	var linked = make(map[NodePtr]bool)
+
+	selected.Nodes = nil
+	selected.Edges = nil
+	selected.Arrows = make(map[ArrowPtr]ArrowDirectory)
+	selected.Inverses = make(map[ArrowPtr]ArrowPtr)
+
+	for _,e := range g.Edges {
+
+		if !wanted[e.Arr] {
+			continue
+		}
+
+		selected.Edges = append(selected.Edges,e)
+		linked[e.From] = true
+		linked[e.To] = true
+
+		if arrow,ok := g.Arrows[e.Arr]; ok {
+			selected.Arrows[e.Arr] = arrow
+		}
+
+		if inverse,ok := g.Inverses[e.Arr]; ok {
+			selected.Arrows[inverse] = g.Arrows[inverse]
+			selected.Inverses[e.Arr] = inverse
+			selected.Inverses[inverse] = e.Arr
+		}
+	}
+
+	for _,n := range g.Nodes {
+		if linked[n.NPtr] {
+			selected.Nodes = append(selected.Nodes,n)
+		}
+	}
+
+	return selected
+}
+
+//**************************************************************
+
+func FormatGraph(g ExportGraph,format string) string {
+
+	s,err := TryFormatGraph(g,format)
+
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	return s
+}
+
+//**************************************************************
+
+func TryFormatGraph(g ExportGraph,format string) (string,error) {
+
+	switch strings.ToLower(format) {
+
+	case "dot":
+		return ExportDOT(g),nil
+	case "turtle","ttl":
+		return ExportTurtle(g),nil
+	case "jsonld","json-ld":
+		return ExportJSONLD(g)
+	case "graphml":
+		return ExportGraphML(g),nil
+	case "gexf":
+		return ExportGEXF(g),nil
+	}
+
+	return "",fmt.Errorf("%w: %s, try one of %s",ErrNoSuchFormat,format,strings.Join(ExportFormats(),", "))
+}
+
+//**************************************************************
+
+func ExportDOT(g ExportGraph) string {
+
+	// Graphviz, with the edge style showing the STtype: leadsto solid,
+	// contains dashed, express dotted, and near without a direction
+
+	var b strings.Builder
+
+	fmt.Fprintf(&b,"digraph %s {\n",DOTString(g.Title))
+	fmt.Fprintf(&b,"  node [shape=box,style=rounded];\n")
+
+	for _,n := range g.Nodes {
+		fmt.Fprintf(&b,"  %s [label=%s,tooltip=%s];\n",DOTNodeId(n.NPtr),DOTString(n.S),DOTString(n.Chap))
+	}
+
+	for _,e := range g.Edges {
+
+		var attr []string
+
+		if arrow,ok := g.Arrows[e.Arr]; ok {
+			attr = append(attr,"label="+DOTString(arrow.Long))
+		}
+
+		switch e.STType {
+		case NEAR:
+			attr = append(attr,"dir=none")
+		case LEADSTO:
+			attr = append(attr,"style=solid")
+		case CONTAINS:
+			attr = append(attr,"style=dashed")
+		case EXPRESS:
+			attr = append(attr,"style=dotted")
+		}
+
+		fmt.Fprintf(&b,"  %s -> %s [%s];\n",DOTNodeId(e.From),DOTNodeId(e.To),strings.Join(attr,","))
+	}
+
+	b.WriteString("}\n")
+
+	return b.String()
+}
+
+//**************************************************************
+
+func DOTNodeId(nptr NodePtr) string {
+
+	return fmt.Sprintf("n%d_%d",nptr.Class,nptr.CPtr)
+}
+
+//**************************************************************
+
+func DOTString(s string) string {
+
+	s = strings.ReplaceAll(s,"\\","\\\\")
+	s = strings.ReplaceAll(s,"\"","\\\"")
+	s = strings.ReplaceAll(s,"\n","\\n")
+
+	return "\"" + s + "\""
+}
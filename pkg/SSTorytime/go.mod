@@ -3,3 +3,10 @@ module SSTorytime
 go 1.24.2
 
 require github.com/lib/pq v1.10.9
+
+replace N4L => ../N4L
+
+require (
+	N4L v0.0.0-00010101000000-000000000000
+	golang.org/x/text v0.24.0 // indirect
+)
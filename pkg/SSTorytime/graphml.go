@@ -0,0 +1,209 @@
+//**************************************************************
+//
+// graphml.go
+//
+// Export as GraphML and GEXF, the XML formats read by network
+// analysis tools like Gephi, Cytoscape, yEd and networkx, with
+// the node and link attributes kept as data columns
+//
+//**************************************************************
+
+package SSTorytime
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+//**************************************************************
+
+func TextSizeClassName(class int) string {
+
+	switch class {
+	case N1GRAM:
+		return "n1gram"
+	case N2GRAM:
+		return "n2gram"
+	case N3GRAM:
+		return "n3gram"
+	case LT128:
+		return "lt128"
+	case LT1024:
+		return "lt1024"
+	case GT1024:
+		return "gt1024"
+	}
+
+	return "unknown"
+}
+
+//**************************************************************
+
+func XMLString(s string) string {
+
+	// Escaped for both text and attribute values
+
+	var b strings.Builder
+	xml.EscapeText(&b,[]byte(s))
+	return b.String()
+}
+
+//**************************************************************
+
+func exportEdgeNames(g ExportGraph,e ExportEdge) (string,string) {
+
+	if arrow,ok := g.Arrows[e.Arr]; ok {
+		return arrow.Long,arrow.Short
+	}
+
+	return "",""
+}
+
+//**************************************************************
+
+func ExportGraphML(g ExportGraph) string {
+
+	// NEAR links are the only undirected edges
+
+	var b strings.Builder
+
+	b.WriteString("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	b.WriteString("<graphml xmlns=\"http://graphml.graphdrawing.org/xmlns\"")
+	b.WriteString(" xmlns:xsi=\"http://www.w3.org/2001/XMLSchema-instance\"")
+	b.WriteString(" xsi:schemaLocation=\"http://graphml.graphdrawing.org/xmlns http://graphml.graphdrawing.org/xmlns/1.0/graphml.xsd\">\n")
+
+	var keys = [][3]string{
+		{"node","label","string"},
+		{"node","chapter","string"},
+		{"node","class","int"},
+		{"node","sizeclass","string"},
+		{"node","nptr","string"},
+		{"edge","arrow","string"},
+		{"edge","short","string"},
+		{"edge","sttype","int"},
+		{"edge","stname","string"},
+		{"edge","weight","double"},
+		{"edge","context","string"},
+	}
+
+	for _,k := range keys {
+		fmt.Fprintf(&b,"  <key id=\"%s\" for=\"%s\" attr.name=\"%s\" attr.type=\"%s\"/>\n",k[1],k[0],k[1],k[2])
+	}
+
+	fmt.Fprintf(&b,"  <graph id=\"%s\" edgedefault=\"directed\">\n",XMLString(g.Title))
+
+	for _,n := range g.Nodes {
+
+		fmt.Fprintf(&b,"    <node id=\"%s\">\n",DOTNodeId(n.NPtr))
+		fmt.Fprintf(&b,"      <data key=\"label\">%s</data>\n",XMLString(n.S))
+		fmt.Fprintf(&b,"      <data key=\"chapter\">%s</data>\n",XMLString(n.Chap))
+		fmt.Fprintf(&b,"      <data key=\"class\">%d</data>\n",n.NPtr.Class)
+		fmt.Fprintf(&b,"      <data key=\"sizeclass\">%s</data>\n",TextSizeClassName(n.NPtr.Class))
+		fmt.Fprintf(&b,"      <data key=\"nptr\">(%d,%d)</data>\n",n.NPtr.Class,n.NPtr.CPtr)
+		b.WriteString("    </node>\n")
+	}
+
+	for i,e := range g.Edges {
+
+		long,short := exportEdgeNames(g,e)
+
+		directed := ""
+
+		if e.STType == NEAR {
+			directed = " directed=\"false\""
+		}
+
+		fmt.Fprintf(&b,"    <edge id=\"e%d\" source=\"%s\" target=\"%s\"%s>\n",i,DOTNodeId(e.From),DOTNodeId(e.To),directed)
+		fmt.Fprintf(&b,"      <data key=\"arrow\">%s</data>\n",XMLString(long))
+		fmt.Fprintf(&b,"      <data key=\"short\">%s</data>\n",XMLString(short))
+		fmt.Fprintf(&b,"      <data key=\"sttype\">%d</data>\n",e.STType)
+		fmt.Fprintf(&b,"      <data key=\"stname\">%s</data>\n",STTypeProperty(e.STType))
+		fmt.Fprintf(&b,"      <data key=\"weight\">%g</data>\n",e.Wgt)
+		fmt.Fprintf(&b,"      <data key=\"context\">%s</data>\n",XMLString(strings.Join(e.Ctx,",")))
+		b.WriteString("    </edge>\n")
+	}
+
+	b.WriteString("  </graph>\n")
+	b.WriteString("</graphml>\n")
+
+	return b.String()
+}
+
+//**************************************************************
+
+func ExportGEXF(g ExportGraph) string {
+
+	// GEXF 1.2, as read by Gephi, with the arrow as the edge label
+
+	var b strings.Builder
+
+	b.WriteString("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	b.WriteString("<gexf xmlns=\"http://www.gexf.net/1.2draft\" version=\"1.2\">\n")
+	b.WriteString("  <meta>\n")
+	b.WriteString("    <creator>SSTorytime</creator>\n")
+	fmt.Fprintf(&b,"    <description>%s</description>\n",XMLString(g.Title))
+	b.WriteString("  </meta>\n")
+	b.WriteString("  <graph mode=\"static\" defaultedgetype=\"directed\">\n")
+
+	var node_attr = [][2]string{{"chapter","string"},{"class","integer"},{"sizeclass","string"},{"nptr","string"}}
+	var edge_attr = [][2]string{{"arrow","string"},{"short","string"},{"sttype","integer"},{"stname","string"},{"context","string"}}
+
+	b.WriteString("    <attributes class=\"node\">\n")
+
+	for i,a := range node_attr {
+		fmt.Fprintf(&b,"      <attribute id=\"%d\" title=\"%s\" type=\"%s\"/>\n",i,a[0],a[1])
+	}
+
+	b.WriteString("    </attributes>\n")
+	b.WriteString("    <attributes class=\"edge\">\n")
+
+	for i,a := range edge_attr {
+		fmt.Fprintf(&b,"      <attribute id=\"%d\" title=\"%s\" type=\"%s\"/>\n",i,a[0],a[1])
+	}
+
+	b.WriteString("    </attributes>\n")
+	b.WriteString("    <nodes>\n")
+
+	for _,n := range g.Nodes {
+
+		fmt.Fprintf(&b,"      <node id=\"%s\" label=\"%s\">\n",DOTNodeId(n.NPtr),XMLString(n.S))
+		b.WriteString("        <attvalues>\n")
+		fmt.Fprintf(&b,"          <attvalue for=\"0\" value=\"%s\"/>\n",XMLString(n.Chap))
+		fmt.Fprintf(&b,"          <attvalue for=\"1\" value=\"%d\"/>\n",n.NPtr.Class)
+		fmt.Fprintf(&b,"          <attvalue for=\"2\" value=\"%s\"/>\n",TextSizeClassName(n.NPtr.Class))
+		fmt.Fprintf(&b,"          <attvalue for=\"3\" value=\"(%d,%d)\"/>\n",n.NPtr.Class,n.NPtr.CPtr)
+		b.WriteString("        </attvalues>\n")
+		b.WriteString("      </node>\n")
+	}
+
+	b.WriteString("    </nodes>\n")
+	b.WriteString("    <edges>\n")
+
+	for i,e := range g.Edges {
+
+		long,short := exportEdgeNames(g,e)
+
+		kind := ""
+
+		if e.STType == NEAR {
+			kind = " type=\"undirected\""
+		}
+
+		fmt.Fprintf(&b,"      <edge id=\"%d\" source=\"%s\" target=\"%s\" label=\"%s\" weight=\"%g\"%s>\n",i,DOTNodeId(e.From),DOTNodeId(e.To),XMLString(long),e.Wgt,kind)
+		b.WriteString("        <attvalues>\n")
+		fmt.Fprintf(&b,"          <attvalue for=\"0\" value=\"%s\"/>\n",XMLString(long))
+		fmt.Fprintf(&b,"          <attvalue for=\"1\" value=\"%s\"/>\n",XMLString(short))
+		fmt.Fprintf(&b,"          <attvalue for=\"2\" value=\"%d\"/>\n",e.STType)
+		fmt.Fprintf(&b,"          <attvalue for=\"3\" value=\"%s\"/>\n",STTypeProperty(e.STType))
+		fmt.Fprintf(&b,"          <attvalue for=\"4\" value=\"%s\"/>\n",XMLString(strings.Join(e.Ctx,",")))
+		b.WriteString("        </attvalues>\n")
+		b.WriteString("      </edge>\n")
+	}
+
+	b.WriteString("    </edges>\n")
+	b.WriteString("  </graph>\n")
+	b.WriteString("</gexf>\n")
+
+	return b.String()
+}
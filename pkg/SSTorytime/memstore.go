@@ -0,0 +1,2073 @@
+//**************************************************************
+//
+// A pure Go, in-memory GraphStore with the same semantics as
+// the postgres tables and stored functions, for experiments and
+// testing without a running database
+//
+//**************************************************************
+
+package SSTorytime
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+//**************************************************************
+
+type MemStore struct {
+
+	Filename string            // if set, Close() saves a snapshot here
+
+	Nodes    map[NodePtr]*Node // the Node table
+	Links    []NodeArrowNode   // the NodeArrowNode table
+	PageMap  []PageMap
+	Arrows   []ArrowDirectory
+	Inverses map[ArrowPtr]ArrowPtr
+
+	SourceNodes map[string][]NodePtr  // provenance by file
+	SourceLinks map[string][]LinkKey
+
+	Centrality  map[string][]Centrality  // saved scores by scope
+
+	text     map[string]NodePtr    // exact text index, like WHERE s = ...
+	lower    map[string]NodePtr    // lower case text index
+	nan      map[LinkKey]bool   // idempotence of NodeArrowNode
+	top      map[int]ClassedNodePtr
+	dirty    bool
+}
+
+//**************************************************************
+
+type MemSnapshot struct {
+
+	// The on-disk form of a MemStore, see Save() and Load()
+
+	Nodes    []Node
+	Links    []NodeArrowNode
+	PageMap  []PageMap
+	Arrows   []ArrowDirectory
+	Inverses map[ArrowPtr]ArrowPtr
+
+	SourceNodes map[string][]NodePtr
+	SourceLinks map[string][]LinkKey
+
+	Centrality  map[string][]Centrality
+}
+
+//**************************************************************
+
+func NewMemStore() *MemStore {
+
+	var m MemStore
+
+	m.Nodes = make(map[NodePtr]*Node)
+	m.Inverses = make(map[ArrowPtr]ArrowPtr)
+	m.SourceNodes = make(map[string][]NodePtr)
+	m.SourceLinks = make(map[string][]LinkKey)
+	m.Centrality = make(map[string][]Centrality)
+	m.text = make(map[string]NodePtr)
+	m.lower = make(map[string]NodePtr)
+	m.nan = make(map[LinkKey]bool)
+	m.top = make(map[int]ClassedNodePtr)
+
+	return &m
+}
+
+//**************************************************************
+
+func OpenMemStore(filename string,load_arrows bool) PoSST {
+
+	ctx,err := TryOpenMemStore(filename,load_arrows)
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	return ctx
+}
+
+//**************************************************************
+
+func TryOpenMemStore(filename string,load_arrows bool) (PoSST,error) {
+
+	// Start from the snapshot in filename, if any, and write it back on Close()
+
+	var ctx PoSST
+
+	MemoryInit()
+
+	m := NewMemStore()
+	m.Filename = filename
+
+	_,err := os.Stat(filename)
+
+	if err == nil && !WIPE_DB {
+		err = m.Load(filename)
+		if err != nil {
+			return ctx,err
+		}
+	}
+
+	if WIPE_DB {
+		m.dirty = true
+	}
+
+	ctx.Store = m
+
+	if load_arrows {
+		err = m.DownloadArrows()
+		if err != nil {
+			return ctx,err
+		}
+	}
+
+	NO_NODE_PTR.Class = 0
+	NO_NODE_PTR.CPtr =  -1
+
+	return ctx,nil
+}
+
+//**************************************************************
+
+func OpenMemoryGraph() PoSST {
+
+	ctx,err := TryOpenMemoryGraph()
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	return ctx
+}
+
+//**************************************************************
+
+func TryOpenMemoryGraph() (PoSST,error) {
+
+	// A store holding just the graph compiled in memory by N4L, so the
+	// library can query it without a database. Nothing is saved
+
+	var ctx PoSST
+
+	m := NewMemStore()
+	upload,_ := MemoryGraphUpload(nil,nil)
+
+	err := m.UploadGraph(upload)
+
+	if err != nil {
+		return ctx,err
+	}
+
+	ctx.Store = m
+
+	NO_NODE_PTR.Class = 0
+	NO_NODE_PTR.CPtr =  -1
+
+	return ctx,nil
+}
+
+//**************************************************************
+
+func (m *MemStore) Load(filename string) error {
+
+	content,err := os.ReadFile(filename)
+
+	if err != nil {
+		return fmt.Errorf("%w: reading graph snapshot: %v",ErrDBConnection,err)
+	}
+
+	var snap MemSnapshot
+
+	err = json.Unmarshal(content,&snap)
+
+	if err != nil {
+		return fmt.Errorf("%w: parsing graph snapshot %s: %v",ErrDBConnection,filename,err)
+	}
+
+	for n := range snap.Nodes {
+		node := snap.Nodes[n]
+		m.insertNode(&node)
+	}
+
+	for l := range snap.Links {
+		m.insertLink(snap.Links[l])
+	}
+
+	m.PageMap = snap.PageMap
+	m.Arrows = snap.Arrows
+
+	for plus,minus := range snap.Inverses {
+		m.Inverses[plus] = minus
+	}
+
+	for file := range snap.SourceNodes {
+		m.SourceNodes[file] = snap.SourceNodes[file]
+	}
+
+	for file := range snap.SourceLinks {
+		m.SourceLinks[file] = snap.SourceLinks[file]
+	}
+
+	for scope := range snap.Centrality {
+		m.Centrality[scope] = snap.Centrality[scope]
+	}
+
+	// Just as it is on disk, so a read-only run doesn't write it again
+
+	m.dirty = false
+	return nil
+}
+
+//**************************************************************
+
+func (m *MemStore) Save(filename string) error {
+
+	var snap MemSnapshot
+
+	for _,n := range m.Nodes {
+		snap.Nodes = append(snap.Nodes,*n)
+	}
+
+	sort.Slice(snap.Nodes, func(i,j int) bool {
+		return NodePtrLess(snap.Nodes[i].NPtr,snap.Nodes[j].NPtr)
+	})
+
+	snap.Links = m.Links
+	snap.PageMap = m.PageMap
+	snap.Arrows = m.Arrows
+	snap.Inverses = m.Inverses
+	snap.SourceNodes = m.SourceNodes
+	snap.SourceLinks = m.SourceLinks
+	snap.Centrality = m.Centrality
+
+	content,err := json.Marshal(snap)
+
+	if err != nil {
+		return fmt.Errorf("%w: encoding graph snapshot: %v",ErrDBQuery,err)
+	}
+
+	// Write a new file and rename it over the old one, so that an
+	// interrupted run can't leave half a snapshot
+
+	tmp,err := os.CreateTemp(filepath.Dir(filename),filepath.Base(filename)+".*.tmp")
+
+	if err != nil {
+		return fmt.Errorf("%w: writing graph snapshot: %v",ErrDBQuery,err)
+	}
+
+	_,err = tmp.Write(content)
+
+	if err == nil {
+		err = tmp.Sync()
+	}
+
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		err = os.Chmod(tmp.Name(),0644)
+	}
+
+	if err == nil {
+		err = os.Rename(tmp.Name(),filename)
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("%w: writing graph snapshot: %v",ErrDBQuery,err)
+	}
+
+	m.dirty = false
+	return nil
+}
+
+//**************************************************************
+
+func (m *MemStore) Close() error {
+
+	if m.Filename != "" && m.dirty {
+		return m.Save(m.Filename)
+	}
+
+	return nil
+}
+
+//**************************************************************
+
+func (m *MemStore) insertNode(n *Node) {
+
+	m.Nodes[n.NPtr] = n
+	m.text[n.S] = n.NPtr
+
+	if _,exists := m.lower[strings.ToLower(n.S)]; !exists {
+		m.lower[strings.ToLower(n.S)] = n.NPtr
+	}
+
+	if top,ok := m.top[n.NPtr.Class]; !ok || n.NPtr.CPtr > top {
+		m.top[n.NPtr.Class] = n.NPtr.CPtr
+	}
+
+	m.dirty = true
+}
+
+//**************************************************************
+
+func (m *MemStore) insertLink(nan NodeArrowNode) {
+
+	key := LinkKey{NFrom: nan.NFrom, Arr: nan.Arr, NTo: nan.NTo}
+
+	if m.nan[key] {
+		return
+	}
+
+	m.nan[key] = true
+	m.Links = append(m.Links,nan)
+	m.dirty = true
+}
+
+//**************************************************************
+// Upload
+//**************************************************************
+
+func (m *MemStore) CreateNode(n Node) (Node,error) {
+
+	// As IdempInsertNode(): keep the caller's NPtr unless the text exists
+
+	n.L,n.NPtr.Class = StorageClass(n.S)
+
+	if nptr,exists := m.text[n.S]; exists {
+		n.NPtr = nptr
+		return n,nil
+	}
+
+	if _,exists := m.lower[strings.ToLower(n.S)]; exists {
+		n.NPtr = NodePtr{}
+		return n,nil
+	}
+
+	if other,used := m.Nodes[n.NPtr]; used {
+		return n,fmt.Errorf("%w: duplicate key %v for (%s) and (%s)",ErrDBQuery,n.NPtr,n.S,other.S)
+	}
+
+	node := n
+	m.insertNode(&node)
+	return n,nil
+}
+
+//**************************************************************
+
+func (m *MemStore) IdempAddNode(n Node) (Node,error) {
+
+	// As IdempAppendNode(): allocate the next free pointer in the class
+
+	n.L,n.NPtr.Class = StorageClass(n.S)
+
+	if nptr,exists := m.text[n.S]; exists {
+		n.NPtr = nptr
+		return n,nil
+	}
+
+	n.NPtr.CPtr = 0
+
+	if top,ok := m.top[n.NPtr.Class]; ok {
+		n.NPtr.CPtr = top + 1
+	}
+
+	node := n
+	m.insertNode(&node)
+	return n,nil
+}
+
+//**************************************************************
+
+func (m *MemStore) AppendLinkToNode(nptr NodePtr,lnk Link,sttype int) (bool,error) {
+
+	if sttype < -EXPRESS || sttype > EXPRESS {
+		return false,fmt.Errorf("%w: %d",ErrSTOutOfBounds,sttype)
+	}
+
+	if nptr == lnk.Dst {
+		return false,nil
+	}
+
+	node,exists := m.Nodes[nptr]
+
+	if !exists {
+		return true,nil
+	}
+
+	lnk.Ctx = SortedContext(lnk.Ctx)
+
+	stindex := STTypeToSTIndex(sttype)
+
+	for l := range node.I[stindex] {
+		if SameLink(node.I[stindex][l],lnk) {
+			return true,nil
+		}
+	}
+
+	node.I[stindex] = append(node.I[stindex],lnk)
+	m.dirty = true
+
+	return true,nil
+}
+
+//**************************************************************
+
+func (m *MemStore) CreateNodeArrowNode(org NodePtr,dst Link,sttype int) error {
+
+	var nan NodeArrowNode
+
+	nan.NFrom = org
+	nan.STType = sttype
+	nan.Arr = dst.Arr
+	nan.Wgt = dst.Wgt
+	nan.Ctx = SortedContext(dst.Ctx)
+	nan.NTo = dst.Dst
+
+	m.insertLink(nan)
+	return nil
+}
+
+//**************************************************************
+
+func (m *MemStore) AddArrow(ad ArrowDirectory) error {
+
+	// Arrows are unique by pointer, long and short name, as in the table
+
+	for a := range m.Arrows {
+		if m.Arrows[a].Long == ad.Long || m.Arrows[a].Short == ad.Short || m.Arrows[a].Ptr == ad.Ptr {
+			return fmt.Errorf("%w: Failed to insert arrow: duplicate key (%s)",ErrDBQuery,ad.Long)
+		}
+	}
+
+	m.Arrows = append(m.Arrows,ad)
+
+	sort.Slice(m.Arrows, func(i,j int) bool {
+		return m.Arrows[i].Ptr < m.Arrows[j].Ptr
+	})
+
+	m.dirty = true
+	return nil
+}
+
+//**************************************************************
+
+func (m *MemStore) AddInverseArrow(plus,minus ArrowPtr) error {
+
+	if stored,ok := m.Inverses[plus]; ok && stored == minus {
+		return fmt.Errorf("%w: Failed to insert inverse arrow: duplicate key (%d,%d)",ErrDBQuery,plus,minus)
+	}
+
+	m.Inverses[plus] = minus
+	m.dirty = true
+	return nil
+}
+
+//**************************************************************
+
+func (m *MemStore) AddPageMapEvent(line PageMap) error {
+
+	// Replace rather than duplicate a line that is uploaded again
+
+	for l := range m.PageMap {
+		if m.PageMap[l].Chapter == line.Chapter && m.PageMap[l].Line == line.Line && m.PageMap[l].File == line.File {
+			m.PageMap[l] = line
+			m.dirty = true
+			return nil
+		}
+	}
+
+	m.PageMap = append(m.PageMap,line)
+	m.dirty = true
+	return nil
+}
+
+//**************************************************************
+
+func (m *MemStore) UploadGraph(upload GraphUpload) error {
+
+	// Nothing to batch in memory, so store item by item as UploadNodeToDB() did
+
+	const nolink = 999
+	var empty Link
+
+	for _,node := range upload.Nodes {
+
+		_,err := m.CreateNode(node)
+
+		if err != nil {
+			return err
+		}
+
+		for stindex := range node.I {
+			for _,lnk := range node.I[stindex] {
+
+				sttype := STIndexToSTType(stindex)
+
+				_,err = m.AppendLinkToNode(node.NPtr,lnk,sttype)
+
+				if err != nil {
+					return err
+				}
+
+				m.CreateNodeArrowNode(node.NPtr,lnk,sttype)
+			}
+		}
+
+		m.CreateNodeArrowNode(node.NPtr,empty,nolink)
+	}
+
+	for _,ad := range upload.Arrows {
+
+		exists := false
+
+		for a := range m.Arrows {
+			if m.Arrows[a].Ptr == ad.Ptr && m.Arrows[a].Long == ad.Long {
+				exists = true
+				break
+			}
+		}
+
+		if !exists {
+			err := m.AddArrow(ad)
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for plus,minus := range upload.Inverses {
+		m.Inverses[plus] = minus
+	}
+
+	for _,line := range upload.PageMap {
+		m.AddPageMapEvent(line)
+	}
+
+	m.dirty = true
+	return nil
+}
+
+//**************************************************************
+
+func (m *MemStore) DownloadArrows() error {
+
+	// Install the saved arrows in the global directories, as DownloadArrowsFromDB()
+
+	if ARROW_DIRECTORY_TOP != 0 {
+		return nil
+	}
+
+	for a := range m.Arrows {
+
+		ad := m.Arrows[a]
+
+		if ad.Ptr != ARROW_DIRECTORY_TOP {
+			return fmt.Errorf("%w: %v %d %d",ErrArrowMismatch,ad,ad.Ptr,ARROW_DIRECTORY_TOP)
+		}
+
+		ARROW_DIRECTORY = append(ARROW_DIRECTORY,ad)
+		ARROW_SHORT_DIR[ad.Short] = ARROW_DIRECTORY_TOP
+		ARROW_LONG_DIR[ad.Long] = ARROW_DIRECTORY_TOP
+		ARROW_DIRECTORY_TOP++
+	}
+
+	for plus,minus := range m.Inverses {
+		INVERSE_ARROWS[plus] = minus
+	}
+
+	return nil
+}
+
+//**************************************************************
+
+func (m *MemStore) GetArrowDirectory() ([]ArrowDirectory,map[ArrowPtr]ArrowPtr,error) {
+
+	var inverses = make(map[ArrowPtr]ArrowPtr)
+
+	for plus,minus := range m.Inverses {
+		inverses[plus] = minus
+	}
+
+	return append([]ArrowDirectory{},m.Arrows...),inverses,nil
+}
+
+//**************************************************************
+// Deletion
+//**************************************************************
+
+func (m *MemStore) RemoveLink(key LinkKey) error {
+
+	// As TryRemoveDBLink()
+
+	if n,exists := m.Nodes[key.NFrom]; exists {
+		for stindex := range n.I {
+			var keep []Link
+			for _,lnk := range n.I[stindex] {
+				if lnk.Arr != key.Arr || lnk.Dst != key.NTo {
+					keep = append(keep,lnk)
+				}
+			}
+			n.I[stindex] = keep
+		}
+	}
+
+	if m.nan[key] {
+
+		delete(m.nan,key)
+
+		var links []NodeArrowNode
+
+		for _,nan := range m.Links {
+			if nan.NFrom != key.NFrom || nan.Arr != key.Arr || nan.NTo != key.NTo {
+				links = append(links,nan)
+			}
+		}
+
+		m.Links = links
+	}
+
+	for file := range m.SourceLinks {
+
+		var keep []LinkKey
+
+		for _,other := range m.SourceLinks[file] {
+			if other != key {
+				keep = append(keep,other)
+			}
+		}
+
+		m.SourceLinks[file] = keep
+	}
+
+	m.dirty = true
+	return nil
+}
+
+//**************************************************************
+
+func (m *MemStore) DeleteNode(nptr NodePtr) error {
+
+	// As TryDeleteDBNode()
+
+	if _,exists := m.Nodes[nptr]; !exists {
+		return fmt.Errorf("%w: %v",ErrNoSuchNode,nptr)
+	}
+
+	m.deleteNode(nptr)
+
+	for _,n := range m.Nodes {
+		for stindex := range n.I {
+			var keep []Link
+			for _,lnk := range n.I[stindex] {
+				if lnk.Dst != nptr {
+					keep = append(keep,lnk)
+				}
+			}
+			n.I[stindex] = keep
+		}
+	}
+
+	var links []NodeArrowNode
+
+	for _,nan := range m.Links {
+		if nan.NFrom == nptr || nan.NTo == nptr {
+			delete(m.nan,LinkKey{NFrom: nan.NFrom, Arr: nan.Arr, NTo: nan.NTo})
+		} else {
+			links = append(links,nan)
+		}
+	}
+
+	m.Links = links
+
+	for l := range m.PageMap {
+		var keep []Link
+		for _,lnk := range m.PageMap[l].Path {
+			if lnk.Dst != nptr {
+				keep = append(keep,lnk)
+			}
+		}
+		m.PageMap[l].Path = keep
+	}
+
+	for file := range m.SourceNodes {
+
+		var keep []NodePtr
+
+		for _,other := range m.SourceNodes[file] {
+			if other != nptr {
+				keep = append(keep,other)
+			}
+		}
+
+		m.SourceNodes[file] = keep
+	}
+
+	for file := range m.SourceLinks {
+
+		var keep []LinkKey
+
+		for _,key := range m.SourceLinks[file] {
+			if key.NFrom != nptr && key.NTo != nptr {
+				keep = append(keep,key)
+			}
+		}
+
+		m.SourceLinks[file] = keep
+	}
+
+	m.dirty = true
+	return nil
+}
+
+//**************************************************************
+
+func (m *MemStore) DeleteChapter(chap string) (int,error) {
+
+	// As TryDeleteDBChapter()
+
+	var nodes []NodePtr
+
+	for nptr,n := range m.Nodes {
+		if n.Chap == chap {
+			nodes = append(nodes,nptr)
+		}
+	}
+
+	for _,nptr := range nodes {
+		m.DeleteNode(nptr)
+	}
+
+	for _,n := range m.Nodes {
+
+		chapters := strings.Split(n.Chap,",")
+		var keep []string
+
+		for _,c := range chapters {
+			if c != chap {
+				keep = append(keep,c)
+			}
+		}
+
+		if len(keep) < len(chapters) {
+			n.Chap = strings.Join(keep,",")
+		}
+	}
+
+	var pagemap []PageMap
+
+	for _,line := range m.PageMap {
+		if line.Chapter != chap {
+			pagemap = append(pagemap,line)
+		}
+	}
+
+	m.PageMap = pagemap
+	m.dirty = true
+
+	return len(nodes),nil
+}
+
+//**************************************************************
+// Provenance
+//**************************************************************
+
+func (m *MemStore) AddProvenance(file string,nodes []NodePtr,links []LinkKey) error {
+
+	var nodeset = make(map[NodePtr]bool)
+	var linkset = make(map[LinkKey]bool)
+
+	for _,nptr := range m.SourceNodes[file] {
+		nodeset[nptr] = true
+	}
+
+	for _,key := range m.SourceLinks[file] {
+		linkset[key] = true
+	}
+
+	for _,nptr := range nodes {
+		if !nodeset[nptr] {
+			nodeset[nptr] = true
+			m.SourceNodes[file] = append(m.SourceNodes[file],nptr)
+		}
+	}
+
+	for _,key := range links {
+		if !linkset[key] {
+			linkset[key] = true
+			m.SourceLinks[file] = append(m.SourceLinks[file],key)
+		}
+	}
+
+	m.dirty = true
+	return nil
+}
+
+//**************************************************************
+
+func (m *MemStore) GetProvenance(file string) ([]NodePtr,[]NodeArrowNode,error) {
+
+	var links []NodeArrowNode
+
+	for _,key := range m.SourceLinks[file] {
+
+		n,exists := m.Nodes[key.NFrom]
+
+		if !exists {
+			continue
+		}
+
+		for stindex := range n.I {
+			for _,lnk := range n.I[stindex] {
+				if lnk.Arr == key.Arr && lnk.Dst == key.NTo {
+					links = append(links,NodeArrowNode{NFrom: key.NFrom, STType: STIndexToSTType(stindex), Arr: lnk.Arr, Wgt: lnk.Wgt, Ctx: lnk.Ctx, NTo: lnk.Dst})
+				}
+			}
+		}
+	}
+
+	return append([]NodePtr{},m.SourceNodes[file]...),links,nil
+}
+
+//**************************************************************
+
+func (m *MemStore) RemoveSourceContribution(file string,nodes []NodePtr,removed,changed []LinkKey) error {
+
+	// As TryRemoveDBSourceContribution()
+
+	var claimed_links = make(map[LinkKey]bool)
+	var claimed_nodes = make(map[NodePtr]bool)
+
+	for other := range m.SourceLinks {
+		if other != file {
+			for _,key := range m.SourceLinks[other] {
+				claimed_links[key] = true
+			}
+			for _,nptr := range m.SourceNodes[other] {
+				claimed_nodes[nptr] = true
+			}
+		}
+	}
+
+	var unlink = make(map[LinkKey]bool)
+
+	for _,key := range removed {
+		if !claimed_links[key] {
+			unlink[key] = true
+		}
+	}
+
+	for _,key := range changed {
+		unlink[key] = true
+	}
+
+	for key := range unlink {
+
+		delete(m.nan,key)
+
+		if n,exists := m.Nodes[key.NFrom]; exists {
+			for stindex := range n.I {
+				var keep []Link
+				for _,lnk := range n.I[stindex] {
+					if lnk.Arr != key.Arr || lnk.Dst != key.NTo {
+						keep = append(keep,lnk)
+					}
+				}
+				n.I[stindex] = keep
+			}
+		}
+	}
+
+	var linked = make(map[NodePtr]bool)
+	var links []NodeArrowNode
+
+	for _,nan := range m.Links {
+		if !unlink[LinkKey{NFrom: nan.NFrom, Arr: nan.Arr, NTo: nan.NTo}] {
+			links = append(links,nan)
+			if nan.STType != 999 {
+				linked[nan.NFrom] = true
+				linked[nan.NTo] = true
+			}
+		}
+	}
+
+	var orphans = make(map[NodePtr]bool)
+
+	for _,nptr := range nodes {
+		if !claimed_nodes[nptr] && !linked[nptr] {
+			orphans[nptr] = true
+			m.deleteNode(nptr)
+		}
+	}
+
+	m.Links = nil
+
+	for _,nan := range links {
+		if orphans[nan.NFrom] {
+			delete(m.nan,LinkKey{NFrom: nan.NFrom, Arr: nan.Arr, NTo: nan.NTo})
+		} else {
+			m.Links = append(m.Links,nan)
+		}
+	}
+
+	var pagemap []PageMap
+
+	for _,line := range m.PageMap {
+		if line.File != file {
+			pagemap = append(pagemap,line)
+		}
+	}
+
+	m.PageMap = pagemap
+
+	delete(m.SourceNodes,file)
+	delete(m.SourceLinks,file)
+
+	m.dirty = true
+	return nil
+}
+
+//**************************************************************
+
+func (m *MemStore) deleteNode(nptr NodePtr) {
+
+	n,exists := m.Nodes[nptr]
+
+	if !exists {
+		return
+	}
+
+	delete(m.Nodes,nptr)
+	delete(m.text,n.S)
+
+	lower := strings.ToLower(n.S)
+
+	if m.lower[lower] == nptr {
+
+		delete(m.lower,lower)
+
+		for _,other := range m.Nodes {
+			if strings.ToLower(other.S) == lower {
+				m.lower[lower] = other.NPtr
+				break
+			}
+		}
+	}
+}
+
+//**************************************************************
+// Lookup and name matching
+//**************************************************************
+
+func (m *MemStore) GetNodeByNodePtr(nptr NodePtr) (Node,error) {
+
+	var n Node
+
+	if node,exists := m.Nodes[nptr]; exists {
+		n = *node
+	}
+
+	n.NPtr = nptr
+	return n,nil
+}
+
+//**************************************************************
+
+func (m *MemStore) GetNodesByNodePtrs(nptrs []NodePtr) (map[NodePtr]Node,error) {
+
+	nodes := make(map[NodePtr]Node)
+
+	for _,nptr := range nptrs {
+		if node,exists := m.Nodes[nptr]; exists {
+			n := *node
+			n.NPtr = nptr
+			nodes[nptr] = n
+		}
+	}
+
+	return nodes,nil
+}
+
+//**************************************************************
+
+func (m *MemStore) GetNodePtrsByText(texts []string) (map[string]NodePtr,error) {
+
+	var retval = make(map[string]NodePtr)
+
+	for t := range texts {
+
+		if nptr,ok := m.text[texts[t]]; ok {
+			retval[texts[t]] = nptr
+		}
+
+		if nptr,ok := m.lower[strings.ToLower(texts[t])]; ok {
+			retval[m.Nodes[nptr].S] = nptr
+		}
+	}
+
+	return retval,nil
+}
+
+//**************************************************************
+
+func (m *MemStore) GetTopNodePtrs() (map[int]ClassedNodePtr,error) {
+
+	var retval = make(map[int]ClassedNodePtr)
+
+	for class,top := range m.top {
+		retval[class] = top
+	}
+
+	return retval,nil
+}
+
+//**************************************************************
+
+func (m *MemStore) GetChaptersMatchingName(src string) ([]string,error) {
+
+	remove_accents,stripped := IsBracketedSearchTerm(src)
+
+	search := "%"+src+"%"
+
+	if remove_accents {
+		search = "%"+stripped+"%"
+	}
+
+	var idemp = make(map[string]bool)
+	var retval []string
+
+	for _,n := range m.Nodes {
+		if SQLLikeLower(n.Chap,search,remove_accents) && !idemp[n.Chap] {
+			idemp[n.Chap] = true
+			retval = append(retval,n.Chap)
+		}
+	}
+
+	sort.Strings(retval)
+	return retval,nil
+}
+
+//**************************************************************
+
+func (m *MemStore) GetContextsMatchingName(src string) ([]string,error) {
+
+	remove_accents,stripped := IsBracketedSearchTerm(src)
+
+	search := src
+
+	if remove_accents {
+		search = stripped
+	}
+
+	var idemp = make(map[string]int)
+	var retval []string
+
+	for l := range m.Links {
+		if SQLMatchContext(m.Links[l].Ctx,[]string{search}) {
+			for _,c := range m.Links[l].Ctx {
+				idemp[c]++
+			}
+		}
+	}
+
+	for s := range idemp {
+		retval = append(retval,s)
+	}
+
+	sort.Strings(retval)
+	return retval,nil
+}
+
+//**************************************************************
+
+func (m *MemStore) GetNodePtrMatchingName(src,chap string) ([]NodePtr,error) {
+
+	if src == "" || src == "empty" {
+		return nil,nil
+	}
+
+	var retval []NodePtr
+
+	for _,n := range m.Nodes {
+		if MemNameAndChapterMatch(n,src,chap) {
+			retval = append(retval,n.NPtr)
+		}
+	}
+
+	SortNodePtrs(retval)
+	return retval,nil
+}
+
+//**************************************************************
+
+func (m *MemStore) GetNodePtrMatching(nm,chap string,cn []string,arrow []ArrowPtr) ([]NodePtr,error) {
+
+	_,cn_stripped := IsBracketedSearchList(cn)
+
+	var idemp = make(map[NodePtr]bool)
+	var retval []NodePtr
+
+	for l := range m.Links {
+
+		nan := m.Links[l]
+
+		if idemp[nan.NFrom] || !SQLMatchContext(nan.Ctx,cn_stripped) || !SQLMatchArrows(nan.Arr,arrow) {
+			continue
+		}
+
+		n,exists := m.Nodes[nan.NFrom]
+
+		if exists && MemNameAndChapterMatch(n,nm,chap) {
+			idemp[nan.NFrom] = true
+			retval = append(retval,nan.NFrom)
+		}
+	}
+
+	SortNodePtrs(retval)
+	return retval,nil
+}
+
+//**************************************************************
+
+func (m *MemStore) SearchNodeText(text,chap string,limit int) ([]NodeMatch,error) {
+
+	// Approximates TrySearchDBNodeText(), with whole words standing in
+	// for the text search rank
+
+	remove_accents,stripped := IsBracketedSearchTerm(text)
+
+	fold := func(s string) string {
+
+		s = strings.ToLower(s)
+
+		if remove_accents {
+			s = Unaccent(s)
+		}
+
+		return s
+	}
+
+	search := fold(stripped)
+	words := strings.Fields(search)
+
+	var importance = make(map[NodePtr]float64)
+
+	for _,c := range m.Centrality[""] {
+		importance[c.NPtr] = c.PageRank
+	}
+
+	var retval []NodeMatch
+
+	for _,n := range m.Nodes {
+
+		if !MemChapterMatch(n.Chap,chap) {
+			continue
+		}
+
+		s := fold(n.S)
+		sim := TrigramSimilarity(s,search)
+
+		var found int
+
+		for _,w := range strings.Fields(s) {
+			for _,q := range words {
+				if w == q {
+					found++
+				}
+			}
+		}
+
+		if !strings.Contains(s,search) && found == 0 && sim < 0.3 {
+			continue
+		}
+
+		var nm NodeMatch
+
+		nm.NPtr = n.NPtr
+		nm.S = n.S
+		nm.Chap = n.Chap
+		nm.Rank = sim + 0.1 * float64(found)
+
+		if s == search {
+			nm.Rank += 1.0
+		}
+
+		nm.Highlight = HighlightText(n.S,text)
+		nm.Importance = importance[n.NPtr]
+		retval = append(retval,nm)
+	}
+
+	sort.Slice(retval, func(i,j int) bool {
+		if retval[i].Rank != retval[j].Rank {
+			return retval[i].Rank > retval[j].Rank
+		}
+		if retval[i].Importance != retval[j].Importance {
+			return retval[i].Importance > retval[j].Importance
+		}
+		if len(retval[i].S) != len(retval[j].S) {
+			return len(retval[i].S) < len(retval[j].S)
+		}
+		return retval[i].S < retval[j].S
+	})
+
+	if limit > 0 && len(retval) > limit {
+		retval = retval[:limit]
+	}
+
+	return retval,nil
+}
+
+//**************************************************************
+
+func (m *MemStore) GetNodeArrowNodeMatchingArrowPtrs(chap string,cn []string,arrows []ArrowPtr) ([]NodeArrowNode,error) {
+
+	// As the SQL, a context list replaces the arrow filter by a chapter filter
+
+	var nanlist []NodeArrowNode
+
+	for l := range m.Links {
+
+		nan := m.Links[l]
+
+		if cn == nil {
+			if MatchArrows(arrows,nan.Arr) {
+				nanlist = append(nanlist,nan)
+			}
+			continue
+		}
+
+		n,exists := m.Nodes[nan.NFrom]
+
+		if exists && SQLMatchContext(nan.Ctx,cn) && SQLLikeLower(n.Chap,"%"+chap+"%",false) {
+			nanlist = append(nanlist,nan)
+		}
+	}
+
+	return nanlist,nil
+}
+
+//**************************************************************
+
+func (m *MemStore) GetLinksMatching(chap string,cn []string,arrows []ArrowPtr) ([]NodeArrowNode,error) {
+
+	var nanlist []NodeArrowNode
+
+	for l := range m.Links {
+
+		nan := m.Links[l]
+
+		if len(arrows) > 0 && !MatchArrows(arrows,nan.Arr) {
+			continue
+		}
+
+		if len(cn) > 0 && !SQLMatchContext(nan.Ctx,cn) {
+			continue
+		}
+
+		from,exists_from := m.Nodes[nan.NFrom]
+		to,exists_to := m.Nodes[nan.NTo]
+
+		if !exists_from || !exists_to || !MemChapterMatch(from.Chap,chap) || !MemChapterMatch(to.Chap,chap) {
+			continue
+		}
+
+		nanlist = append(nanlist,nan)
+	}
+
+	return nanlist,nil
+}
+
+//**************************************************************
+
+func (m *MemStore) SaveCentrality(scope string,scores []Centrality) error {
+
+	m.Centrality[scope] = scores
+	m.dirty = true
+	return nil
+}
+
+//**************************************************************
+
+func (m *MemStore) GetCentrality(scope string) ([]Centrality,error) {
+
+	return m.Centrality[scope],nil
+}
+
+//**************************************************************
+
+func (m *MemStore) GetNodeContextsMatchingArrow(searchtext string,chap string,cn []string,arrow []ArrowPtr,page int) ([]QNodePtr,error) {
+
+	const hits_per_page = 30
+	offset := (page-1) * hits_per_page
+
+	if offset < 0 {
+		return nil,fmt.Errorf("%w: GetDBNodeArrowNodeByContext Failed: OFFSET must not be negative",ErrDBQuery)
+	}
+
+	type row struct {
+		nptr NodePtr
+		ctx []string
+		chap string
+	}
+
+	var idemp = make(map[string]bool)
+	var rows []row
+
+	for l := range m.Links {
+
+		nan := m.Links[l]
+
+		if !SQLMatchContext(nan.Ctx,cn) || !SQLMatchArrows(nan.Arr,arrow) {
+			continue
+		}
+
+		n,exists := m.Nodes[nan.NFrom]
+
+		if !exists || !SQLLikeLower(n.Chap,"%"+chap+"%",false) {
+			continue
+		}
+
+		key := fmt.Sprint(nan.NFrom,nan.Ctx,n.Chap)
+
+		if !idemp[key] {
+			idemp[key] = true
+			rows = append(rows,row{nan.NFrom,nan.Ctx,n.Chap})
+		}
+	}
+
+	// ORDER BY Ctx,NFrom DESC
+
+	sort.SliceStable(rows, func(i,j int) bool {
+		if c := CompareStringArrays(rows[i].ctx,rows[j].ctx); c != 0 {
+			return c < 0
+		}
+		return NodePtrLess(rows[j].nptr,rows[i].nptr)
+	})
+
+	var return_value []QNodePtr
+
+	for r := offset; r < len(rows) && r < offset+hits_per_page; r++ {
+
+		var qptr QNodePtr
+
+		qptr.NPtr = rows[r].nptr
+		qptr.Chapter = rows[r].chap
+		qptr.Context = SQLArrayString(rows[r].ctx)
+
+		if qptr.Context == "" {
+			qptr.Context = "(no context)"
+		}
+
+		return_value = append(return_value,qptr)
+	}
+
+	return return_value,nil
+}
+
+//**************************************************************
+
+func (m *MemStore) GetStoryStartNodes(arrow,inverse ArrowPtr,sttype int) ([]NodePtr,error) {
+
+	return m.GetNCCStoryStartNodes(arrow,inverse,sttype,"",nil)
+}
+
+//**************************************************************
+
+func (m *MemStore) GetNCCStoryStartNodes(arrow,inverse ArrowPtr,sttype int,chapter string,context []string) ([]NodePtr,error) {
+
+	// Nodes with an outgoing arrow but no incoming inverse, i.e. the top of a chain
+
+	if sttype < -EXPRESS || sttype > EXPRESS {
+		return nil,fmt.Errorf("%w: No such sttype %d",ErrSTOutOfBounds,sttype)
+	}
+
+	var matches []NodePtr
+
+	for _,n := range m.Nodes {
+
+		if !SQLLikeLower(n.Chap,"%"+chapter+"%",false) {
+			continue
+		}
+
+		if MemArrowInContextList(arrow,n.I[STTypeToSTIndex(sttype)],context) &&
+			!MemArrowInContextList(inverse,n.I[STTypeToSTIndex(-sttype)],context) {
+			matches = append(matches,n.NPtr)
+		}
+	}
+
+	SortNodePtrs(matches)
+	return matches,nil
+}
+
+//**************************************************************
+
+func (m *MemStore) GetPageMap(chap string,cn []string,page int) ([]PageMap,error) {
+
+	const hits_per_page = 30
+	offset := (page-1) * hits_per_page
+
+	if offset < 0 {
+		return nil,fmt.Errorf("%w: GetDBPageMap Failed: OFFSET must not be negative",ErrDBQuery)
+	}
+
+	var lines []PageMap
+
+	for l := range m.PageMap {
+		if SQLMatchContext(m.PageMap[l].Context,cn) && SQLLikeLower(m.PageMap[l].Chapter,"%"+chap+"%",false) {
+			lines = append(lines,m.PageMap[l])
+		}
+	}
+
+	sort.SliceStable(lines, func(i,j int) bool {
+		return lines[i].Line < lines[j].Line
+	})
+
+	var pagemap []PageMap
+
+	for l := offset; l < len(lines) && l < offset+hits_per_page; l++ {
+		pagemap = append(pagemap,lines[l])
+	}
+
+	return pagemap,nil
+}
+
+//**************************************************************
+
+func (m *MemStore) GetTableOfContents(chap string,cn []string) (map[string][]string,error) {
+
+	_,cn_stripped := IsBracketedSearchList(cn)
+
+	var toc = make(map[string][]string)
+	var idemp = make(map[string]bool)
+
+	for l := range m.Links {
+
+		nan := m.Links[l]
+
+		if !SQLMatchContext(nan.Ctx,cn_stripped) {
+			continue
+		}
+
+		n,exists := m.Nodes[nan.NFrom]
+
+		if !exists || !MemChapterMatch(n.Chap,chap) {
+			continue
+		}
+
+		key := fmt.Sprint(n.Chap,nan.Ctx)
+
+		if idemp[key] {
+			continue
+		}
+
+		idemp[key] = true
+
+		chps := strings.Split(n.Chap,",")
+
+		for c := range chps {
+			toc[chps[c]] = append(toc[chps[c]],nan.Ctx...)
+		}
+	}
+
+	return toc,nil
+}
+
+//**************************************************************
+// Cone and path expansion, as the plpgsql functions
+//**************************************************************
+
+func (m *MemStore) GetNeighboursByType(start NodePtr,chapter string,sttype int) ([]Link,error) {
+
+	if sttype < -EXPRESS || sttype > EXPRESS {
+		return nil,fmt.Errorf("%w: No such sttype %d",ErrSTOutOfBounds,sttype)
+	}
+
+	n,exists := m.Nodes[start]
+
+	if !exists || !SQLLikeLower(n.Chap,"%"+chapter+"%",false) {
+		return nil,nil
+	}
+
+	return n.I[STTypeToSTIndex(sttype)],nil
+}
+
+//**************************************************************
+
+func (m *MemStore) GetFwdLinks(start NodePtr,chapter string,context []string,exclude []NodePtr,sttype int) ([]Link,error) {
+
+	// GetFwdLinks() with chapter "" and nil context, else GetNCFwdLinks()
+
+	fwdlinks,err := m.GetNeighboursByType(start,chapter,sttype)
+
+	if err != nil {
+		return nil,err
+	}
+
+	var neighbours []Link
+
+	for l := range fwdlinks {
+
+		lnk := fwdlinks[l]
+
+		if lnk.Arr == 0 || !SQLMatchContext(lnk.Ctx,context) {
+			continue
+		}
+
+		if !InNodeSet(exclude,lnk.Dst) {
+			neighbours = append(neighbours,lnk)
+		}
+	}
+
+	return neighbours,nil
+}
+
+//**************************************************************
+
+func (m *MemStore) GetFwdConeAsNodes(start NodePtr,sttype,depth int) ([]NodePtr,error) {
+
+	links,err := m.GetFwdConeAsLinks(start,sttype,depth)
+
+	var cone []NodePtr
+
+	for l := range links {
+		cone = append(cone,links[l].Dst)
+	}
+
+	return cone,err
+}
+
+//**************************************************************
+
+func (m *MemStore) GetFwdConeAsLinks(start NodePtr,sttype,depth int) ([]Link,error) {
+
+	// Breadth first, each node once, up to depth hops from start
+
+	var cone []Link
+
+	exclude := []NodePtr{NodePtr{0,0}}
+	level := []Link{SingletonLink(start)}
+
+	for counter := 0; counter <= depth && len(level) > 0; counter++ {
+
+		var next []Link
+
+		for _,neigh := range level {
+
+			if InNodeSet(exclude,neigh.Dst) {
+				continue
+			}
+
+			cone = append(cone,neigh)
+			exclude = append(exclude,neigh.Dst)
+
+			part,err := m.GetFwdLinks(neigh.Dst,"",nil,exclude,sttype)
+
+			if err != nil {
+				return cone,err
+			}
+
+			next = append(next,part...)
+		}
+
+		level = next
+	}
+
+	return cone,nil
+}
+
+//**************************************************************
+
+func (m *MemStore) GetFwdPathsAsLinks(start NodePtr,sttype,depth int) ([][]Link,int,error) {
+
+	paths,err := ExpandPaths(m.GetNodesByNodePtrs,[]NodePtr{start},[]int{sttype},depth,"",nil)
+
+	return paths,len(paths),err
+}
+
+//**************************************************************
+
+func (m *MemStore) GetEntireConePathsAsLinks(orientation string,start NodePtr,depth int) ([][]Link,int,error) {
+
+	paths,err := ExpandPaths(m.GetNodesByNodePtrs,[]NodePtr{start},ConeSTTypes(orientation),depth,"",nil)
+
+	SortPathsByLength(paths)
+
+	return paths,len(paths),err
+}
+
+//**************************************************************
+
+func (m *MemStore) GetEntireNCConePathsAsLinks(orientation string,start NodePtr,depth int,chapter string,context []string) ([][]Link,int,error) {
+
+	paths,err := ExpandPaths(m.GetNodesByNodePtrs,[]NodePtr{start},NCConeSTTypes(orientation),depth,chapter,context)
+
+	return paths,len(paths),err
+}
+
+//**************************************************************
+
+func (m *MemStore) GetEntireNCSuperConePathsAsLinks(orientation string,start []NodePtr,depth int,chapter string,context []string) ([][]Link,int,error) {
+
+	paths,err := ExpandPaths(m.GetNodesByNodePtrs,start,NCConeSTTypes(orientation),depth,chapter,context)
+
+	return paths,len(paths),err
+}
+
+//**************************************************************
+// Matroid analysis
+//**************************************************************
+
+func (m *MemStore) GetAppointmentArrayByArrow(context []string,chapter string) (map[ArrowPtr][]NodePtr,error) {
+
+	var retval = make(map[ArrowPtr][]NodePtr)
+
+	for l := range m.Links {
+		if context == nil || SQLMatchContext(m.Links[l].Ctx,context) {
+			arr := m.Links[l].Arr
+			retval[arr] = IdempAddNodePtr(retval[arr],m.Links[l].NTo)
+		}
+	}
+
+	for arr := range retval {
+		SortNodePtrs(retval[arr])
+	}
+
+	return retval,nil
+}
+
+//**************************************************************
+
+func (m *MemStore) GetAppointmentArrayBySSType() (map[int][]NodePtr,error) {
+
+	var retval = make(map[int][]NodePtr)
+
+	for l := range m.Links {
+		st := m.Links[l].STType
+		retval[st] = IdempAddNodePtr(retval[st],m.Links[l].NTo)
+	}
+
+	for st := range retval {
+		SortNodePtrs(retval[st])
+	}
+
+	return retval,nil
+}
+
+//**************************************************************
+
+func (m *MemStore) GetAppointmentHistogramByArrow() (map[ArrowPtr]int,error) {
+
+	var retval = make(map[ArrowPtr]int)
+
+	for l := range m.Links {
+		retval[m.Links[l].Arr]++
+	}
+
+	return retval,nil
+}
+
+//**************************************************************
+
+func (m *MemStore) GetAppointmentHistogramBySSType() (map[int]int,error) {
+
+	var retval = make(map[int]int)
+
+	for l := range m.Links {
+		retval[m.Links[l].STType]++
+	}
+
+	return retval,nil
+}
+
+//**************************************************************
+
+func (m *MemStore) GetAppointmentNodesByArrow() ([]ArrowAppointment,error) {
+
+	var groups = make(map[LinkKey][]NodePtr)
+
+	for l := range m.Links {
+		key := LinkKey{NFrom: m.Links[l].NFrom, Arr: m.Links[l].Arr}
+		groups[key] = append(groups[key],m.Links[l].NTo)
+	}
+
+	var retval []ArrowAppointment
+
+	for key,nto := range groups {
+		if len(nto) > 1 {
+			retval = append(retval,ArrowAppointment{NFrom: key.NFrom, Arr: key.Arr, NTo: nto})
+		}
+	}
+
+	sort.SliceStable(retval, func(i,j int) bool {
+		if retval[i].Arr != retval[j].Arr {
+			return retval[i].Arr < retval[j].Arr
+		}
+		return NodePtrLess(retval[i].NFrom,retval[j].NFrom)
+	})
+
+	return retval,nil
+}
+
+//**************************************************************
+
+func (m *MemStore) GetAppointmentNodesBySTType() ([]STTypeAppointment,error) {
+
+	type stkey struct {
+		nfrom NodePtr
+		sttype int
+	}
+
+	var groups = make(map[stkey][]NodePtr)
+
+	for l := range m.Links {
+		key := stkey{m.Links[l].NFrom,m.Links[l].STType}
+		groups[key] = append(groups[key],m.Links[l].NTo)
+	}
+
+	var retval []STTypeAppointment
+
+	for key,nto := range groups {
+		if len(nto) > 1 {
+			retval = append(retval,STTypeAppointment{NFrom: key.nfrom, STType: key.sttype, NTo: nto})
+		}
+	}
+
+	sort.SliceStable(retval, func(i,j int) bool {
+		if retval[i].STType != retval[j].STType {
+			return retval[i].STType < retval[j].STType
+		}
+		return NodePtrLess(retval[i].NFrom,retval[j].NFrom)
+	})
+
+	return retval,nil
+}
+
+//**************************************************************
+// Go versions of the SQL matching helpers
+//**************************************************************
+
+func SQLMatchContext(db_set,user_set []string) bool {
+
+	// As the match_context() stored function
+
+	if len(user_set) == 0 || len(db_set) == 0 {
+		return true
+	}
+
+	var db_ref []string
+
+	for _,item := range db_set {
+		db_ref = append(db_ref,strings.ToLower(Unaccent(item)))
+	}
+
+	for _,item := range user_set {
+
+		if item == "any" || item == "" {
+			return true
+		}
+
+		pattern := strings.ToLower(strings.Replace(item,"|","",-1))
+
+		re,err := regexp.Compile(pattern)
+
+		if err != nil {
+			re = regexp.MustCompile(regexp.QuoteMeta(pattern))
+		}
+
+		for _,try := range db_ref {
+			if utf8.RuneCountInString(re.FindString(try)) > 3 {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+//**************************************************************
+
+func SQLMatchArrows(arr ArrowPtr,user_set []ArrowPtr) bool {
+
+	// As the match_arrows() stored function
+
+	return len(user_set) == 0 || MatchArrows(user_set,arr)
+}
+
+//**************************************************************
+
+func SQLLikeLower(s,pattern string,remove_accents bool) bool {
+
+	// lower(s) LIKE lower(pattern), optionally lower(unaccent(s))
+
+	if remove_accents {
+		s = Unaccent(s)
+	}
+
+	var expr string = "(?s)^"
+
+	escaped := false
+
+	for _,r := range strings.ToLower(pattern) {
+		switch {
+		case escaped:
+			expr += regexp.QuoteMeta(string(r))
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '%':
+			expr += ".*"
+		case r == '_':
+			expr += "."
+		default:
+			expr += regexp.QuoteMeta(string(r))
+		}
+	}
+
+	expr += "$"
+
+	re,err := regexp.Compile(expr)
+
+	if err != nil {
+		return false
+	}
+
+	return re.MatchString(strings.ToLower(s))
+}
+
+//**************************************************************
+
+func Unaccent(s string) string {
+
+	// Approximates the postgres unaccent extension for latin scripts
+
+	return UNACCENT.Replace(s)
+}
+
+var UNACCENT = strings.NewReplacer(
+	"à","a","á","a","â","a","ã","a","ä","a","å","a","ā","a","ă","a","ą","a",
+	"À","A","Á","A","Â","A","Ã","A","Ä","A","Å","A","Ā","A","Ă","A","Ą","A",
+	"ç","c","ć","c","č","c","Ç","C","Ć","C","Č","C",
+	"ď","d","đ","d","Ď","D","Đ","D",
+	"è","e","é","e","ê","e","ë","e","ē","e","ė","e","ę","e","ě","e",
+	"È","E","É","E","Ê","E","Ë","E","Ē","E","Ė","E","Ę","E","Ě","E",
+	"ğ","g","Ğ","G",
+	"ì","i","í","i","î","i","ï","i","ī","i","į","i","ı","i",
+	"Ì","I","Í","I","Î","I","Ï","I","Ī","I","Į","I","İ","I",
+	"ł","l","Ł","L",
+	"ñ","n","ń","n","ň","n","Ñ","N","Ń","N","Ň","N",
+	"ò","o","ó","o","ô","o","õ","o","ö","o","ø","o","ō","o","ő","o",
+	"Ò","O","Ó","O","Ô","O","Õ","O","Ö","O","Ø","O","Ō","O","Ő","O",
+	"ř","r","Ř","R",
+	"ś","s","š","s","ş","s","Ś","S","Š","S","Ş","S","ß","ss",
+	"ť","t","ţ","t","Ť","T","Ţ","T",
+	"ù","u","ú","u","û","u","ü","u","ū","u","ů","u","ű","u","ų","u",
+	"Ù","U","Ú","U","Û","U","Ü","U","Ū","U","Ů","U","Ű","U","Ų","U",
+	"ý","y","ÿ","y","Ý","Y","Ÿ","Y",
+	"ź","z","ż","z","ž","z","Ź","Z","Ż","Z","Ž","Z",
+	"æ","ae","Æ","AE","œ","oe","Œ","OE",
+)
+
+//**************************************************************
+
+func Trigrams(s string) map[string]bool {
+
+	// As pg_trgm: each word padded with two spaces in front and one
+	// behind, cut into three letter pieces
+
+	var set = make(map[string]bool)
+
+	words := strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	for _,w := range words {
+
+		padded := []rune("  "+w+" ")
+
+		for i := 0; i+3 <= len(padded); i++ {
+			set[string(padded[i:i+3])] = true
+		}
+	}
+
+	return set
+}
+
+//**************************************************************
+
+func TrigramSimilarity(a,b string) float64 {
+
+	// As similarity() in pg_trgm, the fraction of trigrams in common
+
+	ta := Trigrams(a)
+	tb := Trigrams(b)
+
+	var common int
+
+	for t := range ta {
+		if tb[t] {
+			common++
+		}
+	}
+
+	union := len(ta) + len(tb) - common
+
+	if union == 0 {
+		return 0
+	}
+
+	return float64(common) / float64(union)
+}
+
+//**************************************************************
+
+func SQLArrayString(array []string) string {
+
+	// The text form of a postgres text[], the inverse of ParseSQLArrayString()
+
+	if len(array) == 0 {
+		return ""
+	}
+
+	var items []string
+
+	for _,s := range array {
+		if s == "" || strings.ToLower(s) == "null" || strings.ContainsAny(s,"{},\"\\ \t\n") {
+			s = "\"" + strings.NewReplacer("\\","\\\\","\"","\\\"").Replace(s) + "\""
+		}
+		items = append(items,s)
+	}
+
+	return "{" + strings.Join(items,",") + "}"
+}
+
+//**************************************************************
+
+func MemNameAndChapterMatch(n *Node,nm,chap string) bool {
+
+	remove_accents,stripped := IsBracketedSearchTerm(nm)
+
+	search := "%"+nm+"%"
+
+	if remove_accents {
+		search = "%"+stripped+"%"
+	}
+
+	if !SQLLikeLower(n.S,search,remove_accents) {
+		return false
+	}
+
+	return MemChapterMatch(n.Chap,chap)
+}
+
+//**************************************************************
+
+func MemChapterMatch(node_chap,chap string) bool {
+
+	if chap == "any" || chap == "" {
+		return true
+	}
+
+	remove_accents,stripped := IsBracketedSearchTerm(chap)
+
+	if remove_accents {
+		return SQLLikeLower(node_chap,"%"+stripped+"%",true)
+	}
+
+	return SQLLikeLower(node_chap,"%"+chap+"%",false)
+}
+
+//**************************************************************
+
+func MemArrowInContextList(arrow ArrowPtr,links []Link,context []string) bool {
+
+	for l := range links {
+		if links[l].Arr == arrow && SQLMatchContext(links[l].Ctx,context) {
+			return true
+		}
+	}
+
+	return false
+}
+
+//**************************************************************
+
+func SortedContext(ctx []string) []string {
+
+	if len(ctx) == 0 {
+		return nil
+	}
+
+	sorted := make([]string,len(ctx))
+	copy(sorted,ctx)
+	sort.Strings(sorted)
+	return sorted
+}
+
+//**************************************************************
+
+func SingletonLink(start NodePtr) Link {
+
+	// As GetSingletonAsLink(), an empty link pointing to the start
+
+	var lnk Link
+
+	lnk.Arr = 0
+	lnk.Wgt = 1.0
+	lnk.Dst = start
+
+	return lnk
+}
+
+//**************************************************************
+
+func SameLink(l1,l2 Link) bool {
+
+	return l1.Arr == l2.Arr && l1.Wgt == l2.Wgt && l1.Dst == l2.Dst &&
+		CompareStringArrays(l1.Ctx,l2.Ctx) == 0
+}
+
+//**************************************************************
+
+func CompareStringArrays(a,b []string) int {
+
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := strings.Compare(a[i],b[i]); c != 0 {
+			return c
+		}
+	}
+
+	return len(a) - len(b)
+}
+
+//**************************************************************
+
+func NodePtrLess(a,b NodePtr) bool {
+
+	if a.Class != b.Class {
+		return a.Class < b.Class
+	}
+
+	return a.CPtr < b.CPtr
+}
+
+//**************************************************************
+
+func SortNodePtrs(list []NodePtr) {
+
+	sort.Slice(list, func(i,j int) bool {
+		return NodePtrLess(list[i],list[j])
+	})
+}
@@ -0,0 +1,499 @@
+//**************************************************************
+//
+// memstore_test.go
+//
+// Uploads, cone and path queries and file sync, run against the
+// in-memory GraphStore, so they need no database
+//
+//**************************************************************
+
+package SSTorytime
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+//**************************************************************
+
+func resetMemoryGraph() {
+
+	// Forget the graph compiled so far, as a new run of N4L would
+
+	ARROW_DIRECTORY = nil
+	ARROW_SHORT_DIR = make(map[string]ArrowPtr)
+	ARROW_LONG_DIR = make(map[string]ArrowPtr)
+	ARROW_DIRECTORY_TOP = 0
+	INVERSE_ARROWS = make(map[ArrowPtr]ArrowPtr)
+	PAGE_MAP = nil
+	NODE_DIRECTORY = NodeDirectory{}
+	PROVENANCE = make(map[string]*Provenance)
+	SOURCE_FILE = ""
+	SYNC_SOURCES = false
+	WIPE_DB = false
+
+	MemoryInit()
+}
+
+//**************************************************************
+
+func testArrows() (ArrowPtr,ArrowPtr) {
+
+	// Arrow 0 means no link, so the mandatory arrows come first, as
+	// in every configuration
+
+	empty := InsertArrowDirectory("leadsto","empty","debug","+")
+	void := InsertArrowDirectory("leadsto","void","unbug","-")
+	InsertInverseArrowDirectory(empty,void)
+
+	fwd := InsertArrowDirectory("leadsto","fwd","forwards","+")
+	bwd := InsertArrowDirectory("leadsto","bwd","backwards","-")
+	InsertInverseArrowDirectory(fwd,bwd)
+
+	return fwd,bwd
+}
+
+//**************************************************************
+
+func testNode(s string) NodePtr {
+
+	var n Node
+
+	n.S = s
+	n.Chap = "test"
+	n.L,n.NPtr.Class = StorageClass(s)
+
+	return AppendTextToDirectory(n,func(string) {})
+}
+
+//**************************************************************
+
+func testLink(from NodePtr,arr ArrowPtr,wgt float64,to NodePtr) {
+
+	// Both ways, as N4L stores them
+
+	AppendLinkToNode(from,Link{Arr: arr,Wgt: wgt},to)
+	AppendLinkToNode(to,Link{Arr: INVERSE_ARROWS[arr],Wgt: wgt},from)
+}
+
+//**************************************************************
+
+func openTestStore(t *testing.T,filename string) PoSST {
+
+	ctx,err := TryOpenMemStore(filename,false)
+
+	if err != nil {
+		t.Fatalf("open %s: %v",filename,err)
+	}
+
+	return ctx
+}
+
+//**************************************************************
+
+func findNode(t *testing.T,ctx PoSST,s string) NodePtr {
+
+	nptrs,err := TryGetDBNodePtrMatchingName(ctx,s,"")
+
+	if err != nil || len(nptrs) != 1 {
+		t.Fatalf("looking up %q: %v %v",s,nptrs,err)
+	}
+
+	return nptrs[0]
+}
+
+//**************************************************************
+
+func uploadChain(t *testing.T,ctx PoSST) {
+
+	// Alpha -> Beta -> Gamma, with a dearer shortcut Alpha -> Gamma
+
+	resetMemoryGraph()
+	SetSourceFile("chain.n4l")
+
+	fwd,_ := testArrows()
+	a := testNode("Alpha")
+	b := testNode("Beta")
+	c := testNode("Gamma")
+
+	testLink(a,fwd,1,b)
+	testLink(b,fwd,1,c)
+	testLink(a,fwd,5,c)
+
+	if err := TryGraphToDB(ctx); err != nil {
+		t.Fatalf("upload: %v",err)
+	}
+}
+
+//**************************************************************
+
+func TestMemStoreUploadAndSnapshot(t *testing.T) {
+
+	file := filepath.Join(t.TempDir(),"graph.json")
+	ctx := openTestStore(t,file)
+	uploadChain(t,ctx)
+
+	if n := len(ctx.Store.(*MemStore).Nodes); n != 3 {
+		t.Errorf("expected 3 nodes, got %d",n)
+	}
+
+	Close(ctx)
+
+	// Reopened from the snapshot, the graph is all there
+
+	ctx = openTestStore(t,file)
+
+	for _,s := range []string{"Alpha","Beta","Gamma"} {
+		findNode(t,ctx,s)
+	}
+
+	// and only looking doesn't write it again
+
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	os.Chtimes(file,past,past)
+
+	Close(ctx)
+
+	info,err := os.Stat(file)
+
+	if err != nil || !info.ModTime().Equal(past) {
+		t.Errorf("a read-only run rewrote the snapshot: %v %v",info.ModTime(),err)
+	}
+
+	leftovers,_ := filepath.Glob(file+".*")
+
+	if len(leftovers) > 0 {
+		t.Errorf("temporary files left behind: %v",leftovers)
+	}
+}
+
+//**************************************************************
+
+func TestMemStoreUploadIsIdempotent(t *testing.T) {
+
+	ctx := openTestStore(t,filepath.Join(t.TempDir(),"graph.json"))
+
+	uploadChain(t,ctx)
+	links := len(ctx.Store.(*MemStore).Links)
+	uploadChain(t,ctx)
+
+	if n := len(ctx.Store.(*MemStore).Nodes); n != 3 {
+		t.Errorf("a second upload duplicated nodes: %d",n)
+	}
+
+	if n := len(ctx.Store.(*MemStore).Links); n != links {
+		t.Errorf("a second upload changed the links from %d to %d",links,n)
+	}
+}
+
+//**************************************************************
+
+func TestMemStoreConePaths(t *testing.T) {
+
+	ctx := openTestStore(t,filepath.Join(t.TempDir(),"graph.json"))
+	uploadChain(t,ctx)
+
+	a := findNode(t,ctx,"Alpha")
+	c := findNode(t,ctx,"Gamma")
+
+	paths,count,err := TryGetFwdPathsAsLinks(ctx,a,LEADSTO,3)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != len(paths) {
+		t.Errorf("count %d for %d paths",count,len(paths))
+	}
+
+	var direct,twostep bool
+
+	for _,path := range paths {
+
+		last := path[len(path)-1].Dst
+
+		switch {
+		case len(path) == 2 && last == c:
+			direct = true
+		case len(path) == 3 && last == c:
+			twostep = true
+		}
+	}
+
+	if !direct || !twostep {
+		t.Errorf("expected Alpha->Gamma and Alpha->Beta->Gamma in %v",paths)
+	}
+}
+
+//**************************************************************
+
+func TestMemStoreWeightedPath(t *testing.T) {
+
+	ctx := openTestStore(t,filepath.Join(t.TempDir(),"graph.json"))
+	uploadChain(t,ctx)
+
+	a := findNode(t,ctx,"Alpha")
+	b := findNode(t,ctx,"Beta")
+	c := findNode(t,ctx,"Gamma")
+
+	paths,err := TryGetShortestWeightedPaths(ctx,[]NodePtr{a},[]NodePtr{c},nil,"",nil,2)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(paths) != 2 {
+		t.Fatalf("expected the two paths from Alpha to Gamma, got %v",paths)
+	}
+
+	// The cheaper, longer path first
+
+	if len(paths[0]) != 3 || paths[0][1].Dst != b || paths[0][2].Dst != c {
+		t.Errorf("expected Alpha->Beta->Gamma first, got %v",paths[0])
+	}
+}
+
+//**************************************************************
+
+func TestMemStoreSyncRemovesStaleLinks(t *testing.T) {
+
+	ctx := openTestStore(t,filepath.Join(t.TempDir(),"graph.json"))
+	uploadChain(t,ctx)
+
+	// The same file, now without the shortcut
+
+	resetMemoryGraph()
+	SetSourceFile("chain.n4l")
+
+	fwd,_ := testArrows()
+	a := testNode("Alpha")
+	b := testNode("Beta")
+	c := testNode("Gamma")
+
+	testLink(a,fwd,1,b)
+	testLink(b,fwd,1,c)
+
+	SYNC_SOURCES = true
+	defer func() { SYNC_SOURCES = false }()
+
+	if err := TryGraphToDB(ctx); err != nil {
+		t.Fatalf("sync: %v",err)
+	}
+
+	alpha := findNode(t,ctx,"Alpha")
+	gamma := findNode(t,ctx,"Gamma")
+
+	for _,nan := range ctx.Store.(*MemStore).Links {
+		if nan.NFrom == alpha && nan.NTo == gamma {
+			t.Errorf("the removed link Alpha->Gamma is still stored: %v",nan)
+		}
+	}
+
+	paths,_,err := TryGetFwdPathsAsLinks(ctx,alpha,LEADSTO,3)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _,path := range paths {
+		if len(path) == 2 && path[1].Dst == gamma {
+			t.Errorf("Alpha still leads straight to Gamma: %v",path)
+		}
+	}
+}
+
+//**************************************************************
+
+func TestMemStoreDeleteLinkUsesStoredArrows(t *testing.T) {
+
+	ctx := openTestStore(t,filepath.Join(t.TempDir(),"graph.json"))
+	uploadChain(t,ctx)
+
+	fwd := ARROW_SHORT_DIR["fwd"]
+	a := GetDBNodeByNodePtr(ctx,findNode(t,ctx,"Alpha"))
+	c := GetDBNodeByNodePtr(ctx,findNode(t,ctx,"Gamma"))
+
+	// A program that hasn't loaded the arrows still deletes by them
+
+	ARROW_DIRECTORY = nil
+	INVERSE_ARROWS = make(map[ArrowPtr]ArrowPtr)
+
+	err := TryDeleteDBLink(ctx,a,Link{Arr: 99},c)
+
+	if !errors.Is(err,ErrNoSuchArrow) {
+		t.Errorf("expected ErrNoSuchArrow for an unknown arrow, got %v",err)
+	}
+
+	err = TryDeleteDBLink(ctx,a,Link{Arr: fwd},c)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _,nan := range ctx.Store.(*MemStore).Links {
+		if (nan.NFrom == a.NPtr && nan.NTo == c.NPtr) || (nan.NFrom == c.NPtr && nan.NTo == a.NPtr) {
+			t.Errorf("the deleted link or its inverse is still stored: %v",nan)
+		}
+	}
+}
+
+//**************************************************************
+
+func compileNotes(t *testing.T,config,file string) (PoSST,bool) {
+
+	// What N4L would make of a file, in a store of its own, or false
+	// if the notes have errors
+
+	t.Helper()
+
+	resetMemoryGraph()
+	N4LInit(io.Discard)
+	N4LParseConfig(config)
+
+	N4L_COMPILER.Compile(file)
+
+	if N4L_REPORTER.Errors > 0 {
+		return PoSST{},false
+	}
+
+	ctx,err := TryOpenMemoryGraph()
+
+	if err != nil {
+		t.Fatalf("%s: %v",file,err)
+	}
+
+	return ctx,true
+}
+
+//**************************************************************
+
+func graphByText(ctx PoSST) ([]string,[]string) {
+
+	// The nodes and links of a store, by their text, since the same
+	// notes compiled again may number them differently
+
+	m := ctx.Store.(*MemStore)
+
+	var nodes,links []string
+
+	for _,n := range m.Nodes {
+		nodes = append(nodes,fmt.Sprintf("%s\t%s",n.Chap,n.S))
+	}
+
+	const nolink = 999 // placeholders for nodes without links
+
+	for _,l := range m.Links {
+
+		if l.STType == nolink {
+			continue
+		}
+
+		// A text in another capitalization of one already stored
+		// isn't stored, as in postgres, so its links name no node
+
+		if m.Nodes[l.NFrom] == nil || m.Nodes[l.NTo] == nil {
+			continue
+		}
+
+		ctx := append([]string{},l.Ctx...)
+		sort.Strings(ctx)
+		links = append(links,fmt.Sprintf("%s -(%s,%g,%v)-> %s",m.Nodes[l.NFrom].S,ARROW_DIRECTORY[l.Arr].Long,l.Wgt,ctx,m.Nodes[l.NTo].S))
+	}
+
+	sort.Strings(nodes)
+	sort.Strings(links)
+
+	return nodes,links
+}
+
+//**************************************************************
+
+func TestMemStoreDecompileRoundTrip(t *testing.T) {
+
+	// Compile each example, write it back out as N4L and compile that:
+	// the nodes and links should be the same
+
+	config := "../../examples/N4Lconfig.in"
+	files,_ := filepath.Glob("../../examples/*.n4l")
+
+	if len(files) == 0 {
+		t.Skip("no examples")
+	}
+
+	defer resetMemoryGraph()
+
+	dir := t.TempDir()
+
+	for _,file := range files {
+
+		ctx,ok := compileNotes(t,config,file)
+
+		if !ok {
+			continue // examples with mistakes in them
+		}
+
+		nodes,links := graphByText(ctx)
+
+		text,problems,err := TryDecompileN4L(ctx,"")
+
+		if err != nil {
+			t.Errorf("%s: could not decompile: %v",file,err)
+			continue
+		}
+
+		// Some texts can't be written in N4L, and say so
+
+		if len(problems) > 0 {
+			t.Logf("%s: not round trip tested, %d problem(s): %s",file,len(problems),problems[0])
+			continue
+		}
+
+		again := filepath.Join(dir,filepath.Base(file))
+
+		if err := os.WriteFile(again,[]byte(text),0644); err != nil {
+			t.Fatal(err)
+		}
+
+		ctx,ok = compileNotes(t,config,again)
+
+		if !ok {
+			t.Errorf("%s: the decompiled notes don't compile",file)
+			continue
+		}
+
+		nodes2,links2 := graphByText(ctx)
+
+		if !reflect.DeepEqual(nodes,nodes2) {
+			t.Errorf("%s: %d nodes became %d: %s",file,len(nodes),len(nodes2),firstDifference(nodes,nodes2))
+		}
+
+		if !reflect.DeepEqual(links,links2) {
+			t.Errorf("%s: %d links became %d: %s",file,len(links),len(links2),firstDifference(links,links2))
+		}
+	}
+}
+
+//**************************************************************
+
+func firstDifference(a,b []string) string {
+
+	for i := range a {
+		if i >= len(b) || a[i] != b[i] {
+			return "first lost "+strings.TrimSpace(a[i])
+		}
+	}
+
+	if len(b) > len(a) {
+		return "first gained "+strings.TrimSpace(b[len(a)])
+	}
+
+	return "same"
+}
@@ -0,0 +1,764 @@
+//**************************************************************
+//
+// Compiling N4L notes into the in-memory graph, for the N4L
+// commands, and the summaries of it that they print
+//
+//**************************************************************
+
+package SSTorytime
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	N4L "N4L"
+)
+
+//**************************************************************
+
+var (
+	N4L_COMPILER *N4L.Compiler  // the notes, see N4LInit()
+	N4L_REPORTER *N4L.Reporter  // errors and warnings
+
+	// Set by N4LFlags()
+
+	N4L_VERBOSE bool
+	N4L_DIAGNOSTIC bool    // -d, also append the account to test_output/
+	N4L_SUMMARIZE bool
+	N4L_ADJ_LIST string    // -adj, the arrows of the adjacency matrix, or "none"
+	N4L_MAX_ERRORS int
+	N4L_JSON bool
+)
+
+//**************************************************************
+
+type RCtype struct {
+	Row NodePtr
+	Col NodePtr
+}
+
+//**************************************************************
+
+func N4LFlags() {
+
+	// Register the options that all the N4L compilers share.
+	// Call before flag.Parse(), then N4LInit()
+
+	flag.Usage = N4LUsage
+	flag.BoolVar(&N4L_VERBOSE,"v",false,"verbose")
+	flag.BoolVar(&N4L_DIAGNOSTIC,"d",false,"diagnostic mode")
+	flag.BoolVar(&N4L_SUMMARIZE,"s",false,"summary (node,links...)")
+	flag.StringVar(&N4L_ADJ_LIST,"adj","none","a quoted, comma-separated list of short link names")
+	flag.IntVar(&N4L_MAX_ERRORS,"max-errors",0,"stop after this many errors (0 for no limit)")
+	flag.BoolVar(&N4L_JSON,"json",false,"print the errors and warnings as JSON")
+}
+
+//**************************************************************
+
+func N4LUsage() {
+
+	fmt.Printf("usage: N4L [-v] [-u] [-s] [file].dat\n")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+//**************************************************************
+
+func N4LInit(out io.Writer) {
+
+	// Make a compiler that builds the in-memory graph, writing its
+	// account and diagnostics to out
+
+	if N4L_DIAGNOSTIC {
+		N4L_VERBOSE = true
+	}
+
+	N4L_REPORTER = N4L.NewReporter(out)
+	N4L_REPORTER.MaxErrors = N4L_MAX_ERRORS
+	N4L_REPORTER.JSON = N4L_JSON
+
+	if N4L_DIAGNOSTIC {
+		N4L_REPORTER.LogFile = func() string { return DiagnosticName(N4L_COMPILER.File()) }
+	}
+
+	N4L_COMPILER = N4L.NewCompiler(N4L_REPORTER)
+	N4L_COMPILER.Verbose = N4L_VERBOSE
+	N4L_COMPILER.Out = out
+	N4L_COMPILER.AddNode = N4LAddNode
+	N4L_COMPILER.AddLink = N4LAddLink
+	N4L_COMPILER.AddPageLine = N4LPageMap
+	N4L_COMPILER.EnterFile = SetSourceFile
+
+	MemoryInit()
+}
+
+//**************************************************************
+
+func N4LParseConfig(filename string) {
+
+	cfg := N4L_COMPILER.Configure(filename)
+
+	// The notes can't be understood without a sound configuration
+
+	if N4L_REPORTER.Errors > 0 {
+		N4L_REPORTER.Finish()
+	}
+
+	// The mandatory arrows come first, and need no comment
+
+	var mandatory = len(N4L.MandatoryArrows())
+	var section string
+	var fwd,bwd ArrowPtr
+
+	for i,arr := range cfg.Arrows {
+
+		if i >= mandatory && arr.Section != section {
+			section = arr.Section
+			N4L_COMPILER.Box("Configuration of",section)
+		}
+
+		N4L_COMPILER.LineNum = arr.Pos.Line
+
+		switch arr.Sign {
+
+		case "+":
+			fwd = InsertArrowDirectory(arr.Section,arr.Short,arr.Long,"+")
+
+			if i >= mandatory {
+				N4L_COMPILER.PVerbose("In",arr.Section,"short name",arr.Short,"for",arr.Long,", direction","+")
+			}
+
+		case "-":
+			bwd = InsertArrowDirectory(arr.Section,arr.Short,arr.Long,"-")
+			InsertInverseArrowDirectory(fwd,bwd)
+
+			if i >= mandatory {
+				N4L_COMPILER.PVerbose("In",arr.Section,"short name",arr.Short,"for",arr.Long,", direction","-")
+			}
+
+		default:
+			index := InsertArrowDirectory(arr.Section,arr.Short,arr.Long,"both")
+			InsertInverseArrowDirectory(index,index)
+			N4L_COMPILER.PVerbose("In",arr.Section,arr.Short,"for",arr.Long,", direction","both")
+		}
+	}
+
+	if len(cfg.Marks) > 0 {
+		N4L_COMPILER.Box("Configuration of","annotations")
+	}
+
+	for _,m := range cfg.Marks {
+		N4L_COMPILER.LineNum = m.Pos.Line
+		N4L_COMPILER.PVerbose("Annotation marker",m.Mark,"defined as arrow:",m.Arrow)
+	}
+}
+
+//**************************************************************
+
+func N4LCompile(files []string) {
+
+	// Compile each file in turn, unless one before it included it,
+	// and exit if there were errors
+
+	for _,file := range files {
+
+		if N4L_COMPILER.Files.AlreadyRead(file) {
+			continue
+		}
+
+		N4L_COMPILER.Compile(file)
+
+		if N4L_REPORTER.Stopped() {
+			break
+		}
+	}
+
+	N4L_REPORTER.Finish()
+}
+
+//**************************************************************
+
+func N4LReport() {
+
+	// What -s and -adj ask for
+
+	if N4L_SUMMARIZE {
+		SummarizeGraph()
+	}
+
+	if N4L_ADJ_LIST != "none" {
+		dim, key, d_adj, u_adj := CreateAdjacencyMatrix(N4L_ADJ_LIST)
+		PrintMatrix("directed adjacency sub-matrix",dim,key,d_adj)
+		PrintMatrix("undirected adjacency sub-matrix",dim,key,u_adj)
+		evc := ComputeEVC(dim,u_adj)
+		PrintNZVector("Eigenvector centrality (EVC) score for symmetrized graph",dim,key,evc)
+	}
+}
+
+//**************************************************************
+// Memory representation
+//**************************************************************
+
+func N4LAddNode(text,annotated,chapter string) N4L.NodeRef {
+
+	var node Node
+
+	node.S = text
+	node.L,node.NPtr.Class = StorageClass(annotated)
+	node.Chap = chapter
+
+	// The compiler has warned of different capitals already
+
+	return AppendTextToDirectory(node,func(string) {})
+}
+
+//**************************************************************
+
+func N4LAddLink(from N4L.NodeRef,link N4L.Link,to N4L.NodeRef) {
+
+	AppendLinkToNode(from.(NodePtr),N4LLink(link),to.(NodePtr))
+}
+
+//**************************************************************
+
+func N4LLink(link N4L.Link) Link {
+
+	// The arrows were inserted in the order of the configuration,
+	// so an arrow's pointer is its index there
+
+	var l Link
+
+	l.Arr = ArrowPtr(link.Arrow)
+	l.Wgt = link.Weight
+	l.Ctx = link.Context
+
+	if link.Dst != nil {
+		l.Dst = link.Dst.(NodePtr)
+	}
+
+	return l
+}
+
+//**************************************************************
+
+func N4LPageMap(page N4L.PageLine) {
+
+	if page.File == "N4Lconfig.in" {
+		return
+	}
+
+	var page_event PageMap
+
+	page_event.Chapter = page.Chapter
+	page_event.Alias = page.Alias
+	page_event.Context = page.Context
+	page_event.Line = page.Line
+	page_event.File = SOURCE_FILE
+
+	for _,leg := range page.Path {
+		page_event.Path = append(page_event.Path,N4LLink(leg))
+	}
+
+	PAGE_MAP = append(PAGE_MAP,page_event)
+}
+
+//**************************************************************
+// Summaries
+//**************************************************************
+
+func SummarizeGraph() {
+
+	N4L_COMPILER.Box("SUMMARIZE GRAPH.....\n")
+
+	var count_nodes int = 0
+	var count_links [4]int
+	var total int
+
+	for class := N1GRAM; class <= GT1024; class++ {
+		switch class {
+		case N1GRAM:
+			for n := range NODE_DIRECTORY.N1directory {
+				count_nodes++
+				PrintNodeSystem(n,NODE_DIRECTORY.N1directory[n],&count_links)
+			}
+		case N2GRAM:
+			for n := range NODE_DIRECTORY.N2directory {
+				count_nodes++
+				PrintNodeSystem(n,NODE_DIRECTORY.N2directory[n],&count_links)
+			}
+		case N3GRAM:
+			for n := range NODE_DIRECTORY.N3directory {
+				count_nodes++
+				PrintNodeSystem(n,NODE_DIRECTORY.N3directory[n],&count_links)
+			}
+		case LT128:
+			for n := range NODE_DIRECTORY.LT128 {
+				count_nodes++
+				PrintNodeSystem(n,NODE_DIRECTORY.LT128[n],&count_links)
+			}
+		case LT1024:
+			for n := range NODE_DIRECTORY.LT1024 {
+				count_nodes++
+				PrintNodeSystem(n,NODE_DIRECTORY.LT1024[n],&count_links)
+			}
+		case GT1024:
+			for n := range NODE_DIRECTORY.GT1024 {
+				count_nodes++
+				PrintNodeSystem(n,NODE_DIRECTORY.GT1024[n],&count_links)
+			}
+		}
+	}
+
+	fmt.Println("-------------------------------------")
+	fmt.Println("Incidence summary of raw declarations")
+	fmt.Println("-------------------------------------")
+
+	fmt.Println("Total nodes",count_nodes)
+
+	for st := 0; st < 4; st++ {
+		total += count_links[st]
+		fmt.Println("Total directed links of type",STTypeName(st),count_links[st])
+	}
+
+	complete := count_nodes * (count_nodes-1)
+	fmt.Println("Total links",total,"sparseness (fraction of completeness)",float64(total)/float64(complete))
+}
+
+//**************************************************************
+
+func PrintNodeSystem(n int,org Node, count_links *[4]int) {
+
+	fmt.Println(n,"\t",org.S)
+
+	for sttype := range org.I {
+		for lnk := range org.I[sttype] {
+			count_links[FlatSTType(sttype)]++
+			PrintLink(org.I[sttype][lnk])
+		}
+	}
+	fmt.Println()
+}
+
+//**************************************************************
+
+func PrintLink(l Link) {
+
+	to := GetNodeTxtFromPtr(l.Dst)
+	arrow := ARROW_DIRECTORY[l.Arr]
+	Verbose("\t ... --(",arrow.Long,",",l.Wgt,")->",to,l.Ctx," \t . . .",PrintSTAIndex(arrow.STAindex))
+}
+
+//**************************************************************
+
+func FlatSTType(i int) int {
+
+	n := i - ST_ZERO
+	if n < 0 {
+		n = -n
+	}
+
+	return n
+}
+
+//**************************************************************
+// Adjacency and eigenvector centrality
+//**************************************************************
+
+func CreateAdjacencyMatrix(searchlist string) (int,[]NodePtr,[][]float64,[][]float64) {
+
+	search_list := ValidateLinkArgs(searchlist)
+
+	// the matrix is dim x dim
+
+	filtered_node_list,path_weights := AssembleInvolvedNodes(search_list)
+
+	dim := len(filtered_node_list)
+
+	for f := 0; f < len(filtered_node_list); f++ {
+		Verbose("    - row/col key [",f,"/",dim,"]",GetNodeTxtFromPtr(filtered_node_list[f]))
+	}
+
+	var subadj_matrix [][]float64 = make([][]float64,dim)
+	var symadj_matrix [][]float64 = make([][]float64,dim)
+
+	for row := 0; row < dim; row++ {
+		subadj_matrix [row] = make([]float64,dim)
+		symadj_matrix [row] = make([]float64,dim)
+	}
+
+	for row := 0; row < dim; row++ {
+		for col := 0; col < dim; col++ {
+
+			var rc, rcT RCtype
+			rc.Row = filtered_node_list[row]
+			rc.Col = filtered_node_list[col]
+
+			rcT.Row = filtered_node_list[col]
+			rcT.Col = filtered_node_list[row]
+
+			subadj_matrix[row][col] = path_weights[rc]
+
+			symadj_matrix[row][col] = path_weights[rc] + path_weights[rcT]
+			symadj_matrix[col][row] = path_weights[rc] + path_weights[rcT]
+		}
+	}
+
+	return dim, filtered_node_list, subadj_matrix, symadj_matrix
+}
+
+//**************************************************************
+
+func PrintMatrix(name string, dim int, key []NodePtr, matrix [][]float64) {
+
+	s := "\n "+name+" ...\n\n"
+	Verbose(s)
+
+	for row := 0; row < dim; row++ {
+
+		s = fmt.Sprintf("%20.15s ..\r\t\t\t(",GetNodeTxtFromPtr(key[row]))
+
+		for col := 0; col < dim; col++ {
+
+			const screenwidth = 12
+
+			if col > screenwidth {
+				s += fmt.Sprint("\t...")
+				break
+			} else {
+				s += fmt.Sprintf("  %4.1f",matrix[row][col])
+			}
+		}
+		s += fmt.Sprint(")")
+		Verbose(s)
+	}
+}
+
+//**************************************************************
+
+func PrintNZVector(name string, dim int, key []NodePtr, vector[]float64) {
+
+	s := "\n "+name+" ...\n\n"
+
+	Verbose(s)
+
+	type KV struct {
+		Key string
+		Value float64
+	}
+
+	var vec []KV = make([]KV,dim)
+
+	for row := 0; row < dim; row++ {
+		vec[row].Key = GetNodeTxtFromPtr(key[row])
+		vec[row].Value = vector[row]
+	}
+
+	sort.SliceStable(vec, func(i, j int) bool {
+		return vec[i].Value > vec[j].Value
+	})
+
+	for row := 0; row < dim; row++ {
+		if vec[row].Value > 0.1 {
+			s = fmt.Sprintf("ordered by EVC:  (%4.1f)  ",vec[row].Value)
+			s += fmt.Sprintf("%-80.79s",vec[row].Key)
+			Verbose(s)
+		}
+	}
+}
+
+//**************************************************************
+
+func ComputeEVC(dim int,adj [][]float64) []float64 {
+
+	v := MakeInitVector(dim,1.0)
+	vlast := v
+
+	const several = 6
+
+	for i := 0; i < several; i++ {
+
+		v = MatrixOpVector(dim,adj,vlast)
+
+		if CompareVec(v,vlast) < 0.1 {
+			break
+		}
+		vlast = v
+	}
+
+	maxval := GetVecMax(v)
+	v = NormalizeVec(v,maxval)
+
+	return v
+}
+
+//**************************************************************
+
+func MakeInitVector(dim int, init_value float64) []float64 {
+
+	var v = make([]float64,dim)
+
+	for r := 0; r < dim; r++ {
+		v[r] = init_value
+	}
+
+	return v
+}
+
+//**************************************************************
+
+func MatrixOpVector(dim int,m [][]float64, v []float64) []float64 {
+
+	var vp = make([]float64,dim)
+
+	for r := 0; r < dim; r++ {
+		for c := 0; c < dim; c++ {
+			if m[r][c] != 0 {
+				vp[r] += m[r][c] * v[c]
+			}
+		}
+	}
+	return vp
+}
+
+//**************************************************************
+
+func GetVecMax(v []float64) float64 {
+
+	var max float64 = -1
+
+	for r := range v {
+		if v[r] > max {
+			max = v[r]
+		}
+	}
+
+	return max
+}
+
+//**************************************************************
+
+func NormalizeVec(v []float64, div float64) []float64 {
+
+	for r := range v {
+		v[r] = v[r] / div
+	}
+
+	return v
+}
+
+//**************************************************************
+
+func CompareVec(v1,v2 []float64) float64 {
+
+	var max float64 = -1
+
+	for r := range v1 {
+		diff := v1[r]-v2[r]
+
+		if diff < 0 {
+			diff = -diff
+		}
+
+		if diff > max {
+			max = diff
+		}
+	}
+
+	return max
+}
+
+//**************************************************************
+
+func ValidateLinkArgs(s string) []ArrowPtr {
+
+	list := strings.Split(s,",")
+	var search_list []ArrowPtr
+
+	if s == "" || s == "all" {
+		return nil
+	}
+
+	for i := range list {
+		v,ok := ARROW_SHORT_DIR[list[i]]
+
+		if ok {
+			typ := FlatSTType(ARROW_DIRECTORY[v].STAindex)
+			name := ARROW_DIRECTORY[v].Long
+			ptr := ARROW_DIRECTORY[v].Ptr
+
+			fmt.Println(" - including search pathway STtype",STTypeName(typ),"->",name)
+			search_list = append(search_list,ptr)
+
+			if typ != NEAR {
+				inverse := INVERSE_ARROWS[ptr]
+				fmt.Println("   including inverse meaning",ARROW_DIRECTORY[inverse].Long)
+				search_list = append(search_list,inverse)
+			}
+		} else {
+			fmt.Println("\nThere is no link abbreviation called ",list[i])
+			os.Exit(-1)
+		}
+	}
+
+	return search_list
+}
+
+//**************************************************************
+
+func AssembleInvolvedNodes(search_list []ArrowPtr) ([]NodePtr,map[RCtype]float64) {
+
+	var node_list []NodePtr
+	var weights = make(map[RCtype]float64)
+
+	for class := N1GRAM; class <= GT1024; class++ {
+
+		switch class {
+		case N1GRAM:
+			for n := range NODE_DIRECTORY.N1directory {
+				node_list = SearchIncidentRowClass(NODE_DIRECTORY.N1directory[n],search_list,node_list,weights)
+			}
+		case N2GRAM:
+			for n := range NODE_DIRECTORY.N2directory {
+				node_list = SearchIncidentRowClass(NODE_DIRECTORY.N2directory[n],search_list,node_list,weights)
+			}
+		case N3GRAM:
+			for n := range NODE_DIRECTORY.N3directory {
+				node_list = SearchIncidentRowClass(NODE_DIRECTORY.N3directory[n],search_list,node_list,weights)
+			}
+		case LT128:
+			for n := range NODE_DIRECTORY.LT128 {
+				node_list = SearchIncidentRowClass(NODE_DIRECTORY.LT128[n],search_list,node_list,weights)
+			}
+		case LT1024:
+			for n := range NODE_DIRECTORY.LT1024 {
+				node_list = SearchIncidentRowClass(NODE_DIRECTORY.LT1024[n],search_list,node_list,weights)
+			}
+		case GT1024:
+			for n := range NODE_DIRECTORY.GT1024 {
+				node_list = SearchIncidentRowClass(NODE_DIRECTORY.GT1024[n],search_list,node_list,weights)
+			}
+		}
+	}
+
+	return node_list,weights
+}
+
+//**************************************************************
+
+func SearchIncidentRowClass(node Node, searcharrows []ArrowPtr,node_list []NodePtr,ret_weights map[RCtype]float64) []NodePtr {
+
+	var row_nodes = make(map[NodePtr]bool)
+	var ret_nodes []NodePtr
+
+	var rc,cr RCtype
+
+	rc.Row = node.NPtr // transposes
+	cr.Col = node.NPtr
+
+	// flip backward facing arrows
+	const inverse_flip_arrow = ST_ZERO
+
+	// Only sum over outgoing (+) links
+
+	for sttype := ST_ZERO; sttype < len(node.I); sttype++ {
+
+		for lnk := range node.I[sttype] {
+			arrowptr := node.I[sttype][lnk].Arr
+
+			if len(searcharrows) == 0 {
+				match := node.I[sttype][lnk]
+				row_nodes[match.Dst] = true
+				rc.Col = match.Dst
+				cr.Row = match.Dst
+
+				if sttype < inverse_flip_arrow {
+					ret_weights[cr] += match.Wgt  // flip arrow
+				} else {
+					ret_weights[rc] += match.Wgt
+				}
+			} else {
+				for l := range searcharrows {
+					if arrowptr == searcharrows[l] {
+						match := node.I[sttype][lnk]
+						row_nodes[match.Dst] = true
+						rc.Col = match.Dst
+						cr.Row = match.Dst
+						if sttype < inverse_flip_arrow {
+							ret_weights[cr] += match.Wgt  // flip arrow
+						} else {
+							ret_weights[rc] += match.Wgt
+						}
+					}
+				}
+			}
+		}
+	}
+
+	if len(row_nodes) > 0 {
+		row_nodes[node.NPtr] = true // Add the parent if it has children
+	}
+
+	for nptr := range node_list {
+		row_nodes[node_list[nptr]] = true
+	}
+
+	// Merge idempotently
+
+	for nptr := range row_nodes {
+		ret_nodes = append(ret_nodes,nptr)
+	}
+
+	return ret_nodes
+}
+
+//**************************************************************
+// Tools
+//**************************************************************
+
+func Verbose(a ...interface{}) {
+
+	line := fmt.Sprintln(a...)
+
+	if N4L_DIAGNOSTIC {
+		AppendStringToFile(DiagnosticName(N4L_COMPILER.File()),line)
+	}
+
+	if N4L_VERBOSE {
+		fmt.Print(line)
+	}
+}
+
+//**************************************************************
+
+func DiagnosticName(filename string) string {
+
+	return "test_output/"+filename+"_test_log"
+}
+
+//**************************************************************
+
+func AppendStringToFile(name string, s string) {
+
+	// strip out \r that mess up the file format but are useful for term
+
+	san := strings.Replace(s,"\r","",-1)
+
+	f, err := os.OpenFile(name,os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+
+	if err != nil {
+		fmt.Println("Couldn't open for write/append to",name,err)
+		return
+	}
+
+	_, err = f.WriteString(san)
+
+	if err != nil {
+		fmt.Println("Couldn't write/append to",name,err)
+	}
+
+	f.Close()
+}
@@ -0,0 +1,638 @@
+//**************************************************************
+//
+// paths.go - growing cone paths level by level
+//
+// The cone searches used to build their paths by recursion in
+// plpgsql, concatenating one long string that had to be parsed
+// again, which took minutes or ran out of memory on dense graphs.
+// Here the paths grow breadth first, fetching each level's nodes in
+// one query (see GetDBNodesByNodePtrs), and come back as links
+//
+//**************************************************************
+
+package SSTorytime
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+)
+
+//**************************************************************
+
+// A single search stops growing paths when it has this many, so the
+// deeper levels of a dense cone may be left out
+
+var MAX_CONE_PATHS = 10000
+
+//**************************************************************
+
+type NodeFetcher func(nptrs []NodePtr) (map[NodePtr]Node,error)
+
+//**************************************************************
+
+type pathStep struct {  // the paths of ExpandPaths() form a tree
+
+	lnk    Link
+	parent *pathStep
+	index  int              // position among the parent's steps
+	steps  []*pathStep      // the steps taken from here so far, in order
+	taken  map[NodePtr]int  // and their index, by Dst
+	cost   float64          // for the weighted search
+	seq    int
+}
+
+//**************************************************************
+
+func (s *pathStep) Add(lnk Link) *pathStep {
+
+	if s.taken == nil {
+		s.taken = make(map[NodePtr]int)
+	}
+
+	var step pathStep
+
+	step.lnk = lnk
+	step.parent = s
+	step.index = len(s.steps)
+
+	s.taken[lnk.Dst] = step.index
+	s.steps = append(s.steps,&step)
+
+	return &step
+}
+
+//**************************************************************
+
+func (s *pathStep) Excludes(dst NodePtr) bool {
+
+	// As the exclude list of the recursive versions: a path never
+	// returns to a node on it, nor to a node that an earlier branch
+	// at any of its forks went to, which makes it cycle safe
+
+	if _,taken := s.taken[dst]; taken {
+		return true
+	}
+
+	for c := s; c != nil; c = c.parent {
+
+		if c.lnk.Dst == dst {
+			return true
+		}
+
+		if c.parent != nil {
+			if index,taken := c.parent.taken[dst]; taken && index < c.index {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+//**************************************************************
+
+func (s *pathStep) Paths(retval [][]Link) [][]Link {
+
+	// The paths ending in the leaves below s, in depth first order
+
+	if len(s.steps) == 0 {
+		if s.parent != nil {
+			retval = append(retval,s.Path())
+		}
+		return retval
+	}
+
+	for _,step := range s.steps {
+		retval = step.Paths(retval)
+	}
+
+	return retval
+}
+
+//**************************************************************
+
+func (s *pathStep) Path() []Link {
+
+	var depth int
+
+	for c := s; c != nil; c = c.parent {
+		depth++
+	}
+
+	path := make([]Link,depth)
+
+	for c := s; c != nil; c = c.parent {
+		depth--
+		path[depth] = c.lnk
+	}
+
+	return path
+}
+
+//**************************************************************
+
+func DBNodeFetcher(ctx PoSST) NodeFetcher {
+
+	return func(nptrs []NodePtr) (map[NodePtr]Node,error) {
+		return TryGetDBNodesByNodePtrs(ctx,nptrs)
+	}
+}
+
+//**************************************************************
+
+func ExpandPaths(fetch NodeFetcher,start []NodePtr,sttypes []int,maxdepth int,chapter string,context []string) ([][]Link,error) {
+
+	// All the paths of up to maxdepth nodes from each start node,
+	// following links of the sttypes in that order, from nodes whose
+	// chapter matches and with links whose context matches. Paths that
+	// never leave their start node are left out
+
+	exclude := make(map[NodePtr]bool)
+
+	var roots,level []*pathStep
+
+	for s := range start {
+		exclude[start[s]] = true
+		roots = append(roots,&pathStep{lnk: SingletonLink(start[s])})
+	}
+
+	level = roots
+	paths := len(roots)
+
+	for depth := 1; depth < maxdepth && len(level) > 0 && paths < MAX_CONE_PATHS; depth++ {
+
+		var ends []NodePtr
+
+		for _,step := range level {
+			ends = append(ends,step.lnk.Dst)
+		}
+
+		nodes,err := fetch(ends)
+
+		if err != nil {
+			return nil,err
+		}
+
+		var next []*pathStep
+
+		for _,step := range level {
+
+			n,exists := nodes[step.lnk.Dst]
+
+			if !exists || !SQLLikeLower(n.Chap,"%"+chapter+"%",false) {
+				continue
+			}
+
+			for _,st := range sttypes {
+
+				for _,lnk := range n.I[STTypeToSTIndex(st)] {
+
+					if lnk.Arr == 0 || exclude[lnk.Dst] || !SQLMatchContext(lnk.Ctx,context) || step.Excludes(lnk.Dst) {
+						continue
+					}
+
+					// Every fork makes one more path
+
+					if len(step.steps) > 0 {
+
+						if paths >= MAX_CONE_PATHS {
+							break
+						}
+
+						paths++
+					}
+
+					next = append(next,step.Add(lnk))
+				}
+			}
+		}
+
+		level = next
+	}
+
+	var retval [][]Link
+
+	for _,root := range roots {
+		retval = root.Paths(retval)
+	}
+
+	return retval,nil
+}
+
+//**************************************************************
+
+func ConeSTTypes(orientation string) []int {
+
+	// The link types followed by GetEntireConePathsAsLinks()
+
+	switch orientation {
+	case "bwd":
+		return []int{-EXPRESS,-CONTAINS,-LEADSTO,NEAR}
+	case "fwd":
+		return []int{NEAR,LEADSTO,CONTAINS,EXPRESS}
+	default:
+		return []int{-EXPRESS,-CONTAINS,-LEADSTO,NEAR,LEADSTO,CONTAINS,EXPRESS}
+	}
+}
+
+//**************************************************************
+
+func NCConeSTTypes(orientation string) []int {
+
+	// The link types followed by GetEntireNCConePathsAsLinks(), in an
+	// order that respects the geometry of the temporal links, so that
+	// (then) will always come last for visual sensemaking
+
+	switch orientation {
+	case "bwd":
+		return []int{-LEADSTO,NEAR,-CONTAINS,-EXPRESS}
+	case "fwd":
+		return []int{NEAR,CONTAINS,EXPRESS,LEADSTO}
+	default:
+		return []int{-LEADSTO,NEAR,CONTAINS,-CONTAINS,EXPRESS,-EXPRESS,LEADSTO}
+	}
+}
+
+//**************************************************************
+
+func SortPathsByLength(paths [][]Link) {
+
+	sort.SliceStable(paths, func(i,j int) bool {
+		return len(paths[i]) < len(paths[j])
+	})
+}
+
+//**************************************************************
+// Weighted shortest paths
+//**************************************************************
+
+type linkGraph struct {  // the part of the graph a weighted search has seen
+
+	fetch   NodeFetcher
+	nodes   map[NodePtr]Node
+	loaded  map[NodePtr]bool
+	sttypes []int
+	arrows  map[ArrowPtr]bool
+	chapter string
+	context []string
+}
+
+//**************************************************************
+
+func (g *linkGraph) Load(nptrs []NodePtr) error {
+
+	var missing []NodePtr
+
+	for _,nptr := range nptrs {
+		if !g.loaded[nptr] {
+			g.loaded[nptr] = true
+			missing = append(missing,nptr)
+		}
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	nodes,err := g.fetch(missing)
+
+	for nptr,n := range nodes {
+		g.nodes[nptr] = n
+	}
+
+	return err
+}
+
+//**************************************************************
+
+func (g *linkGraph) Links(nptr NodePtr) ([]Link,error) {
+
+	// The links a path may take from nptr. The nodes they lead to are
+	// fetched together now, as the search will probably need them next
+
+	err := g.Load([]NodePtr{nptr})
+
+	if err != nil {
+		return nil,err
+	}
+
+	n,exists := g.nodes[nptr]
+
+	if !exists || !SQLLikeLower(n.Chap,"%"+g.chapter+"%",false) {
+		return nil,nil
+	}
+
+	var links []Link
+	var next []NodePtr
+
+	for _,st := range g.sttypes {
+
+		for _,lnk := range n.I[STTypeToSTIndex(st)] {
+
+			if lnk.Arr == 0 || !SQLMatchContext(lnk.Ctx,g.context) {
+				continue
+			}
+
+			if len(g.arrows) > 0 && !g.arrows[lnk.Arr] {
+				continue
+			}
+
+			if lnk.Wgt < 0 {
+				return nil,fmt.Errorf("%w: %v -(%d,%g)-> %v",ErrNegativeWeight,nptr,lnk.Arr,lnk.Wgt,lnk.Dst)
+			}
+
+			links = append(links,lnk)
+			next = append(next,lnk.Dst)
+		}
+	}
+
+	return links,g.Load(next)
+}
+
+//**************************************************************
+
+type pathQueue []*pathStep  // a priority queue of the cheapest steps first
+
+func (q pathQueue) Len() int { return len(q) }
+func (q pathQueue) Less(i,j int) bool { return q[i].cost < q[j].cost || q[i].cost == q[j].cost && q[i].seq < q[j].seq }
+func (q pathQueue) Swap(i,j int) { q[i],q[j] = q[j],q[i] }
+func (q *pathQueue) Push(x interface{}) { *q = append(*q,x.(*pathStep)) }
+func (q *pathQueue) Pop() interface{} { old := *q; x := old[len(old)-1]; *q = old[:len(old)-1]; return x }
+
+//**************************************************************
+
+func (g *linkGraph) Cheapest(sources []NodePtr,targets map[NodePtr]bool,banned_nodes map[NodePtr]bool,banned_links map[LinkKey]bool) ([]Link,float64,error) {
+
+	// Dijkstra from any of the sources to the nearest of the targets,
+	// avoiding the banned nodes and links, or nil if there is no way
+
+	settled := make(map[NodePtr]bool)
+	best := make(map[NodePtr]float64)
+
+	var queue pathQueue
+	var seq int
+
+	for _,src := range sources {
+		if !banned_nodes[src] {
+			heap.Push(&queue,&pathStep{lnk: SingletonLink(src),seq: seq})
+			best[src] = 0
+			seq++
+		}
+	}
+
+	for queue.Len() > 0 {
+
+		step := heap.Pop(&queue).(*pathStep)
+		here := step.lnk.Dst
+
+		if settled[here] {
+			continue
+		}
+
+		settled[here] = true
+
+		if step.parent != nil && targets[here] {
+			return step.Path(),step.cost,nil
+		}
+
+		links,err := g.Links(here)
+
+		if err != nil {
+			return nil,0,err
+		}
+
+		for _,lnk := range links {
+
+			if settled[lnk.Dst] || banned_nodes[lnk.Dst] || banned_links[LinkKey{NFrom: here, Arr: lnk.Arr, NTo: lnk.Dst}] {
+				continue
+			}
+
+			cost := step.cost + lnk.Wgt
+
+			if prev,seen := best[lnk.Dst]; seen && prev <= cost {
+				continue
+			}
+
+			best[lnk.Dst] = cost
+			heap.Push(&queue,&pathStep{lnk: lnk,parent: step,cost: cost,seq: seq})
+			seq++
+		}
+	}
+
+	return nil,0,nil
+}
+
+//**************************************************************
+
+func GetShortestWeightedPaths(ctx PoSST,from,to []NodePtr,arrows []ArrowPtr,chapter string,context []string,k int) [][]Link {
+
+	paths,err := TryGetShortestWeightedPaths(ctx,from,to,arrows,chapter,context,k)
+
+	if err != nil {
+		fmt.Println("GetShortestWeightedPaths failed:",err)
+
+		if errors.Is(err,ErrNegativeWeight) {
+			os.Exit(-1)
+		}
+	}
+
+	return paths
+}
+
+//**************************************************************
+
+func TryGetShortestWeightedPaths(ctx PoSST,from,to []NodePtr,arrows []ArrowPtr,chapter string,context []string,k int) ([][]Link,error) {
+
+	// The k cheapest paths from the from set to the to set, cheapest
+	// first, where a path costs the sum of its link weights, following
+	// only the given arrows, or else any forward link. Yen's algorithm:
+	// each further path leaves one of the previous at some node (or
+	// starts elsewhere), and is the cheapest way that hasn't been taken
+
+	var g linkGraph
+
+	g.fetch = DBNodeFetcher(ctx)
+	g.nodes = make(map[NodePtr]Node)
+	g.loaded = make(map[NodePtr]bool)
+	g.arrows = make(map[ArrowPtr]bool)
+	g.chapter = chapter
+	g.context = context
+
+	if len(arrows) == 0 {
+		g.sttypes = NCConeSTTypes("fwd")
+	}
+
+	channels := make(map[int]bool)
+
+	for _,arr := range arrows {
+
+		if arr < 0 || int(arr) >= len(ARROW_DIRECTORY) {
+			return nil,fmt.Errorf("%w: %d",ErrNoSuchArrow,arr)
+		}
+
+		st := STIndexToSTType(ARROW_DIRECTORY[arr].STAindex)
+
+		if !channels[st] {
+			channels[st] = true
+			g.sttypes = append(g.sttypes,st)
+		}
+
+		g.arrows[arr] = true
+	}
+
+	// A path has to go somewhere
+
+	starts := make(map[NodePtr]bool)
+	targets := make(map[NodePtr]bool)
+
+	for _,nptr := range from {
+		starts[nptr] = true
+	}
+
+	for _,nptr := range to {
+		if !starts[nptr] {
+			targets[nptr] = true
+		}
+	}
+
+	if k < 1 {
+		k = 1
+	}
+
+	first,_,err := g.Cheapest(from,targets,nil,nil)
+
+	if err != nil || first == nil {
+		return nil,err
+	}
+
+	found := [][]Link{first}
+
+	var candidates [][]Link
+
+	for len(found) < k {
+
+		last := found[len(found)-1]
+
+		// Leave the last path after its first i+1 nodes, or at -1 choose
+		// another start
+
+		for i := -1; i < len(last)-1; i++ {
+
+			root := last[:i+1]
+
+			banned_nodes := make(map[NodePtr]bool)
+			banned_links := make(map[LinkKey]bool)
+
+			for _,lnk := range root[:max(i,0)] {  // all but the spur node
+				banned_nodes[lnk.Dst] = true
+			}
+
+			for _,path := range found {
+
+				if len(path) <= i+1 || !SamePathPrefix(path,root) {
+					continue
+				}
+
+				if i < 0 {
+					banned_nodes[path[0].Dst] = true
+				} else {
+					banned_links[LinkKey{NFrom: path[i].Dst, Arr: path[i+1].Arr, NTo: path[i+1].Dst}] = true
+				}
+			}
+
+			var sources []NodePtr
+
+			if i < 0 {
+				sources = from
+			} else {
+				sources = []NodePtr{last[i].Dst}
+			}
+
+			spur,_,err := g.Cheapest(sources,targets,banned_nodes,banned_links)
+
+			if err != nil {
+				return found,err
+			}
+
+			if spur == nil {
+				continue
+			}
+
+			var path []Link
+
+			if i < 0 {
+				path = spur
+			} else {
+				path = append(append([]Link{},root...),spur[1:]...)
+			}
+
+			if !InPathSet(found,path) && !InPathSet(candidates,path) {
+				candidates = append(candidates,path)
+			}
+		}
+
+		if len(candidates) == 0 {
+			break
+		}
+
+		// Promote the cheapest candidate, preferring fewer hops
+
+		sort.SliceStable(candidates, func(i,j int) bool {
+			ci,cj := PathCost(candidates[i]),PathCost(candidates[j])
+			return ci < cj || ci == cj && len(candidates[i]) < len(candidates[j])
+		})
+
+		found = append(found,candidates[0])
+		candidates = candidates[1:]
+	}
+
+	return found,nil
+}
+
+//**************************************************************
+
+func PathCost(path []Link) float64 {
+
+	// The sum of the link weights, not counting the start
+
+	var cost float64
+
+	for l := 1; l < len(path); l++ {
+		cost += path[l].Wgt
+	}
+
+	return cost
+}
+
+//**************************************************************
+
+func SamePathPrefix(path,prefix []Link) bool {
+
+	if len(path) < len(prefix) {
+		return false
+	}
+
+	for l := range prefix {
+		if path[l].Dst != prefix[l].Dst || (l > 0 && path[l].Arr != prefix[l].Arr) {
+			return false
+		}
+	}
+
+	return true
+}
+
+//**************************************************************
+
+func InPathSet(paths [][]Link,path []Link) bool {
+
+	for p := range paths {
+		if len(paths[p]) == len(path) && SamePathPrefix(paths[p],path) {
+			return true
+		}
+	}
+
+	return false
+}
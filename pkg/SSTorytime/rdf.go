@@ -0,0 +1,333 @@
+//**************************************************************
+//
+// rdf.go
+//
+// Export as RDF, in Turtle or JSON-LD. Each arrow becomes a
+// predicate, a sub-property of its STtype, and the context and
+// weight of a link are said about it by reification
+//
+//**************************************************************
+
+package SSTorytime
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+//**************************************************************
+
+const (
+	RDF_NS  = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	RDFS_NS = "http://www.w3.org/2000/01/rdf-schema#"
+	OWL_NS  = "http://www.w3.org/2002/07/owl#"
+	XSD_NS  = "http://www.w3.org/2001/XMLSchema#"
+	SST_NS  = "https://github.com/markburgess/SSTorytime/vocab#"
+)
+
+//**************************************************************
+
+var EXPORT_BASE_IRI = "urn:sstorytime:"  // nodes are <base>node:class_cptr
+
+//**************************************************************
+
+func STTypeProperty(sttype int) string {
+
+	// The super-property of all arrows of an STtype, in the sst: namespace
+
+	switch sttype {
+	case -EXPRESS:
+		return "expressedBy"
+	case -CONTAINS:
+		return "containedBy"
+	case -LEADSTO:
+		return "comesFrom"
+	case NEAR:
+		return "near"
+	case LEADSTO:
+		return "leadsTo"
+	case CONTAINS:
+		return "contains"
+	case EXPRESS:
+		return "expresses"
+	}
+
+	return "unknown"
+}
+
+//**************************************************************
+
+func NodeIRI(nptr NodePtr) string {
+
+	return fmt.Sprintf("%snode:%d_%d",EXPORT_BASE_IRI,nptr.Class,nptr.CPtr)
+}
+
+//**************************************************************
+
+func ArrowIRI(arrow ArrowDirectory) string {
+
+	return EXPORT_BASE_IRI + "arrow:" + IRIEscape(arrow.Short)
+}
+
+//**************************************************************
+
+func IRIEscape(s string) string {
+
+	// Percent encode all but the unreserved characters of RFC 3986
+
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+
+		c := s[i]
+
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("-._~",c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b,"%%%02X",c)
+		}
+	}
+
+	return b.String()
+}
+
+//**************************************************************
+
+func TurtleString(s string) string {
+
+	r := strings.NewReplacer("\\","\\\\","\"","\\\"","\n","\\n","\r","\\r","\t","\\t")
+	return "\"" + r.Replace(s) + "\""
+}
+
+//**************************************************************
+
+func exportArrowOrder(g ExportGraph) []ArrowDirectory {
+
+	var arrows []ArrowDirectory
+
+	for _,a := range g.Arrows {
+		arrows = append(arrows,a)
+	}
+
+	sort.Slice(arrows, func(i,j int) bool {
+		return arrows[i].Ptr < arrows[j].Ptr
+	})
+
+	return arrows
+}
+
+//**************************************************************
+
+func exportReified(e ExportEdge) bool {
+
+	// Only links that carry more than the triple itself
+
+	return len(e.Ctx) > 0 || e.Wgt != 1
+}
+
+//**************************************************************
+
+func ExportTurtle(g ExportGraph) string {
+
+	var b strings.Builder
+
+	fmt.Fprintf(&b,"# %s\n\n",strings.ReplaceAll(g.Title,"\n"," "))
+	fmt.Fprintf(&b,"@prefix rdf: <%s> .\n",RDF_NS)
+	fmt.Fprintf(&b,"@prefix rdfs: <%s> .\n",RDFS_NS)
+	fmt.Fprintf(&b,"@prefix owl: <%s> .\n",OWL_NS)
+	fmt.Fprintf(&b,"@prefix xsd: <%s> .\n",XSD_NS)
+	fmt.Fprintf(&b,"@prefix sst: <%s> .\n",SST_NS)
+	fmt.Fprintf(&b,"@prefix node: <%snode:> .\n\n",EXPORT_BASE_IRI)
+
+	// The four STtypes and their inverses
+
+	b.WriteString("sst:Node a rdfs:Class .\n")
+
+	for sttype := -EXPRESS; sttype <= EXPRESS; sttype++ {
+
+		fmt.Fprintf(&b,"sst:%s a owl:ObjectProperty ; rdfs:label %s",STTypeProperty(sttype),TurtleString(STTypeName(sttype)[1:]))
+
+		if sttype == NEAR {
+			b.WriteString(" ; a owl:SymmetricProperty")
+		} else {
+			fmt.Fprintf(&b," ; owl:inverseOf sst:%s",STTypeProperty(-sttype))
+		}
+
+		b.WriteString(" .\n")
+	}
+
+	b.WriteString("\n")
+
+	for _,a := range exportArrowOrder(g) {
+
+		fmt.Fprintf(&b,"<%s> a owl:ObjectProperty ;\n",ArrowIRI(a))
+		fmt.Fprintf(&b,"    rdfs:label %s ;\n",TurtleString(a.Long))
+		fmt.Fprintf(&b,"    sst:shortName %s ;\n",TurtleString(a.Short))
+
+		if inverse,ok := g.Inverses[a.Ptr]; ok {
+			fmt.Fprintf(&b,"    owl:inverseOf <%s> ;\n",ArrowIRI(g.Arrows[inverse]))
+		}
+
+		fmt.Fprintf(&b,"    rdfs:subPropertyOf sst:%s .\n\n",STTypeProperty(STIndexToSTType(a.STAindex)))
+	}
+
+	for _,n := range g.Nodes {
+		fmt.Fprintf(&b,"node:%d_%d a sst:Node ; rdfs:label %s ; sst:chapter %s .\n",n.NPtr.Class,n.NPtr.CPtr,TurtleString(n.S),TurtleString(n.Chap))
+	}
+
+	b.WriteString("\n")
+
+	for _,e := range g.Edges {
+		fmt.Fprintf(&b,"node:%d_%d <%s> node:%d_%d .\n",e.From.Class,e.From.CPtr,ArrowIRI(g.Arrows[e.Arr]),e.To.Class,e.To.CPtr)
+	}
+
+	// What the triples can't say themselves
+
+	for _,e := range g.Edges {
+
+		if !exportReified(e) {
+			continue
+		}
+
+		fmt.Fprintf(&b,"\n[] a rdf:Statement ;\n")
+		fmt.Fprintf(&b,"    rdf:subject node:%d_%d ;\n",e.From.Class,e.From.CPtr)
+		fmt.Fprintf(&b,"    rdf:predicate <%s> ;\n",ArrowIRI(g.Arrows[e.Arr]))
+		fmt.Fprintf(&b,"    rdf:object node:%d_%d ;\n",e.To.Class,e.To.CPtr)
+
+		if len(e.Ctx) > 0 {
+
+			var cn []string
+
+			for _,c := range e.Ctx {
+				cn = append(cn,TurtleString(c))
+			}
+
+			fmt.Fprintf(&b,"    sst:context %s ;\n",strings.Join(cn,", "))
+		}
+
+		fmt.Fprintf(&b,"    sst:weight \"%g\"^^xsd:double .\n",e.Wgt)
+	}
+
+	return b.String()
+}
+
+//**************************************************************
+
+func ExportJSONLD(g ExportGraph) (string,error) {
+
+	// The same statements as ExportTurtle()
+
+	type object = map[string]interface{}
+
+	ref := func(iri string) object {
+		return object{"@id": iri}
+	}
+
+	var graph []object
+
+	graph = append(graph,object{"@id": "sst:Node","@type": "rdfs:Class"})
+
+	for sttype := -EXPRESS; sttype <= EXPRESS; sttype++ {
+
+		prop := object{
+			"@id": "sst:"+STTypeProperty(sttype),
+			"@type": "owl:ObjectProperty",
+			"rdfs:label": STTypeName(sttype)[1:],
+		}
+
+		if sttype == NEAR {
+			prop["@type"] = []string{"owl:ObjectProperty","owl:SymmetricProperty"}
+		} else {
+			prop["owl:inverseOf"] = ref("sst:"+STTypeProperty(-sttype))
+		}
+
+		graph = append(graph,prop)
+	}
+
+	for _,a := range exportArrowOrder(g) {
+
+		prop := object{
+			"@id": ArrowIRI(a),
+			"@type": "owl:ObjectProperty",
+			"rdfs:label": a.Long,
+			"sst:shortName": a.Short,
+			"rdfs:subPropertyOf": ref("sst:"+STTypeProperty(STIndexToSTType(a.STAindex))),
+		}
+
+		if inverse,ok := g.Inverses[a.Ptr]; ok {
+			prop["owl:inverseOf"] = ref(ArrowIRI(g.Arrows[inverse]))
+		}
+
+		graph = append(graph,prop)
+	}
+
+	var out = make(map[NodePtr]map[string][]object)
+
+	for _,e := range g.Edges {
+
+		if out[e.From] == nil {
+			out[e.From] = make(map[string][]object)
+		}
+
+		iri := ArrowIRI(g.Arrows[e.Arr])
+		out[e.From][iri] = append(out[e.From][iri],ref(NodeIRI(e.To)))
+	}
+
+	for _,n := range g.Nodes {
+
+		node := object{
+			"@id": NodeIRI(n.NPtr),
+			"@type": "sst:Node",
+			"rdfs:label": n.S,
+			"sst:chapter": n.Chap,
+		}
+
+		for iri,objects := range out[n.NPtr] {
+			node[iri] = objects
+		}
+
+		graph = append(graph,node)
+	}
+
+	for _,e := range g.Edges {
+
+		if !exportReified(e) {
+			continue
+		}
+
+		statement := object{
+			"@type": "rdf:Statement",
+			"rdf:subject": ref(NodeIRI(e.From)),
+			"rdf:predicate": ref(ArrowIRI(g.Arrows[e.Arr])),
+			"rdf:object": ref(NodeIRI(e.To)),
+			"sst:weight": object{"@value": e.Wgt,"@type": "xsd:double"},
+		}
+
+		if len(e.Ctx) > 0 {
+			statement["sst:context"] = e.Ctx
+		}
+
+		graph = append(graph,statement)
+	}
+
+	doc := object{
+		"@context": object{
+			"rdf": RDF_NS,
+			"rdfs": RDFS_NS,
+			"owl": OWL_NS,
+			"xsd": XSD_NS,
+			"sst": SST_NS,
+		},
+		"@graph": graph,
+	}
+
+	jstr,err := json.MarshalIndent(doc,"","  ")
+
+	if err != nil {
+		return "",fmt.Errorf("Unable to encode JSON-LD: %v",err)
+	}
+
+	return string(jstr)+"\n",nil
+}
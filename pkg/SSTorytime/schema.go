@@ -0,0 +1,495 @@
+//**************************************************************
+//
+// Schema versions and migrations for the postgres tables and
+// stored functions, so that old databases can be upgraded in place
+//
+//**************************************************************
+
+package SSTorytime
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+//**************************************************************
+
+const SCHEMA_VERSION_TABLE = "CREATE TABLE IF NOT EXISTS schema_version " +
+	"( " +
+	"Version     int primary key, " +
+	"Description text,            " +
+	"Applied     timestamp default now() " +
+	")"
+
+const FUNCTION_VERSION_TABLE = "CREATE TABLE IF NOT EXISTS function_version " +
+	"( " +
+	"Name        text primary key, " +
+	"Hash        text,             " +
+	"Defined     timestamp default now() " +
+	")"
+
+const SEARCH_CONFIG_TABLE = "CREATE TABLE IF NOT EXISTS search_config " +
+	"( " +
+	"Language    regconfig " +  // e.g. simple, english, norwegian
+	")"
+
+//**************************************************************
+
+type Migration struct {
+
+	Version     int
+	Description string
+	Statements  []string
+}
+
+//**************************************************************
+
+var (
+	// Set false to open a database without upgrading it, e.g. to report on it
+
+	SCHEMA_AUTO_UPGRADE bool = true
+
+	// In order, never edit or renumber one that has been released, add
+	// a new one instead. Databases made before schema_version existed
+	// replay them all, so each statement has to tolerate what is there
+
+	MIGRATIONS = []Migration{
+		// The tables as first released, spelled out. Those of
+		// today are these, as changed by the migrations after
+
+		{1,"Node, link, page map and arrow tables",[]string{
+			IgnoreDuplicate("CREATE TYPE NodePtr AS (Chan int, CPtr int)"),
+			IgnoreDuplicate("CREATE TYPE Link AS (Arr int, Wgt real, Ctx text, Dst NodePtr)"),
+			"CREATE TABLE IF NOT EXISTS PageMap " +
+				"(Chap Text, Alias Text, Ctx Text[], Line Int, Path Link[])",
+			"CREATE TABLE IF NOT EXISTS Node " +
+				"(NPtr NodePtr, L int, S text, Chap text, " +
+				"Im3 Link[], Im2 Link[], Im1 Link[], In0 Link[], Il1 Link[], Ic2 Link[], Ie3 Link[])",
+			"CREATE TABLE IF NOT EXISTS NodeArrowNode " +
+				"(NFrom NodePtr, STtype int, Arr int, Wgt int, Ctx text[], NTo NodePtr)",
+			"CREATE TABLE IF NOT EXISTS ArrowInverses " +
+				"(Plus int, Minus int, Primary Key(Plus,Minus))",
+			"CREATE TABLE IF NOT EXISTS ArrowDirectory " +
+				"(STAindex int, Long text, Short text, ArrPtr int primary key)",
+		}},
+		{2,"Source file provenance of nodes, links and page map lines",[]string{
+			"ALTER TABLE PageMap ADD COLUMN IF NOT EXISTS File text",
+			SOURCE_NODE_TABLE,
+			SOURCE_LINK_TABLE,
+		}},
+		{3,"Arrows are unique by long and short name",[]string{
+			IgnoreDuplicate("ALTER TABLE ArrowDirectory ADD CONSTRAINT arrowdirectory_long_key UNIQUE (Long)"),
+			IgnoreDuplicate("ALTER TABLE ArrowDirectory ADD CONSTRAINT arrowdirectory_short_key UNIQUE (Short)"),
+		}},
+		{4,"Indexes for arrow and text lookup",[]string{
+			"CREATE INDEX IF NOT EXISTS nodearrownode_arr_sttype ON NodeArrowNode (Arr,STType)",
+			"CREATE INDEX IF NOT EXISTS node_chan_l_s ON Node (((NPtr).Chan),L,S)",
+		}},
+		{5,"Ranked full text and trigram search on node text",[]string{
+			"CREATE EXTENSION IF NOT EXISTS pg_trgm",
+			SEARCH_CONFIG_TABLE,
+			"INSERT INTO search_config (Language) SELECT 'simple' WHERE NOT EXISTS (SELECT 1 FROM search_config)",
+			"CREATE OR REPLACE FUNCTION sst_search_language() RETURNS regconfig AS $fn$ " +
+				"SELECT COALESCE((SELECT Language FROM search_config LIMIT 1),'simple'::regconfig) " +
+				"$fn$ LANGUAGE sql STABLE",
+			"ALTER TABLE Node ADD COLUMN IF NOT EXISTS Search tsvector",
+			"CREATE OR REPLACE FUNCTION node_search_vector() RETURNS trigger AS $fn$ " +
+				"BEGIN NEW.Search := to_tsvector(sst_search_language(),COALESCE(NEW.S,'')); RETURN NEW; END " +
+				"$fn$ LANGUAGE plpgsql",
+			"DROP TRIGGER IF EXISTS node_search ON Node",
+			"CREATE TRIGGER node_search BEFORE INSERT OR UPDATE OF S ON Node FOR EACH ROW EXECUTE FUNCTION node_search_vector()",
+			"UPDATE Node SET Search=to_tsvector(sst_search_language(),COALESCE(S,''))",
+			"CREATE INDEX IF NOT EXISTS node_search ON Node USING gin (Search)",
+			"CREATE INDEX IF NOT EXISTS node_s_trgm ON Node USING gin (lower(S) gin_trgm_ops)",
+		}},
+		{6,"Link weights in NodeArrowNode are real, as in Link",[]string{
+			"ALTER TABLE NodeArrowNode ALTER COLUMN Wgt TYPE real",
+		}},
+		{7,"Saved centrality scores for ranking nodes by importance",[]string{
+			CENTRALITY_TABLE,
+		}},
+	}
+)
+
+//**************************************************************
+
+type SchemaStatus struct {
+
+	Version        int          // as recorded in the database
+	Latest         int          // as known to this program
+	Pending        []Migration
+	StaleFunctions []string     // stored functions missing or changed
+}
+
+//**************************************************************
+
+func IgnoreDuplicate(qstr string) string {
+
+	// Wrap a statement that has no IF NOT EXISTS form
+
+	return "DO $$ BEGIN " + qstr + "; " +
+		"EXCEPTION WHEN duplicate_object OR duplicate_table THEN NULL; END $$"
+}
+
+//**************************************************************
+
+func LatestSchemaVersion() int {
+
+	return MIGRATIONS[len(MIGRATIONS)-1].Version
+}
+
+//**************************************************************
+
+func TryGetDBSchemaVersion(ctx PoSST) (int,error) {
+
+	// Zero for a new database, or one from before schema_version
+
+	if ctx.Store != nil {
+		return LatestSchemaVersion(),nil
+	}
+
+	exists,err := TryDBTableExists(ctx,"schema_version")
+
+	if err != nil || !exists {
+		return 0,err
+	}
+
+	row,err := SQLConn(ctx).Query("SELECT COALESCE(max(Version),0) FROM schema_version")
+
+	if err != nil {
+		return 0,fmt.Errorf("%w: reading schema version: %v",ErrDBQuery,err)
+	}
+
+	var version int
+
+	for row.Next() {
+		err = row.Scan(&version)
+	}
+
+	row.Close()
+
+	if err != nil {
+		return 0,fmt.Errorf("%w: reading schema version: %v",ErrDBQuery,err)
+	}
+
+	return version,nil
+}
+
+//**************************************************************
+
+func TryDBTableExists(ctx PoSST,table string) (bool,error) {
+
+	row,err := SQLConn(ctx).Query("SELECT to_regclass($1) IS NOT NULL",table)
+
+	if err != nil {
+		return false,fmt.Errorf("%w: looking for table %s: %v",ErrDBQuery,table,err)
+	}
+
+	var exists bool
+
+	for row.Next() {
+		err = row.Scan(&exists)
+	}
+
+	row.Close()
+
+	if err != nil {
+		return false,fmt.Errorf("%w: looking for table %s: %v",ErrDBQuery,table,err)
+	}
+
+	return exists,nil
+}
+
+//**************************************************************
+
+func TryMigrateDB(ctx PoSST) (applied []Migration,err error) {
+
+	// Apply the migrations the database hasn't seen, in one transaction
+
+	if ctx.Store != nil {
+		return nil,nil
+	}
+
+	ctx,owned,err := TryBeginTransaction(ctx)
+
+	if err != nil {
+		return nil,err
+	}
+
+	defer func() {
+		err = TryEndTransaction(ctx,owned,err)
+
+		if err != nil {
+			applied = nil
+		}
+	}()
+
+	// Two programs opening a new database at once would both migrate it
+
+	for _,qstr := range []string{"SELECT pg_advisory_xact_lock(hashtext('SSTorytime schema'))",SCHEMA_VERSION_TABLE} {
+
+		_,err = ctx.Tx.Exec(qstr)
+
+		if err != nil {
+			return nil,fmt.Errorf("%w: %v\n%s",ErrDBSchema,err,qstr)
+		}
+	}
+
+	version,err := TryGetDBSchemaVersion(ctx)
+
+	if err != nil {
+		return nil,err
+	}
+
+	if version > LatestSchemaVersion() {
+		return nil,fmt.Errorf("%w: the database has schema version %d, but this program only knows up to %d, please upgrade SSTorytime",
+			ErrDBSchema,version,LatestSchemaVersion())
+	}
+
+	for _,m := range MIGRATIONS {
+
+		if m.Version <= version {
+			continue
+		}
+
+		fmt.Printf("Upgrading database schema to version %d: %s\n",m.Version,m.Description)
+
+		for _,qstr := range m.Statements {
+
+			_,err = ctx.Tx.Exec(qstr)
+
+			if err != nil {
+				return nil,fmt.Errorf("%w: migration %d (%s): %v\n%s",ErrDBSchema,m.Version,m.Description,err,qstr)
+			}
+		}
+
+		_,err = ctx.Tx.Exec("INSERT INTO schema_version (Version,Description) VALUES ($1,$2)",m.Version,m.Description)
+
+		if err != nil {
+			return nil,fmt.Errorf("%w: recording migration %d: %v",ErrDBSchema,m.Version,err)
+		}
+
+		applied = append(applied,m)
+	}
+
+	return applied,nil
+}
+
+//**************************************************************
+
+func StoredFunctionName(qstr string) string {
+
+	name := regexp.MustCompile(`(?i)CREATE OR REPLACE FUNCTION\s+(\w+)`).FindStringSubmatch(qstr)
+
+	if name == nil {
+		return ""
+	}
+
+	return strings.ToLower(name[1])
+}
+
+//**************************************************************
+
+func StoredFunctionHash(qstr string) string {
+
+	return fmt.Sprintf("%x",sha256.Sum256([]byte(qstr)))
+}
+
+//**************************************************************
+
+func TryGetStaleStoredFunctions(ctx PoSST) ([]string,error) {
+
+	// Names of the stored functions whose definition has changed since
+	// they were last defined, or which are missing from the database
+
+	if ctx.Store != nil {
+		return nil,nil
+	}
+
+	var defined = make(map[string]string)
+
+	exists,err := TryDBTableExists(ctx,"function_version")
+
+	if err != nil {
+		return nil,err
+	}
+
+	qstr := "SELECT f.Name,f.Hash FROM function_version f " +
+		"WHERE EXISTS (SELECT 1 FROM pg_proc p WHERE lower(p.proname)=f.Name)"
+
+	if exists {
+
+		row,err := SQLConn(ctx).Query(qstr)
+
+		if err != nil {
+			return nil,fmt.Errorf("%w: reading function versions: %v\n%s",ErrDBQuery,err,qstr)
+		}
+
+		for row.Next() {
+
+			var name,hash string
+
+			err = row.Scan(&name,&hash)
+
+			if err != nil {
+				row.Close()
+				return nil,fmt.Errorf("%w: reading function versions: %v",ErrDBQuery,err)
+			}
+
+			defined[name] = hash
+		}
+
+		row.Close()
+	}
+
+	var stale []string
+
+	for _,qstr := range StoredFunctionDefinitions() {
+
+		name := StoredFunctionName(qstr)
+
+		if defined[name] != StoredFunctionHash(qstr) {
+			stale = append(stale,name)
+		}
+	}
+
+	return stale,nil
+}
+
+//**************************************************************
+
+func TryUpdateStoredFunctions(ctx PoSST) (int,error) {
+
+	// Only re-create the stored functions that have changed, and return
+	// how many were. A changed return type or parameter list can't be
+	// replaced, so drop the old one first in that case
+
+	if ctx.Store != nil {
+		return 0,nil
+	}
+
+	_,err := SQLConn(ctx).Exec(FUNCTION_VERSION_TABLE)
+
+	if err != nil {
+		return 0,fmt.Errorf("%w: %v\n%s",ErrDBSchema,err,FUNCTION_VERSION_TABLE)
+	}
+
+	stale,err := TryGetStaleStoredFunctions(ctx)
+
+	if err != nil {
+		return 0,err
+	}
+
+	var redefine = make(map[string]bool)
+
+	for _,name := range stale {
+		redefine[name] = true
+	}
+
+	var errs []error
+	var count int
+
+	for _,qstr := range StoredFunctionDefinitions() {
+
+		name := StoredFunctionName(qstr)
+
+		if !redefine[name] {
+			continue
+		}
+
+		err = DefineStoredFunction(ctx,qstr)
+
+		if err != nil && strings.Contains(err.Error(),"cannot change") {
+
+			_,err = SQLConn(ctx).Exec("DROP FUNCTION IF EXISTS "+name)
+
+			if err == nil {
+				err = DefineStoredFunction(ctx,qstr)
+			}
+		}
+
+		if err != nil {
+			errs = append(errs,err)
+			continue
+		}
+
+		qrec := "INSERT INTO function_version (Name,Hash) VALUES ($1,$2) " +
+			"ON CONFLICT (Name) DO UPDATE SET Hash=EXCLUDED.Hash,Defined=now()"
+
+		_,err = SQLConn(ctx).Exec(qrec,name,StoredFunctionHash(qstr))
+
+		if err != nil {
+			errs = append(errs,fmt.Errorf("%w: recording function version: %v",ErrDBQuery,err))
+			continue
+		}
+
+		count++
+	}
+
+	return count,errors.Join(errs...)
+}
+
+//**************************************************************
+
+func TryGetDBSchemaStatus(ctx PoSST) (SchemaStatus,error) {
+
+	var status SchemaStatus
+
+	status.Latest = LatestSchemaVersion()
+
+	version,err := TryGetDBSchemaVersion(ctx)
+
+	if err != nil {
+		return status,err
+	}
+
+	status.Version = version
+
+	for _,m := range MIGRATIONS {
+		if m.Version > version {
+			status.Pending = append(status.Pending,m)
+		}
+	}
+
+	status.StaleFunctions,err = TryGetStaleStoredFunctions(ctx)
+
+	return status,err
+}
+
+//**************************************************************
+
+func TrySetDBSearchLanguage(ctx PoSST,language string) (err error) {
+
+	// Change the text search configuration used to rank node text,
+	// e.g. "english" to match plurals etc, and reindex the nodes
+
+	if ctx.Store != nil {
+		return nil
+	}
+
+	ctx,owned,err := TryBeginTransaction(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		err = TryEndTransaction(ctx,owned,err)
+	}()
+
+	for _,qstr := range []string{
+		"UPDATE search_config SET Language=$1::regconfig",
+		"UPDATE Node SET Search=to_tsvector($1::regconfig,COALESCE(S,''))",
+	} {
+		_,err = ctx.Tx.Exec(qstr,language)
+
+		if err != nil {
+			return fmt.Errorf("%w: setting search language %s: %v\n%s",ErrDBQuery,language,err,qstr)
+		}
+	}
+
+	return nil
+}
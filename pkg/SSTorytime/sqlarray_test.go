@@ -0,0 +1,42 @@
+//**************************************************************
+//
+// sqlarray_test.go
+//
+// Context strings with quotes and backslashes survive the
+// postgres array literals they are written into
+//
+//**************************************************************
+
+package SSTorytime
+
+import (
+	"reflect"
+	"testing"
+)
+
+//**************************************************************
+
+func TestSQLLinkContextEscapes(t *testing.T) {
+
+	ctx := []string{`plain`,`say "hi"`,`back\slash`,`a, b`,`{braces}`}
+
+	want := []string{`a, b`,`back\slash`,`plain`,`say "hi"`,`{braces}`}
+
+	lit := SQLLinkContext(ctx)
+
+	if lit != `{ "a, b", "back\\slash", "plain", "say \"hi\"", "{braces}" }` {
+		t.Errorf("SQLLinkContext wrote %s",lit)
+	}
+
+	// ParseSQLArrayString drops all braces, so leave those out here
+
+	got := ParseSQLArrayString(SQLLinkContext(want[:4]))
+
+	if !reflect.DeepEqual(got,want[:4]) {
+		t.Errorf("read back %q, want %q",got,want[:4])
+	}
+
+	if SQLLinkContext(nil) != "{ }" {
+		t.Errorf("empty context wrote %s",SQLLinkContext(nil))
+	}
+}
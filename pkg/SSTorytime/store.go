@@ -0,0 +1,307 @@
+//**************************************************************
+//
+// Pluggable graph storage, so that the same library calls can
+// run against postgres or a pure Go in-memory graph
+//
+//**************************************************************
+
+package SSTorytime
+
+import (
+	"database/sql"
+)
+
+//**************************************************************
+
+type GraphStore interface {
+
+	// Write (upload) primitives
+
+	CreateNode(n Node) (Node,error)
+	IdempAddNode(n Node) (Node,error)
+	AppendLinkToNode(nptr NodePtr,lnk Link,sttype int) (bool,error)
+	CreateNodeArrowNode(org NodePtr,dst Link,sttype int) error
+	AddArrow(ad ArrowDirectory) error
+	AddInverseArrow(plus,minus ArrowPtr) error
+	AddPageMapEvent(line PageMap) error
+	UploadGraph(upload GraphUpload) error
+	DownloadArrows() error
+	GetArrowDirectory() ([]ArrowDirectory,map[ArrowPtr]ArrowPtr,error)
+
+	// Deletion
+
+	RemoveLink(key LinkKey) error
+	DeleteNode(nptr NodePtr) error
+	DeleteChapter(chap string) (int,error)
+
+	// Provenance of nodes and links by source file
+
+	AddProvenance(file string,nodes []NodePtr,links []LinkKey) error
+	GetProvenance(file string) ([]NodePtr,[]NodeArrowNode,error)
+	RemoveSourceContribution(file string,nodes []NodePtr,removed,changed []LinkKey) error
+
+	// Lookup and name matching
+
+	GetNodeByNodePtr(nptr NodePtr) (Node,error)
+	GetNodesByNodePtrs(nptrs []NodePtr) (map[NodePtr]Node,error)
+	GetNodePtrsByText(texts []string) (map[string]NodePtr,error)
+	GetTopNodePtrs() (map[int]ClassedNodePtr,error)
+	GetChaptersMatchingName(src string) ([]string,error)
+	GetContextsMatchingName(src string) ([]string,error)
+	GetNodePtrMatchingName(src,chap string) ([]NodePtr,error)
+	GetNodePtrMatching(nm,chap string,cn []string,arrow []ArrowPtr) ([]NodePtr,error)
+	SearchNodeText(text,chap string,limit int) ([]NodeMatch,error)
+	GetNodeArrowNodeMatchingArrowPtrs(chap string,cn []string,arrows []ArrowPtr) ([]NodeArrowNode,error)
+	GetLinksMatching(chap string,cn []string,arrows []ArrowPtr) ([]NodeArrowNode,error)
+	GetNodeContextsMatchingArrow(searchtext string,chap string,cn []string,arrow []ArrowPtr,page int) ([]QNodePtr,error)
+	GetStoryStartNodes(arrow,inverse ArrowPtr,sttype int) ([]NodePtr,error)
+	GetNCCStoryStartNodes(arrow,inverse ArrowPtr,sttype int,chapter string,context []string) ([]NodePtr,error)
+	GetPageMap(chap string,cn []string,page int) ([]PageMap,error)
+	GetTableOfContents(chap string,cn []string) (map[string][]string,error)
+
+	// Cone and path expansion
+
+	GetFwdConeAsNodes(start NodePtr,sttype,depth int) ([]NodePtr,error)
+	GetFwdConeAsLinks(start NodePtr,sttype,depth int) ([]Link,error)
+	GetFwdPathsAsLinks(start NodePtr,sttype,depth int) ([][]Link,int,error)
+	GetEntireConePathsAsLinks(orientation string,start NodePtr,depth int) ([][]Link,int,error)
+	GetEntireNCConePathsAsLinks(orientation string,start NodePtr,depth int,chapter string,context []string) ([][]Link,int,error)
+	GetEntireNCSuperConePathsAsLinks(orientation string,start []NodePtr,depth int,chapter string,context []string) ([][]Link,int,error)
+
+	// Matroid analysis
+
+	GetAppointmentArrayByArrow(context []string,chapter string) (map[ArrowPtr][]NodePtr,error)
+	GetAppointmentArrayBySSType() (map[int][]NodePtr,error)
+	GetAppointmentHistogramByArrow() (map[ArrowPtr]int,error)
+	GetAppointmentHistogramBySSType() (map[int]int,error)
+	GetAppointmentNodesByArrow() ([]ArrowAppointment,error)
+	GetAppointmentNodesBySTType() ([]STTypeAppointment,error)
+
+	// Graph-wide node importance, see centrality.go
+
+	SaveCentrality(scope string,scores []Centrality) error
+	GetCentrality(scope string) ([]Centrality,error)
+
+	Close() error
+}
+
+//**************************************************************
+
+func GetGraphStore(ctx PoSST) GraphStore {
+
+	// A PoSST with no Store talks to postgres directly via ctx.DB
+
+	if ctx.Store != nil {
+		return ctx.Store
+	}
+
+	return NewPGStore(ctx.DB)
+}
+
+//**************************************************************
+// Postgres backend, a thin adapter for the SQL code in SSTorytime.go
+//**************************************************************
+
+type PGStore struct {
+
+	ctx PoSST  // always has a nil Store, so calls go to the database
+}
+
+//**************************************************************
+
+func NewPGStore(db *sql.DB) *PGStore {
+
+	var pg PGStore
+	pg.ctx.DB = db
+	return &pg
+}
+
+//**************************************************************
+
+func (pg *PGStore) CreateNode(n Node) (Node,error) {
+	return TryCreateDBNode(pg.ctx,n)
+}
+
+func (pg *PGStore) IdempAddNode(n Node) (Node,error) {
+	return TryIdempDBAddNode(pg.ctx,n)
+}
+
+func (pg *PGStore) AppendLinkToNode(nptr NodePtr,lnk Link,sttype int) (bool,error) {
+	return TryAppendDBLinkToNode(pg.ctx,nptr,lnk,sttype)
+}
+
+func (pg *PGStore) CreateNodeArrowNode(org NodePtr,dst Link,sttype int) error {
+	return TryCreateDBNodeArrowNode(pg.ctx,org,dst,sttype)
+}
+
+func (pg *PGStore) AddArrow(ad ArrowDirectory) error {
+	return TryUploadArrowToDB(pg.ctx,ad)
+}
+
+func (pg *PGStore) AddInverseArrow(plus,minus ArrowPtr) error {
+	return TryUploadInverseArrowToDB(pg.ctx,plus,minus)
+}
+
+func (pg *PGStore) AddPageMapEvent(line PageMap) error {
+	return TryUploadPageMapEvent(pg.ctx,line)
+}
+
+func (pg *PGStore) UploadGraph(upload GraphUpload) error {
+	return TryUploadGraph(pg.ctx,upload)
+}
+
+func (pg *PGStore) DownloadArrows() error {
+	return TryDownloadArrowsFromDB(pg.ctx)
+}
+
+func (pg *PGStore) GetArrowDirectory() ([]ArrowDirectory,map[ArrowPtr]ArrowPtr,error) {
+	return TryGetDBArrowDirectory(pg.ctx)
+}
+
+func (pg *PGStore) RemoveLink(key LinkKey) error {
+	return TryRemoveDBLink(pg.ctx,key)
+}
+
+func (pg *PGStore) DeleteNode(nptr NodePtr) error {
+	return TryDeleteDBNode(pg.ctx,nptr)
+}
+
+func (pg *PGStore) DeleteChapter(chap string) (int,error) {
+	return TryDeleteDBChapter(pg.ctx,chap)
+}
+
+func (pg *PGStore) AddProvenance(file string,nodes []NodePtr,links []LinkKey) error {
+	return TryUploadProvenance(pg.ctx,file,nodes,links)
+}
+
+func (pg *PGStore) GetProvenance(file string) ([]NodePtr,[]NodeArrowNode,error) {
+	return TryGetDBProvenance(pg.ctx,file)
+}
+
+func (pg *PGStore) RemoveSourceContribution(file string,nodes []NodePtr,removed,changed []LinkKey) error {
+	return TryRemoveDBSourceContribution(pg.ctx,file,nodes,removed,changed)
+}
+
+func (pg *PGStore) GetNodeByNodePtr(nptr NodePtr) (Node,error) {
+	return TryGetDBNodeByNodePtr(pg.ctx,nptr)
+}
+
+func (pg *PGStore) GetNodesByNodePtrs(nptrs []NodePtr) (map[NodePtr]Node,error) {
+	return TryGetDBNodesByNodePtrs(pg.ctx,nptrs)
+}
+
+func (pg *PGStore) GetNodePtrsByText(texts []string) (map[string]NodePtr,error) {
+	return TryGetDBNodePtrsByText(pg.ctx,texts)
+}
+
+func (pg *PGStore) GetTopNodePtrs() (map[int]ClassedNodePtr,error) {
+	return TryGetDBTopNodePtrs(pg.ctx)
+}
+
+func (pg *PGStore) GetChaptersMatchingName(src string) ([]string,error) {
+	return TryGetDBChaptersMatchingName(pg.ctx,src)
+}
+
+func (pg *PGStore) GetContextsMatchingName(src string) ([]string,error) {
+	return TryGetDBContextsMatchingName(pg.ctx,src)
+}
+
+func (pg *PGStore) GetNodePtrMatchingName(src,chap string) ([]NodePtr,error) {
+	return TryGetDBNodePtrMatchingName(pg.ctx,src,chap)
+}
+
+func (pg *PGStore) GetNodePtrMatching(nm,chap string,cn []string,arrow []ArrowPtr) ([]NodePtr,error) {
+	return TryGetDBNodePtrMatching(pg.ctx,nm,chap,cn,arrow)
+}
+
+func (pg *PGStore) SearchNodeText(text,chap string,limit int) ([]NodeMatch,error) {
+	return TrySearchDBNodeText(pg.ctx,text,chap,limit)
+}
+
+func (pg *PGStore) GetNodeArrowNodeMatchingArrowPtrs(chap string,cn []string,arrows []ArrowPtr) ([]NodeArrowNode,error) {
+	return TryGetDBNodeArrowNodeMatchingArrowPtrs(pg.ctx,chap,cn,arrows)
+}
+
+func (pg *PGStore) GetLinksMatching(chap string,cn []string,arrows []ArrowPtr) ([]NodeArrowNode,error) {
+	return TryGetDBLinksMatching(pg.ctx,chap,cn,arrows)
+}
+
+func (pg *PGStore) GetNodeContextsMatchingArrow(searchtext string,chap string,cn []string,arrow []ArrowPtr,page int) ([]QNodePtr,error) {
+	return TryGetDBNodeContextsMatchingArrow(pg.ctx,searchtext,chap,cn,arrow,page)
+}
+
+func (pg *PGStore) GetStoryStartNodes(arrow,inverse ArrowPtr,sttype int) ([]NodePtr,error) {
+	return TryGetDBStoryStartNodes(pg.ctx,arrow,inverse,sttype)
+}
+
+func (pg *PGStore) GetNCCStoryStartNodes(arrow,inverse ArrowPtr,sttype int,chapter string,context []string) ([]NodePtr,error) {
+	return TryGetDBNCCStoryStartNodes(pg.ctx,arrow,inverse,sttype,chapter,context)
+}
+
+func (pg *PGStore) GetPageMap(chap string,cn []string,page int) ([]PageMap,error) {
+	return TryGetDBPageMap(pg.ctx,chap,cn,page)
+}
+
+func (pg *PGStore) GetTableOfContents(chap string,cn []string) (map[string][]string,error) {
+	return TryGetDBTableOfContents(pg.ctx,chap,cn)
+}
+
+func (pg *PGStore) GetFwdConeAsNodes(start NodePtr,sttype,depth int) ([]NodePtr,error) {
+	return TryGetFwdConeAsNodes(pg.ctx,start,sttype,depth)
+}
+
+func (pg *PGStore) GetFwdConeAsLinks(start NodePtr,sttype,depth int) ([]Link,error) {
+	return TryGetFwdConeAsLinks(pg.ctx,start,sttype,depth)
+}
+
+func (pg *PGStore) GetFwdPathsAsLinks(start NodePtr,sttype,depth int) ([][]Link,int,error) {
+	return TryGetFwdPathsAsLinks(pg.ctx,start,sttype,depth)
+}
+
+func (pg *PGStore) GetEntireConePathsAsLinks(orientation string,start NodePtr,depth int) ([][]Link,int,error) {
+	return TryGetEntireConePathsAsLinks(pg.ctx,orientation,start,depth)
+}
+
+func (pg *PGStore) GetEntireNCConePathsAsLinks(orientation string,start NodePtr,depth int,chapter string,context []string) ([][]Link,int,error) {
+	return TryGetEntireNCConePathsAsLinks(pg.ctx,orientation,start,depth,chapter,context)
+}
+
+func (pg *PGStore) GetEntireNCSuperConePathsAsLinks(orientation string,start []NodePtr,depth int,chapter string,context []string) ([][]Link,int,error) {
+	return TryGetEntireNCSuperConePathsAsLinks(pg.ctx,orientation,start,depth,chapter,context)
+}
+
+func (pg *PGStore) GetAppointmentArrayByArrow(context []string,chapter string) (map[ArrowPtr][]NodePtr,error) {
+	return TryGetAppointmentArrayByArrow(pg.ctx,context,chapter)
+}
+
+func (pg *PGStore) GetAppointmentArrayBySSType() (map[int][]NodePtr,error) {
+	return TryGetAppointmentArrayBySSType(pg.ctx)
+}
+
+func (pg *PGStore) GetAppointmentHistogramByArrow() (map[ArrowPtr]int,error) {
+	return TryGetAppointmentHistogramByArrow(pg.ctx)
+}
+
+func (pg *PGStore) GetAppointmentHistogramBySSType() (map[int]int,error) {
+	return TryGetAppointmentHistogramBySSType(pg.ctx)
+}
+
+func (pg *PGStore) GetAppointmentNodesByArrow() ([]ArrowAppointment,error) {
+	return TryGetAppointmentNodesByArrow(pg.ctx)
+}
+
+func (pg *PGStore) GetAppointmentNodesBySTType() ([]STTypeAppointment,error) {
+	return TryGetAppointmentNodesBySTType(pg.ctx)
+}
+
+func (pg *PGStore) SaveCentrality(scope string,scores []Centrality) error {
+	return TrySaveDBCentrality(pg.ctx,scope,scores)
+}
+
+func (pg *PGStore) GetCentrality(scope string) ([]Centrality,error) {
+	return TryGetDBCentrality(pg.ctx,scope)
+}
+
+func (pg *PGStore) Close() error {
+	return pg.ctx.DB.Close()
+}
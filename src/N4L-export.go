@@ -0,0 +1,88 @@
+//******************************************************************
+//
+// Decompile the stored graph back into N4L notes, e.g. to hand a
+// chapter to someone else or to recover lost source files
+//
+//******************************************************************
+
+package main
+
+import (
+	"fmt"
+	"flag"
+	"os"
+
+        SST "SSTorytime"
+)
+
+//******************************************************************
+
+var (
+	CHAPTER string
+	OUTPUT  string
+)
+
+//******************************************************************
+
+func main() {
+
+	Init()
+
+	load_arrows := true
+	ctx := SST.Open(load_arrows)
+
+	text,problems,err := SST.TryDecompileN4L(ctx,CHAPTER)
+
+	SST.Close(ctx)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr,"Could not export:",err)
+		os.Exit(-1)
+	}
+
+	for _,p := range problems {
+		fmt.Fprintln(os.Stderr,"N4L-export: could not write",p)
+	}
+
+	if OUTPUT == "" {
+		fmt.Print(text)
+		return
+	}
+
+	err = os.WriteFile(OUTPUT,[]byte(text),0644)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr,err)
+		os.Exit(-1)
+	}
+}
+
+//**************************************************************
+
+func Usage() {
+
+	fmt.Printf("usage: N4L-export [-chapter string] [-o file.n4l] [-db-config file]\n")
+	flag.PrintDefaults()
+
+	os.Exit(2)
+}
+
+//**************************************************************
+
+func Init() {
+
+	flag.Usage = Usage
+
+	chapterPtr := flag.String("chapter", "", "only this chapter, otherwise everything")
+	outputPtr := flag.String("o", "", "write to this file instead of stdout")
+
+	SST.DBFlags()
+	flag.Parse()
+
+	if flag.NArg() > 0 {
+		Usage()
+	}
+
+	CHAPTER = *chapterPtr
+	OUTPUT = *outputPtr
+}
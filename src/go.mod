@@ -4,7 +4,10 @@ go 1.24.2
 
 replace SSTorytime => ../pkg/SSTorytime
 
+replace N4L => ../pkg/N4L
+
 require (
+	N4L v0.0.0-00010101000000-000000000000
 	SSTorytime v0.0.0-00010101000000-000000000000
 	github.com/lib/pq v1.10.9
 	golang.org/x/text v0.24.0
@@ -5,6 +5,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"net/http"
 	"strings"
@@ -16,10 +17,16 @@ import (
 
 var CTX SST.PoSST
 
+const MAX_MATCHES = 50  // best ranked nodes to show for a search by name
+const PATH_MAXDEPTH = 15 // furthest each end of a path solution looks
+
 // *********************************************************************
 
 func main() {
 
+	SST.DBFlags()
+	flag.Parse()
+
 	CTX = SST.Open(true)	
 
 	http.HandleFunc("/",PageHandler)
@@ -29,6 +36,8 @@ func main() {
 	http.HandleFunc("/Browse", SystematicHandler)
 	http.HandleFunc("/TOC", TableOfContents)
 	http.HandleFunc("/Sequence", SequenceHandler)
+	http.HandleFunc("/Clusters", ClustersHandler)
+	http.HandleFunc("/Export", ExportHandler)
 
 	fmt.Println("Listening at http://localhost:8080")
 	http.ListenAndServe(":8080", nil)
@@ -79,8 +88,8 @@ func OrbitHandler(w http.ResponseWriter, r *http.Request) {
 				name = "semantic"
 			}
 			fmt.Println("Matching Orbit by name(",name,chapter,context,")")
-			nptrs := SST.GetDBNodePtrMatchingName(CTX,name,chapter)
-			HandleOrbit(w,r,nptrs,chapter,context)
+			matches := SST.SearchDBNodeText(CTX,name,chapter,MAX_MATCHES)
+			HandleOrbit(w,r,SST.NodeMatchPtrs(matches),chapter,context,matches)
 		} else {
 			fmt.Println("Matching Orbit by NPtr(",nclass,ncptr,chapter,context,")")
 			var nptrs []SST.NodePtr
@@ -88,7 +97,7 @@ func OrbitHandler(w http.ResponseWriter, r *http.Request) {
 			fmt.Sscanf(nclass,"%d",&nptr.Class)
 			fmt.Sscanf(ncptr,"%d",&nptr.CPtr)
 			nptrs = append(nptrs,nptr)
-			HandleOrbit(w,r,nptrs,chapter,context)
+			HandleOrbit(w,r,nptrs,chapter,context,nil)
 		}
 
 	default:
@@ -98,7 +107,7 @@ func OrbitHandler(w http.ResponseWriter, r *http.Request) {
 
 // *********************************************************************
 
-func HandleOrbit(w http.ResponseWriter, r *http.Request,nptrs []SST.NodePtr,chapter,context string) {
+func HandleOrbit(w http.ResponseWriter, r *http.Request,nptrs []SST.NodePtr,chapter,context string,matches []SST.NodeMatch) {
 
 	chapter = strings.TrimSpace(chapter)
 
@@ -113,7 +122,16 @@ func HandleOrbit(w http.ResponseWriter, r *http.Request,nptrs []SST.NodePtr,chap
 		}
 	}
 	
-	events += "] }"
+	events += "]"
+
+	// The ranking of a search by name, in the same order as the events
+
+	if matches != nil {
+		jstr,_ := json.Marshal(matches)
+		events += fmt.Sprintf(",\n\"matches\" : %s",string(jstr))
+	}
+
+	events += " }"
 	
 	w.Write([]byte(events))
 	fmt.Println("Reply Orbit sent")
@@ -135,14 +153,20 @@ func ConeHandler(w http.ResponseWriter, r *http.Request) {
 		context := r.FormValue("context")
 		arrnames := r.FormValue("arrnames")
 
+		// weighted=true asks for the k cheapest paths instead of the fewest hops
+
+		weighted := r.FormValue("weighted") == "true"
+		k := 1
+		fmt.Sscanf(r.FormValue("k"),"%d",&k)
+
 		isdirac,begin,end,cnt := SST.DiracNotation(name)
 
 		if isdirac {
 			fmt.Println("Detected dirac transit",begin,cnt,end)
 			if cnt == "" {
-				HandlePathSolve(w,r,begin,end,chapter,context)
+				HandlePathSolve(w,r,begin,end,chapter,context,weighted,k)
 			} else {
-				HandlePathSolve(w,r,begin,end,chapter,cnt)
+				HandlePathSolve(w,r,begin,end,chapter,cnt,weighted,k)
 			}
 			return
 		}
@@ -176,7 +200,8 @@ func HandleEntireCone(w http.ResponseWriter, r *http.Request,name,chapter,cntstr
 
 	fmt.Println("Matching...EntireCone(",name,chapter,cntxt,arrows,")")
 
-	nptrs := SST.GetDBNodePtrMatching(CTX,name,chapter,cntxt,arrows)
+	matches := SST.SearchDBNodeTextInContext(CTX,name,chapter,cntxt,arrows,MAX_MATCHES)
+	nptrs := SST.NodeMatchPtrs(matches)
 
 	maxdepth := 20
 	var count int
@@ -190,6 +215,9 @@ func HandleEntireCone(w http.ResponseWriter, r *http.Request,name,chapter,cntstr
 		thiscone := fmt.Sprintf(" { \"NClass\" : %d,\n",nptrs[n].Class)
 		thiscone += fmt.Sprintf("   \"NCPtr\" : %d,\n",nptrs[n].CPtr)
 		thiscone += fmt.Sprintf("   \"Title\" : \"%s\",\n",name)
+		highlight,_ := json.Marshal(matches[n].Highlight)
+		thiscone += fmt.Sprintf("   \"Highlight\" : %s,\n",string(highlight))
+		thiscone += fmt.Sprintf("   \"Rank\" : %.3f,\n",matches[n].Rank)
 		empty := true
 
 		cone,span := SST.GetEntireConePathsAsLinks(CTX,"any",nptrs[n],maxdepth)
@@ -220,12 +248,7 @@ func HandleEntireCone(w http.ResponseWriter, r *http.Request,name,chapter,cntstr
 
 //******************************************************************
 
-func HandlePathSolve(w http.ResponseWriter, r *http.Request,begin,end,chapter,cntext string) {
-
-	const maxdepth = 15
-	var Lnum,Rnum int
-	var count int
-	var left_paths, right_paths [][]SST.Link
+func HandlePathSolve(w http.ResponseWriter, r *http.Request,begin,end,chapter,cntext string,weighted bool,k int) {
 
 	start_bc := []string{begin}
 	end_bc := []string{end}
@@ -258,32 +281,61 @@ func HandlePathSolve(w http.ResponseWriter, r *http.Request,begin,end,chapter,cn
 
 	// Find the path matrix
 
-	var solutions [][]SST.Link
-	var ldepth,rdepth int = 1,1
+	solutions := SolvePaths(leftptrs,rightptrs,chapter,context,weighted,k)
+
+	if len(solutions) == 0 {
+		fmt.Println("No paths satisfy constraints",context," between end points",begin,"TO",end,"in chapter",chapter)
+		os.Exit(-1)
+	}
+
+	// format paths
+
 	var json string
 
-	for turn := 0; ldepth < maxdepth && rdepth < maxdepth; turn++ {
+	json += fmt.Sprintf("{ \"paths\" : [\n")
+	json += fmt.Sprintf(" { \"NClass\" : %d,\n",solutions[0][0].Dst.Class)
+	json += fmt.Sprintf("   \"NCPtr\" : %d,\n",solutions[0][0].Dst.CPtr)
+	json += fmt.Sprintf("   \"Title\" : \"%s\",\n",dirac_form)
+	json += fmt.Sprintf("   \"BTWC\" : [ %s ],\n",SST.BetweenNessCentrality(CTX,solutions))
+	json += fmt.Sprintf("   \"Supernodes\" : [ %s ],\n",SST.SuperNodes(CTX,solutions,PATH_MAXDEPTH))
+
+	if weighted {
+		var costs []string
+		for s := range solutions {
+			costs = append(costs,fmt.Sprintf("%.3f",SST.PathCost(solutions[s])))
+		}
+		json += fmt.Sprintf("   \"Costs\" : [ %s ],\n",strings.Join(costs,","))
+	}
 
-		left_paths,Lnum = SST.GetEntireNCSuperConePathsAsLinks(CTX,"fwd",leftptrs,ldepth,chapter,context)
-		right_paths,Rnum = SST.GetEntireNCSuperConePathsAsLinks(CTX,"bwd",rightptrs,rdepth,chapter,context)
-		solutions,_ = SST.WaveFrontsOverlap(CTX,left_paths,right_paths,Lnum,Rnum,ldepth,rdepth)
+	json += fmt.Sprintf("\"Entire\" : %s ",SST.JSONCone(CTX,solutions,chapter,context))
+	json += "\n}\n]\n}"
 
-		if len(solutions) > 0 {
+	w.Write([]byte(json))
+	fmt.Println("Reply PathSolve sent")
 
-			// format paths
+}
 
-			json += fmt.Sprintf("{ \"paths\" : [\n")
-			json += fmt.Sprintf(" { \"NClass\" : %d,\n",solutions[0][0].Dst.Class)
-			json += fmt.Sprintf("   \"NCPtr\" : %d,\n",solutions[0][0].Dst.CPtr)
-			json += fmt.Sprintf("   \"Title\" : \"%s\",\n",dirac_form)
-			json += fmt.Sprintf("   \"BTWC\" : [ %s ],\n",SST.BetweenNessCentrality(CTX,solutions))
-			json += fmt.Sprintf("   \"Supernodes\" : [ %s ],\n",SST.SuperNodes(CTX,solutions,maxdepth))
+//******************************************************************
 
-			json += fmt.Sprintf("\"Entire\" : %s ",SST.JSONCone(CTX,solutions,chapter,context))	
-			json += "\n}\n]\n}"
+func SolvePaths(leftptrs,rightptrs []SST.NodePtr,chapter string,context []string,weighted bool,k int) [][]SST.Link {
 
-			count++
-			break
+	// The k cheapest paths, or the shortest paths where the cones
+	// from each end first meet
+
+	if weighted {
+		return SST.GetShortestWeightedPaths(CTX,leftptrs,rightptrs,nil,chapter,context,k)
+	}
+
+	var ldepth,rdepth int = 1,1
+
+	for turn := 0; ldepth < PATH_MAXDEPTH && rdepth < PATH_MAXDEPTH; turn++ {
+
+		left_paths,Lnum := SST.GetEntireNCSuperConePathsAsLinks(CTX,"fwd",leftptrs,ldepth,chapter,context)
+		right_paths,Rnum := SST.GetEntireNCSuperConePathsAsLinks(CTX,"bwd",rightptrs,rdepth,chapter,context)
+		solutions,_ := SST.WaveFrontsOverlap(CTX,left_paths,right_paths,Lnum,Rnum,ldepth,rdepth)
+
+		if len(solutions) > 0 {
+			return solutions
 		}
 
 		if turn % 2 == 0 {
@@ -293,14 +345,109 @@ func HandlePathSolve(w http.ResponseWriter, r *http.Request,begin,end,chapter,cn
 		}
 	}
 
-	if len(solutions) == 0 {
-		fmt.Println("No paths satisfy constraints",context," between end points",begin,"TO",end,"in chapter",chapter)
-		os.Exit(-1)
-	}
+	return nil
+}
 
-	w.Write([]byte(json))
-	fmt.Println("Reply PathSolve sent")
+//******************************************************************
+
+func ExportHandler(w http.ResponseWriter, r *http.Request) {
+
+	// A chapter, the cone around a name, or the paths of a Dirac
+	// <end|start>, as a graph file to draw or load elsewhere
+
+	GenHeader(w,r)
 
+	fmt.Println("Export handler")
+
+	switch r.Method {
+	case "POST","GET":
+		format := r.FormValue("format")
+		name := strings.TrimSpace(r.FormValue("name"))
+		chapter := strings.TrimSpace(r.FormValue("chapter"))
+		cntstr := r.FormValue("context")
+		context,_ := SST.Str2Array(cntstr)
+		arrnames,_ := SST.Str2Array(r.FormValue("arrows"))
+
+		if format == "" {
+			format = "dot"
+		}
+
+		var arrows []SST.ArrowPtr
+
+		for _,a := range arrnames {
+
+			if a == "" {
+				continue
+			}
+
+			arr,err := SST.TryGetDBArrowByName(CTX,a)
+
+			if err != nil {
+				http.Error(w,err.Error(),http.StatusBadRequest)
+				return
+			}
+
+			arrows = append(arrows,arr)
+		}
+
+		if chapter == "any" {
+			chapter = ""
+		}
+
+		var g SST.ExportGraph
+		var err error
+
+		isdirac,begin,end,cnt := SST.DiracNotation(name)
+
+		switch {
+
+		case isdirac:
+
+			if cnt != "" {
+				context = strings.Split(cnt,",")
+			}
+
+			leftptrs := SST.GetDBNodePtrMatchingName(CTX,begin,chapter)
+			rightptrs := SST.GetDBNodePtrMatchingName(CTX,end,chapter)
+			k := 1
+			fmt.Sscanf(r.FormValue("k"),"%d",&k)
+
+			solutions := SolvePaths(leftptrs,rightptrs,chapter,context,r.FormValue("weighted") == "true",k)
+			g,err = SST.TryExportPaths(CTX,name,solutions)
+
+		case name != "":
+
+			depth := 3
+			fmt.Sscanf(r.FormValue("depth"),"%d",&depth)
+
+			matches := SST.SearchDBNodeTextInContext(CTX,name,chapter,context,nil,MAX_MATCHES)
+			cone,_ := SST.GetEntireNCSuperConePathsAsLinks(CTX,"any",SST.NodeMatchPtrs(matches),depth,chapter,context)
+			g,err = SST.TryExportPaths(CTX,name,cone)
+
+		default:
+			g,err = SST.TryExportMatching(CTX,chapter,context,arrows)
+		}
+
+		g = SST.SelectExportArrows(g,arrows)
+
+		var out string
+
+		if err == nil {
+			out,err = SST.TryFormatGraph(g,format)
+		}
+
+		if err != nil {
+			fmt.Println(err)
+			http.Error(w,err.Error(),http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type",SST.ExportMIMEType(format))
+		w.Write([]byte(out))
+		fmt.Println("Reply Export sent")
+	default:
+		http.Error(w, "Not supported", http.StatusMethodNotAllowed)
+	}
 }
 
 //******************************************************************
@@ -448,6 +595,55 @@ func TableOfContents(w http.ResponseWriter, r *http.Request) {
 
 // *********************************************************************
 
+func ClustersHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Connected components and communities in a chapter and context,
+	// by links of the given STtypes (all if none)
+
+	GenHeader(w,r)
+
+	fmt.Println("Clusters handler")
+
+	switch r.Method {
+	case "POST","GET":
+		chapter := r.FormValue("chapter")
+		cntstr := r.FormValue("context")
+		context,_ := SST.Str2Array(cntstr)
+		ststr,_ := SST.Str2Array(r.FormValue("sttypes"))
+
+		var sttypes []int
+
+		for _,st := range ststr {
+			if st == "" {
+				continue
+			}
+			var sttype int
+			_,err := fmt.Sscanf(st,"%d",&sttype)
+			if err != nil || sttype < -3 || sttype > 3 {
+				http.Error(w,"An STtype is a number from -3 to 3",http.StatusBadRequest)
+				return
+			}
+			sttypes = append(sttypes,sttype)
+		}
+
+		clusters,err := SST.TryGetGraphClusters(CTX,chapter,context,sttypes)
+
+		if err != nil {
+			fmt.Println(err)
+			http.Error(w,"Could not find clusters",http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(SST.JSONClusters(CTX,clusters)))
+		fmt.Println("Reply Clusters sent")
+	default:
+		http.Error(w, "Not supported", http.StatusMethodNotAllowed)
+	}
+}
+
+// *********************************************************************
+
 func SequenceHandler(w http.ResponseWriter, r *http.Request) {
 
         // Find a sequence of arrows matching arrname/default "then" for which
@@ -0,0 +1,1030 @@
+//******************************************************************
+//
+// n4l-lsp - a language server for N4L notes, so that any editor
+// speaking the Language Server Protocol (over stdio) can show the
+// parser's errors as you type, complete arrow names and $label.n
+// references, and explain arrows
+//
+//******************************************************************
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf16"
+
+        SST "SSTorytime"
+        N4L "N4L"
+)
+
+//******************************************************************
+
+const (
+	CONFIG_NAME = "N4Lconfig.in"
+
+	LSP_SYNC_FULL = 1
+
+	LSP_KIND_VARIABLE = 6
+	LSP_KIND_REFERENCE = 18
+	LSP_KIND_OPERATOR = 24
+
+	ERR_PARSE = -32700
+	ERR_INVALID_PARAMS = -32602
+	ERR_METHOD_NOT_FOUND = -32601
+)
+
+//******************************************************************
+// The protocol, as much of it as we need
+//******************************************************************
+
+type Message struct {
+
+	JSONRPC string           `json:"jsonrpc"`
+	ID      json.RawMessage  `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+}
+
+type LSPPosition struct {
+
+	Line      int `json:"line"`       // from 0
+	Character int `json:"character"`  // in UTF-16 units, from 0
+}
+
+type LSPRange struct {
+
+	Start LSPPosition `json:"start"`
+	End   LSPPosition `json:"end"`
+}
+
+type Location struct {
+
+	URI   string   `json:"uri"`
+	Range LSPRange `json:"range"`
+}
+
+type LSPDiagnostic struct {
+
+	Range    LSPRange `json:"range"`
+	Severity int      `json:"severity"`   // same numbers as N4L.ERROR and N4L.WARNING
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type TextEdit struct {
+
+	Range   LSPRange `json:"range"`
+	NewText string   `json:"newText"`
+}
+
+type CompletionItem struct {
+
+	Label         string    `json:"label"`
+	Kind          int       `json:"kind"`
+	Detail        string    `json:"detail,omitempty"`
+	TextEdit      *TextEdit `json:"textEdit,omitempty"`
+}
+
+type MarkupContent struct {
+
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+type Hover struct {
+
+	Contents MarkupContent `json:"contents"`
+	Range    LSPRange      `json:"range"`
+}
+
+type DocumentParams struct {
+
+	TextDocument struct {
+		URI  string `json:"uri"`
+		Text string `json:"text"`
+	} `json:"textDocument"`
+
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+
+	Position LSPPosition `json:"position"`
+}
+
+//******************************************************************
+// The notes being edited
+//******************************************************************
+
+type OpenDoc struct {
+
+	URI    string
+	Path   string
+	Text   string
+	Lines  []string
+	Doc    *N4L.Document
+	Config *N4L.Config     // nil if there is no configuration to be found
+}
+
+type ConfigCache struct {
+
+	Modified time.Time
+	Config   *N4L.Config
+}
+
+//******************************************************************
+
+var (
+	DOCS = make(map[string]*OpenDoc)         // by URI
+	CONFIGS = make(map[string]ConfigCache)   // by path, as last read from disk
+
+	CONFIG_FILE string
+	VERBOSE bool = false
+	SHUTDOWN bool = false
+
+	OUT = bufio.NewWriter(os.Stdout)
+)
+
+//******************************************************************
+
+func main() {
+
+	Init()
+
+	in := bufio.NewReader(os.Stdin)
+
+	for {
+		msg,err := ReadMessage(in)
+
+		if err == io.EOF {
+			return
+		}
+
+		if err != nil {
+			Log("bad message:",err)
+			Reply(nil,nil,&ResponseError{Code: ERR_PARSE,Message: err.Error()})
+			continue
+		}
+
+		Handle(msg)
+	}
+}
+
+//******************************************************************
+
+func Usage() {
+
+	fmt.Printf("usage: n4l-lsp [-config N4Lconfig.in] [-v]\n")
+	fmt.Printf("A language server for N4L, speaking LSP on stdin and stdout\n")
+	flag.PrintDefaults()
+
+	os.Exit(2)
+}
+
+//******************************************************************
+
+func Init() {
+
+	flag.Usage = Usage
+
+	configPtr := flag.String("config", "", "the arrow configuration, otherwise the nearest "+CONFIG_NAME+" above each file")
+	verbosePtr := flag.Bool("v", false, "log the conversation to stderr")
+
+	flag.Parse()
+
+	CONFIG_FILE = *configPtr
+	VERBOSE = *verbosePtr
+}
+
+//******************************************************************
+
+func Handle(msg Message) {
+
+	Log("<-",msg.Method)
+
+	var p DocumentParams
+
+	if len(msg.Params) > 0 {
+		if err := json.Unmarshal(msg.Params,&p); err != nil {
+			if msg.ID != nil {
+				Reply(msg.ID,nil,&ResponseError{Code: ERR_INVALID_PARAMS,Message: err.Error()})
+			}
+			return
+		}
+	}
+
+	switch msg.Method {
+
+	case "initialize":
+		Reply(msg.ID,Capabilities(),nil)
+
+	case "shutdown":
+		SHUTDOWN = true
+		Reply(msg.ID,nil,nil)
+
+	case "exit":
+		if SHUTDOWN {
+			os.Exit(0)
+		}
+		os.Exit(1)
+
+	case "textDocument/didOpen":
+		d := &OpenDoc{URI: p.TextDocument.URI,Path: URIToPath(p.TextDocument.URI)}
+		DOCS[d.URI] = d
+		Update(d,p.TextDocument.Text)
+
+	case "textDocument/didChange":
+		d,ok := DOCS[p.TextDocument.URI]
+
+		if ok && len(p.ContentChanges) > 0 {
+			Update(d,p.ContentChanges[len(p.ContentChanges)-1].Text)
+		}
+
+	case "textDocument/didClose":
+		delete(DOCS,p.TextDocument.URI)
+		PublishDiagnostics(p.TextDocument.URI,[]LSPDiagnostic{})
+
+		if filepath.Base(URIToPath(p.TextDocument.URI)) == CONFIG_NAME {
+			AnalyzeAll()
+		}
+
+	case "workspace/didChangeWatchedFiles":
+		AnalyzeAll()
+
+	case "textDocument/completion":
+		Reply(msg.ID,Completion(DOCS[p.TextDocument.URI],p.Position),nil)
+
+	case "textDocument/hover":
+		Reply(msg.ID,HoverAt(DOCS[p.TextDocument.URI],p.Position),nil)
+
+	case "textDocument/definition":
+		Reply(msg.ID,Definition(DOCS[p.TextDocument.URI],p.Position),nil)
+
+	default:
+		// Notifications we don't know can be ignored, requests not
+
+		if msg.ID != nil {
+			Reply(msg.ID,nil,&ResponseError{Code: ERR_METHOD_NOT_FOUND,Message: "not supported: "+msg.Method})
+		}
+	}
+}
+
+//******************************************************************
+
+func Capabilities() interface{} {
+
+	return map[string]interface{}{
+		"capabilities": map[string]interface{}{
+			"textDocumentSync": LSP_SYNC_FULL,
+			"completionProvider": map[string]interface{}{
+				"triggerCharacters": []string{"(","$","@","."},
+			},
+			"hoverProvider": true,
+			"definitionProvider": true,
+		},
+		"serverInfo": map[string]interface{}{
+			"name": "n4l-lsp",
+		},
+	}
+}
+
+//******************************************************************
+// Diagnostics
+//******************************************************************
+
+func Update(d *OpenDoc,text string) {
+
+	d.Text = text
+	d.Lines = strings.Split(text,"\n")
+
+	Analyze(d)
+
+	// The arrows of the other notes may have changed with it
+
+	if filepath.Base(d.Path) == CONFIG_NAME {
+		AnalyzeAll()
+	}
+}
+
+//******************************************************************
+
+func AnalyzeAll() {
+
+	for _,d := range DOCS {
+		Analyze(d)
+	}
+}
+
+//******************************************************************
+
+func Analyze(d *OpenDoc) {
+
+	var diags []N4L.Diagnostic
+
+	if filepath.Base(d.Path) == CONFIG_NAME {
+		d.Config,diags = N4L.ParseConfigString(d.Path,d.Text)
+		d.Doc = &N4L.Document{File: d.Path}
+	} else {
+		d.Config = LoadConfig(d.Path)
+		d.Doc,diags = N4L.ParseString(d.Path,d.Text,d.Config)
+
+		for _,s := range d.Doc.Includes() {
+
+			path := N4L.IncludePath(d.Path,s.Value)
+
+			if _,err := os.Stat(path); err != nil {
+				diags = append(diags,d.Doc.Diagnose(s.Pos,N4L.ERROR,N4L.ERR_NO_SUCH_FILE_FOUND+path))
+			}
+		}
+	}
+
+	var list = []LSPDiagnostic{}
+
+	for _,diag := range diags {
+
+		var l LSPDiagnostic
+
+		l.Range = DiagnosticRange(d.Lines,diag.Pos)
+		l.Severity = diag.Severity
+		l.Source = "n4l"
+		l.Message = strings.TrimPrefix(diag.Message,"WARNING: ")
+		list = append(list,l)
+	}
+
+	PublishDiagnostics(d.URI,list)
+}
+
+//******************************************************************
+
+func PublishDiagnostics(uri string,list []LSPDiagnostic) {
+
+	params := map[string]interface{}{"uri": uri,"diagnostics": list}
+
+	Send(map[string]interface{}{"jsonrpc": "2.0","method": "textDocument/publishDiagnostics","params": params})
+}
+
+//******************************************************************
+
+func DiagnosticRange(lines []string,pos N4L.Position) LSPRange {
+
+	// From the place to the end of the word there, so there is
+	// something to underline
+
+	end := pos
+
+	if pos.Line >= 1 && pos.Line <= len(lines) {
+
+		text := []rune(lines[pos.Line-1])
+		c := pos.Column-1
+
+		for c < len(text) && !unicode.IsSpace(text[c]) {
+			c++
+		}
+
+		end.Column = c+1
+	}
+
+	if end.Column <= pos.Column {
+		end.Column = pos.Column+1
+	}
+
+	return LSPRange{Start: ToLSP(lines,pos),End: ToLSP(lines,end)}
+}
+
+//******************************************************************
+
+func LoadConfig(path string) *N4L.Config {
+
+	// The configuration that goes with a file, as the compilers would
+	// find it when run in its directory, preferring what's in the editor
+
+	name := CONFIG_FILE
+
+	if name == "" {
+		name = FindConfig(filepath.Dir(path))
+	}
+
+	if name == "" {
+		return nil
+	}
+
+	for _,d := range DOCS {
+		if d.Path == name && d.Config != nil {
+			return d.Config
+		}
+	}
+
+	info,err := os.Stat(name)
+
+	if err != nil {
+		return nil
+	}
+
+	cached,ok := CONFIGS[name]
+
+	if ok && cached.Modified.Equal(info.ModTime()) {
+		return cached.Config
+	}
+
+	cfg,diags := N4L.ParseConfigFile(name)
+
+	for _,diag := range diags {
+		Log(diag)
+	}
+
+	CONFIGS[name] = ConfigCache{Modified: info.ModTime(),Config: cfg}
+
+	return cfg
+}
+
+//******************************************************************
+
+func FindConfig(dir string) string {
+
+	// The nearest one above, or in the directory we were started in
+
+	for {
+		name := filepath.Join(dir,CONFIG_NAME)
+
+		if _,err := os.Stat(name); err == nil {
+			return name
+		}
+
+		parent := filepath.Dir(dir)
+
+		if parent == dir {
+			break
+		}
+
+		dir = parent
+	}
+
+	if _,err := os.Stat(CONFIG_NAME); err == nil {
+		abs,_ := filepath.Abs(CONFIG_NAME)
+		return abs
+	}
+
+	return ""
+}
+
+//******************************************************************
+// Completion
+//******************************************************************
+
+func Completion(d *OpenDoc,lp LSPPosition) []CompletionItem {
+
+	var items = []CompletionItem{}
+
+	if d == nil || lp.Line >= len(d.Lines) || filepath.Base(d.Path) == CONFIG_NAME {
+		return items
+	}
+
+	pos := FromLSP(d.Lines,lp)
+	text := []rune(d.Lines[lp.Line])
+	before := text[:pos.Column-1]
+
+	// Inside a relation, (name ...
+
+	open := LastIndexRune(before,'(')
+
+	if open >= 0 && LastIndexRune(before,')') < open {
+
+		name := before[open+1:]
+
+		if IndexRune(name,',') >= 0 {
+			return items // weights and context
+		}
+
+		start := open+1
+
+		for start < len(before) && unicode.IsSpace(before[start]) {
+			start++
+		}
+
+		return ArrowCompletions(d,d.Lines,pos,start)
+	}
+
+	// Or a word starting with $ or @
+
+	start := len(before)
+
+	for start > 0 && !unicode.IsSpace(before[start-1]) {
+		start--
+	}
+
+	if start == len(before) {
+		return items
+	}
+
+	switch before[start] {
+	case '$':
+		return ReferenceCompletions(d,pos,start)
+	case '@':
+		return LabelCompletions(d,pos,start)
+	}
+
+	return items
+}
+
+//******************************************************************
+
+func ArrowCompletions(d *OpenDoc,lines []string,pos N4L.Position,start int) []CompletionItem {
+
+	var items = []CompletionItem{}
+
+	cfg := d.Config
+
+	if cfg == nil {
+		cfg = N4L.NewConfig()
+	}
+
+	edit := LSPRange{Start: ToLSP(lines,N4L.Position{Line: pos.Line,Column: start+1}),End: ToLSP(lines,pos)}
+	seen := make(map[string]bool)
+
+	for _,arr := range cfg.Arrows {
+
+		if !seen[arr.Short] {
+			seen[arr.Short] = true
+			items = append(items,CompletionItem{Label: arr.Short,Kind: LSP_KIND_OPERATOR,Detail: arr.Long,TextEdit: &TextEdit{Range: edit,NewText: arr.Short}})
+		}
+
+		if !seen[arr.Long] {
+			seen[arr.Long] = true
+			items = append(items,CompletionItem{Label: arr.Long,Kind: LSP_KIND_OPERATOR,Detail: "("+arr.Short+")",TextEdit: &TextEdit{Range: edit,NewText: arr.Long}})
+		}
+	}
+
+	return items
+}
+
+//******************************************************************
+
+func ReferenceCompletions(d *OpenDoc,pos N4L.Position,start int) []CompletionItem {
+
+	// $label.n for each item on an @label line
+
+	var items = []CompletionItem{}
+
+	edit := LSPRange{Start: ToLSP(d.Lines,N4L.Position{Line: pos.Line,Column: start+1}),End: ToLSP(d.Lines,pos)}
+
+	for _,label := range d.Doc.Labels() {
+
+		for n,item := range label.Items {
+
+			ref := "$"+label.Name+"."+strconv.Itoa(n+1)
+			detail := fmt.Sprintf("%s (line %d)",item.Text,item.Pos.Line)
+			items = append(items,CompletionItem{Label: ref,Kind: LSP_KIND_REFERENCE,Detail: detail,TextEdit: &TextEdit{Range: edit,NewText: ref}})
+		}
+	}
+
+	return items
+}
+
+//******************************************************************
+
+func LabelCompletions(d *OpenDoc,pos N4L.Position,start int) []CompletionItem {
+
+	// Labels that are referred to but never made, then those in use
+
+	var items = []CompletionItem{}
+	var names []string
+
+	defined := make(map[string]bool)
+	seen := make(map[string]bool)
+
+	for _,label := range d.Doc.Labels() {
+		defined[label.Name] = true
+	}
+
+	for _,line := range d.Doc.Lines {
+		for _,s := range line.Statements {
+			if s.Role == N4L.ROLE_LOOKUP && !defined[s.Alias] && !seen[s.Alias] {
+				seen[s.Alias] = true
+				names = append(names,s.Alias)
+			}
+		}
+	}
+
+	for _,label := range d.Doc.Labels() {
+		names = append(names,label.Name)
+	}
+
+	edit := LSPRange{Start: ToLSP(d.Lines,N4L.Position{Line: pos.Line,Column: start+1}),End: ToLSP(d.Lines,pos)}
+
+	for _,name := range names {
+
+		detail := "referred to, not yet labelled"
+
+		if defined[name] {
+			detail = "already a label"
+		}
+
+		items = append(items,CompletionItem{Label: "@"+name,Kind: LSP_KIND_VARIABLE,Detail: detail,TextEdit: &TextEdit{Range: edit,NewText: "@"+name}})
+	}
+
+	return items
+}
+
+//******************************************************************
+// Hover and go to definition
+//******************************************************************
+
+func HoverAt(d *OpenDoc,lp LSPPosition) interface{} {
+
+	if d == nil || lp.Line >= len(d.Lines) {
+		return nil
+	}
+
+	s,ok := d.Doc.StatementAt(FromLSP(d.Lines,lp))
+
+	if !ok {
+		return nil
+	}
+
+	var text string
+
+	switch s.Role {
+
+	case N4L.ROLE_RELATION:
+		text = ArrowSummary(d.Config,s.Relation)
+
+	case N4L.ROLE_LOOKUP:
+		label,_,_,ok := LookupLabel(d,s)
+
+		if ok && s.Index <= len(label.Items) {
+			item := label.Items[s.Index-1]
+			text = fmt.Sprintf("`%s` is **%s**, item %d of @%s on line %d",s.Text,item.Text,s.Index,s.Alias,item.Pos.Line)
+
+			if item.Pos.File != d.Path {
+				text += " of "+filepath.Base(item.Pos.File)
+			}
+		}
+
+	case N4L.ROLE_INCLUDE:
+		text = "Includes **"+N4L.IncludePath(d.Path,s.Value)+"**, whose labels are $"+N4L.FileAlias(s.Value)+":label.n"
+	}
+
+	if text == "" {
+		return nil
+	}
+
+	return Hover{Contents: MarkupContent{Kind: "markdown",Value: text},Range: LSPRange{Start: ToLSP(d.Lines,s.Pos),End: ToLSP(d.Lines,s.End())}}
+}
+
+//******************************************************************
+
+func ArrowSummary(cfg *N4L.Config,rel N4L.Relation) string {
+
+	// What kind of link this is, and how it reads backwards
+
+	if cfg == nil {
+		cfg = N4L.NewConfig()
+	}
+
+	arr,ok := cfg.Arrow(rel.Name)
+
+	if !ok {
+		return "No such arrow: **"+rel.Name+"**"
+	}
+
+	sttype := SST.STIndexToSTType(SST.GetSTIndexByName(arr.Section,arr.Sign))
+
+	text := fmt.Sprintf("**(%s)** %s\n\nSTtype %d, %s (in %s)",arr.Short,arr.Long,sttype,SST.STTypeName(sttype),arr.Section)
+
+	if inv,ok := cfg.Inverse(rel.Name); ok {
+		text += fmt.Sprintf("\n\nInverse: **(%s)** %s",inv.Short,inv.Long)
+	}
+
+	if rel.Weight != 1 {
+		text += fmt.Sprintf("\n\nWeight: %g",rel.Weight)
+	}
+
+	if len(rel.Context) > 0 {
+		text += "\n\nContext: "+strings.Join(rel.Context,", ")
+	}
+
+	return text
+}
+
+//******************************************************************
+
+func Definition(d *OpenDoc,lp LSPPosition) interface{} {
+
+	// From $label.n to the @label, from #include to the file, or
+	// from an arrow to where the configuration declares it
+
+	if d == nil || lp.Line >= len(d.Lines) {
+		return nil
+	}
+
+	s,ok := d.Doc.StatementAt(FromLSP(d.Lines,lp))
+
+	if !ok {
+		return nil
+	}
+
+	switch s.Role {
+
+	case N4L.ROLE_LOOKUP:
+		label,lines,uri,ok := LookupLabel(d,s)
+
+		if !ok {
+			return nil
+		}
+
+		end := label.Pos
+		end.Column += len([]rune(label.Name))+1
+
+		return Location{URI: uri,Range: LSPRange{Start: ToLSP(lines,label.Pos),End: ToLSP(lines,end)}}
+
+	case N4L.ROLE_INCLUDE:
+		path := N4L.IncludePath(d.Path,s.Value)
+
+		if FileLines(path) == nil {
+			return nil
+		}
+
+		return Location{URI: PathToURI(path),Range: LSPRange{}}
+
+	case N4L.ROLE_RELATION:
+		if d.Config == nil {
+			return nil
+		}
+
+		arr,ok := d.Config.Arrow(s.Relation.Name)
+
+		if !ok || arr.Pos.File == "" {
+			return nil // a mandatory arrow
+		}
+
+		lines := FileLines(arr.Pos.File)
+		end := arr.Pos
+		end.Column += len([]rune(arr.Short))+2
+
+		return Location{URI: PathToURI(arr.Pos.File),Range: LSPRange{Start: ToLSP(lines,arr.Pos),End: ToLSP(lines,end)}}
+	}
+
+	return nil
+}
+
+//******************************************************************
+
+func LookupLabel(d *OpenDoc,s N4L.Statement) (N4L.Label,[]string,string,bool) {
+
+	// The @label of $label.n, or of $file:label.n in another file,
+	// with the lines and URI of the file it is in
+
+	if s.AliasFile == "" || s.AliasFile == N4L.FileAlias(d.Path) {
+		label,ok := d.Doc.Label(s.Alias)
+		return label,d.Lines,d.URI,ok
+	}
+
+	path,ok := d.Doc.AliasFilePath(s.AliasFile)
+
+	if !ok {
+		return N4L.Label{},nil,"",false
+	}
+
+	lines := FileLines(path)
+	doc,_ := N4L.ParseString(path,strings.Join(lines,"\n"),nil)
+	label,ok := doc.Label(s.Alias)
+
+	return label,lines,PathToURI(path),ok
+}
+
+//******************************************************************
+
+func FileLines(path string) []string {
+
+	for _,d := range DOCS {
+		if d.Path == path {
+			return d.Lines
+		}
+	}
+
+	content,err := os.ReadFile(path)
+
+	if err != nil {
+		return nil
+	}
+
+	return strings.Split(string(content),"\n")
+}
+
+//******************************************************************
+// Positions: N4L counts characters from 1, LSP counts UTF-16
+// units from 0
+//******************************************************************
+
+func ToLSP(lines []string,pos N4L.Position) LSPPosition {
+
+	var lp LSPPosition
+
+	lp.Line = pos.Line-1
+	lp.Character = pos.Column-1
+
+	if lp.Line < 0 || lp.Line >= len(lines) {
+		return lp
+	}
+
+	text := []rune(lines[lp.Line])
+
+	if lp.Character > len(text) {
+		lp.Character = len(text)
+	}
+
+	lp.Character = len(utf16.Encode(text[:lp.Character]))
+
+	return lp
+}
+
+//******************************************************************
+
+func FromLSP(lines []string,lp LSPPosition) N4L.Position {
+
+	pos := N4L.Position{Line: lp.Line+1,Column: lp.Character+1}
+
+	if lp.Line < 0 || lp.Line >= len(lines) {
+		return pos
+	}
+
+	var units int
+
+	text := []rune(lines[lp.Line])
+
+	for c,r := range text {
+
+		if units >= lp.Character {
+			pos.Column = c+1
+			return pos
+		}
+
+		units += utf16.RuneLen(r)
+	}
+
+	pos.Column = len(text)+1
+
+	return pos
+}
+
+//******************************************************************
+
+func URIToPath(uri string) string {
+
+	u,err := url.Parse(uri)
+
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+
+	return filepath.FromSlash(u.Path)
+}
+
+//******************************************************************
+
+func PathToURI(path string) string {
+
+	abs,err := filepath.Abs(path)
+
+	if err == nil {
+		path = abs
+	}
+
+	u := url.URL{Scheme: "file",Path: filepath.ToSlash(path)}
+
+	return u.String()
+}
+
+//******************************************************************
+
+func IndexRune(text []rune,r rune) int {
+
+	for i := range text {
+		if text[i] == r {
+			return i
+		}
+	}
+
+	return -1
+}
+
+//******************************************************************
+
+func LastIndexRune(text []rune,r rune) int {
+
+	for i := len(text)-1; i >= 0; i-- {
+		if text[i] == r {
+			return i
+		}
+	}
+
+	return -1
+}
+
+//******************************************************************
+// JSON-RPC over stdio, each message after a Content-Length header
+//******************************************************************
+
+type ResponseError struct {
+
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+//******************************************************************
+
+func ReadMessage(in *bufio.Reader) (Message,error) {
+
+	var msg Message
+	var length int = -1
+
+	for {
+		line,err := in.ReadString('\n')
+
+		if err != nil {
+			return msg,err
+		}
+
+		line = strings.TrimSpace(line)
+
+		if line == "" {
+			break
+		}
+
+		if value,ok := strings.CutPrefix(line,"Content-Length:"); ok {
+			length,err = strconv.Atoi(strings.TrimSpace(value))
+
+			if err != nil {
+				return msg,fmt.Errorf("bad Content-Length: %s",value)
+			}
+		}
+	}
+
+	if length < 0 {
+		return msg,fmt.Errorf("missing Content-Length")
+	}
+
+	body := make([]byte,length)
+
+	if _,err := io.ReadFull(in,body); err != nil {
+		return msg,err
+	}
+
+	err := json.Unmarshal(body,&msg)
+
+	return msg,err
+}
+
+//******************************************************************
+
+func Reply(id json.RawMessage,result interface{},rerr *ResponseError) {
+
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+
+	response := map[string]interface{}{"jsonrpc": "2.0","id": id}
+
+	if rerr != nil {
+		response["error"] = rerr
+	} else {
+		response["result"] = result
+	}
+
+	Send(response)
+}
+
+//******************************************************************
+
+func Send(v interface{}) {
+
+	body,err := json.Marshal(v)
+
+	if err != nil {
+		Log("can't encode reply:",err)
+		return
+	}
+
+	fmt.Fprintf(OUT,"Content-Length: %d\r\n\r\n",len(body))
+	OUT.Write(body)
+	OUT.Flush()
+}
+
+//******************************************************************
+
+func Log(a ...interface{}) {
+
+	if VERBOSE {
+		fmt.Fprintln(os.Stderr,a...)
+	}
+}
@@ -0,0 +1,174 @@
+//******************************************************************
+//
+// n4lfmt - rewrite N4L notes in the canonical layout, with the
+// relations of neighbouring lines in columns, so that notes
+// written by different people (and editors) look alike
+//
+//******************************************************************
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+        N4L "N4L"
+)
+
+//******************************************************************
+
+var (
+	WRITE bool = false
+	CHECK bool = false
+
+	UNFORMATTED bool = false
+	FAILED bool = false
+)
+
+//******************************************************************
+
+func main() {
+
+	args := Init()
+
+	if len(args) == 0 {
+		FormatStream("<stdin>",os.Stdin)
+	}
+
+	for _,name := range args {
+		FormatFile(name)
+	}
+
+	if FAILED {
+		os.Exit(2)
+	}
+
+	if UNFORMATTED {
+		os.Exit(1)
+	}
+}
+
+//******************************************************************
+
+func Usage() {
+
+	fmt.Printf("usage: n4lfmt [-w] [-check] [file.n4l ...]\n")
+	fmt.Printf("Without files, formats stdin to stdout\n")
+	flag.PrintDefaults()
+
+	os.Exit(2)
+}
+
+//******************************************************************
+
+func Init() []string {
+
+	flag.Usage = Usage
+
+	writePtr := flag.Bool("w", false, "write the result back to the file, instead of stdout")
+	checkPtr := flag.Bool("check", false, "only list the files that aren't formatted, and exit 1 if there are any")
+
+	flag.Parse()
+
+	WRITE = *writePtr
+	CHECK = *checkPtr
+
+	return flag.Args()
+}
+
+//******************************************************************
+
+func FormatFile(name string) {
+
+	content,err := os.ReadFile(name)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr,"n4lfmt:",err)
+		FAILED = true
+		return
+	}
+
+	text := string(content)
+	formatted,ok := Format(name,text)
+
+	if !ok {
+		return
+	}
+
+	switch {
+
+	case CHECK:
+		if formatted != text {
+			fmt.Println(name)
+			UNFORMATTED = true
+		}
+
+	case WRITE:
+		if formatted != text {
+			if err := os.WriteFile(name,[]byte(formatted),0644); err != nil {
+				fmt.Fprintln(os.Stderr,"n4lfmt:",err)
+				FAILED = true
+			}
+		}
+
+	default:
+		fmt.Print(formatted)
+	}
+}
+
+//******************************************************************
+
+func FormatStream(name string,in io.Reader) {
+
+	content,err := io.ReadAll(in)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr,"n4lfmt:",err)
+		FAILED = true
+		return
+	}
+
+	text := string(content)
+	formatted,ok := Format(name,text)
+
+	if !ok {
+		return
+	}
+
+	if CHECK {
+		if formatted != text {
+			fmt.Println(name)
+			UNFORMATTED = true
+		}
+		return
+	}
+
+	fmt.Print(formatted)
+}
+
+//******************************************************************
+
+func Format(name,text string) (string,bool) {
+
+	// Notes with errors are left as they are, as the parser can't
+	// say what they mean
+
+	formatted,diags := N4L.Format(name,text)
+
+	if !N4L.HasErrors(diags) {
+		return formatted,true
+	}
+
+	for _,d := range diags {
+		if d.Severity == N4L.ERROR {
+			fmt.Fprintln(os.Stderr,d)
+			fmt.Fprint(os.Stderr,d.Excerpt())
+		}
+	}
+
+	FAILED = true
+
+	return "",false
+}
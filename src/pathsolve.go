@@ -26,6 +26,9 @@ var (
 	VERBOSE bool
 	FWD     string
 	BWD     string
+	WEIGHTED bool
+	K       int
+	FORMAT  string
 )
 
 //******************************************************************
@@ -45,7 +48,7 @@ func main() {
 
 func Usage() {
 	
-	fmt.Printf("usage: PathSolve [-v] -begin <string> -end <string> [-chapter string] subject [context]\n")
+	fmt.Printf("usage: PathSolve [-v] [-weighted [-k n]] [-format dot] -begin <string> -end <string> [-chapter string] subject [context]\n")
 	flag.PrintDefaults()
 
 	os.Exit(2)
@@ -62,7 +65,11 @@ func Init() []string {
 	beginPtr := flag.String("begin", "", "a string match start/begin set")
 	endPtr := flag.String("end", "", "a string to match final end set")
 	dirPtr := flag.Bool("bwd", false, "reverse search direction")
+	weightedPtr := flag.Bool("weighted", false, "find the cheapest paths by the sum of link weights, instead of the fewest hops")
+	kPtr := flag.Int("k", 1, "with -weighted, the number of cheapest paths to find")
+	formatPtr := flag.String("format", "", "write the solutions as a graph in this format, instead of analysing them: "+strings.Join(SST.ExportFormats(),", "))
 
+	SST.DBFlags()
 	flag.Parse()
 	args := flag.Args()
 
@@ -70,6 +77,10 @@ func Init() []string {
 		VERBOSE = true
 	}
 
+	WEIGHTED = *weightedPtr
+	K = *kPtr
+	FORMAT = *formatPtr
+
 	CHAPTER = ""
 
 	if *dirPtr {
@@ -143,7 +154,9 @@ func PathSolve(ctx SST.PoSST, chapter,cntext,begin, end string) {
 		return
 	}
 
-	fmt.Printf("\n\n Paths < end_set= {%s} | {%s} = start set>\n\n",ShowNode(ctx,rightptrs),ShowNode(ctx,leftptrs))
+	if FORMAT == "" {
+		fmt.Printf("\n\n Paths < end_set= {%s} | {%s} = start set>\n\n",ShowNode(ctx,rightptrs),ShowNode(ctx,leftptrs))
+	}
 
 	// Find the path matrix
 
@@ -151,19 +164,24 @@ func PathSolve(ctx SST.PoSST, chapter,cntext,begin, end string) {
 	var ldepth,rdepth int = 1,1
 	var betweenness = make(map[string]int)
 
-	for turn := 0; ldepth < maxdepth && rdepth < maxdepth; turn++ {
+	if WEIGHTED {
+
+		// Weights make sense in the direction of the arrows, so -bwd just swaps the ends
+
+		if FWD == "bwd" {
+			solutions = SST.GetShortestWeightedPaths(ctx,rightptrs,leftptrs,nil,chapter,context,K)
+		} else {
+			solutions = SST.GetShortestWeightedPaths(ctx,leftptrs,rightptrs,nil,chapter,context,K)
+		}
+	}
+
+	for turn := 0; !WEIGHTED && ldepth < maxdepth && rdepth < maxdepth; turn++ {
 
 		left_paths,Lnum = SST.GetEntireNCSuperConePathsAsLinks(ctx,FWD,leftptrs,ldepth,chapter,context)
 		right_paths,Rnum = SST.GetEntireNCSuperConePathsAsLinks(ctx,BWD,rightptrs,rdepth,chapter,context)
 		solutions,_ = SST.WaveFrontsOverlap(ctx,left_paths,right_paths,Lnum,Rnum,ldepth,rdepth)
 
 		if len(solutions) > 0 {
-
-			for s := 0; s < len(solutions); s++ {
-				prefix := fmt.Sprintf(" - story path: ")
-				SST.PrintLinkPath(ctx,solutions,s,prefix,"",nil)
-				betweenness = TallyPath(ctx,solutions[s],betweenness)
-			}
 			count++
 			break
 		}
@@ -180,6 +198,23 @@ func PathSolve(ctx SST.PoSST, chapter,cntext,begin, end string) {
 		os.Exit(-1)
 	}
 
+	if FORMAT != "" {
+		Export(ctx,FORMAT,begin+" to "+end,solutions)
+		return
+	}
+
+	for s := 0; s < len(solutions); s++ {
+
+		prefix := fmt.Sprintf(" - story path: ")
+
+		if WEIGHTED {
+			prefix = fmt.Sprintf(" - cost %.2f, path: ",SST.PathCost(solutions[s]))
+		}
+
+		SST.PrintLinkPath(ctx,solutions,s,prefix,"",nil)
+		betweenness = TallyPath(ctx,solutions[s],betweenness)
+	}
+
 	// Calculate the node layer sets S[path][depth]
 
 	fmt.Println(" *\n *\n * PATH ANALYSIS: into node flow equivalence groups\n *\n *\n\n")
@@ -210,6 +245,26 @@ func PathSolve(ctx SST.PoSST, chapter,cntext,begin, end string) {
 
 // **********************************************************
 
+func Export(ctx SST.PoSST,format,title string,solutions [][]SST.Link) {
+
+	g,err := SST.TryExportPaths(ctx,title,solutions)
+
+	var out string
+
+	if err == nil {
+		out,err = SST.TryFormatGraph(g,format)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr,"Could not export:",err)
+		os.Exit(-1)
+	}
+
+	fmt.Print(out)
+}
+
+// **********************************************************
+
 func TallyPath(ctx SST.PoSST,path []SST.Link,between map[string]int) map[string]int {
 
 	// count how often each node appears in the different path solutions
@@ -30,6 +30,11 @@ var (
 	BROWSE bool
 	EXPLORE bool
 	LIMIT int
+	UNRANKED bool
+	CENTRALITY bool
+	CLUSTERS bool
+	STTYPES []int
+	FORMAT string
 )
 
 //******************************************************************
@@ -41,6 +46,24 @@ func main() {
 	load_arrows := true
 	ctx := SST.Open(load_arrows)
 
+	if CENTRALITY {
+		Centrality(ctx,ARROWS,CHAPTER,CONTEXT,LIMIT)
+		SST.Close(ctx)
+		return
+	}
+
+	if CLUSTERS {
+		Clusters(ctx,CHAPTER,CONTEXT,STTYPES,LIMIT)
+		SST.Close(ctx)
+		return
+	}
+
+	if FORMAT != "" {
+		Export(ctx,FORMAT,ARROWS,CHAPTER,CONTEXT,SUBJECT,LIMIT)
+		SST.Close(ctx)
+		return
+	}
+
 	if SUBJECT == "" {
 		fmt.Println("\nTo browse everything use: --browse everything..\n")
 		Usage()
@@ -58,6 +81,9 @@ func main() {
 func Usage() {
 	
 	fmt.Printf("usage: searchN4L [-v] [-arrows=] [-chapter string] subject [context]\n")
+	fmt.Printf("       searchN4L -centrality [-arrows=] [-chapter string] [-limit n] [context]\n")
+	fmt.Printf("       searchN4L -clusters [-sttypes=] [-chapter string] [-limit n] [context]\n")
+	fmt.Printf("       searchN4L -format dot [-chapter string] [subject [context]]\n")
 	flag.PrintDefaults()
 
 	os.Exit(2)
@@ -75,7 +101,13 @@ func Init() []string {
 	limitPtr := flag.Int("limit", 20, "an approximate limit on the number of items returned, where applicable")
 	browsePtr := flag.Bool("browse", false,"browse through all items")
 	explorePtr := flag.Bool("explore", false,"explore items")
+	unrankedPtr := flag.Bool("unranked", false,"match node names by substring only, in no particular order")
+	centralityPtr := flag.Bool("centrality", false,"compute and save the importance of nodes in the chapter, context and arrows, and show the top limit")
+	clustersPtr := flag.Bool("clusters", false,"show the disconnected parts and tightly knit communities of the chapter and context")
+	sttypesPtr := flag.String("sttypes", "", "a list of link types from -3 to 3 for -clusters, e.g. 0,1 for near and leadsto")
+	formatPtr := flag.String("format", "", "write the cone around the subject, or the whole chapter, in this format: "+strings.Join(SST.ExportFormats(),", "))
 
+	SST.DBFlags()
 	flag.Parse()
 	args := flag.Args()
 
@@ -91,6 +123,22 @@ func Init() []string {
 		EXPLORE = true
 	}
 
+	UNRANKED = *unrankedPtr
+	CENTRALITY = *centralityPtr
+	CLUSTERS = *clustersPtr
+	FORMAT = *formatPtr
+
+	if *sttypesPtr != "" {
+		for _,st := range strings.Split(*sttypesPtr,",") {
+			var sttype int
+			_,err := fmt.Sscanf(strings.TrimSpace(st),"%d",&sttype)
+			if err != nil || sttype < -3 || sttype > 3 {
+				fmt.Println("An STtype is a number from -3 to 3, not",st)
+				os.Exit(1)
+			}
+			STTYPES = append(STTYPES,sttype)
+		}
+	}
 
 	if *arrowsPtr != "" {
 		ARROWS = strings.Split(*arrowsPtr,",")
@@ -102,7 +150,13 @@ func Init() []string {
 		CHAPTER = *chapterPtr
 	}
 
-	if len(args) > 0 {
+	if CENTRALITY || CLUSTERS {
+
+		// There is no subject, only context
+
+		CONTEXT = args
+
+	} else if len(args) > 0 {
 		SUBJECT = args[0]
 		
 		for c := 1; c < len(args); c++ {
@@ -149,7 +203,7 @@ func Search(ctx SST.PoSST,arrows []string,chapter string,context []string,search
 		searchtext = ""
 	}
 
-	EventSearch(ctx,chapter,context,searchtext)
+	EventSearch(ctx,chapter,context,searchtext,limit)
 
 	if EXPLORE {
 		BroadByName(ctx,chapter,context,searchtext,arrows)
@@ -169,9 +223,25 @@ func Search(ctx SST.PoSST,arrows []string,chapter string,context []string,search
 
 //******************************************************************
 
-func EventSearch(ctx SST.PoSST, chaptext string,context []string,searchtext string) {
-	
-	nptrs := SST.GetDBNodePtrMatchingName(ctx,searchtext,chaptext)
+func EventSearch(ctx SST.PoSST, chaptext string,context []string,searchtext string,limit int) {
+
+	var nptrs []SST.NodePtr
+
+	if UNRANKED {
+		nptrs = SST.GetDBNodePtrMatchingName(ctx,searchtext,chaptext)
+	} else {
+		matches := SST.SearchDBNodeText(ctx,searchtext,chaptext,limit)
+
+		if len(matches) > 0 {
+			fmt.Print("   Best matches:\n\n")
+		}
+
+		for m := range matches {
+			fmt.Printf("   %3d. %-50s %.2f\n",m,matches[m].Highlight,matches[m].Rank)
+		}
+
+		nptrs = SST.NodeMatchPtrs(matches)
+	}
 
 	for nptr := range nptrs {
 		fmt.Print("\n",nptr,": ")
@@ -275,6 +345,157 @@ func BroadByName(ctx SST.PoSST, chaptext string,context []string,searchtext stri
 
 //******************************************************************
 
+func Centrality(ctx SST.PoSST,arrnames []string,chaptext string,context []string,limit int) {
+
+	var arrows []SST.ArrowPtr
+
+	for _,name := range arrnames {
+		if name != "" {
+			arrows = append(arrows,SST.GetDBArrowByName(ctx,name))
+		}
+	}
+
+	scores,err := SST.TryUpdateCentrality(ctx,chaptext,context,arrows)
+
+	if err != nil {
+		fmt.Println("Could not compute centrality:",err)
+		os.Exit(-1)
+	}
+
+	fmt.Printf("\nSaved the centrality of %d nodes as scope \"%s\"\n\n",len(scores),SST.CentralityScope(chaptext,context,arrows))
+
+	if limit > 0 && len(scores) > limit {
+		scores = scores[:limit]
+	}
+
+	var nptrs []SST.NodePtr
+
+	for _,c := range scores {
+		nptrs = append(nptrs,c.NPtr)
+	}
+
+	nodes := SST.GetDBNodesByNodePtrs(ctx,nptrs)
+
+	fmt.Printf("   %-8s %-8s %-8s  %s\n","pagerank","eigen","between","node")
+
+	for _,c := range scores {
+		fmt.Printf("   %8.5f %8.5f %8.5f  %s (in %s)\n",c.PageRank,c.Eigenvector,c.Betweenness,nodes[c.NPtr].S,nodes[c.NPtr].Chap)
+	}
+
+	fmt.Println()
+}
+
+//******************************************************************
+
+func Clusters(ctx SST.PoSST,chaptext string,context []string,sttypes []int,limit int) {
+
+	clusters,err := SST.TryGetGraphClusters(ctx,chaptext,context,sttypes)
+
+	if err != nil {
+		fmt.Println("Could not find clusters:",err)
+		os.Exit(-1)
+	}
+
+	fmt.Printf("\nFound %d disconnected parts and %d communities, with modularity %.3f\n",
+		len(clusters.Components),len(clusters.Communities),clusters.Modularity)
+
+	ShowClusters(ctx,"Part",clusters.Components,limit)
+	ShowClusters(ctx,"Community",clusters.Communities,limit)
+}
+
+//******************************************************************
+
+func ShowClusters(ctx SST.PoSST,kind string,clusters []SST.Cluster,limit int) {
+
+	// The largest clusters, with a sample of their members
+
+	const sample = 5
+
+	if limit > 0 && len(clusters) > limit {
+		clusters = clusters[:limit]
+	}
+
+	var nptrs []SST.NodePtr
+
+	for _,c := range clusters {
+		for i := 0; i < len(c.Nodes) && i < sample; i++ {
+			nptrs = append(nptrs,c.Nodes[i])
+		}
+	}
+
+	nodes := SST.GetDBNodesByNodePtrs(ctx,nptrs)
+
+	fmt.Println()
+
+	for _,c := range clusters {
+
+		fmt.Printf("   %s %d: %d nodes, %d links\n",kind,c.Id,len(c.Nodes),c.Links)
+
+		for i := 0; i < len(c.Nodes) && i < sample; i++ {
+			fmt.Printf("      - %s\n",nodes[c.Nodes[i]].S)
+		}
+
+		if len(c.Nodes) > sample {
+			fmt.Printf("      ... and %d more\n",len(c.Nodes)-sample)
+		}
+	}
+}
+
+//******************************************************************
+
+func Export(ctx SST.PoSST,format string,arrnames []string,chaptext string,context []string,searchtext string,limit int) {
+
+	// The cone around the best matches for the subject, else the chapter,
+	// made only of the arrows given, if any
+
+	const maxdepth = 3
+
+	if chaptext == "any" {
+		chaptext = ""
+	}
+
+	var arrows []SST.ArrowPtr
+
+	for _,name := range arrnames {
+		if name != "" {
+			arrows = append(arrows,SST.GetDBArrowByName(ctx,name))
+		}
+	}
+
+	var g SST.ExportGraph
+	var err error
+
+	if searchtext == "" {
+		g,err = SST.TryExportMatching(ctx,chaptext,context,arrows)
+	} else {
+		matches,serr := SST.TrySearchDBNodeTextInContext(ctx,searchtext,chaptext,context,nil,limit)
+
+		if serr != nil {
+			fmt.Fprintln(os.Stderr,serr)
+			os.Exit(-1)
+		}
+
+		paths,_ := SST.GetEntireNCSuperConePathsAsLinks(ctx,"any",SST.NodeMatchPtrs(matches),maxdepth,chaptext,context)
+		g,err = SST.TryExportPaths(ctx,searchtext,paths)
+		g = SST.SelectExportArrows(g,arrows)
+	}
+
+	var out string
+
+	if err == nil {
+		out,err = SST.TryFormatGraph(g,format)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr,"Could not export:",err)
+		os.Exit(-1)
+	}
+
+	fmt.Print(out)
+}
+
+//******************************************************************
+
 func Systematic(ctx SST.PoSST, chaptext string,context []string,searchtext string,arrnames []string) {
 
 	chaptext = strings.TrimSpace(chaptext)
@@ -0,0 +1,151 @@
+//******************************************************************
+//
+// Report on the database schema version and stored functions, and
+// optionally upgrade them to what this version of SSTorytime expects
+//
+//******************************************************************
+
+package main
+
+import (
+	"fmt"
+	"flag"
+	"os"
+
+        SST "SSTorytime"
+)
+
+//******************************************************************
+
+var (
+	UPGRADE bool
+	SEARCH_LANGUAGE string
+)
+
+//******************************************************************
+
+func main() {
+
+	Init()
+
+	// Look before we leap, Open() would otherwise upgrade silently
+
+	SST.SCHEMA_AUTO_UPGRADE = false
+
+	load_arrows := false
+	ctx := SST.Open(load_arrows)
+
+	if ctx.Store != nil {
+		fmt.Println("This is an in-memory graph, which has no database schema to upgrade")
+		SST.Close(ctx)
+		return
+	}
+
+	ok := Report(ctx)
+
+	if UPGRADE && !ok {
+
+		applied,err := SST.TryMigrateDB(ctx)
+
+		if err != nil {
+			fmt.Println("Upgrade failed, nothing was changed:",err)
+			SST.Close(ctx)
+			os.Exit(-1)
+		}
+
+		count,err := SST.TryUpdateStoredFunctions(ctx)
+
+		if err != nil {
+			fmt.Println(err)
+		}
+
+		fmt.Printf("\nApplied %d migrations and redefined %d stored functions\n\n",len(applied),count)
+		ok = Report(ctx)
+	}
+
+	if SEARCH_LANGUAGE != "" {
+
+		if !ok {
+			fmt.Println("Upgrade the schema before setting the search language")
+		} else {
+			err := SST.TrySetDBSearchLanguage(ctx,SEARCH_LANGUAGE)
+
+			if err != nil {
+				fmt.Println(err)
+			} else {
+				fmt.Println("Node text is now searched as",SEARCH_LANGUAGE)
+			}
+		}
+	}
+
+	SST.Close(ctx)
+}
+
+//**************************************************************
+
+func Usage() {
+
+	fmt.Printf("usage: sst-schema [-upgrade] [-search-language name] [-db-config file]\n")
+	flag.PrintDefaults()
+
+	os.Exit(2)
+}
+
+//**************************************************************
+
+func Init() {
+
+	flag.Usage = Usage
+
+	upgradePtr := flag.Bool("upgrade", false,"apply any pending migrations and redefine changed stored functions")
+	languagePtr := flag.String("search-language", "", "the postgres text search configuration for node text, e.g. simple or english")
+
+	SST.DBFlags()
+	flag.Parse()
+
+	UPGRADE = *upgradePtr
+	SEARCH_LANGUAGE = *languagePtr
+}
+
+//**************************************************************
+
+func Report(ctx SST.PoSST) bool {
+
+	status,err := SST.TryGetDBSchemaStatus(ctx)
+
+	if err != nil {
+		fmt.Println(err)
+		SST.Close(ctx)
+		os.Exit(-1)
+	}
+
+	fmt.Printf("Database schema version %d, this program expects %d\n",status.Version,status.Latest)
+
+	if status.Version > status.Latest {
+		fmt.Println("The database is newer than this program, please upgrade SSTorytime")
+		return true
+	}
+
+	for _,m := range status.Pending {
+		fmt.Printf("   pending migration %d: %s\n",m.Version,m.Description)
+	}
+
+	if len(status.StaleFunctions) > 0 {
+		fmt.Printf("%d stored functions are missing or out of date\n",len(status.StaleFunctions))
+
+		for _,name := range status.StaleFunctions {
+			fmt.Println("   ",name)
+		}
+	}
+
+	if len(status.Pending) == 0 && len(status.StaleFunctions) == 0 {
+		fmt.Println("The schema is up to date")
+		return true
+	}
+
+	if !UPGRADE {
+		fmt.Println("Run sst-schema -upgrade to bring it up to date")
+	}
+
+	return false
+}